@@ -0,0 +1,24 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// CachePurge is the result of a cache purge
+type CachePurge struct {
+	Pattern string `json:"pattern,omitempty"` // Key pattern purged, empty for exact keys
+	Deleted int64  `json:"deleted"`
+}
+
+// PurgeCache deletes cached translations, which needs the admin token as
+// Options.APIKey. query selects them as DELETE /admin/cache does: key, a
+// source and/or target language, hash, or all=true.
+func (c *Client) PurgeCache(ctx context.Context, query url.Values) (*CachePurge, error) {
+	var purge CachePurge
+	if err := c.do(ctx, jsonCodec, http.MethodDelete, "/admin/cache?"+query.Encode(), nil, &purge); err != nil {
+		return nil, err
+	}
+	return &purge, nil
+}
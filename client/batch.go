@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// TranslateAll translates every request, up to Options.Concurrency at a
+// time, and returns the responses in the order of reqs. The first failure
+// cancels the requests still in flight and is returned.
+func (c *Client) TranslateAll(ctx context.Context, reqs []Request) ([]*Response, error) {
+	responses := make([]*Response, len(reqs))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.options.Concurrency)
+	for i, req := range reqs {
+		i, req := i, req
+		g.Go(func() error {
+			resp, err := c.Translate(ctx, req)
+			if err != nil {
+				return fmt.Errorf("request %d: %w", i, err)
+			}
+			responses[i] = resp
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+// TranslateTexts translates texts from sourceLang, detected when empty, to
+// targetLang and returns the translations in the order of texts. They are
+// sent as a single /translate/document request, so each distinct text is
+// translated once and the batch counts as one request against rate limits.
+// Blank texts are returned as they are.
+func (c *Client) TranslateTexts(ctx context.Context, texts []string, sourceLang, targetLang string) ([]string, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	document, err := json.Marshal(texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode texts: %v", err)
+	}
+
+	req := struct {
+		Document   json.RawMessage `json:"document"`
+		Selectors  []string        `json:"selectors"`
+		SourceLang string          `json:"source_lang,omitempty"`
+		TargetLang string          `json:"target_lang"`
+	}{document, []string{"$[*]"}, sourceLang, targetLang}
+	var resp struct {
+		Document json.RawMessage `json:"document"`
+	}
+	if err := c.do(ctx, jsonCodec, http.MethodPost, "/translate/document", req, &resp); err != nil {
+		return nil, err
+	}
+
+	var translations []string
+	if err := json.Unmarshal(resp.Document, &translations); err != nil {
+		return nil, fmt.Errorf("failed to decode translated texts: %v", err)
+	}
+	if len(translations) != len(texts) {
+		return nil, fmt.Errorf("got %d translations for %d texts", len(translations), len(texts))
+	}
+	return translations, nil
+}
@@ -0,0 +1,415 @@
+// Package client is a Go client for the translation service's HTTP API.
+//
+//	c := client.New("https://translate.internal", client.Options{APIKey: key})
+//	resp, err := c.Translate(ctx, client.Request{Text: "Hello", TargetLang: "es"})
+//
+// Requests are authenticated with a bearer token or signed with a signing
+// key, and retried with backoff on network errors, 429 and 5xx responses.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Request is a text to translate, as POSTed to /translate
+type Request struct {
+	Text       string `json:"text"`
+	SourceLang string `json:"source_lang,omitempty"` // ISO 639-1 code, detected when empty
+	TargetLang string `json:"target_lang"`           // ISO 639-1 code
+	Format     string `json:"format,omitempty"`      // text (default) or html
+	Formality  string `json:"formality,omitempty"`   // formal or informal, where the provider supports it
+	Tone       string `json:"tone,omitempty"`        // Such as friendly or professional, for LLM providers
+	Context    string `json:"context,omitempty"`     // Where the text appears, such as a sentence or screen name
+	Domain     string `json:"domain,omitempty"`      // Such as medical or legal, selecting a configured model, terminology or prompt
+
+	// Cache control
+	CacheTTLSeconds int  `json:"cache_ttl_seconds,omitempty"` // Overrides the cache TTL, within the service's bounds
+	NoCache         bool `json:"no_cache,omitempty"`          // Skip the cache lookup
+	NoStore         bool `json:"no_store,omitempty"`          // Don't cache the result
+
+	// Detect the languages an auto-detected text may also be in
+	DetectAlternatives bool `json:"detect_alternatives,omitempty"`
+	// Check the translation by translating it back
+	Verify bool `json:"verify,omitempty"`
+	// Queue the translation for a human reviewer
+	Review bool `json:"review,omitempty"`
+}
+
+// Response is a translated text
+type Response struct {
+	TranslatedText string       `json:"translated_text"`
+	SourceLang     string       `json:"source_lang"`
+	TargetLang     string       `json:"target_lang"`
+	CacheHit       bool         `json:"cache_hit"`
+	Provider       string       `json:"provider"`          // Provider that produced the translation
+	Memory         *MemoryMatch `json:"memory,omitempty"`  // Translation memory entry the translation was served from
+	Skipped        bool         `json:"skipped,omitempty"` // The text was returned as is, without translating it
+	SkipReason     string       `json:"skip_reason,omitempty"`
+
+	// Auto-detection of the source language, as reported by the provider
+	DetectionConfidence   float64            `json:"detection_confidence,omitempty"`   // From 0 to 1
+	DetectionAlternatives []DetectedLanguage `json:"detection_alternatives,omitempty"` // Other likely languages, most likely first
+
+	Verification *Verification `json:"verification,omitempty"` // Back-translation check, with Verify
+	Moderation   *Moderation   `json:"moderation,omitempty"`   // What moderation found in the text or translation
+
+	Characters        int     `json:"characters"`          // Characters of the text
+	BilledCharacters  int     `json:"billed_characters"`   // Characters sent to the provider, zero when none was called
+	ProviderLatencyMs int64   `json:"provider_latency_ms"` // Time spent waiting for the provider
+	EstimatedCost     float64 `json:"estimated_cost"`      // Cost of the billed characters at the server's prices
+}
+
+// DetectedLanguage is a language a text may be in
+type DetectedLanguage struct {
+	Language   string  `json:"language"`
+	Confidence float64 `json:"confidence,omitempty"` // From 0 to 1, left out when the provider gives none
+}
+
+// Verification is the back-translation check of a translation
+type Verification struct {
+	BackTranslation string  `json:"back_translation"`       // The translation translated back into the source language
+	Similarity      float64 `json:"similarity"`             // Of the back-translation to the text, from 0 to 1
+	RetriedWith     string  `json:"retried_with,omitempty"` // Provider the text was translated again with, for scoring too low
+}
+
+// Moderation reports what the moderation stages found in a translation
+type Moderation struct {
+	Input  *ModerationResult `json:"input,omitempty"`  // Of the text
+	Output *ModerationResult `json:"output,omitempty"` // Of the translation
+}
+
+// ModerationResult is what a moderation stage found in a text
+type ModerationResult struct {
+	Categories []string `json:"categories"`       // What the text was flagged for, profanity for wordlist matches
+	Masked     int      `json:"masked,omitempty"` // Wordlist matches replaced with asterisks
+}
+
+// MemoryMatch describes the translation memory entry a translation was
+// served from
+type MemoryMatch struct {
+	Provenance string    `json:"provenance"`
+	Version    int       `json:"version"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Pinned     bool      `json:"pinned,omitempty"`
+	Score      float64   `json:"score"`                 // Similarity of the entry's source text, 1 for exact matches
+	SourceText string    `json:"source_text,omitempty"` // Of fuzzy matches
+}
+
+// Codes of Error, to tell error causes apart
+const (
+	CodeInvalidRequest       = "INVALID_REQUEST"
+	CodeInvalidLang          = "INVALID_LANG"
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeForbidden            = "FORBIDDEN"
+	CodeLanguagePairDenied   = "LANGUAGE_PAIR_DENIED"
+	CodeNotFound             = "NOT_FOUND"
+	CodeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	CodeConflict             = "CONFLICT"
+	CodeContentRejected      = "CONTENT_REJECTED"
+	CodePayloadTooLarge      = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeQuotaExceeded        = "QUOTA_EXCEEDED"
+	CodeProviderError        = "PROVIDER_ERROR"
+	CodeProviderUnavailable  = "PROVIDER_UNAVAILABLE"
+	CodeFetchFailed          = "FETCH_FAILED"
+	CodeServiceUnavailable   = "SERVICE_UNAVAILABLE"
+	CodeNotImplemented       = "NOT_IMPLEMENTED"
+	CodeInternalError        = "INTERNAL_ERROR"
+)
+
+// Error is a non-2xx response from the service
+type Error struct {
+	StatusCode int
+	Code       string // One of the Code constants, empty if the response had no error body
+	Message    string
+	Details    json.RawMessage // Depends on Code, such as the violations of CodeInvalidRequest bodies
+	RequestID  string          // The request's ID in the service's logs
+	RetryAfter time.Duration   // From the Retry-After header, zero when absent
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("translation service returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("translation service returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Options configures a Client. The zero value sends unauthenticated
+// requests with the default retries.
+type Options struct {
+	APIKey     string // API key or JWT, sent as a bearer token
+	SigningKey string // Signs every request with this secret instead of sending APIKey
+
+	HTTPClient *http.Client // Defaults to a client with a 30s timeout
+
+	MaxAttempts    int           // Total attempts per request, including the first; defaults to 3, 1 disables retries
+	InitialBackoff time.Duration // Backoff before the first retry, doubled for each one after; defaults to 200ms
+	MaxBackoff     time.Duration // Upper bound for the backoff and for Retry-After waits; defaults to 5s
+
+	Concurrency int // Requests in flight at once in TranslateAll; defaults to 4
+
+	MessagePack bool // Send and accept MessagePack instead of JSON bodies in Translate and TranslateAll
+	GzipBodies  bool // Gzip request bodies, for large documents over slow links; responses are decompressed either way
+}
+
+// Client calls the translation service. It is safe for concurrent use.
+type Client struct {
+	baseURL     string
+	options     Options
+	translation codec // Of Translate
+}
+
+// codec encodes request bodies and decodes response bodies of a media type
+type codec struct {
+	mediaType string
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+var jsonCodec = codec{"application/json", json.Marshal, json.Unmarshal}
+
+// msgpackCodec encodes by the json tags, like the service
+var msgpackCodec = codec{
+	mediaType: "application/msgpack",
+	marshal: func(v interface{}) ([]byte, error) {
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		err := enc.Encode(v)
+		return buf.Bytes(), err
+	},
+	unmarshal: func(data []byte, v interface{}) error {
+		dec := msgpack.NewDecoder(bytes.NewReader(data))
+		dec.SetCustomStructTag("json")
+		return dec.Decode(v)
+	},
+}
+
+// New returns a client for the service at baseURL, such as
+// http://localhost:8080
+func New(baseURL string, options Options) *Client {
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 3
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = 200 * time.Millisecond
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = 5 * time.Second
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 4
+	}
+	c := &Client{baseURL: strings.TrimRight(baseURL, "/"), options: options, translation: jsonCodec}
+	if options.MessagePack {
+		c.translation = msgpackCodec
+	}
+	return c
+}
+
+// Translate translates a text
+func (c *Client) Translate(ctx context.Context, req Request) (*Response, error) {
+	var resp Response
+	if err := c.do(ctx, c.translation, http.MethodPost, "/translate", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// idempotencyKey is the context key of WithIdempotencyKey
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a context whose requests, including their
+// retries, are sent with key as the Idempotency-Key header, so the service
+// performs admin mutations and job submissions once however often they are
+// retried
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// do sends body, if not nil, to path and decodes the response into out,
+// both with codec, retrying transient failures
+func (c *Client) do(ctx context.Context, codec codec, method, path string, body, out interface{}) error {
+	var payload []byte
+	var err error
+	if body != nil {
+		if payload, err = codec.marshal(body); err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = c.send(ctx, codec, method, path, payload, out)
+		if err == nil || attempt >= c.options.MaxAttempts || !retryable(ctx, err) {
+			return err
+		}
+
+		// Honour Retry-After, but give up rather than wait out a quota
+		// that resets in hours
+		delay := c.backoff(attempt)
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			if apiErr.RetryAfter > c.options.MaxBackoff {
+				return err
+			}
+			delay = apiErr.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// send makes a single attempt. Signed requests get a fresh timestamp and
+// signature each time, as the service accepts every signature once.
+func (c *Client) send(ctx context.Context, codec codec, method, path string, payload []byte, out interface{}) error {
+	// Signatures cover the uncompressed body
+	body, compressed := payload, c.options.GzipBodies && len(payload) > 0
+	if compressed {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(payload)
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("failed to compress request: %v", err)
+		}
+		body = buf.Bytes()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	if compressed {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	httpReq.Header.Set("Content-Type", codec.mediaType)
+	httpReq.Header.Set("Accept", codec.mediaType)
+	if key, _ := ctx.Value(idempotencyKey{}).(string); key != "" {
+		httpReq.Header.Set("Idempotency-Key", key)
+	}
+	switch {
+	case c.options.SigningKey != "":
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		httpReq.Header.Set("X-Timestamp", timestamp)
+		httpReq.Header.Set("X-Signature", "sha256="+hex.EncodeToString(sign(c.options.SigningKey, timestamp, payload)))
+	case c.options.APIKey != "":
+		httpReq.Header.Set("Authorization", "Bearer "+c.options.APIKey)
+	}
+
+	resp, err := c.options.HTTPClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newError(resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err == nil {
+		err = codec.unmarshal(data, out)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// newError returns the Error of a non-2xx response, decoding its error
+// body. Responses without one, such as from a proxy, keep their body as
+// the message.
+func newError(resp *http.Response) *Error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	var envelope struct {
+		Code      string          `json:"code"`
+		Message   string          `json:"message"`
+		Details   json.RawMessage `json:"details"`
+		RequestID string          `json:"request_id"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Code != "" {
+		apiErr.Code, apiErr.Message, apiErr.Details, apiErr.RequestID = envelope.Code, envelope.Message, envelope.Details, envelope.RequestID
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
+
+// sign returns the HMAC-SHA256 of "<timestamp>.<body>" under secret, the
+// signature the service expects in X-Signature
+func sign(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// backoff returns the full-jitter delay before retry number attempt
+// (1-based)
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.options.InitialBackoff << (attempt - 1)
+	if d <= 0 || d > c.options.MaxBackoff {
+		d = c.options.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// retryable reports whether an attempt is worth repeating: network errors,
+// 429 and 5xx responses other than 501. Nothing is retried once ctx is done.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests ||
+			apiErr.StatusCode >= 500 && apiErr.StatusCode != http.StatusNotImplemented
+	}
+	// Failures to connect or to get a response; encoding and decoding
+	// errors aren't going to change
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// parseRetryAfter parses a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
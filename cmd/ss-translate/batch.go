@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dphase/ss-translate/client"
+)
+
+// batchCommand translates the text column of a CSV file. The output has
+// the input's columns followed by translated_text and error; rows that
+// fail are written with their error and make the command exit non-zero
+// once every row is done.
+func batchCommand(args []string) error {
+	var conn connection
+	fs := newFlagSet("batch", "batch -f FILE.csv [-to LANG] [flags]")
+	conn.register(fs)
+	file := fs.String("f", "", "CSV file with a header row and a text column, - for stdin (required)")
+	output := fs.String("o", "-", "Output CSV file, - for stdout")
+	from := fs.String("from", "", "Source language of rows without a source_lang column, detected when empty")
+	to := fs.String("to", "", "Target language of rows without a target_lang column")
+	format := fs.String("format", "", "text (default) or html")
+	concurrency := fs.Int("concurrency", 4, "Rows translated at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		fs.Usage()
+		return errors.New("-f is required")
+	}
+	if *concurrency < 1 {
+		return errors.New("-concurrency must be at least 1")
+	}
+
+	rows, err := readCSV(*file)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("%s has no header row", *file)
+	}
+	header := rows[0]
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	textColumn, ok := columns["text"]
+	if !ok {
+		return fmt.Errorf("%s has no text column", *file)
+	}
+	column := func(row []string, name, fallback string) string {
+		if i, ok := columns[name]; ok && i < len(row) && row[i] != "" {
+			return row[i]
+		}
+		return fallback
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	b, err := conn.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	// Rows fail individually, so every row gets translated
+	results := make([][2]string, len(rows)-1)
+	var failed atomic.Int64
+	var g errgroup.Group
+	g.SetLimit(*concurrency)
+	for i, row := range rows[1:] {
+		i, row := i, row
+		g.Go(func() error {
+			req := client.Request{
+				SourceLang: column(row, "source_lang", *from),
+				TargetLang: column(row, "target_lang", *to),
+				Format:     *format,
+			}
+			if textColumn < len(row) {
+				req.Text = row[textColumn]
+			}
+			if req.Text == "" {
+				return nil
+			}
+			resp, err := b.Translate(ctx, req)
+			if err != nil {
+				failed.Add(1)
+				results[i][1] = err.Error()
+				return nil
+			}
+			results[i][0] = resp.TranslatedText
+			return nil
+		})
+	}
+	g.Wait()
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			return fmt.Errorf("failed to create output: %v", err)
+		}
+		defer out.Close()
+	}
+	w := csv.NewWriter(out)
+	w.Write(append(header, "translated_text", "error"))
+	for i, row := range rows[1:] {
+		w.Write(append(row, results[i][0], results[i][1]))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write output: %v", err)
+	}
+
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("%d of %d rows failed", n, len(rows)-1)
+	}
+	return nil
+}
+
+// readCSV reads every row of the CSV file at path, or stdin for -
+func readCSV(path string) ([][]string, error) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return rows, nil
+}
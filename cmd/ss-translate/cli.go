@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	translate "github.com/dphase/ss-translate"
+	"github.com/dphase/ss-translate/client"
+)
+
+const usage = `Usage: ss-translate [command] [flags]
+
+Commands:
+  serve                          Run the service (the default)
+  translate -to LANG [text]      Translate text, or stdin
+  detect [text]                  Detect the language of text, or stdin
+  batch -f FILE.csv [-to LANG]   Translate the text column of a CSV file
+  cache purge [selector]         Purge cached translations from the service
+
+The commands other than serve talk to the service at -server
+(TRANSLATE_SERVER), authenticated with -api-key (TRANSLATE_API_KEY), or
+without -server directly to the providers configured like the service, from
+the environment and -config. Run a command with -h for its flags.
+`
+
+// backend is what the commands translate with: the service over HTTP, or
+// the configured providers directly
+type backend interface {
+	Translate(ctx context.Context, req client.Request) (*client.Response, error)
+	Detect(ctx context.Context, text string) (*translate.Detection, error)
+	Close()
+}
+
+// connection holds the flags selecting the backend
+type connection struct {
+	server string
+	apiKey string
+	config string
+}
+
+// register adds the connection flags to fs
+func (c *connection) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.server, "server", os.Getenv("TRANSLATE_SERVER"), "URL of the service, instead of calling the providers directly")
+	fs.StringVar(&c.apiKey, "api-key", os.Getenv("TRANSLATE_API_KEY"), "API key or JWT for -server, the admin token for cache purge")
+	fs.StringVar(&c.config, "config", os.Getenv("CONFIG_FILE"), "Config file, without -server")
+}
+
+// client returns the client of the service at -server
+func (c *connection) client() *client.Client {
+	return client.New(c.server, client.Options{APIKey: c.apiKey})
+}
+
+// open returns the backend selected by the flags
+func (c *connection) open(ctx context.Context) (backend, error) {
+	if c.server != "" {
+		return remoteBackend{c.client()}, nil
+	}
+
+	// Only warnings and errors, so they don't drown the output
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	config, err := translate.LoadConfig(c.config)
+	if err != nil {
+		return nil, err
+	}
+	service, err := translate.New(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return localBackend{service}, nil
+}
+
+// remoteBackend translates with the service
+type remoteBackend struct {
+	*client.Client
+}
+
+// Detect isn't part of the HTTP API
+func (remoteBackend) Detect(context.Context, string) (*translate.Detection, error) {
+	return nil, errors.New("language detection calls the providers directly, run it without -server")
+}
+
+func (remoteBackend) Close() {}
+
+// localBackend translates with the providers, through the embedded service
+type localBackend struct {
+	service *translate.Service
+}
+
+func (b localBackend) Translate(ctx context.Context, req client.Request) (*client.Response, error) {
+	resp, err := b.service.Translate(ctx, translate.Request{
+		Text:            req.Text,
+		SourceLang:      req.SourceLang,
+		TargetLang:      req.TargetLang,
+		Format:          req.Format,
+		Formality:       req.Formality,
+		Tone:            req.Tone,
+		Context:         req.Context,
+		Domain:          req.Domain,
+		CacheTTLSeconds: req.CacheTTLSeconds,
+		NoCache:         req.NoCache,
+		NoStore:         req.NoStore,
+
+		DetectAlternatives: req.DetectAlternatives,
+		Verify:             req.Verify,
+		Review:             req.Review,
+	})
+	if err != nil {
+		return nil, err
+	}
+	response := &client.Response{
+		TranslatedText: resp.TranslatedText,
+		SourceLang:     resp.SourceLang,
+		TargetLang:     resp.TargetLang,
+		CacheHit:       resp.CacheHit,
+		Provider:       resp.Provider,
+		Skipped:        resp.Skipped,
+		SkipReason:     resp.SkipReason,
+
+		DetectionConfidence: resp.DetectionConfidence,
+
+		Characters:        resp.Characters,
+		BilledCharacters:  resp.BilledCharacters,
+		ProviderLatencyMs: resp.ProviderLatencyMs,
+		EstimatedCost:     resp.EstimatedCost,
+	}
+	for _, d := range resp.DetectionAlternatives {
+		response.DetectionAlternatives = append(response.DetectionAlternatives, client.DetectedLanguage{Language: d.Language, Confidence: d.Confidence})
+	}
+	if v := resp.Verification; v != nil {
+		response.Verification = &client.Verification{BackTranslation: v.BackTranslation, Similarity: v.Similarity, RetriedWith: v.RetriedWith}
+	}
+	if m := resp.Moderation; m != nil {
+		response.Moderation = &client.Moderation{}
+		if m.Input != nil {
+			response.Moderation.Input = &client.ModerationResult{Categories: m.Input.Categories, Masked: m.Input.Masked}
+		}
+		if m.Output != nil {
+			response.Moderation.Output = &client.ModerationResult{Categories: m.Output.Categories, Masked: m.Output.Masked}
+		}
+	}
+	if m := resp.Memory; m != nil {
+		response.Memory = &client.MemoryMatch{
+			Provenance: m.Provenance,
+			Version:    m.Version,
+			UpdatedAt:  m.UpdatedAt,
+			Pinned:     m.Pinned,
+			Score:      m.Score,
+			SourceText: m.SourceText,
+		}
+	}
+	return response, nil
+}
+
+func (b localBackend) Detect(ctx context.Context, text string) (*translate.Detection, error) {
+	return b.service.Detect(ctx, text)
+}
+
+func (b localBackend) Close() {
+	b.service.Close(context.Background())
+}
+
+// newFlagSet returns the flag set of a command, printing its usage to
+// stderr on errors and -h
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ss-translate %s\n\nFlags:\n", synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// commandContext returns a context cancelled on SIGINT/SIGTERM
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+// inputText returns the arguments joined with spaces, or stdin without
+// arguments
+func inputText(args []string) (string, error) {
+	if len(args) > 0 {
+		return strings.Join(args, " "), nil
+	}
+	text, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stdin: %v", err)
+	}
+	return strings.TrimRight(string(text), "\n"), nil
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// translateCommand translates its arguments or stdin
+func translateCommand(args []string) error {
+	var conn connection
+	fs := newFlagSet("translate", "translate -to LANG [flags] [text]")
+	conn.register(fs)
+	from := fs.String("from", "", "Source language, detected when empty")
+	to := fs.String("to", "", "Target language (required)")
+	format := fs.String("format", "", "text (default) or html")
+	formality := fs.String("formality", "", "formal or informal, where the provider supports it")
+	tone := fs.String("tone", "", "Tone, such as friendly or professional, for LLM providers")
+	textContext := fs.String("context", "", "Where the text appears, such as a sentence or screen name")
+	domain := fs.String("domain", "", "Domain, such as medical or legal, selecting a configured model, terminology or prompt")
+	noCache := fs.Bool("no-cache", false, "Skip the cache lookup")
+	alternatives := fs.Bool("alternatives", false, "Detect the other languages an auto-detected text may be in, shown with -json")
+	verify := fs.Bool("verify", false, "Check the translation by translating it back, shown with -json")
+	review := fs.Bool("review", false, "Queue the translation for a human reviewer")
+	asJSON := fs.Bool("json", false, "Print the whole response as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" {
+		fs.Usage()
+		return errors.New("-to is required")
+	}
+	text, err := inputText(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	b, err := conn.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	resp, err := b.Translate(ctx, client.Request{
+		Text:               text,
+		SourceLang:         *from,
+		TargetLang:         *to,
+		Format:             *format,
+		Formality:          *formality,
+		Tone:               *tone,
+		Context:            *textContext,
+		Domain:             *domain,
+		NoCache:            *noCache,
+		DetectAlternatives: *alternatives,
+		Verify:             *verify,
+		Review:             *review,
+	})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(resp)
+	}
+	fmt.Println(resp.TranslatedText)
+	return nil
+}
+
+// detectCommand detects the language of its arguments or stdin
+func detectCommand(args []string) error {
+	var conn connection
+	fs := newFlagSet("detect", "detect [flags] [text]")
+	conn.register(fs)
+	asJSON := fs.Bool("json", false, "Print the language and confidence as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	text, err := inputText(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	b, err := conn.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	detection, err := b.Detect(ctx, text)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		alternatives := make([]map[string]interface{}, 0, len(detection.Alternatives))
+		for _, a := range detection.Alternatives {
+			alternatives = append(alternatives, map[string]interface{}{"language": a.Language, "confidence": a.Confidence})
+		}
+		return printJSON(map[string]interface{}{"language": detection.Language, "confidence": detection.Confidence,
+			"alternatives": alternatives})
+	}
+	fmt.Printf("%s\t%.2f\n", detection.Language, detection.Confidence)
+	return nil
+}
+
+// cacheCommand runs a cache subcommand: purge, through the service's admin
+// API
+func cacheCommand(args []string) error {
+	if len(args) == 0 || args[0] != "purge" {
+		return errors.New("usage: ss-translate cache purge [flags]")
+	}
+
+	var conn connection
+	fs := newFlagSet("cache purge", "cache purge -server URL -api-key ADMIN_TOKEN (-all | -source LANG -target LANG | -key KEY | -hash SHA256)")
+	conn.register(fs)
+	fs.Bool("all", false, "Purge every cached translation")
+	fs.String("source", "", "Purge a source language; empty matches auto-detected entries")
+	fs.String("target", "", "Purge a target language")
+	fs.String("key", "", "Purge one exact cache key")
+	fs.String("hash", "", "Purge one text, by its hex SHA-256, in every language pair")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if conn.server == "" {
+		return errors.New("-server is required, the cache belongs to the service")
+	}
+
+	// Only the flags given select entries, so -source= can match
+	// auto-detected ones
+	query := url.Values{}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "all", "source", "target", "key", "hash":
+			query.Set(f.Name, f.Value.String())
+		}
+	})
+	if len(query) == 0 {
+		fs.Usage()
+		return errors.New("one of -all, -source/-target, -key or -hash is required")
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	purge, err := conn.client().PurgeCache(ctx, query)
+	if err != nil {
+		return err
+	}
+	if purge.Pattern != "" {
+		fmt.Printf("deleted %d keys matching %s\n", purge.Deleted, purge.Pattern)
+	} else {
+		fmt.Printf("deleted %d keys\n", purge.Deleted)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Command ss-translate runs the translation service, configured from the
+// environment and an optional config file passed with -config. Its
+// subcommands translate from the terminal, see usage.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/dphase/ss-translate/internal/api"
+)
+
+func main() {
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		serve(args)
+		return
+	case "translate":
+		err = translateCommand(args)
+	case "detect":
+		err = detectCommand(args)
+	case "batch":
+		err = batchCommand(args)
+	case "cache":
+		err = cacheCommand(args)
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "ss-translate %s: %v\n", command, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// serve runs the service until SIGINT or SIGTERM
+func serve(args []string) {
+	// Settings from a config file apply where the environment doesn't set
+	// them
+	if err := api.LoadConfigFile(api.ConfigFilePath(args)); err != nil {
+		fatal("failed to load config file", "error", err)
+	}
+	config := api.ReadConfig()
+	api.SetupLogging(&config)
+	if errs := api.ValidateConfig(&config); len(errs) > 0 {
+		fatal("invalid configuration", "errors", errs)
+	}
+
+	// Stop accepting requests on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	s, err := api.NewServer(ctx, config)
+	if err != nil {
+		fatal("failed to set up the service", "error", err)
+	}
+
+	newHTTPServer := func(port string, handler http.Handler) *http.Server {
+		return &http.Server{
+			Addr:              ":" + port,
+			Handler:           handler,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			ReadTimeout:       config.ReadTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+		}
+	}
+	httpServers := []*http.Server{newHTTPServer(config.ServerPort, s.Handler())}
+	if proxy := s.ProxyHandler(); proxy != nil {
+		httpServers = append(httpServers, newHTTPServer(config.ProxyPort, proxy))
+	}
+	if s.TLSEnabled() {
+		tlsConfig, err := s.TLSConfig()
+		if err != nil {
+			fatal("invalid TLS configuration", "error", err)
+		}
+		for _, httpServer := range httpServers {
+			httpServer.TLSConfig = tlsConfig
+		}
+	}
+
+	// Process asynchronous jobs until shutdown
+	s.Start(ctx)
+	s.ReloadOnSignal(ctx)
+
+	// Start servers
+	serverErr := make(chan error, len(httpServers))
+	for _, httpServer := range httpServers {
+		httpServer := httpServer
+		go func() {
+			if s.TLSEnabled() {
+				// Certificates come from the TLSConfig
+				serverErr <- httpServer.ListenAndServeTLS("", "")
+				return
+			}
+			serverErr <- httpServer.ListenAndServe()
+		}()
+	}
+	slog.Info("translation service started", "port", config.ServerPort, "tls", s.TLSEnabled(), "version", api.Version)
+	if len(httpServers) > 1 {
+		slog.Info("reverse proxy started", "port", config.ProxyPort, "upstream", config.ProxyUpstream)
+	}
+
+	select {
+	case err := <-serverErr:
+		fatal("server failed to start", "error", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Drain in-flight requests, then release clients
+	slog.Info("shutting down", "grace_period", config.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	for _, httpServer := range httpServers {
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to drain in-flight requests", "addr", httpServer.Addr, "error", err)
+		}
+	}
+	s.Close(shutdownCtx)
+	slog.Info("shutdown complete")
+}
+
+// fatal logs msg at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
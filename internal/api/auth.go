@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// anonymousKeyName identifies requests when no API keys are configured
+const anonymousKeyName = "anonymous"
+
+// apiKeyContextKey is the context key holding the authenticated key's name
+type apiKeyContextKey struct{}
+
+// parseAPIKeys parses a comma-separated list of name:key pairs
+func parseAPIKeys(value string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		name, key, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || name == "" || key == "" {
+			if entry != "" {
+				slog.Warn("ignoring malformed key entry, expected name:key")
+			}
+			continue
+		}
+		keys[name] = key
+	}
+	return keys
+}
+
+// requestAPIKey extracts the API key from the Authorization: Bearer or
+// X-API-Key header
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// acceptQueryAPIKey takes the API key of a request without one in its
+// headers from its api_key query parameter, for the browser clients of
+// streaming endpoints, which can't set headers
+func acceptQueryAPIKey(r *http.Request) {
+	if key := r.URL.Query().Get("api_key"); key != "" && requestAPIKey(r) == "" {
+		r.Header.Set("X-API-Key", key)
+	}
+}
+
+// authenticateRequest authenticates the caller of an API request and
+// returns a copy of its context carrying the caller's name, tenant and
+// priority. The
+// caller is named by its key (the key ID for managed keys), found among the
+// configured keys, then the managed keys in Redis, by the signing key of a
+// signed request, or by the subject of its JWT. When neither static keys,
+// signing keys, JWTs nor an admin token are configured authentication is
+// disabled and every request is anonymous.
+func (s *Server) authenticateRequest(r *http.Request) (context.Context, bool) {
+	ctx := withRequestPriority(r.Context(), r)
+	if len(s.config.APIKeys) == 0 && len(s.config.SigningKeys) == 0 && s.config.AdminToken == "" && !s.jwtEnabled() {
+		return s.withAPIKeyName(ctx, anonymousKeyName, ""), true
+	}
+	if len(s.config.SigningKeys) > 0 && signedRequest(r) {
+		name, ok := s.authenticateSignature(r)
+		if !ok {
+			return nil, false
+		}
+		return s.withAPIKeyName(ctx, name, s.keyTenant(ctx, name)), true
+	}
+
+	token := requestAPIKey(r)
+	if token == "" {
+		return nil, false
+	}
+	if s.jwtEnabled() && looksLikeJWT(token) {
+		name, tenant, ok := s.authenticateJWT(r, token)
+		if !ok {
+			return nil, false
+		}
+		return s.withAPIKeyName(ctx, name, tenant), true
+	}
+
+	// Compare against every key so timing doesn't reveal which one matched
+	var matched string
+	for name, key := range s.config.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			matched = name
+		}
+	}
+	if matched != "" {
+		return s.withAPIKeyName(ctx, matched, s.keyTenant(ctx, matched)), true
+	}
+
+	id, err := s.lookupAPIKey(ctx, token)
+	if err != nil {
+		logger(ctx).Error("failed to look up API key", "error", err)
+		return nil, false
+	}
+	if id == "" {
+		return nil, false
+	}
+	return s.withAPIKeyName(ctx, id, s.keyTenant(ctx, id)), true
+}
+
+// authenticateAdmin validates the admin token, or a JWT granting
+// JWT_ADMIN_SCOPE, of a request to an /admin endpoint, writing the error
+// response and returning false on failure
+func (s *Server) authenticateAdmin(w http.ResponseWriter, r *http.Request) bool {
+	jwtAdmin := s.jwtEnabled() && s.config.JWTAdminScope != ""
+	if s.config.AdminToken == "" && !jwtAdmin {
+		writeError(w, http.StatusForbidden, codeForbidden, "Admin API is disabled")
+		return false
+	}
+	token := requestAPIKey(r)
+	if jwtAdmin && looksLikeJWT(token) {
+		claims, err := s.validateJWT(r.Context(), token)
+		if err == nil && claims.hasScope(s.config.JWTAdminScope) {
+			logger(r.Context()).Info("admin request", "subject", claims.subject)
+			return true
+		}
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: Invalid admin token")
+		logger(r.Context()).Warn("unauthorized admin request", "remote_addr", s.clientIP(r), "error", err)
+		return false
+	}
+	if s.config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: Invalid admin token")
+		logger(r.Context()).Warn("unauthorized admin request", "remote_addr", s.clientIP(r))
+		return false
+	}
+	return true
+}
+
+// withAPIKeyName returns a copy of ctx carrying the authenticated caller's
+// name and tenant, if any, which are also added to the request's log lines
+func (s *Server) withAPIKeyName(ctx context.Context, name, tenant string) context.Context {
+	if info := getRequestInfo(ctx); info != nil {
+		info.KeyID = name
+	}
+	return s.withTenant(context.WithValue(ctx, apiKeyContextKey{}, name), tenant)
+}
+
+// apiKeyName returns the authenticated key's name from ctx, if any
+func apiKeyName(ctx context.Context) string {
+	name, _ := ctx.Value(apiKeyContextKey{}).(string)
+	return name
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dphase/ss-translate/internal/provider"
+	"github.com/go-redis/redis/v8"
+)
+
+// priorityContextKey is the context key marking low-priority translations
+type priorityContextKey struct{}
+
+// priorityHeader lets callers mark a request as low priority with "low"
+const priorityHeader = "X-Priority"
+
+// budgetError is returned without calling a provider whose character budget
+// for the current minute or day would be exceeded
+type budgetError struct {
+	provider   string
+	window     string        // minute or day
+	retryAfter time.Duration // Until the window resets
+}
+
+// Error implements error
+func (e *budgetError) Error() string {
+	return fmt.Sprintf("%s character budget per %s exhausted", e.provider, e.window)
+}
+
+// withRequestPriority returns a copy of ctx marked low priority when the
+// request asks for it
+func withRequestPriority(ctx context.Context, r *http.Request) context.Context {
+	if strings.EqualFold(strings.TrimSpace(r.Header.Get(priorityHeader)), "low") {
+		return withLowPriority(ctx)
+	}
+	return ctx
+}
+
+// withLowPriority returns a copy of ctx whose translations may only use the
+// share of provider budgets outside PROVIDER_BUDGET_RESERVE
+func withLowPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, priorityContextKey{}, true)
+}
+
+// lowPriority reports whether ctx's translations are low priority
+func lowPriority(ctx context.Context) bool {
+	low, _ := ctx.Value(priorityContextKey{}).(bool)
+	return low
+}
+
+// budgetWindow is a period a provider's characters are counted over
+type budgetWindow struct {
+	name   string
+	budget int64
+	key    string
+	resets time.Time
+}
+
+// budgetWindows returns the windows with a budget of the provider named
+// name at t
+func (s *Server) budgetWindows(name string, t time.Time) []budgetWindow {
+	t = t.UTC()
+	var windows []budgetWindow
+	if budget := s.config.ProviderMinuteBudgets[name]; budget > 0 {
+		windows = append(windows, budgetWindow{"minute", budget, "budget:chars:" + name + ":" + t.Format("200601021504"), t.Truncate(time.Minute).Add(time.Minute)})
+	}
+	if budget := s.config.ProviderDailyBudgets[name]; budget > 0 {
+		windows = append(windows, budgetWindow{"day", budget, "budget:chars:" + name + ":" + t.Format("20060102"), nextQuotaReset(t)})
+	}
+	return windows
+}
+
+// reserveBudget counts chars against the budgets of the provider named
+// name, failing with a *budgetError when that would exceed one. Low-priority
+// translations are held to the budgets less PROVIDER_BUDGET_RESERVE, keeping
+// the rest for interactive traffic. The returned function refunds the
+// characters of a call that failed. Budgets aren't enforced while Redis is
+// unavailable.
+func (s *Server) reserveBudget(ctx context.Context, name string, chars int) (func(), error) {
+	now := time.Now()
+	windows := s.budgetWindows(name, now)
+	if len(windows) == 0 || chars == 0 || !s.redisAvailable() {
+		return func() {}, nil
+	}
+
+	pipe := s.redis.TxPipeline()
+	used := make([]*redis.IntCmd, len(windows))
+	for i, window := range windows {
+		used[i] = pipe.IncrBy(ctx, window.key, int64(chars))
+		pipe.ExpireAt(ctx, window.key, window.resets.Add(time.Hour))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger(ctx).Warn("provider budget check failed, allowing request", "provider", name, "error", err)
+		return func() {}, nil
+	}
+	refund := func() {
+		pipe := s.redis.TxPipeline()
+		for _, window := range windows {
+			pipe.DecrBy(context.WithoutCancel(ctx), window.key, int64(chars))
+		}
+		if _, err := pipe.Exec(context.WithoutCancel(ctx)); err != nil {
+			logger(ctx).Warn("failed to refund provider budget", "provider", name, "error", err)
+		}
+	}
+
+	share := 1.0
+	priority := "normal"
+	if lowPriority(ctx) {
+		share = 1 - s.config.ProviderBudgetReserve
+		priority = "low"
+	}
+	for i, window := range windows {
+		if float64(used[i].Val()) <= float64(window.budget)*share {
+			continue
+		}
+		refund()
+		budgetRejections.WithLabelValues(name, window.name, priority).Inc()
+		logger(ctx).Warn("provider character budget exhausted", "provider", name, "window", window.name,
+			"budget", window.budget, "priority", priority)
+		return nil, &budgetError{provider: name, window: window.name, retryAfter: window.resets.Sub(now)}
+	}
+	return refund, nil
+}
+
+// budgetedProvider enforces the provider's character budgets, so requests
+// are turned away, or fail over, before the provider's own quota runs out
+type budgetedProvider struct {
+	provider.Wrapper
+	server *Server
+}
+
+// Translate implements Provider
+func (p *budgetedProvider) Translate(ctx context.Context, req provider.Request) (*provider.Result, error) {
+	refund, err := p.server.reserveBudget(ctx, p.Name(), utf8.RuneCountInString(req.Text))
+	if err != nil {
+		return nil, err
+	}
+	result, err := p.Provider.Translate(ctx, req)
+	if err != nil {
+		refund()
+	}
+	return result, err
+}
+
+// Detect implements Provider
+func (p *budgetedProvider) Detect(ctx context.Context, text string) (*provider.Detection, error) {
+	refund, err := p.server.reserveBudget(ctx, p.Name(), utf8.RuneCountInString(text))
+	if err != nil {
+		return nil, err
+	}
+	detection, err := p.Provider.Detect(ctx, text)
+	if err != nil {
+		refund()
+	}
+	return detection, err
+}
+
+// translateDeferred translates a low-priority request, waiting out
+// exhausted budgets that reset within PROVIDER_BUDGET_MAX_DEFER rather than
+// failing it
+func (s *Server) translateDeferred(ctx context.Context, req TranslationRequest) (*TranslationResponse, error) {
+	for {
+		response, err := s.translateText(ctx, req)
+		var budgetErr *budgetError
+		if !errors.As(err, &budgetErr) || budgetErr.retryAfter > s.config.ProviderBudgetMaxDefer {
+			return response, err
+		}
+		logger(ctx).Info("deferring translation until the provider budget resets", "provider", budgetErr.provider,
+			"delay", budgetErr.retryAfter.String())
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(budgetErr.retryAfter):
+		}
+	}
+}
+
+// parseProviderBudgets parses a comma-separated list of provider:characters
+// pairs
+func parseProviderBudgets(value string) map[string]int64 {
+	budgets := make(map[string]int64)
+	for _, entry := range strings.Split(value, ",") {
+		provider, budget, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(budget, 10, 64)
+		if err != nil {
+			slog.Warn("ignoring invalid provider budget", "provider", provider, "error", err)
+			continue
+		}
+		budgets[provider] = n
+	}
+	return budgets
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// dailyBudgetKey is the key today's characters of the fake provider are
+// counted at
+func dailyBudgetKey() string {
+	return "budget:chars:fake:" + time.Now().UTC().Format("20060102")
+}
+
+func TestReserveBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		minute     int64
+		day        int64
+		used       int64 // Of the day so far
+		chars      int
+		low        bool
+		wantWindow string // Of the budgetError, empty when allowed
+		wantUsed   int64  // Of the day afterwards
+	}{
+		{name: "within the budget", day: 100, chars: 50, wantUsed: 50},
+		{name: "up to the budget", day: 100, used: 60, chars: 40, wantUsed: 100},
+		{name: "over the budget", day: 100, used: 80, chars: 30, wantWindow: "day", wantUsed: 80},
+		{name: "low priority outside the reserve", day: 100, used: 70, chars: 10, low: true, wantUsed: 80},
+		{name: "low priority into the reserve", day: 100, used: 75, chars: 10, low: true, wantWindow: "day", wantUsed: 75},
+		{name: "over the minute budget", minute: 20, day: 100, chars: 25, wantWindow: "minute", wantUsed: 0},
+		{name: "no budget", chars: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mr := newTestServer(t, &fakeProvider{}, func(c *Config) {
+				c.ProviderMinuteBudgets = map[string]int64{"fake": tt.minute}
+				c.ProviderDailyBudgets = map[string]int64{"fake": tt.day}
+				c.ProviderBudgetReserve = 0.2
+			})
+			if tt.used > 0 {
+				mr.Set(dailyBudgetKey(), strconv.FormatInt(tt.used, 10))
+			}
+			ctx := context.Background()
+			if tt.low {
+				ctx = withLowPriority(ctx)
+			}
+
+			refund, err := s.reserveBudget(ctx, "fake", tt.chars)
+			if tt.wantWindow == "" {
+				if err != nil {
+					t.Fatalf("reserveBudget() error = %v", err)
+				}
+			} else {
+				var budgetErr *budgetError
+				if !errors.As(err, &budgetErr) {
+					t.Fatalf("reserveBudget() error = %v, want a budgetError", err)
+				}
+				if budgetErr.window != tt.wantWindow || budgetErr.retryAfter <= 0 {
+					t.Errorf("budgetError = %+v, want window %q and a retryAfter", budgetErr, tt.wantWindow)
+				}
+			}
+			if used := counter(t, mr, dailyBudgetKey()); used != tt.wantUsed {
+				t.Errorf("used = %d, want %d", used, tt.wantUsed)
+			}
+
+			if err == nil {
+				refund()
+				if used := counter(t, mr, dailyBudgetKey()); used != tt.used {
+					t.Errorf("used after the refund = %d, want %d", used, tt.used)
+				}
+			}
+		})
+	}
+}
+
+func TestBudgetedProvider(t *testing.T) {
+	failure := errors.New("unavailable")
+	tests := []struct {
+		name     string
+		err      error
+		wantUsed int64
+		// Whether a second call finds the budget exhausted, which it is
+		// once the first was charged
+		wantExhausted bool
+	}{
+		{"charges translations", nil, 5, true},
+		{"refunds failures", failure, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeProvider{err: tt.err}
+			s, mr := newTestServer(t, fake, func(c *Config) {
+				c.ProviderDailyBudgets = map[string]int64{"fake": 8}
+			})
+			p := &budgetedProvider{Wrapper: provider.Wrapper{Provider: fake}, server: s}
+			req := provider.Request{Text: "hello", TargetLang: "de"}
+
+			if _, err := p.Translate(context.Background(), req); err != tt.err {
+				t.Fatalf("Translate() error = %v, want %v", err, tt.err)
+			}
+			if used := counter(t, mr, dailyBudgetKey()); used != tt.wantUsed {
+				t.Errorf("used = %d, want %d", used, tt.wantUsed)
+			}
+
+			calls := len(fake.Requests())
+			_, err := p.Translate(context.Background(), req)
+			var budgetErr *budgetError
+			if exhausted := errors.As(err, &budgetErr); exhausted != tt.wantExhausted {
+				t.Fatalf("second Translate() error = %v, want the budget exhausted: %v", err, tt.wantExhausted)
+			}
+			if tt.wantExhausted && len(fake.Requests()) != calls {
+				t.Error("the provider was called with its budget exhausted")
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/cache"
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// cacheKeyPrefix prefixes every cached translation's key
+const cacheKeyPrefix = "translate:"
+
+// newCache creates the cache backend registered under name
+func (s *Server) newCache(name string) (cache.Cache, error) {
+	switch name {
+	case "redis":
+		return cache.NewRedis(s.redis, s.redisAvailable), nil
+	case "memory":
+		return cache.NewMemory(s.config.CacheMaxEntries), nil
+	case "none":
+		return cache.Noop{}, nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend: %q", name)
+	}
+}
+
+// cacheEntry is the cached value of a translation. The source text is kept
+// so a hit can be verified against the request.
+type cacheEntry struct {
+	SourceText string `json:"source_text"`
+	// StaleAt is when the entry should be refreshed, if stale-while-revalidate
+	// is enabled
+	StaleAt time.Time `json:"stale_at,omitempty"`
+	// ExpiresAt is when the entry leaves the cache, for refreshing popular
+	// entries before
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	TranslationResponse
+}
+
+// newCacheEntry creates the cache entry of a translation stored for ttl
+func (s *Server) newCacheEntry(req TranslationRequest, response *TranslationResponse, ttl time.Duration) cacheEntry {
+	entry := cacheEntry{SourceText: req.Text, ExpiresAt: time.Now().Add(ttl), TranslationResponse: *response}
+	entry.CacheHit = false
+	entry.Characters, entry.BilledCharacters, entry.ProviderLatencyMs, entry.EstimatedCost = 0, 0, 0, 0
+	if softTTL := s.currentConfig().CacheSoftTTL; softTTL > 0 && softTTL < ttl {
+		entry.StaleAt = time.Now().Add(softTTL)
+	}
+	return entry
+}
+
+// stale reports whether the entry is past its soft TTL
+func (e cacheEntry) stale(now time.Time) bool {
+	return !e.StaleAt.IsZero() && now.After(e.StaleAt)
+}
+
+// refreshInBackground retranslates a stale entry without blocking the
+// request serving it. Only one refresh per key runs at a time.
+func (s *Server) refreshInBackground(ctx context.Context, req TranslationRequest, key string, terms []glossaryTerm) {
+	if _, running := s.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	// Keep the request's values (logging, tracing) but not its cancellation
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.config.CacheRefreshTimeout)
+	go func() {
+		defer s.refreshing.Delete(key)
+		defer cancel()
+		if _, err := s.translateAndCache(ctx, req, key, terms); err != nil {
+			logger(ctx).Warn("failed to refresh stale cache entry", "cache_key", key, "error", err)
+		}
+	}()
+}
+
+// textHash returns the hex SHA-256 of a source text
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheKey returns the cache key of a request,
+// translate:<source>:<target>:<sha256(text)>, keeping keys short and free
+// of the text's newlines and colons. Formats other than plain text, the
+// formality and domain asked for, hashes of the tone and context and any
+// non-empty variants, such as an applied glossary, are added before the
+// hash, as in translate:<source>:<target>:html:formal:<sha256>.
+func cacheKey(req TranslationRequest, variants ...string) string {
+	key := fmt.Sprintf("%s%s:%s:", cacheKeyPrefix, req.SourceLang, req.TargetLang)
+	if req.Format != "" && req.Format != provider.FormatText {
+		key += req.Format + ":"
+	}
+	if req.Formality != "" {
+		key += req.Formality + ":"
+	}
+	if req.Tone != "" {
+		// Tones may contain spaces, which replacing would make "very formal"
+		// and "very-formal" share translations
+		key += "tone-" + textHash(req.Tone)[:16] + ":"
+	}
+	if req.Domain != "" {
+		key += "domain-" + req.Domain + ":"
+	}
+	if req.Context != "" {
+		// Contexts may be long and contain colons
+		key += "ctx-" + textHash(req.Context)[:16] + ":"
+	}
+	for _, variant := range variants {
+		if variant != "" {
+			key += variant + ":"
+		}
+	}
+	return key + textHash(req.Text)
+}
+
+// CacheTTLOverride sets the cache TTL of the translations whose cache key
+// matches Pattern, colon-separated segments compared with the key's after
+// its translate: prefix. A language pair such as en:es matches requests
+// from English to Spanish, auto standing for a detected source language and
+// * for any segment; longer patterns can also match formats and variants,
+// as in *:*:html.
+type CacheTTLOverride struct {
+	Pattern string
+	TTL     time.Duration
+}
+
+// matches reports whether the cache key matches the override's pattern
+func (o CacheTTLOverride) matches(key string) bool {
+	segments := strings.Split(strings.TrimPrefix(key, cacheKeyPrefix), ":")
+	pattern := strings.Split(o.Pattern, ":")
+	// The last segment is the text's hash
+	if len(pattern) > len(segments)-1 {
+		return false
+	}
+	for i, p := range pattern {
+		switch {
+		case p == "*":
+		case i == 0 && strings.EqualFold(p, "auto"):
+			if segments[0] != "" && !strings.EqualFold(segments[0], "auto") {
+				return false
+			}
+		case !strings.EqualFold(p, segments[i]):
+			return false
+		}
+	}
+	return true
+}
+
+// parseCacheTTLOverrides parses a comma-separated list of pattern=TTL pairs
+func parseCacheTTLOverrides(value string) []CacheTTLOverride {
+	var overrides []CacheTTLOverride
+	for _, entry := range strings.Split(value, ",") {
+		pattern, ttl, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || pattern == "" {
+			if entry != "" {
+				slog.Warn("ignoring malformed CACHE_TTL_OVERRIDES entry, expected pattern=ttl", "entry", entry)
+			}
+			continue
+		}
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			slog.Warn("ignoring invalid cache TTL override", "pattern", pattern, "ttl", ttl)
+			continue
+		}
+		overrides = append(overrides, CacheTTLOverride{Pattern: pattern, TTL: d})
+	}
+	return overrides
+}
+
+// cacheTTL returns how long a request's translation is cached under key:
+// the requested TTL clamped to the configured bounds, or else the first
+// matching override's or the server's TTL
+func (s *Server) cacheTTL(req TranslationRequest, key string) time.Duration {
+	c := s.currentConfig()
+	if req.CacheTTLSeconds == 0 {
+		for _, override := range c.CacheTTLOverrides {
+			if override.matches(key) {
+				return override.TTL
+			}
+		}
+		return c.TTL
+	}
+	ttl := time.Duration(req.CacheTTLSeconds) * time.Second
+	if ttl < c.CacheMinTTL {
+		return c.CacheMinTTL
+	}
+	if ttl > c.CacheMaxTTL {
+		return c.CacheMaxTTL
+	}
+	return ttl
+}
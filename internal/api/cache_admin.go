@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dphase/ss-translate/internal/cache"
+)
+
+// CachePurgeResponse is the body returned by DELETE /admin/cache
+type CachePurgeResponse struct {
+	Pattern string `json:"pattern,omitempty"`
+	Deleted int64  `json:"deleted"`
+}
+
+// cachePurgePattern builds the key pattern selected by a purge request's
+// query: a language pair (source and/or target), a text hash, or all=true.
+// An empty source matches auto-detected entries only when given explicitly.
+func cachePurgePattern(r *http.Request) (string, error) {
+	query := r.URL.Query()
+	_, hasSource := query["source"]
+	_, hasTarget := query["target"]
+	hash := query.Get("hash")
+
+	switch {
+	case query.Get("all") == "true":
+		return cacheKeyPrefix + "*", nil
+	case hash != "":
+		if len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+			return "", fmt.Errorf("hash must be a lowercase hex SHA-256")
+		}
+		return cacheKeyPrefix + "*:*:" + hash, nil
+	case hasSource || hasTarget:
+		source, target := "*", "*"
+		if hasSource {
+			source = escapeGlob(query.Get("source"))
+		}
+		if hasTarget {
+			target = escapeGlob(query.Get("target"))
+		}
+		return cacheKeyPrefix + source + ":" + target + ":*", nil
+	default:
+		return "", fmt.Errorf("one of key, source/target, hash or all=true is required")
+	}
+}
+
+// escapeGlob escapes Redis glob metacharacters in s
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(s)
+}
+
+// handleAdminCache purges cached translations:
+//
+//	DELETE /admin/cache?key=translate:en:es:<hash>  one exact key
+//	DELETE /admin/cache?source=en&target=es         a language pair
+//	DELETE /admin/cache?hash=<sha256>               a text in every language pair
+//	DELETE /admin/cache?all=true                    every cached translation
+func (s *Server) handleAdminCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	var response CachePurgeResponse
+	if key := r.URL.Query().Get("key"); key != "" {
+		if !strings.HasPrefix(key, cacheKeyPrefix) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Key must start with %q", cacheKeyPrefix))
+			return
+		}
+		n, err := s.cache.Del(ctx, key)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Cache purge failed: %v", err))
+			return
+		}
+		response.Deleted = n
+	} else {
+		deleter, ok := s.cache.(cache.PatternDeleter)
+		if !ok {
+			writeError(w, http.StatusNotImplemented, codeNotImplemented, "Cache backend does not support pattern purges")
+			return
+		}
+		pattern, err := cachePurgePattern(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		response.Pattern = pattern
+		response.Deleted, err = deleter.DeletePattern(ctx, pattern)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Cache purge failed after deleting %d keys: %v", response.Deleted, err))
+			return
+		}
+	}
+	logger(ctx).Info("purged cache", "pattern", response.Pattern, "deleted", response.Deleted)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// cacheNormalizationVersion is part of the cache key of normalized texts.
+// It must be bumped whenever what a normalization does changes, so entries
+// cached under the old rules are no longer served.
+const cacheNormalizationVersion = 1
+
+// cacheNormalizations are the normalizations CACHE_KEY_NORMALIZATION may
+// enable, in the order they are applied
+var cacheNormalizations = []struct {
+	name  string
+	apply func(string) string
+}{
+	{"nfc", norm.NFC.String},
+	{"whitespace", func(text string) string {
+		// Runs of whitespace other than line breaks become a single space
+		var b strings.Builder
+		space := false
+		for _, r := range text {
+			if unicode.IsSpace(r) && r != '\n' && r != '\r' {
+				if !space {
+					b.WriteByte(' ')
+				}
+				space = true
+				continue
+			}
+			b.WriteRune(r)
+			space = false
+		}
+		return b.String()
+	}},
+	{"trim", strings.TrimSpace},
+}
+
+// normalizeCacheText returns text as hashed into its cache key, with the
+// normalizations of CACHE_KEY_NORMALIZATION applied
+func (s *Server) normalizeCacheText(text string) string {
+	for _, n := range cacheNormalizations {
+		if s.cacheNormalization(n.name) {
+			text = n.apply(text)
+		}
+	}
+	return text
+}
+
+// cacheNormalization reports whether the named normalization is enabled
+func (s *Server) cacheNormalization(name string) bool {
+	for _, enabled := range s.config.CacheKeyNormalization {
+		if enabled == name {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheNormalizationVariant separates the cache keys of normalized texts by
+// the normalizations applied and their version, as norm1-nfc-trim
+func (s *Server) cacheNormalizationVariant() string {
+	variant := ""
+	for _, n := range cacheNormalizations {
+		if s.cacheNormalization(n.name) {
+			variant += "-" + n.name
+		}
+	}
+	if variant == "" {
+		return ""
+	}
+	return fmt.Sprintf("norm%d%s", cacheNormalizationVersion, variant)
+}
+
+// translationCacheKey returns the cache key of req's translation for the
+// caller of ctx, distinguishing tenants, translations a glossary changes
+// and normalized texts
+func (s *Server) translationCacheKey(ctx context.Context, req TranslationRequest, terms []glossaryTerm) string {
+	req.Text = s.normalizeCacheText(req.Text)
+	return cacheKey(req, tenantCacheVariant(ctx), glossaryCacheVariant(terms), s.cacheNormalizationVariant())
+}
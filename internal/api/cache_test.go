@@ -0,0 +1,28 @@
+package api
+
+import "testing"
+
+func TestCacheKeyKeepsOptionsApart(t *testing.T) {
+	base := TranslationRequest{Text: "Hello", SourceLang: "en", TargetLang: "de"}
+	with := func(change func(*TranslationRequest)) TranslationRequest {
+		req := base
+		change(&req)
+		return req
+	}
+	tests := []struct {
+		name string
+		a, b TranslationRequest
+	}{
+		{"tones with spaces and hyphens", with(func(r *TranslationRequest) { r.Tone = "very formal" }), with(func(r *TranslationRequest) { r.Tone = "very-formal" })},
+		{"tone and none", with(func(r *TranslationRequest) { r.Tone = "friendly" }), base},
+		{"contexts", with(func(r *TranslationRequest) { r.Context = "a river bank" }), with(func(r *TranslationRequest) { r.Context = "a savings bank" })},
+		{"formality", with(func(r *TranslationRequest) { r.Formality = "formal" }), base},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if a, b := cacheKey(tt.a), cacheKey(tt.b); a == b {
+				t.Errorf("cacheKey() = %q for both", a)
+			}
+		})
+	}
+}
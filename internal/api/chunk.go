@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dphase/ss-translate/internal/provider"
+	"github.com/rivo/uniseg"
+	"golang.org/x/sync/errgroup"
+)
+
+// TextLimiter is implemented by providers that limit the size of the text
+// in a single request
+type TextLimiter interface {
+	// MaxTextBytes returns the largest text, in UTF-8 bytes, the provider
+	// accepts in one request
+	MaxTextBytes() int
+}
+
+// splitText splits text into chunks of at most limit bytes, breaking at
+// sentence boundaries (Unicode UAX #29). Sentences longer than limit are
+// broken at whitespace, or anywhere as a last resort. In HTML, chunks only
+// break outside of tags, and sentences containing markup are never broken,
+// so chunks may exceed limit. Concatenating the chunks gives back text.
+func splitText(text string, limit int, html bool) []string {
+	var chunks []string
+	var current strings.Builder
+	inTag := false
+	state := -1
+	for rest := text; rest != ""; {
+		var sentence string
+		sentence, rest, state = uniseg.FirstSentenceInString(rest, state)
+		if current.Len() > 0 && current.Len()+len(sentence) > limit && !inTag {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		if current.Len() == 0 && len(sentence) > limit && !(html && strings.ContainsAny(sentence, "<>")) {
+			parts := splitLongText(sentence, limit)
+			chunks = append(chunks, parts[:len(parts)-1]...)
+			sentence = parts[len(parts)-1]
+		}
+		if html {
+			inTag = tagOpenAfter(sentence, inTag)
+		}
+		current.WriteString(sentence)
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// tagOpenAfter reports whether an HTML tag is still open after s, given
+// whether one was open before it
+func tagOpenAfter(s string, inTag bool) bool {
+	for _, r := range s {
+		switch r {
+		case '<':
+			inTag = true
+		case '>':
+			inTag = false
+		}
+	}
+	return inTag
+}
+
+// splitLongText breaks text into parts of at most limit bytes, preferring
+// to break after whitespace and never splitting a UTF-8 sequence
+func splitLongText(text string, limit int) []string {
+	var parts []string
+	for len(text) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if i := strings.LastIndexFunc(text[:cut], unicode.IsSpace); i > 0 {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			cut = i + size
+		}
+		if cut == 0 {
+			// A single rune wider than the limit
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+		parts = append(parts, text[:cut])
+		text = text[cut:]
+	}
+	return append(parts, text)
+}
+
+// splitSpace separates the leading and trailing whitespace of s, which
+// providers don't reliably preserve
+func splitSpace(s string) (lead, core, trail string) {
+	core = strings.TrimLeftFunc(s, unicode.IsSpace)
+	lead = s[:len(s)-len(core)]
+	trimmed := strings.TrimRightFunc(core, unicode.IsSpace)
+	return lead, trimmed, core[len(trimmed):]
+}
+
+// chunkingProvider splits texts over the provider's request limit into
+// chunks translated concurrently and reassembled in order
+type chunkingProvider struct {
+	provider.Wrapper
+	limit       int
+	concurrency int
+}
+
+// Translate implements Provider. When the source language is detected, the
+// first chunk is translated on its own so the remaining chunks use its
+// language, keeping the result consistent.
+func (p *chunkingProvider) Translate(ctx context.Context, req provider.Request) (*provider.Result, error) {
+	if len(req.Text) <= p.limit {
+		return p.Provider.Translate(ctx, req)
+	}
+
+	chunks := splitText(req.Text, p.limit, req.Format == provider.FormatHTML)
+	translated := make([]string, len(chunks))
+	logger(ctx).Debug("translating text in chunks", "provider", p.Name(), "bytes", len(req.Text), "chunks", len(chunks))
+
+	var first *provider.Result
+	translate := func(ctx context.Context, i int, req provider.Request) (*provider.Result, error) {
+		lead, core, trail := splitSpace(chunks[i])
+		if core == "" {
+			translated[i] = chunks[i]
+			return nil, nil
+		}
+		req.Text = core
+		result, err := p.Provider.Translate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		translated[i] = lead + result.TranslatedText + trail
+		return result, nil
+	}
+
+	start := 0
+	if req.SourceLang == "" {
+		for ; start < len(chunks) && first == nil; start++ {
+			result, err := translate(ctx, start, req)
+			if err != nil {
+				return nil, err
+			}
+			first = result
+		}
+		if first != nil {
+			req.SourceLang = first.SourceLang
+		}
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(max(p.concurrency, 1))
+	results := make([]*provider.Result, len(chunks))
+	for i := start; i < len(chunks); i++ {
+		i := i
+		group.Go(func() error {
+			result, err := translate(groupCtx, i, req)
+			results[i] = result
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	if first == nil {
+		for _, result := range results {
+			if result != nil {
+				first = result
+				break
+			}
+		}
+	}
+	combined := &provider.Result{TranslatedText: strings.Join(translated, ""), SourceLang: req.SourceLang}
+	if first != nil {
+		combined.Provider = first.Provider
+		if combined.SourceLang == "" {
+			combined.SourceLang = first.SourceLang
+		}
+	}
+	return combined, nil
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/dphase/ss-translate/translatepb"
+)
+
+// Media types of request and response bodies
+const (
+	mediaJSON     = "application/json"
+	mediaMsgPack  = "application/msgpack"
+	mediaProtobuf = "application/x-protobuf"
+)
+
+// mediaTypeAliases maps other names of the supported media types to theirs
+var mediaTypeAliases = map[string]string{
+	"application/x-msgpack":           mediaMsgPack,
+	"application/vnd.msgpack":         mediaMsgPack,
+	"application/protobuf":            mediaProtobuf,
+	"application/vnd.google.protobuf": mediaProtobuf,
+}
+
+// errUnsupportedMediaType is returned for protobuf bodies of operations that
+// have no protobuf message
+var errUnsupportedMediaType = errors.New("protobuf bodies are only accepted by POST /translate and POST /jobs")
+
+// protoRequests are the protobuf messages of the request bodies that can be
+// sent as protobuf, by method and path
+var protoRequests = map[string]func() proto.Message{
+	"POST /translate": func() proto.Message { return &translatepb.TranslationRequest{} },
+	"POST /jobs":      func() proto.Message { return &translatepb.CreateJobRequest{} },
+}
+
+// protoResponse returns the protobuf message of a response body, or nil for
+// responses only available as JSON and MessagePack
+func protoResponse(v interface{}) proto.Message {
+	switch v.(type) {
+	case *TranslationResponse:
+		return &translatepb.TranslationResponse{}
+	case *Job:
+		return &translatepb.Job{}
+	}
+	return nil
+}
+
+// mediaType returns the media type of a Content-Type or Accept entry, with
+// aliases resolved
+func mediaType(value string) (string, map[string]string) {
+	mt, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return "", nil
+	}
+	if alias, ok := mediaTypeAliases[mt]; ok {
+		mt = alias
+	}
+	return mt, params
+}
+
+// requestBodyJSON returns a request body as JSON, transcoding MessagePack
+// and protobuf bodies so every body is validated against the OpenAPI spec
+// and decoded the same way. Bodies of other types are taken as JSON.
+func requestBodyJSON(r *http.Request, body []byte) ([]byte, error) {
+	mt, _ := mediaType(r.Header.Get("Content-Type"))
+	if mt != mediaProtobuf {
+		return bodyJSON(mt, body, nil)
+	}
+	newMessage, ok := protoRequests[r.Method+" "+r.URL.Path]
+	if !ok {
+		return nil, errUnsupportedMediaType
+	}
+	return bodyJSON(mt, body, newMessage())
+}
+
+// bodyJSON transcodes a body of media type mt to JSON, decoding protobuf
+// bodies into message. Bodies of other types are taken as JSON.
+func bodyJSON(mt string, body []byte, message proto.Message) ([]byte, error) {
+	switch mt {
+	case mediaMsgPack:
+		var value interface{}
+		if err := msgpack.Unmarshal(body, &value); err != nil {
+			return nil, fmt.Errorf("invalid MessagePack body: %v", err)
+		}
+		return json.Marshal(value)
+	case mediaProtobuf:
+		if err := proto.Unmarshal(body, message); err != nil {
+			return nil, fmt.Errorf("invalid protobuf body: %v", err)
+		}
+		return protojson.MarshalOptions{UseProtoNames: true}.Marshal(message)
+	}
+	return body, nil
+}
+
+// responseMediaType picks the media type of a response by the request's
+// Accept header, preferring JSON among equally acceptable ones. Responses
+// fall back to JSON when nothing acceptable is supported.
+func responseMediaType(r *http.Request, v interface{}) string {
+	best, bestQ := mediaJSON, -1.0
+	for _, entry := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params := mediaType(entry)
+		switch mt {
+		case "*/*", "application/*":
+			mt = mediaJSON
+		case mediaJSON, mediaMsgPack:
+		case mediaProtobuf:
+			if protoResponse(v) == nil {
+				continue
+			}
+		default:
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > bestQ || q == bestQ && mt == mediaJSON {
+			best, bestQ = mt, q
+		}
+	}
+	return best
+}
+
+// writeResponse writes v with status in the format the request accepts:
+// JSON, MessagePack or, for the responses with a protobuf message, protobuf
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	mt := responseMediaType(r, v)
+	body, err := encodeBody(mt, v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", mt)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// encodeBody encodes v as JSON, MessagePack or, for the values with a
+// protobuf message, protobuf
+func encodeBody(mt string, v interface{}) ([]byte, error) {
+	switch mt {
+	case mediaMsgPack:
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		enc.UseCompactInts(true)
+		err := enc.Encode(v)
+		return buf.Bytes(), err
+	case mediaProtobuf:
+		message := protoResponse(v)
+		if message == nil {
+			return nil, errUnsupportedMediaType
+		}
+		body, err := json.Marshal(v)
+		if err == nil {
+			err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, message)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return proto.Marshal(message)
+	}
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// CompareResult is one provider's translation in a comparison
+type CompareResult struct {
+	Provider       string `json:"provider"`
+	TranslatedText string `json:"translated_text,omitempty"`
+	SourceLang     string `json:"source_lang,omitempty"`
+	TargetLang     string `json:"target_lang,omitempty"` // Language translated into, a variant of the requested one the provider supports
+	LatencyMs      int64  `json:"latency_ms"`
+	Error          string `json:"error,omitempty"`
+}
+
+// CompareResponse holds the results of every configured provider, in the
+// configured provider order
+type CompareResponse struct {
+	Text       string          `json:"text"`
+	TargetLang string          `json:"target_lang"`
+	Results    []CompareResult `json:"results"`
+}
+
+// handleCompare translates the same text with every configured provider
+// concurrently so results can be reviewed side by side. The cache is
+// bypassed, since the point is to see what each provider returns now.
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	req, ctx, ok := s.parseTranslationRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := validateLanguages(req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidLang, err.Error())
+		return
+	}
+	if err := s.checkLanguagePair(ctx, req.SourceLang, req.TargetLang); err != nil {
+		s.writeTranslationError(ctx, w, err)
+		return
+	}
+
+	response := CompareResponse{
+		Text:       req.Text,
+		TargetLang: req.TargetLang,
+		Results:    s.compareProviders(ctx, req),
+	}
+	chars := utf8.RuneCountInString(req.Text)
+	s.recordQuotaUsage(ctx, chars*len(response.Results))
+	for _, result := range response.Results {
+		if result.Error == "" {
+			s.recordUsage(ctx, apiKeyName(ctx), &TranslationResponse{
+				SourceLang:        result.SourceLang,
+				TargetLang:        req.TargetLang,
+				Provider:          result.Provider,
+				Characters:        chars,
+				BilledCharacters:  chars,
+				ProviderLatencyMs: result.LatencyMs,
+			})
+		}
+	}
+
+	writeResponse(w, r, http.StatusOK, response)
+}
+
+// compareProviders runs req through every configured provider concurrently
+func (s *Server) compareProviders(ctx context.Context, req TranslationRequest) []CompareResult {
+	results := make([]CompareResult, len(s.providers))
+	var wg sync.WaitGroup
+	for i, p := range s.providers {
+		wg.Add(1)
+		go func(i int, p provider.Provider) {
+			defer wg.Done()
+			providerReq := req.providerRequest()
+			providerReq.SourceLang = s.supportedLanguage(ctx, p, providerReq.SourceLang)
+			providerReq.TargetLang = s.supportedLanguage(ctx, p, providerReq.TargetLang)
+			start := time.Now()
+			result, err := p.Translate(ctx, providerReq)
+			results[i] = CompareResult{
+				Provider:  p.Name(),
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].TranslatedText = result.TranslatedText
+			results[i].SourceLang = result.SourceLang
+			results[i].TargetLang = providerReq.TargetLang
+		}(i, p)
+	}
+	wg.Wait()
+	return results
+}
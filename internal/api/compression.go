@@ -0,0 +1,163 @@
+package api
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipWriters are reused across responses, as each holds sizeable buffers
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+
+// withCompression decompresses gzip request bodies, before body limits so
+// they apply to the decompressed size, and gzips responses of at least
+// config.GzipMinBytes for clients sending Accept-Encoding: gzip
+func (s *Server) withCompression(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			body, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: invalid gzip body: %v", err))
+				return
+			}
+			defer body.Close()
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		default:
+			writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, fmt.Sprintf("Unsupported media type: Content-Encoding %s, only gzip is accepted", encoding))
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		// Upgraded connections, as of /ws/translate, have no response to compress
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: s.config.GzipMinBytes, status: http.StatusOK}
+		defer gw.Close()
+		handler.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, entry := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(entry, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it reaches
+// minSize, then compresses it; smaller responses are sent as they are, as
+// gzip would only make them bigger
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	started bool         // Whether the status was sent
+	gz      *gzip.Writer // Of compressed responses, once started
+}
+
+// WriteHeader implements http.ResponseWriter, deferring the status until
+// the response is known to be compressed or not
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if !w.started {
+		w.status = status
+	}
+}
+
+// Write implements http.ResponseWriter
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minSize {
+			return len(p), nil
+		}
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// start sends the status and the buffered body, compressed if compress is
+// set and the handler didn't encode the body itself
+func (w *gzipResponseWriter) start(compress bool) error {
+	w.started = true
+	h := w.Header()
+	if compress && h.Get("Content-Encoding") == "" && !strings.HasPrefix(h.Get("Content-Type"), "image/") &&
+		w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(w.buf))
+		}
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what was written so far, compressed, for streamed responses
+func (w *gzipResponseWriter) Flush() {
+	if !w.started {
+		w.start(true)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close sends a response still buffered and finishes a compressed one
+func (w *gzipResponseWriter) Close() {
+	if !w.started {
+		w.start(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
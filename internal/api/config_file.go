@@ -0,0 +1,494 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/provider"
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v3"
+)
+
+// externalSettings are read outside the Config struct, by client libraries
+// or after startup, and may be set in the config file too
+var (
+	externalSettings        = []string{"USE_REDIS_UNSECURE", "GOOGLE_APPLICATION_CREDENTIALS", "GOOGLE_APPLICATION_CREDENTIALS_JSON", "PUBSUB_EMULATOR_HOST"}
+	externalSettingPrefixes = []string{"OTEL_", "AWS_"}
+)
+
+var (
+	// configFile is the path of the loaded config file, if any
+	configFile string
+	// fileSettings are the settings taken from the config file because the
+	// environment doesn't set them
+	fileSettings = make(map[string]bool)
+	// fileKeys are every setting named in the config file
+	fileKeys []string
+	// settingsRead are the names of the settings read so far
+	settingsRead = make(map[string]bool)
+	// configErrors collects invalid settings, reported together at startup
+	// and on reload
+	configErrors []string
+)
+
+// ConfigFilePath returns the path passed with -config or --config, falling
+// back to CONFIG_FILE
+func ConfigFilePath(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv("CONFIG_FILE")
+}
+
+// LoadConfigFile reads settings from a YAML file into the environment,
+// where they are picked up like environment variables. Keys are the names
+// of the environment variables, in any case; lists are joined with commas
+// and maps become comma-separated key:value pairs, e.g. for API_KEYS.
+// Variables already set in the environment take precedence.
+func LoadConfigFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("invalid YAML in %s: %v", path, err)
+	}
+
+	configFile = path
+	for key, raw := range settings {
+		name := strings.ToUpper(key)
+		value, err := settingValue(raw)
+		if err != nil {
+			return fmt.Errorf("%s in %s: %v", name, path, err)
+		}
+		fileKeys = append(fileKeys, name)
+		if os.Getenv(name) != "" {
+			continue
+		}
+		os.Setenv(name, value)
+		fileSettings[name] = true
+	}
+	sort.Strings(fileKeys)
+	return nil
+}
+
+// unloadConfigFile removes the settings of the config file from the
+// environment, so it can be loaded again with its current contents
+func unloadConfigFile() {
+	for name := range fileSettings {
+		os.Unsetenv(name)
+	}
+	configFile = ""
+	fileSettings = make(map[string]bool)
+	fileKeys = nil
+	configErrors = nil
+}
+
+// settingValue formats a YAML value the way it would be written in an
+// environment variable
+func settingValue(raw interface{}) (string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := scalarValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for key, item := range v {
+			s, err := scalarValue(item)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+":"+s)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return scalarValue(v)
+	}
+}
+
+// scalarValue formats a YAML scalar
+func scalarValue(raw interface{}) (string, error) {
+	switch v := raw.(type) {
+	case string:
+		return v, nil
+	case int, bool:
+		return fmt.Sprint(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("expected a string, number or boolean, got %T", raw)
+	}
+}
+
+// setting returns the value of a setting, from the environment or the
+// config file, and records that it exists
+func setting(key string) string {
+	settingsRead[key] = true
+	return os.Getenv(key)
+}
+
+// settingError records an invalid setting, naming where it was set
+func settingError(key, format string, args ...interface{}) {
+	name := key
+	switch {
+	case fileSettings[key]:
+		name += " (set in " + configFile + ")"
+	case os.Getenv(key) != "":
+		name += " (set in the environment)"
+	}
+	configErrors = append(configErrors, name+": "+fmt.Sprintf(format, args...))
+}
+
+// knownSetting reports whether a config file key names a setting
+func knownSetting(key string) bool {
+	if settingsRead[key] {
+		return true
+	}
+	for _, name := range externalSettings {
+		if key == name {
+			return true
+		}
+	}
+	for _, prefix := range externalSettingPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestSetting returns the known setting most similar to a misspelled
+// one, or an empty string when none is close
+func closestSetting(key string) string {
+	var best string
+	bestScore := 0.79
+	for name := range settingsRead {
+		if score := similarity(key, name); score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+	return best
+}
+
+// ValidateConfig checks the settings for values the service can't run
+// with, returning every problem found rather than just the first
+func ValidateConfig(c *Config) []string {
+	for _, key := range fileKeys {
+		if knownSetting(key) {
+			continue
+		}
+		message := fmt.Sprintf("%s (set in %s): unknown setting", key, configFile)
+		if suggestion := closestSetting(key); suggestion != "" {
+			message += fmt.Sprintf(", did you mean %s?", suggestion)
+		}
+		configErrors = append(configErrors, message)
+	}
+
+	if port, err := strconv.Atoi(c.ServerPort); err != nil || port < 1 || port > 65535 {
+		settingError("SERVER_PORT", "%q is not a port number between 1 and 65535", c.ServerPort)
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		for _, a := range allowed {
+			if strings.EqualFold(value, a) {
+				return
+			}
+		}
+		settingError(key, "unknown value %q, expected one of %s", value, strings.Join(allowed, ", "))
+	}
+	oneOf("CACHE_BACKEND", c.CacheBackend, "redis", "memory", "none")
+	oneOf("LOG_LEVEL", c.LogLevel, "debug", "info", "warn", "error")
+	oneOf("LOG_FORMAT", c.LogFormat, "json", "text")
+	for _, name := range c.CacheKeyNormalization {
+		oneOf("CACHE_KEY_NORMALIZATION", name, "nfc", "whitespace", "trim")
+	}
+	oneOf("GOOGLE_TRANSLATE_API_VERSION", c.GoogleAPIVersion, "v2", "v3")
+	for key, action := range map[string]string{"MODERATION_INPUT": c.ModerationInput, "MODERATION_OUTPUT": c.ModerationOutput} {
+		if action == "" {
+			continue
+		}
+		oneOf(key, action, moderationFlag, moderationMask, moderationReject)
+		if len(c.ModerationWordlists) == 0 && c.ModerationURL == "" {
+			settingError(key, "requires MODERATION_WORDLISTS or MODERATION_URL")
+		}
+	}
+	for lang := range c.ModerationWordlists {
+		if lang != "*" {
+			if _, err := language.Parse(lang); err != nil {
+				settingError("MODERATION_WORDLISTS", "invalid language %q", lang)
+			}
+		}
+	}
+	for _, pattern := range c.PageFetchAllowlist {
+		if host := strings.TrimPrefix(pattern, "*."); host == "" || strings.ContainsAny(host, "*/:@ ") {
+			settingError("PAGE_FETCH_ALLOWLIST", "%q is not a host name or *.domain pattern", pattern)
+		}
+	}
+	if c.ProxyUpstream != "" {
+		if u, err := url.Parse(c.ProxyUpstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			settingError("PROXY_UPSTREAM", "%q is not an http or https URL", c.ProxyUpstream)
+		}
+		if port, err := strconv.Atoi(c.ProxyPort); err != nil || port < 1 || port > 65535 {
+			settingError("PROXY_PORT", "%q is not a port number between 1 and 65535", c.ProxyPort)
+		} else if c.ProxyPort == c.ServerPort {
+			settingError("PROXY_PORT", "must differ from SERVER_PORT")
+		}
+		if _, err := language.Parse(c.ProxySourceLang); err != nil {
+			settingError("PROXY_SOURCE_LANG", "invalid language %q, required with PROXY_UPSTREAM", c.ProxySourceLang)
+		}
+		if len(c.ProxyLanguages) == 0 {
+			settingError("PROXY_LANGUAGES", "required with PROXY_UPSTREAM")
+		}
+		for _, lang := range c.ProxyLanguages {
+			if _, err := language.Parse(lang); err != nil {
+				settingError("PROXY_LANGUAGES", "invalid language %q", lang)
+			}
+		}
+		for _, selector := range c.ProxyJSONSelectors {
+			if _, err := parseSelector(selector); err != nil {
+				settingError("PROXY_JSON_SELECTORS", "invalid selector %q: %v", selector, err)
+			}
+		}
+	}
+	if len(c.KafkaBrokers) > 0 {
+		for key, topic := range map[string]string{"KAFKA_INPUT_TOPIC": c.KafkaInputTopic, "KAFKA_OUTPUT_TOPIC": c.KafkaOutputTopic} {
+			if topic == "" {
+				settingError(key, "required with KAFKA_BROKERS")
+			}
+		}
+		if c.KafkaOutputTopic == c.KafkaInputTopic || c.KafkaDLQTopic == c.KafkaInputTopic {
+			settingError("KAFKA_INPUT_TOPIC", "must differ from KAFKA_OUTPUT_TOPIC and KAFKA_DLQ_TOPIC")
+		}
+		if c.KafkaGroupID == "" {
+			settingError("KAFKA_GROUP_ID", "required with KAFKA_BROKERS")
+		}
+		oneOf("KAFKA_SERDE", c.KafkaSerde, "json", "msgpack", "protobuf")
+		if c.KafkaSASLMechanism != "" {
+			oneOf("KAFKA_SASL_MECHANISM", c.KafkaSASLMechanism, "plain", "scram-sha-256", "scram-sha-512")
+			if c.KafkaSASLUsername == "" {
+				settingError("KAFKA_SASL_USERNAME", "required with KAFKA_SASL_MECHANISM")
+			}
+		}
+	}
+	if c.SQSInputQueueURL != "" {
+		if c.SQSOutputQueueURL == "" {
+			settingError("SQS_OUTPUT_QUEUE_URL", "required with SQS_INPUT_QUEUE_URL")
+		}
+		for key, queueURL := range map[string]string{"SQS_INPUT_QUEUE_URL": c.SQSInputQueueURL, "SQS_OUTPUT_QUEUE_URL": c.SQSOutputQueueURL, "SQS_DLQ_QUEUE_URL": c.SQSDLQQueueURL} {
+			if u, err := url.Parse(queueURL); queueURL != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+				settingError(key, "%q is not an http or https queue URL", queueURL)
+			}
+		}
+		if c.SQSOutputQueueURL == c.SQSInputQueueURL || c.SQSDLQQueueURL == c.SQSInputQueueURL {
+			settingError("SQS_INPUT_QUEUE_URL", "must differ from SQS_OUTPUT_QUEUE_URL and SQS_DLQ_QUEUE_URL")
+		}
+		if c.SQSBatchSize < 1 || c.SQSBatchSize > 10 {
+			settingError("SQS_BATCH_SIZE", "must be between 1 and 10")
+		}
+		if c.SQSVisibilityTimeout < 2*time.Second || c.SQSVisibilityTimeout > 12*time.Hour {
+			settingError("SQS_VISIBILITY_TIMEOUT", "must be between 2s and 12h")
+		}
+		if c.SQSWaitTime > 20*time.Second {
+			settingError("SQS_WAIT_TIME", "must be at most 20s")
+		}
+		if c.SQSRetry.MaxBackoff > 12*time.Hour {
+			settingError("SQS_MAX_BACKOFF", "must be at most 12h")
+		}
+	}
+	if c.PubSubSubscription != "" {
+		if c.PubSubOutputTopic == "" {
+			settingError("PUBSUB_OUTPUT_TOPIC", "required with PUBSUB_SUBSCRIPTION")
+		}
+		if c.PubSubDLQTopic == c.PubSubOutputTopic {
+			settingError("PUBSUB_DLQ_TOPIC", "must differ from PUBSUB_OUTPUT_TOPIC")
+		}
+	}
+	for _, bucket := range c.JobStorageBuckets {
+		if _, err := parseBucketURI(bucket); err != nil {
+			settingError("JOB_STORAGE_BUCKETS", "%v", err)
+		}
+	}
+	if len(c.NATSURL) > 0 {
+		for _, server := range c.NATSURL {
+			if u, err := url.Parse(server); err != nil || u.Host == "" || !slices.Contains([]string{"nats", "tls", "ws", "wss"}, u.Scheme) {
+				settingError("NATS_URL", "%q is not a nats, tls, ws or wss URL", server)
+			}
+		}
+		if c.NATSSubjectPrefix == "" || strings.ContainsAny(c.NATSSubjectPrefix, " \t*>") || strings.HasPrefix(c.NATSSubjectPrefix, ".") || strings.HasSuffix(c.NATSSubjectPrefix, ".") {
+			settingError("NATS_SUBJECT_PREFIX", "%q is not a subject without wildcards", c.NATSSubjectPrefix)
+		}
+		if c.NATSQueueGroup == "" || strings.ContainsAny(c.NATSQueueGroup, " \t*>") {
+			settingError("NATS_QUEUE_GROUP", "%q is not a queue group name", c.NATSQueueGroup)
+		}
+	}
+	if c.QualityEstimator != "" {
+		oneOf("QUALITY_ESTIMATOR", c.QualityEstimator, "chrf", "embedding", "http")
+	}
+	if len(c.Providers) == 0 {
+		oneOf("TRANSLATE_PROVIDER", c.Provider, provider.Names...)
+	}
+	for _, name := range c.Providers {
+		oneOf("PROVIDERS", name, provider.Names...)
+	}
+	if c.CanaryProvider != "" {
+		oneOf("CANARY_PROVIDER", c.CanaryProvider, provider.Names...)
+	}
+
+	for key, value := range map[string]float64{
+		"RATE_LIMIT_RPS":            c.RateLimitRPS,
+		"RATE_LIMIT_BURST":          float64(c.RateLimitBurst),
+		"RATE_LIMIT_CHARS_PER_MIN":  float64(c.RateLimitCharsPerMin),
+		"DAILY_CHAR_QUOTA":          float64(c.DailyCharQuota),
+		"CACHE_SOFT_TTL":            c.CacheSoftTTL.Seconds(),
+		"CHUNK_MAX_BYTES":           float64(c.ChunkMaxBytes),
+		"JOB_WORKERS":               float64(c.JobWorkers),
+		"CIRCUIT_BREAKER_THRESHOLD": float64(c.CircuitBreakerThreshold),
+		"PROVIDER_HEALTH_INTERVAL":  c.ProviderHealthInterval.Seconds(),
+		"HISTORY_RETENTION":         c.HistoryRetention.Seconds(),
+		"GZIP_MIN_BYTES":            float64(c.GzipMinBytes),
+		"GET_CACHE_MAX_AGE":         c.GetCacheMaxAge.Seconds(),
+		"PROVIDER_MAX_IN_FLIGHT":    float64(c.ProviderMaxInFlight),
+		"PROVIDER_QUEUE_SIZE":       float64(c.ProviderQueueSize),
+		"PROVIDER_BUDGET_MAX_DEFER": c.ProviderBudgetMaxDefer.Seconds(),
+		"CACHE_POPULAR_TOP":         float64(c.CachePopularTop),
+		"CACHE_POPULAR_AHEAD":       c.CachePopularAhead.Seconds(),
+		"SQS_WAIT_TIME":             c.SQSWaitTime.Seconds(),
+	} {
+		if value < 0 {
+			settingError(key, "must not be negative")
+		}
+	}
+	for key, value := range map[string]float64{
+		"CACHE_MAX_ENTRIES":          float64(c.CacheMaxEntries),
+		"MAX_BODY_BYTES":             float64(c.MaxBodyBytes),
+		"CHUNK_CONCURRENCY":          float64(c.ChunkConcurrency),
+		"DOCUMENT_CONCURRENCY":       float64(c.DocumentConcurrency),
+		"DOCUMENT_MAX_STRINGS":       float64(c.DocumentMaxStrings),
+		"FILE_MAX_BODY_BYTES":        float64(c.FileMaxBodyBytes),
+		"JOB_MAX_REQUESTS":           float64(c.JobMaxRequests),
+		"JOB_FILE_BATCH_SIZE":        float64(c.JobFileBatchSize),
+		"JOB_FILE_MAX_XLIFF_BYTES":   float64(c.JobFileMaxXLIFFBytes),
+		"RETRY_MAX_ATTEMPTS":         float64(c.Retry.MaxAttempts),
+		"WEBHOOK_MAX_ATTEMPTS":       float64(c.WebhookRetry.MaxAttempts),
+		"KAFKA_CONSUMERS":            float64(c.KafkaConsumers),
+		"KAFKA_MAX_ATTEMPTS":         float64(c.KafkaRetry.MaxAttempts),
+		"SQS_CONSUMERS":              float64(c.SQSConsumers),
+		"SQS_MAX_ATTEMPTS":           float64(c.SQSRetry.MaxAttempts),
+		"PUBSUB_MAX_OUTSTANDING":     float64(c.PubSubMaxOutstanding),
+		"PUBSUB_MAX_ATTEMPTS":        float64(c.PubSubRetry.MaxAttempts),
+		"NATS_CONCURRENCY":           float64(c.NATSConcurrency),
+		"USAGE_RETENTION_DAYS":       c.UsageRetention.Hours() / 24,
+		"REDIS_HEALTH_INTERVAL":      c.RedisHealthInterval.Seconds(),
+		"JWT_JWKS_REFRESH":           c.JWKSRefresh.Seconds(),
+		"SIGNATURE_MAX_AGE":          c.SignatureMaxAge.Seconds(),
+		"SHUTDOWN_TIMEOUT":           c.ShutdownTimeout.Seconds(),
+		"WS_IDLE_TIMEOUT":            c.WSIdleTimeout.Seconds(),
+		"CACHE_TTL":                  c.TTL.Seconds(),
+		"IDEMPOTENCY_TTL":            c.IdempotencyTTL.Seconds(),
+		"PROVIDER_QUEUE_TIMEOUT":     c.ProviderQueueTimeout.Seconds(),
+		"CACHE_WARM_RATE":            c.CacheWarmRate,
+		"CACHE_POPULAR_INTERVAL":     c.CachePopularInterval.Seconds(),
+		"QUALITY_ESTIMATION_WORKERS": float64(c.QualityEstimationWorkers),
+		"REVIEW_RETENTION":           c.ReviewRetention.Seconds(),
+		"PAGE_FETCH_TIMEOUT":         c.PageFetchTimeout.Seconds(),
+		"PAGE_MAX_BYTES":             float64(c.PageMaxBytes),
+		"PROXY_MAX_BYTES":            float64(c.ProxyMaxBytes),
+		"PROXY_CACHE_TTL":            c.ProxyCacheTTL.Seconds(),
+	} {
+		if value <= 0 {
+			settingError(key, "must be greater than zero")
+		}
+	}
+
+	if c.CanaryPercent < 0 || c.CanaryPercent > 100 {
+		settingError("CANARY_PERCENT", "must be between 0 and 100")
+	}
+	if c.ProviderBudgetReserve < 0 || c.ProviderBudgetReserve > 1 {
+		settingError("PROVIDER_BUDGET_RESERVE", "must be between 0 and 1")
+	}
+	if c.TMFuzzyThreshold < 0 || c.TMFuzzyThreshold > 1 {
+		settingError("TM_FUZZY_THRESHOLD", "must be between 0 and 1")
+	}
+	if c.VerifyMinSimilarity < 0 || c.VerifyMinSimilarity > 1 {
+		settingError("VERIFY_MIN_SIMILARITY", "must be between 0 and 1")
+	}
+	if c.ReviewMinConfidence < 0 || c.ReviewMinConfidence > 1 {
+		settingError("REVIEW_MIN_CONFIDENCE", "must be between 0 and 1")
+	}
+	if c.ReviewMaxQuality < 0 || c.ReviewMaxQuality > 1 {
+		settingError("REVIEW_MAX_QUALITY", "must be between 0 and 1")
+	}
+	if c.ReviewMaxQuality > 0 && c.QualityEstimator == "" {
+		settingError("REVIEW_MAX_QUALITY", "requires QUALITY_ESTIMATOR")
+	}
+	if err := validateLanguagePairs(c.LanguagePairsAllow); err != nil {
+		settingError("LANGUAGE_PAIRS_ALLOW", "%v", err)
+	}
+	if err := validateLanguagePairs(c.LanguagePairsDeny); err != nil {
+		settingError("LANGUAGE_PAIRS_DENY", "%v", err)
+	}
+	for key, domains := range map[string]map[string]string{
+		"GOOGLE_DOMAIN_MODELS":               c.GoogleDomainModels,
+		"AWS_TRANSLATE_DOMAIN_TERMINOLOGIES": c.AWSDomainTerminologies,
+		"LLM_DOMAIN_PROMPTS":                 c.LLMDomainPrompts,
+	} {
+		for domain := range domains {
+			if !validDomain.MatchString(domain) {
+				settingError(key, "%q is not a valid domain name", domain)
+			}
+		}
+	}
+	if c.QualityEstimator != "" && c.HistoryDatabaseURL == "" {
+		settingError("QUALITY_ESTIMATOR", "requires HISTORY_DATABASE_URL, where scores are recorded")
+	}
+	if (c.QualityEstimator == "embedding" || c.QualityEstimator == "http") && c.QualityEstimationURL == "" {
+		settingError("QUALITY_ESTIMATION_URL", "is required with QUALITY_ESTIMATOR %s", c.QualityEstimator)
+	}
+	if c.CacheMinTTL > c.CacheMaxTTL {
+		settingError("CACHE_MIN_TTL", "%s is longer than CACHE_MAX_TTL (%s)", c.CacheMinTTL, c.CacheMaxTTL)
+	}
+	if c.RedisMasterName != "" && len(c.RedisSentinelAddresses) == 0 {
+		settingError("REDIS_SENTINEL_ADDRESSES", "is required with REDIS_MASTER_NAME")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		settingError("TLS_CERT_FILE", "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSCertFile != "" && len(c.TLSAutocertDomains) > 0 {
+		settingError("TLS_AUTOCERT_DOMAINS", "can't be combined with TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	sort.Strings(configErrors)
+	if configFile != "" && len(configErrors) == 0 {
+		slog.Info("loaded config file", "path", configFile, "settings", len(fileKeys))
+	}
+	return configErrors
+}
@@ -0,0 +1,480 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DocumentRequest is the body of POST /translate/document
+type DocumentRequest struct {
+	Document   json.RawMessage `json:"document"`
+	Selectors  []string        `json:"selectors"` // JSONPath-style, such as $.items[*].title
+	SourceLang string          `json:"source_lang,omitempty"`
+	TargetLang string          `json:"target_lang"`
+	Format     string          `json:"format,omitempty"` // Format of the selected strings
+	Formality  string          `json:"formality,omitempty"`
+	Tone       string          `json:"tone,omitempty"`
+	Context    string          `json:"context,omitempty"` // Where the document's strings appear
+	Domain     string          `json:"domain,omitempty"`
+
+	CacheTTLSeconds int  `json:"cache_ttl_seconds,omitempty"`
+	NoCache         bool `json:"no_cache,omitempty"`
+	NoStore         bool `json:"no_store,omitempty"`
+}
+
+// translationRequest returns the settings shared by every string of the
+// document
+func (d DocumentRequest) translationRequest() TranslationRequest {
+	return TranslationRequest{
+		SourceLang:      d.SourceLang,
+		TargetLang:      d.TargetLang,
+		Format:          d.Format,
+		Formality:       d.Formality,
+		Tone:            d.Tone,
+		Context:         d.Context,
+		Domain:          d.Domain,
+		CacheTTLSeconds: d.CacheTTLSeconds,
+		NoCache:         d.NoCache,
+		NoStore:         d.NoStore,
+	}
+}
+
+// DocumentResponse is the translated document
+type DocumentResponse struct {
+	Document   json.RawMessage `json:"document"`
+	SourceLang string          `json:"source_lang,omitempty"`
+	TargetLang string          `json:"target_lang"`
+	Strings    int             `json:"strings"`    // Distinct strings translated
+	CacheHits  int             `json:"cache_hits"` // Strings served from the cache
+}
+
+// jsonNode is a parsed JSON value that keeps the order of object members,
+// so documents come back with their keys as they were
+type jsonNode struct {
+	kind    json.Delim   // '{' or '[' for containers, zero for scalars
+	value   interface{}  // string, json.Number, bool or nil
+	members []jsonMember // Object members, in order
+	items   []*jsonNode  // Array elements
+}
+
+// jsonMember is a member of a JSON object
+type jsonMember struct {
+	key  string
+	node *jsonNode
+}
+
+// parseJSONDocument parses a JSON value, keeping numbers as written
+func parseJSONDocument(data []byte) (*jsonNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return parseJSONNode(dec)
+}
+
+// parseJSONNode reads the next value from dec
+func parseJSONNode(dec *json.Decoder) (*jsonNode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return &jsonNode{value: tok}, nil
+	}
+
+	node := &jsonNode{kind: delim}
+	for dec.More() {
+		var key string
+		if delim == '{' {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key = tok.(string)
+		}
+		child, err := parseJSONNode(dec)
+		if err != nil {
+			return nil, err
+		}
+		if delim == '{' {
+			node.members = append(node.members, jsonMember{key: key, node: child})
+		} else {
+			node.items = append(node.items, child)
+		}
+	}
+	// Closing delimiter
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (n *jsonNode) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := n.encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encode writes n as compact JSON
+func (n *jsonNode) encode(buf *bytes.Buffer) error {
+	switch n.kind {
+	case '{':
+		buf.WriteByte('{')
+		for i, m := range n.members {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, err := marshalJSONValue(m.key)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := m.node.encode(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case '[':
+		buf.WriteByte('[')
+		for i, item := range n.items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := item.encode(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		value, err := marshalJSONValue(n.value)
+		if err != nil {
+			return err
+		}
+		buf.Write(value)
+	}
+	return nil
+}
+
+// marshalJSONValue encodes v, unlike json.Marshal leaving <, > and & as
+// they are
+func marshalJSONValue(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// descendants returns n and every value nested in it, in document order
+func (n *jsonNode) descendants() []*jsonNode {
+	nodes := []*jsonNode{n}
+	for _, m := range n.members {
+		nodes = append(nodes, m.node.descendants()...)
+	}
+	for _, item := range n.items {
+		nodes = append(nodes, item.descendants()...)
+	}
+	return nodes
+}
+
+// selectorStep is one step of a parsed selector
+type selectorStep struct {
+	recursive bool // Applies to n and all its descendants (..)
+	wildcard  bool // Every member or element (*)
+	indexed   bool // Array element at index, rather than member name
+	name      string
+	index     int // Negative indexes count from the end
+}
+
+// children returns the values of n the step selects
+func (s selectorStep) children(n *jsonNode) []*jsonNode {
+	var nodes []*jsonNode
+	switch {
+	case s.wildcard:
+		for _, m := range n.members {
+			nodes = append(nodes, m.node)
+		}
+		nodes = append(nodes, n.items...)
+	case s.indexed:
+		i := s.index
+		if i < 0 {
+			i += len(n.items)
+		}
+		if i >= 0 && i < len(n.items) {
+			nodes = append(nodes, n.items[i])
+		}
+	default:
+		for _, m := range n.members {
+			if m.key == s.name {
+				nodes = append(nodes, m.node)
+			}
+		}
+	}
+	return nodes
+}
+
+// parseSelector parses a JSONPath-style selector. It supports member names
+// (.name or ['name']), array indexes ([0], [-1]), wildcards (.* or [*]) and
+// recursive descent (..name); the leading $ is optional.
+func parseSelector(selector string) ([]selectorStep, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(selector), "$")
+	if s != "" && s[0] != '.' && s[0] != '[' {
+		s = "." + s
+	}
+
+	var steps []selectorStep
+	for s != "" {
+		var step selectorStep
+		switch {
+		case strings.HasPrefix(s, ".."):
+			step.recursive = true
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				break
+			}
+			fallthrough
+		case s[0] == '.':
+			s = strings.TrimPrefix(s, ".")
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			name := s[:end]
+			s = s[end:]
+			if name == "" {
+				return nil, fmt.Errorf("empty member name")
+			}
+			if name == "*" {
+				step.wildcard = true
+			} else {
+				step.name = name
+			}
+			steps = append(steps, step)
+			continue
+		}
+
+		if !strings.HasPrefix(s, "[") {
+			return nil, fmt.Errorf("unexpected %q", s)
+		}
+		var err error
+		s, err = parseSelectorBracket(s, &step)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// parseSelectorBracket parses the bracketed step at the start of s into
+// step, returning the rest of s
+func parseSelectorBracket(s string, step *selectorStep) (string, error) {
+	s = s[1:]
+	if s != "" && (s[0] == '\'' || s[0] == '"') {
+		quote := s[0]
+		end := strings.IndexByte(s[1:], quote)
+		if end < 0 || !strings.HasPrefix(s[end+2:], "]") {
+			return "", fmt.Errorf("unterminated quoted name")
+		}
+		step.name = s[1 : end+1]
+		return s[end+3:], nil
+	}
+
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return "", fmt.Errorf("missing ]")
+	}
+	inner := strings.TrimSpace(s[:end])
+	if inner == "*" {
+		step.wildcard = true
+		return s[end+1:], nil
+	}
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return "", fmt.Errorf("invalid index %q", inner)
+	}
+	step.indexed = true
+	step.index = index
+	return s[end+1:], nil
+}
+
+// selectNodes returns the values of root a parsed selector selects
+func selectNodes(root *jsonNode, steps []selectorStep) []*jsonNode {
+	current := []*jsonNode{root}
+	for _, step := range steps {
+		var next []*jsonNode
+		for _, n := range current {
+			if step.recursive {
+				for _, d := range n.descendants() {
+					next = append(next, step.children(d)...)
+				}
+			} else {
+				next = append(next, step.children(n)...)
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+// translateStrings translates each of texts with the settings of base, up
+// to config.DocumentConcurrency at a time. The responses are in the order
+// of texts.
+func (s *Server) translateStrings(ctx context.Context, base TranslationRequest, texts []string) ([]*TranslationResponse, error) {
+	responses := make([]*TranslationResponse, len(texts))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(max(s.config.DocumentConcurrency, 1))
+	for i, text := range texts {
+		i, req := i, base
+		req.Text = text
+		group.Go(func() error {
+			response, err := s.translateText(groupCtx, req)
+			responses[i] = response
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+// recordStringsUsage records quota and usage of translated strings
+func (s *Server) recordStringsUsage(ctx context.Context, texts []string, responses []*TranslationResponse) {
+	keyName := apiKeyName(ctx)
+	total := 0
+	for i, response := range responses {
+		chars := utf8.RuneCountInString(texts[i])
+		total += chars
+		s.recordUsage(ctx, keyName, response)
+	}
+	s.recordQuotaUsage(ctx, total)
+}
+
+// handleDocument translates the string values of a JSON document its
+// selectors select, returning the document otherwise unchanged. Repeated
+// strings are translated once.
+func (s *Server) handleDocument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, ok := s.authenticateRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: Invalid API key")
+		logger(r.Context()).Warn("unauthorized request", "remote_addr", s.clientIP(r))
+		return
+	}
+
+	var req DocumentRequest
+	if !decodeRequestBody(w, r, &req) {
+		return
+	}
+	base := req.translationRequest()
+	if err := validateTranslationOptions(&base); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	if err := validateLanguages(base); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidLang, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	if len(req.Document) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: document is required")
+		return
+	}
+	if len(req.Selectors) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: selectors are required")
+		return
+	}
+	root, err := parseJSONDocument(req.Document)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid document: %v", err))
+		return
+	}
+
+	// Group the selected string values by text
+	nodes := make(map[string][]*jsonNode)
+	selected := make(map[*jsonNode]bool)
+	var texts []string
+	chars := 0
+	for _, selector := range req.Selectors {
+		steps, err := parseSelector(selector)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid selector %q: %v", selector, err))
+			return
+		}
+		for _, n := range selectNodes(root, steps) {
+			text, ok := n.value.(string)
+			if !ok || selected[n] || strings.TrimSpace(text) == "" {
+				continue
+			}
+			selected[n] = true
+			if _, ok := nodes[text]; !ok {
+				texts = append(texts, text)
+				chars += utf8.RuneCountInString(text)
+			}
+			nodes[text] = append(nodes[text], n)
+		}
+	}
+	if len(texts) > s.config.DocumentMaxStrings {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: document selects %d strings, the maximum is %d", len(texts), s.config.DocumentMaxStrings))
+		return
+	}
+
+	if !s.authorizeTranslation(ctx, w, chars) {
+		return
+	}
+
+	responses, err := s.translateStrings(ctx, base, texts)
+	if err != nil {
+		s.writeTranslationError(ctx, w, err)
+		return
+	}
+	response := DocumentResponse{
+		SourceLang: base.SourceLang,
+		TargetLang: base.TargetLang,
+		Strings:    len(texts),
+	}
+	for i, text := range texts {
+		for _, n := range nodes[text] {
+			n.value = responses[i].TranslatedText
+		}
+		if responses[i].CacheHit {
+			response.CacheHits++
+		}
+		if response.SourceLang == "" {
+			response.SourceLang = responses[i].SourceLang
+		}
+	}
+	s.recordStringsUsage(ctx, texts, responses)
+
+	// json.Marshal would escape <, > and & in the translations again
+	var document bytes.Buffer
+	if err := root.encode(&document); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to encode document: %v", err))
+		return
+	}
+	response.Document = document.Bytes()
+	logger(ctx).Info("translated document", "strings", len(texts), "characters", chars, "cache_hits", response.CacheHits)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(response)
+}
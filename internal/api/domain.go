@@ -0,0 +1,25 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// validDomain matches the domain names a request may give, such as medical
+// or legal, and the config mappings name
+var validDomain = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// readDomainPrompts reads the LLM prompt templates of LLM_DOMAIN_PROMPTS,
+// given as file paths by domain
+func readDomainPrompts(paths map[string]string) (map[string]string, error) {
+	prompts := make(map[string]string, len(paths))
+	for domain, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the LLM prompt template of domain %s: %v", domain, err)
+		}
+		prompts[domain] = string(data)
+	}
+	return prompts, nil
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Error codes of error responses, documented in the readme. Clients match
+// on these rather than on messages, so they must not change.
+const (
+	codeInvalidRequest       = "INVALID_REQUEST"
+	codeInvalidLang          = "INVALID_LANG"
+	codeUnauthorized         = "UNAUTHORIZED"
+	codeForbidden            = "FORBIDDEN"
+	codeLanguagePairDenied   = "LANGUAGE_PAIR_DENIED"
+	codeNotFound             = "NOT_FOUND"
+	codeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	codeConflict             = "CONFLICT"
+	codeContentRejected      = "CONTENT_REJECTED"
+	codePayloadTooLarge      = "PAYLOAD_TOO_LARGE"
+	codeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	codeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+	codeRateLimited          = "RATE_LIMITED"
+	codeQuotaExceeded        = "QUOTA_EXCEEDED"
+	codeProviderError        = "PROVIDER_ERROR"
+	codeProviderUnavailable  = "PROVIDER_UNAVAILABLE"
+	codeFetchFailed          = "FETCH_FAILED"
+	codeServiceUnavailable   = "SERVICE_UNAVAILABLE"
+	codeNotImplemented       = "NOT_IMPLEMENTED"
+	codeInternalError        = "INTERNAL_ERROR"
+)
+
+// languageError is returned for language codes that aren't valid tags
+type languageError struct {
+	error
+}
+
+// invalidRequestCode returns the code of a 400 response for a request
+// that failed validation with err
+func invalidRequestCode(err error) string {
+	var langErr languageError
+	if errors.As(err, &langErr) {
+		return codeInvalidLang
+	}
+	return codeInvalidRequest
+}
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"` // An object depending on the code, such as the violations of INVALID_REQUEST bodies
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// writeError writes an error response with the request's ID, as already
+// set in the X-Request-ID response header
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writeErrorDetails writes an error response carrying details
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: w.Header().Get(requestIDHeader),
+	})
+}
+
+// handleNotFound answers requests for paths no endpoint serves
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, codeNotFound, "Not found")
+}
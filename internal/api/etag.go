@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// translationETag returns the entity tag of a translation, hashing its
+// cache key and value. The tag is weak, as cache_hit changes between
+// otherwise identical responses.
+func translationETag(ctx context.Context, req TranslationRequest, response *TranslationResponse) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s", cacheKey(req, tenantCacheVariant(ctx)), response.SourceLang, response.Provider, response.TranslatedText)
+	if m := response.Memory; m != nil {
+		fmt.Fprintf(h, "\x00%s\x00%d", m.Provenance, m.Version)
+	}
+	return weakETag(h.Sum(nil))
+}
+
+// jobETag returns the entity tag of a job's state, which changes with its
+// progress
+func jobETag(job *Job) string {
+	data, _ := json.Marshal(job)
+	sum := sha256.Sum256(data)
+	return weakETag(sum[:])
+}
+
+// weakETag formats a hash as a weak entity tag
+func weakETag(sum []byte) string {
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets the ETag of a response and, for GET and HEAD requests
+// whose If-None-Match matches it, writes 304 Not Modified and returns true.
+// Tags are compared weakly, as the response's format may differ.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.Header().Add("Vary", "Accept")
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
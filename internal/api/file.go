@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultRecordErrorField is the column or member record errors of
+// POST /translate/file are written to
+const defaultRecordErrorField = "translation_error"
+
+// handleRecordFile translates the fields of the records of a CSV or JSON
+// Lines file, returning the file with every other column or member as it
+// was. The file is uploaded like subtitles; its format is the format
+// parameter, or taken from the file name. Records that fail are returned
+// as they were, their error in the error_field column or member.
+func (s *Server) handleRecordFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, ok := s.authenticateRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: Invalid API key")
+		logger(r.Context()).Warn("unauthorized request", "remote_addr", s.clientIP(r))
+		return
+	}
+
+	data, filename, ok := readUploadedFile(w, r)
+	if !ok {
+		return
+	}
+	base := TranslationRequest{
+		SourceLang: r.FormValue("source_lang"),
+		TargetLang: r.FormValue("target_lang"),
+		Formality:  r.FormValue("formality"),
+		Tone:       r.FormValue("tone"),
+		Domain:     r.FormValue("domain"),
+	}
+	if err := validateTranslationOptions(&base); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	if err := validateLanguages(base); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidLang, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = recordFormatOf(filename)
+	}
+	if format != recordsCSV && format != recordsJSONL {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: format must be %q or %q, or given by a .csv, .jsonl or .ndjson file name", recordsCSV, recordsJSONL))
+		return
+	}
+	// Fields are given comma-separated, repeated or both
+	var names []string
+	for _, value := range r.Form["fields"] {
+		for _, name := range strings.Split(value, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	if len(names) == 0 {
+		names = defaultRecordFields
+	}
+	fields, err := parseRecordFields(names)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	errorField := r.FormValue("error_field")
+	if errorField == "" {
+		errorField = defaultRecordErrorField
+	}
+	for _, field := range fields {
+		if errorField == field.source || errorField == field.target {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: error_field must differ from the fields")
+			return
+		}
+	}
+
+	var out bytes.Buffer
+	codec, err := newRecordCodec(format, fields, errorField, bytes.NewReader(data), &out, s.config.FileMaxBodyBytes, s.config.FileMaxBodyBytes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid %s file: %v", format, err))
+		return
+	}
+	var records []*fileRecord
+	for {
+		rec, err := codec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid %s file at record %d: %v", format, len(records), err))
+			return
+		}
+		records = append(records, rec)
+	}
+
+	texts, _ := recordTexts(records)
+	chars := 0
+	for _, text := range texts {
+		chars += utf8.RuneCountInString(text)
+	}
+	if len(texts) > s.config.DocumentMaxStrings {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: file has %d distinct strings, the maximum is %d", len(texts), s.config.DocumentMaxStrings))
+		return
+	}
+	if !s.authorizeTranslation(ctx, w, chars) {
+		return
+	}
+
+	errs, translated, responses := s.translateRecords(ctx, s.translateText, base, records)
+	// A file none of whose strings could be translated fails like a
+	// request would, rather than coming back with an error on every record
+	if len(texts) > 0 && len(translated) == 0 {
+		for i, rec := range records {
+			if rec.err == nil && errs[i] != nil {
+				s.writeTranslationError(ctx, w, errs[i])
+				return
+			}
+		}
+	}
+	s.recordStringsUsage(ctx, translated, responses)
+
+	sourceLang := base.SourceLang
+	for _, response := range responses {
+		if sourceLang == "" {
+			sourceLang = response.SourceLang
+		}
+	}
+	failed := 0
+	for i, rec := range records {
+		if errs[i] != nil {
+			failed++
+		}
+		if err := codec.write(rec, errs[i]); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to encode %s file: %v", format, err))
+			return
+		}
+	}
+	if err := codec.close(sourceLang, base.TargetLang); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to encode %s file: %v", format, err))
+		return
+	}
+	logger(ctx).Info("translated record file", "format", format, "records", len(records), "failed", failed, "strings", len(texts), "characters", chars)
+
+	w.Header().Set("Content-Type", recordFormats[format].contentType+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out.Bytes())
+}
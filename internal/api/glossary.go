@@ -0,0 +1,341 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis keys used by the glossary store
+const (
+	glossaryNamesKey     = "glossaries:names" // Set of API key names that have a glossary
+	glossaryRecordPrefix = "glossaries:key:"  // glossaries:key:<key name> holds the JSON Glossary
+)
+
+// maxGlossaryEntries bounds a glossary so matching stays cheap
+const maxGlossaryEntries = 1000
+
+// errGlossaryNotFound is returned when an API key has no glossary
+var errGlossaryNotFound = errors.New("glossary not found")
+
+// glossaryMask masks glossary terms
+var glossaryMask = newTokenMask("GL")
+
+// GlossaryEntry is a term with a fixed translation per target language, or
+// one that is never translated
+type GlossaryEntry struct {
+	Term           string            `json:"term"`
+	Translations   map[string]string `json:"translations,omitempty"` // By target language code
+	DoNotTranslate bool              `json:"do_not_translate,omitempty"`
+}
+
+// Glossary holds the terms applied to an API key's translations
+type Glossary struct {
+	KeyName   string          `json:"key_name"`
+	Entries   []GlossaryEntry `json:"entries"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// GlossarySummary describes a glossary in GET /admin/glossaries
+type GlossarySummary struct {
+	KeyName   string    `json:"key_name"`
+	Entries   int       `json:"entries"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetGlossaryRequest is the body of PUT /admin/glossaries/{key name}
+type SetGlossaryRequest struct {
+	Entries []GlossaryEntry `json:"entries"`
+}
+
+// glossaryTerm is a glossary entry resolved for one target language
+type glossaryTerm struct {
+	term        string
+	replacement string
+}
+
+// getGlossary loads the glossary of an API key
+func (s *Server) getGlossary(ctx context.Context, keyName string) (*Glossary, error) {
+	data, err := s.redis.Get(ctx, glossaryRecordPrefix+keyName).Result()
+	if err == redis.Nil {
+		return nil, errGlossaryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var glossary Glossary
+	if err := json.Unmarshal([]byte(data), &glossary); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal glossary: %v", err)
+	}
+	return &glossary, nil
+}
+
+// saveGlossary stores a glossary, replacing any previous one
+func (s *Server) saveGlossary(ctx context.Context, glossary *Glossary) error {
+	data, err := json.Marshal(glossary)
+	if err != nil {
+		return err
+	}
+	pipe := s.redis.TxPipeline()
+	pipe.Set(ctx, glossaryRecordPrefix+glossary.KeyName, data, 0)
+	pipe.SAdd(ctx, glossaryNamesKey, glossary.KeyName)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// deleteGlossary removes the glossary of an API key
+func (s *Server) deleteGlossary(ctx context.Context, keyName string) error {
+	pipe := s.redis.TxPipeline()
+	del := pipe.Del(ctx, glossaryRecordPrefix+keyName)
+	pipe.SRem(ctx, glossaryNamesKey, keyName)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if del.Val() == 0 {
+		return errGlossaryNotFound
+	}
+	return nil
+}
+
+// listGlossaries summarizes every glossary ordered by key name
+func (s *Server) listGlossaries(ctx context.Context) ([]GlossarySummary, error) {
+	names, err := s.redis.SMembers(ctx, glossaryNamesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	summaries := make([]GlossarySummary, 0, len(names))
+	for _, name := range names {
+		glossary, err := s.getGlossary(ctx, name)
+		if err == errGlossaryNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, GlossarySummary{
+			KeyName:   glossary.KeyName,
+			Entries:   len(glossary.Entries),
+			UpdatedAt: glossary.UpdatedAt,
+		})
+	}
+	return summaries, nil
+}
+
+// normalizeGlossaryEntries validates entries and lowercases their language
+// codes
+func normalizeGlossaryEntries(entries []GlossaryEntry) ([]GlossaryEntry, error) {
+	if len(entries) > maxGlossaryEntries {
+		return nil, fmt.Errorf("glossary has %d entries, the maximum is %d", len(entries), maxGlossaryEntries)
+	}
+	seen := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		entry.Term = strings.TrimSpace(entry.Term)
+		if entry.Term == "" {
+			return nil, fmt.Errorf("entry %d has no term", i)
+		}
+		if seen[entry.Term] {
+			return nil, fmt.Errorf("duplicate term %q", entry.Term)
+		}
+		seen[entry.Term] = true
+		if !entry.DoNotTranslate && len(entry.Translations) == 0 {
+			return nil, fmt.Errorf("term %q needs translations or do_not_translate", entry.Term)
+		}
+		translations := make(map[string]string, len(entry.Translations))
+		for lang, translation := range entry.Translations {
+			translations[strings.ToLower(lang)] = translation
+		}
+		entry.Translations = translations
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+// resolve returns the replacement of the entry for a target language.
+// A regional target such as pt-BR falls back to the base language.
+func (e GlossaryEntry) resolve(target string) (string, bool) {
+	target = strings.ToLower(target)
+	if translation, ok := e.Translations[target]; ok {
+		return translation, true
+	}
+	if base, _, found := strings.Cut(target, "-"); found {
+		if translation, ok := e.Translations[base]; ok {
+			return translation, true
+		}
+	}
+	if e.DoNotTranslate {
+		return e.Term, true
+	}
+	return "", false
+}
+
+// glossaryPattern matches any of terms as whole words, preferring the
+// longest term at each position
+func glossaryPattern(terms []glossaryTerm) *regexp.Regexp {
+	alternatives := make([]string, len(terms))
+	for i, t := range terms {
+		alternatives[i] = regexp.QuoteMeta(t.term)
+		if r, _ := utf8.DecodeRuneInString(t.term); isWordRune(r) {
+			alternatives[i] = `\b` + alternatives[i]
+		}
+		if r, _ := utf8.DecodeLastRuneInString(t.term); isWordRune(r) {
+			alternatives[i] += `\b`
+		}
+	}
+	return regexp.MustCompile(strings.Join(alternatives, "|"))
+}
+
+// isWordRune reports whether r is an ASCII word character, as matched by \b
+func isWordRune(r rune) bool {
+	return r < utf8.RuneSelf && (r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// glossaryTerms returns the terms of the caller's glossary, its tenant's
+// or its key's, that occur in the request's text, resolved for its target language. Translations
+// proceed without a glossary when it can't be loaded.
+func (s *Server) glossaryTerms(ctx context.Context, req TranslationRequest) []glossaryTerm {
+	if !s.redisAvailable() {
+		return nil
+	}
+	glossary, err := s.getGlossary(ctx, scopeName(ctx))
+	if err == errGlossaryNotFound {
+		return nil
+	}
+	if err != nil {
+		logger(ctx).Warn("failed to load glossary", "error", err)
+		return nil
+	}
+
+	var terms []glossaryTerm
+	for _, entry := range glossary.Entries {
+		if replacement, ok := entry.resolve(req.TargetLang); ok {
+			terms = append(terms, glossaryTerm{term: entry.Term, replacement: replacement})
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if len(terms[i].term) != len(terms[j].term) {
+			return len(terms[i].term) > len(terms[j].term)
+		}
+		return terms[i].term < terms[j].term
+	})
+
+	found := make(map[string]bool)
+	for _, match := range glossaryPattern(terms).FindAllString(req.Text, -1) {
+		found[match] = true
+	}
+	matched := terms[:0]
+	for _, t := range terms {
+		if found[t.term] {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
+
+// glossaryCacheVariant identifies the applied terms in the cache key, so
+// texts are only cached separately when a glossary actually changes them
+func glossaryCacheVariant(terms []glossaryTerm) string {
+	if len(terms) == 0 {
+		return ""
+	}
+	hash := sha256.New()
+	for _, t := range terms {
+		fmt.Fprintf(hash, "%s\x00%s\x00", t.term, t.replacement)
+	}
+	return "glossary-" + hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// applyGlossary masks every occurrence in text of terms, returning the
+// masked text and the replacement of each token
+func applyGlossary(terms []glossaryTerm, text string) (string, []string) {
+	replacements := make(map[string]string, len(terms))
+	for _, t := range terms {
+		replacements[t.term] = t.replacement
+	}
+	var values []string
+	masked := glossaryPattern(terms).ReplaceAllStringFunc(text, func(match string) string {
+		values = append(values, replacements[match])
+		return glossaryMask.token(len(values) - 1)
+	})
+	return masked, values
+}
+
+// handleAdminGlossaries serves the glossary management API. Glossaries
+// belong to an API key name and apply to every translation it requests, or
+// to a tenant, named tenant:<id>, and apply to all of its keys.
+//
+//	GET    /admin/glossaries            list glossaries
+//	GET    /admin/glossaries/{key name} show a glossary
+//	PUT    /admin/glossaries/{key name} create or replace a glossary
+//	DELETE /admin/glossaries/{key name} delete a glossary
+func (s *Server) handleAdminGlossaries(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	keyName := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/glossaries"), "/")
+
+	var response interface{}
+	var err error
+	switch {
+	case keyName == "" && r.Method == http.MethodGet:
+		response, err = s.listGlossaries(ctx)
+	case keyName != "" && r.Method == http.MethodGet:
+		response, err = s.getGlossary(ctx, keyName)
+	case keyName != "" && r.Method == http.MethodPut:
+		var req SetGlossaryRequest
+		if !decodeRequestBody(w, r, &req) {
+			return
+		}
+		entries, err := normalizeGlossaryEntries(req.Entries)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid glossary: %v", err))
+			return
+		}
+		glossary := &Glossary{KeyName: keyName, Entries: entries, UpdatedAt: time.Now().UTC()}
+		if err := s.saveGlossary(ctx, glossary); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Glossary operation failed: %v", err))
+			return
+		}
+		logger(ctx).Info("saved glossary", "key_name", keyName, "entries", len(entries))
+		response = glossary
+	case keyName != "" && r.Method == http.MethodDelete:
+		err = s.deleteGlossary(ctx, keyName)
+		if err == nil {
+			logger(ctx).Info("deleted glossary", "key_name", keyName)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err == errGlossaryNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Glossary operation failed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
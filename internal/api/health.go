@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// providerHealthTimeout bounds the provider check of a readiness probe
+const providerHealthTimeout = 5 * time.Second
+
+// Statuses reported by /readyz, overall and per dependency
+const (
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+	statusFailing  = "failing"
+	statusPending  = "pending"
+)
+
+// DependencyStatus is the state of one dependency in /readyz. Providers
+// checked in the background also report when they were last checked and
+// last responded.
+type DependencyStatus struct {
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	LastCheck   *time.Time `json:"last_check,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// ReadinessResponse is the body of GET /readyz
+type ReadinessResponse struct {
+	Status    string                      `json:"status"`
+	Checks    map[string]DependencyStatus `json:"checks"`
+	Providers map[string]DependencyStatus `json:"providers,omitempty"`
+}
+
+// providerHealth is the outcome of a provider's background checks
+type providerHealth struct {
+	provider provider.Provider
+	canary   bool
+
+	mu          sync.Mutex
+	lastCheck   time.Time
+	lastSuccess time.Time
+	err         error
+}
+
+// check lists the provider's languages, which is free with every provider
+// and fails once its credentials are rejected
+func (h *providerHealth) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, providerHealthTimeout)
+	defer cancel()
+	_, err := h.provider.Languages(ctx, "")
+
+	h.mu.Lock()
+	wasFailing := h.err != nil
+	h.lastCheck = time.Now()
+	h.err = err
+	if err == nil {
+		h.lastSuccess = h.lastCheck
+	}
+	h.mu.Unlock()
+
+	if err == nil {
+		providerUp.WithLabelValues(h.provider.Name()).Set(1)
+		if wasFailing {
+			slog.Info("provider health check recovered", "provider", h.provider.Name())
+		}
+		return
+	}
+	providerUp.WithLabelValues(h.provider.Name()).Set(0)
+	if !wasFailing {
+		slog.Error("provider health check failed", "provider", h.provider.Name(), "error", err)
+	}
+}
+
+// status reports the outcome of the provider's last check
+func (h *providerHealth) status() DependencyStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastCheck.IsZero() {
+		return DependencyStatus{Status: statusPending}
+	}
+	lastCheck := h.lastCheck
+	status := DependencyStatus{Status: statusOK, LastCheck: &lastCheck}
+	if !h.lastSuccess.IsZero() {
+		lastSuccess := h.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	if h.err != nil {
+		status.Status = statusFailing
+		status.Error = h.err.Error()
+	}
+	return status
+}
+
+// monitorProviders checks every provider at once and then every interval
+// until ctx is done, so expired credentials show up in /readyz before
+// translations fail
+func (s *Server) monitorProviders(ctx context.Context, interval time.Duration) {
+	s.providerHealths = make([]*providerHealth, len(s.providers))
+	for i, p := range s.providers {
+		s.providerHealths[i] = &providerHealth{provider: p, canary: s.config.CanaryProvider != "" && i == len(s.providers)-1}
+	}
+	checkAll := func() {
+		var wg sync.WaitGroup
+		for _, h := range s.providerHealths {
+			wg.Add(1)
+			go func(h *providerHealth) {
+				defer wg.Done()
+				h.check(ctx)
+			}(h)
+		}
+		wg.Wait()
+	}
+
+	go func() {
+		checkAll()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkAll()
+			}
+		}
+	}()
+}
+
+// handleLivez reports that the process is alive and serving requests. It
+// never checks dependencies, so an orchestrator doesn't restart the service
+// for failures a restart can't fix.
+func handleLivez(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": statusOK})
+}
+
+// handleReadyz reports whether the service should receive traffic: startup
+// has finished, Redis is reachable and the provider's upstream responds.
+// An unreachable Redis only degrades readiness when READYZ_ALLOW_DEGRADED
+// is set, since translations still work without it.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	response := s.checkReadiness(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Status == statusFailing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// checkReadiness checks every dependency of the service
+func (s *Server) checkReadiness(ctx context.Context) ReadinessResponse {
+	response := ReadinessResponse{Status: statusOK, Checks: make(map[string]DependencyStatus)}
+	fail := func(name string, status DependencyStatus) {
+		response.Checks[name] = status
+		if status.Status != statusDegraded {
+			response.Status = statusFailing
+		} else if response.Status == statusOK {
+			response.Status = statusDegraded
+		}
+	}
+
+	if s.warmedUp.Load() {
+		response.Checks["warmup"] = DependencyStatus{Status: statusOK}
+	} else {
+		fail("warmup", DependencyStatus{Status: statusPending})
+	}
+
+	if s.redisAvailable() {
+		response.Checks["redis"] = DependencyStatus{Status: statusOK}
+	} else {
+		status := DependencyStatus{Status: statusFailing}
+		if err := s.redisStatusError(); err != nil {
+			status.Error = err.Error()
+		}
+		if s.config.ReadyzAllowDegraded {
+			status.Status = statusDegraded
+		}
+		fail("redis", status)
+	}
+
+	if s.providerHealths != nil {
+		// A failover chain is ready while any of its providers is; the
+		// canary only takes a share of traffic and is reported alone
+		response.Providers = make(map[string]DependencyStatus, len(s.providerHealths))
+		overall := DependencyStatus{Status: statusFailing}
+		for _, h := range s.providerHealths {
+			status := h.status()
+			response.Providers[h.provider.Name()] = status
+			if h.canary {
+				continue
+			}
+			if status.Status == statusOK || overall.Status == statusFailing && status.Status == statusPending {
+				overall = DependencyStatus{Status: status.Status}
+			}
+		}
+		if overall.Status == statusOK {
+			response.Checks["provider"] = overall
+		} else {
+			fail("provider", overall)
+		}
+		return response
+	}
+
+	// Providers that can't be checked cheaply are assumed to be healthy
+	if checker, ok := s.provider.(provider.HealthChecker); ok {
+		ctx, cancel := context.WithTimeout(ctx, providerHealthTimeout)
+		defer cancel()
+		if err := checker.HealthCheck(ctx); err != nil {
+			fail("provider", DependencyStatus{Status: statusFailing, Error: err.Error()})
+		} else {
+			response.Checks["provider"] = DependencyStatus{Status: statusOK}
+		}
+	}
+	return response
+}
@@ -0,0 +1,374 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Bounds of the translation history
+const (
+	historyBufferSize     = 10000 // Records waiting to be written; more are dropped
+	historyBatchSize      = 500   // Records written per COPY
+	historyFlushInterval  = time.Second
+	historyPruneInterval  = time.Hour
+	historyDefaultLimit   = 50
+	historyMaxLimit       = 500
+	historyDeleteBatchMax = 10000 // Expired rows deleted per statement, keeping locks short
+)
+
+// historySchema creates the history table when it doesn't exist yet
+const historySchema = `
+CREATE TABLE IF NOT EXISTS translation_history (
+	id              BIGSERIAL PRIMARY KEY,
+	created_at      TIMESTAMPTZ NOT NULL,
+	request_id      TEXT NOT NULL,
+	key_name        TEXT NOT NULL,
+	source_lang     TEXT NOT NULL,
+	target_lang     TEXT NOT NULL,
+	source_text     TEXT NOT NULL,
+	translated_text TEXT NOT NULL,
+	provider        TEXT NOT NULL,
+	cache_hit       BOOLEAN NOT NULL,
+	latency_ms      DOUBLE PRECISION NOT NULL
+);
+CREATE INDEX IF NOT EXISTS translation_history_created_at ON translation_history (created_at);
+CREATE INDEX IF NOT EXISTS translation_history_key_name ON translation_history (key_name, id);
+ALTER TABLE translation_history ADD COLUMN IF NOT EXISTS quality_score DOUBLE PRECISION;
+CREATE INDEX IF NOT EXISTS translation_history_quality_score ON translation_history (quality_score) WHERE quality_score IS NOT NULL;
+`
+
+// historyColumns are the columns written for each record, in order
+var historyColumns = []string{"created_at", "request_id", "key_name", "source_lang", "target_lang",
+	"source_text", "translated_text", "provider", "cache_hit", "latency_ms", "quality_score"}
+
+// HistoryRecord is a recorded translation
+type HistoryRecord struct {
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	RequestID      string    `json:"request_id,omitempty"`
+	KeyName        string    `json:"key_name"`
+	SourceLang     string    `json:"source_lang"`
+	TargetLang     string    `json:"target_lang"`
+	SourceText     string    `json:"source_text"`
+	TranslatedText string    `json:"translated_text"`
+	Provider       string    `json:"provider"`
+	CacheHit       bool      `json:"cache_hit"`
+	LatencyMS      float64   `json:"latency_ms"`
+	QualityScore   *float64  `json:"quality_score,omitempty"` // Of QUALITY_ESTIMATOR, for provider translations it could score
+}
+
+// HistoryResponse is the body returned by GET /admin/history
+type HistoryResponse struct {
+	Records    []HistoryRecord `json:"records"`
+	NextCursor string          `json:"next_cursor,omitempty"` // Pass as cursor for the next, older page
+}
+
+// setupHistory connects to the history database, creates its table and
+// starts writing records and pruning expired ones in the background
+func (s *Server) setupHistory(ctx context.Context) error {
+	pool, err := pgxpool.New(ctx, s.config.HistoryDatabaseURL)
+	if err != nil {
+		return err
+	}
+	if _, err := pool.Exec(ctx, historySchema); err != nil {
+		pool.Close()
+		return fmt.Errorf("failed to create history table: %v", err)
+	}
+	s.historyPool = pool
+	s.historyRecords = make(chan HistoryRecord, historyBufferSize)
+	s.historyStop = make(chan struct{})
+	s.historyDone = make(chan struct{})
+	go s.writeHistory()
+	if s.config.HistoryRetention > 0 {
+		go s.pruneHistory(s.historyStop)
+	}
+	if s.qualityEstimator = newQualityEstimator(s.config, s.redactor); s.qualityEstimator != nil {
+		s.startQualityEstimation()
+	}
+	return nil
+}
+
+// recordHistory queues a translation for the history, scored first with
+// QUALITY_ESTIMATOR, dropping it when the writer can't keep up rather than
+// slowing the request down
+func (s *Server) recordHistory(ctx context.Context, req TranslationRequest, response *TranslationResponse, latency time.Duration) {
+	if s.historyPool == nil {
+		return
+	}
+	record := HistoryRecord{
+		CreatedAt:      time.Now().UTC(),
+		KeyName:        apiKeyName(ctx),
+		SourceLang:     response.SourceLang,
+		TargetLang:     response.TargetLang,
+		SourceText:     req.Text,
+		TranslatedText: response.TranslatedText,
+		Provider:       response.Provider,
+		CacheHit:       response.CacheHit,
+		LatencyMS:      float64(latency.Microseconds()) / 1000,
+	}
+	if info := getRequestInfo(ctx); info != nil {
+		record.RequestID = info.ID
+	}
+	if !s.queueQualityEstimation(record, response) {
+		s.queueHistoryRecord(record)
+	}
+}
+
+// queueHistoryRecord queues a record for the writer, dropping it when the
+// queue is full
+func (s *Server) queueHistoryRecord(record HistoryRecord) {
+	select {
+	case s.historyRecords <- record:
+	default:
+		historyWrites.WithLabelValues("dropped").Inc()
+	}
+}
+
+// writeHistory writes queued records in batches until stopHistory is called,
+// then writes the records still queued
+func (s *Server) writeHistory() {
+	defer close(s.historyDone)
+	ticker := time.NewTicker(historyFlushInterval)
+	defer ticker.Stop()
+	batch := make([]HistoryRecord, 0, historyBatchSize)
+	for {
+		select {
+		case record := <-s.historyRecords:
+			if batch = append(batch, record); len(batch) == historyBatchSize {
+				batch = s.flushHistory(batch)
+			}
+		case <-ticker.C:
+			batch = s.flushHistory(batch)
+		case <-s.historyStop:
+			for {
+				select {
+				case record := <-s.historyRecords:
+					if batch = append(batch, record); len(batch) == historyBatchSize {
+						batch = s.flushHistory(batch)
+					}
+				default:
+					s.flushHistory(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flushHistory writes a batch of records, returning the emptied batch.
+// Records that can't be written are dropped.
+func (s *Server) flushHistory(batch []HistoryRecord) []HistoryRecord {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := s.historyPool.CopyFrom(ctx, pgx.Identifier{"translation_history"}, historyColumns,
+		pgx.CopyFromSlice(len(batch), func(i int) ([]interface{}, error) {
+			r := batch[i]
+			return []interface{}{r.CreatedAt, r.RequestID, r.KeyName, r.SourceLang, r.TargetLang,
+				r.SourceText, r.TranslatedText, r.Provider, r.CacheHit, r.LatencyMS, r.QualityScore}, nil
+		}))
+	if err != nil {
+		slog.Error("failed to write translation history", "records", len(batch), "error", err)
+		historyWrites.WithLabelValues("failed").Add(float64(len(batch)))
+	} else {
+		historyWrites.WithLabelValues("written").Add(float64(len(batch)))
+	}
+	return batch[:0]
+}
+
+// pruneHistory deletes records older than config.HistoryRetention every
+// historyPruneInterval until stop is closed
+func (s *Server) pruneHistory(stop <-chan struct{}) {
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		cutoff := time.Now().Add(-s.config.HistoryRetention)
+		var deleted int64
+		for {
+			tag, err := s.historyPool.Exec(ctx, `DELETE FROM translation_history WHERE id IN
+				(SELECT id FROM translation_history WHERE created_at < $1 LIMIT $2)`, cutoff, historyDeleteBatchMax)
+			if err != nil {
+				slog.Warn("failed to prune translation history", "error", err)
+				break
+			}
+			deleted += tag.RowsAffected()
+			if tag.RowsAffected() < historyDeleteBatchMax {
+				break
+			}
+		}
+		cancel()
+		if deleted > 0 {
+			slog.Info("pruned translation history", "deleted", deleted)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopHistory writes the queued records and closes the history database,
+// giving up when ctx expires
+func (s *Server) stopHistory(ctx context.Context) {
+	if s.historyPool == nil {
+		return
+	}
+	s.stopQualityEstimation(ctx)
+	close(s.historyStop)
+	select {
+	case <-s.historyDone:
+	case <-ctx.Done():
+		slog.Warn("translation history was not written in time")
+	}
+	s.historyPool.Close()
+}
+
+// historyQuery is a parsed GET /admin/history query
+type historyQuery struct {
+	conditions []string
+	args       []interface{}
+	limit      int
+}
+
+// where adds a condition with one $%d argument
+func (q *historyQuery) where(condition string, arg interface{}) {
+	q.args = append(q.args, arg)
+	q.conditions = append(q.conditions, fmt.Sprintf(condition, len(q.args)))
+}
+
+// parseHistoryQuery parses the filters, cursor and limit of a history query
+func parseHistoryQuery(params url.Values) (*historyQuery, error) {
+	q := &historyQuery{limit: historyDefaultLimit}
+	for _, filter := range []struct{ param, column string }{
+		{"key", "key_name"}, {"source_lang", "source_lang"}, {"target_lang", "target_lang"}, {"provider", "provider"},
+	} {
+		if v := params.Get(filter.param); v != "" {
+			q.where(filter.column+" = $%d", v)
+		}
+	}
+	for _, bound := range []struct{ param, condition string }{{"from", "created_at >= $%d"}, {"to", "created_at < $%d"}} {
+		if v := params.Get(bound.param); v != "" {
+			t, err := parseHistoryTime(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", bound.param, err)
+			}
+			q.where(bound.condition, t)
+		}
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"min_quality", "quality_score >= $%d"}, {"max_quality", "quality_score <= $%d"},
+	} {
+		if v := params.Get(bound.param); v != "" {
+			score, err := strconv.ParseFloat(v, 64)
+			if err != nil || score < 0 || score > 1 {
+				return nil, fmt.Errorf("%s must be between 0 and 1", bound.param)
+			}
+			q.where(bound.condition, score)
+		}
+	}
+	if v := params.Get("cursor"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		q.where("id < $%d", id)
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > historyMaxLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", historyMaxLimit)
+		}
+		q.limit = n
+	}
+	return q, nil
+}
+
+// parseHistoryTime parses an RFC 3339 timestamp or a YYYY-MM-DD UTC date
+func parseHistoryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// queryHistory returns a page of the records matching q, newest first
+func (s *Server) queryHistory(ctx context.Context, q *historyQuery) (*HistoryResponse, error) {
+	sql := "SELECT id, created_at, request_id, key_name, source_lang, target_lang, source_text, translated_text, provider, cache_hit, latency_ms, quality_score FROM translation_history"
+	if len(q.conditions) > 0 {
+		sql += " WHERE " + strings.Join(q.conditions, " AND ")
+	}
+	// One more record tells whether there is a next page
+	args := append(q.args, q.limit+1)
+	sql += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := s.historyPool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	response := &HistoryResponse{Records: []HistoryRecord{}}
+	for rows.Next() {
+		var r HistoryRecord
+		if err := rows.Scan(&r.ID, &r.CreatedAt, &r.RequestID, &r.KeyName, &r.SourceLang, &r.TargetLang,
+			&r.SourceText, &r.TranslatedText, &r.Provider, &r.CacheHit, &r.LatencyMS, &r.QualityScore); err != nil {
+			return nil, err
+		}
+		response.Records = append(response.Records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(response.Records) > q.limit {
+		response.Records = response.Records[:q.limit]
+		response.NextCursor = strconv.FormatInt(response.Records[q.limit-1].ID, 10)
+	}
+	return response, nil
+}
+
+// handleAdminHistory serves GET /admin/history, the recorded translations,
+// newest first, optionally filtered by key, source_lang, target_lang,
+// provider, a from/to time range and a min_quality/max_quality score range,
+// which leaves out unscored records. Pages hold limit records; the
+// next_cursor of a page is passed as cursor to get the next one.
+func (s *Server) handleAdminHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+	if s.historyPool == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Translation history is disabled")
+		return
+	}
+
+	query, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	response, err := s.queryHistory(r.Context(), query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("History query failed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"strings"
+)
+
+// icuArgumentMask masks ICU MessageFormat arguments in resource strings
+var icuArgumentMask = newTokenMask("ICU")
+
+// icuMessage is an ICU MessageFormat message, or a plural or select
+// branch of one, split into the text to translate and its arguments
+type icuMessage struct {
+	text string // The message with every argument masked by an icuArgumentMask token
+	args []icuArgument
+}
+
+// icuArgument is an argument of a message. Plural, selectordinal and select
+// arguments have branches, whose messages are translated on their own;
+// other arguments, #, quoted literals and {{name}} interpolations are kept
+// as written.
+type icuArgument struct {
+	raw      string      // As written, for arguments without branches
+	head     string      // Up to the first branch's key, as {count, plural, offset:1
+	branches []icuBranch // In their order
+	tail     string      // After the last branch, as }
+}
+
+// icuBranch is a branch of a plural or select argument
+type icuBranch struct {
+	key     string // Its key with the whitespace around it, as " one " or " =0 "
+	message *icuMessage
+}
+
+// parseICUMessage parses text as an ICU MessageFormat message. It never
+// fails: braces it can't make sense of, such as an unbalanced {, are kept as
+// literal text, and arguments it can't parse are kept as they are. In
+// plural branches, # stands for the number.
+func parseICUMessage(text string, plural bool) *icuMessage {
+	m := &icuMessage{}
+	var b strings.Builder
+	add := func(arg icuArgument) {
+		b.WriteString(icuArgumentMask.token(len(m.args)))
+		m.args = append(m.args, arg)
+	}
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == '{':
+			end := icuClosingBrace(text, i)
+			if end < 0 {
+				b.WriteString(text[i:])
+				i = len(text)
+				continue
+			}
+			add(parseICUArgument(text[i:end+1], plural))
+			i = end + 1
+		case c == '#' && plural:
+			add(icuArgument{raw: "#"})
+			i++
+		case c == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			// An escaped apostrophe, left in the text
+			b.WriteString("''")
+			i += 2
+		case c == '\'' && i+1 < len(text) && (strings.IndexByte("{}|", text[i+1]) >= 0 || plural && text[i+1] == '#'):
+			end := icuQuoteEnd(text, i)
+			add(icuArgument{raw: text[i:end]})
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	m.text = b.String()
+	return m
+}
+
+// icuQuoteEnd returns the end of the quoted literal starting at the
+// apostrophe at start, after its closing apostrophe or at the end of text
+func icuQuoteEnd(text string, start int) int {
+	for i := start + 1; i < len(text); i++ {
+		if text[i] != '\'' {
+			continue
+		}
+		if i+1 < len(text) && text[i+1] == '\'' {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(text)
+}
+
+// icuClosingBrace returns the index of the brace closing the one at start,
+// skipping quoted literals, or -1 when it is never closed
+func icuClosingBrace(text string, start int) int {
+	depth := 0
+	for i := start; i < len(text); i++ {
+		switch text[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case '\'':
+			if i+1 < len(text) && strings.IndexByte("{}#|", text[i+1]) >= 0 {
+				i = icuQuoteEnd(text, i) - 1
+			} else if i+1 < len(text) && text[i+1] == '\'' {
+				i++
+			}
+		}
+	}
+	return -1
+}
+
+// parseICUArgument parses the brace-balanced argument raw, splitting
+// plural, selectordinal and select arguments into their branches
+func parseICUArgument(raw string, plural bool) icuArgument {
+	opaque := icuArgument{raw: raw}
+	inner := raw[1 : len(raw)-1]
+	name, rest, ok := strings.Cut(inner, ",")
+	if !ok || strings.TrimSpace(name) == "" || strings.ContainsAny(name, "{}") {
+		return opaque
+	}
+	kind, style, ok := strings.Cut(rest, ",")
+	switch strings.TrimSpace(kind) {
+	case "plural", "selectordinal":
+		plural = true
+	case "select":
+	default:
+		return opaque
+	}
+	if !ok {
+		return opaque
+	}
+
+	// The head runs up to the first key, past any plural offset
+	offset := 1 + len(name) + 1 + len(kind) + 1
+	arg := icuArgument{}
+	i := 0
+	if trimmed := strings.TrimLeft(style, " \t\r\n"); plural && strings.HasPrefix(trimmed, "offset:") {
+		i = len(style) - len(trimmed) + len("offset:")
+		i += len(style[i:]) - len(strings.TrimLeft(style[i:], " \t\r\n"))
+		for i < len(style) && strings.IndexByte(" \t\r\n{", style[i]) < 0 {
+			i++
+		}
+	}
+	arg.head = raw[:offset+i]
+
+	for {
+		open := strings.IndexByte(style[i:], '{')
+		if open < 0 {
+			break
+		}
+		key := style[i : i+open]
+		if strings.TrimSpace(key) == "" || strings.ContainsAny(strings.TrimSpace(key), " \t\r\n}'") {
+			return opaque
+		}
+		end := icuClosingBrace(style, i+open)
+		if end < 0 {
+			return opaque
+		}
+		arg.branches = append(arg.branches, icuBranch{
+			key:     key,
+			message: parseICUMessage(style[i+open+1:end], plural),
+		})
+		i = end + 1
+	}
+	if len(arg.branches) == 0 || strings.TrimSpace(style[i:]) != "" {
+		return opaque
+	}
+	arg.tail = style[i:] + "}"
+	return arg
+}
+
+// translatable reports whether the message has text to translate besides
+// its arguments
+func (m *icuMessage) translatable() bool {
+	return strings.TrimSpace(icuArgumentMask.pattern.ReplaceAllString(m.text, "")) != ""
+}
+
+// messages calls fn with the message and every branch message within it
+func (m *icuMessage) messages(fn func(*icuMessage)) {
+	fn(m)
+	for _, arg := range m.args {
+		for _, branch := range arg.branches {
+			branch.message.messages(fn)
+		}
+	}
+}
+
+// render reassembles the message, with each message's text replaced by its
+// translation when translate has one. It returns the message and how many
+// arguments the translations lost.
+func (m *icuMessage) render(translate func(*icuMessage) (string, bool)) (string, int) {
+	lost := 0
+	values := make([]string, len(m.args))
+	for i, arg := range m.args {
+		if arg.branches == nil {
+			values[i] = arg.raw
+			continue
+		}
+		var b strings.Builder
+		b.WriteString(arg.head)
+		for _, branch := range arg.branches {
+			text, missing := branch.message.render(translate)
+			lost += missing
+			b.WriteString(branch.key + "{" + text + "}")
+		}
+		b.WriteString(arg.tail)
+		values[i] = b.String()
+	}
+
+	text := m.text
+	if translated, ok := translate(m); ok {
+		text = escapeICUApostrophes(translated)
+	}
+	restored, missing := icuArgumentMask.restore(text, values)
+	return restored, lost + missing
+}
+
+// escapeICUApostrophes doubles the single apostrophes a translation puts
+// right before an argument, as in l'__ICU_0__, which would otherwise quote
+// the argument
+func escapeICUApostrophes(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range icuArgumentMask.pattern.FindAllStringIndex(text, -1) {
+		start := loc[0]
+		if start > 0 && text[start-1] == '\'' && (start < 2 || text[start-2] != '\'') {
+			b.WriteString(text[last:start])
+			b.WriteByte('\'')
+			last = start
+		}
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
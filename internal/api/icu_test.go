@@ -0,0 +1,135 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestICUMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		// texts are those of the message and its branches, as translated
+		texts []string
+		want  string
+	}{
+		{
+			name:  "simple argument",
+			text:  "Hello {name}!",
+			texts: []string{"Hello __ICU_0__!"},
+			want:  "[de] Hello {name}!",
+		},
+		{
+			name:  "formatted argument",
+			text:  "Due {date, date, short}",
+			texts: []string{"Due __ICU_0__"},
+			want:  "[de] Due {date, date, short}",
+		},
+		{
+			name:  "plural",
+			text:  "{count, plural, one {# item} other {# items}}",
+			texts: []string{"__ICU_0__ item", "__ICU_0__ items"},
+			want:  "{count, plural, one {[de] # item} other {[de] # items}}",
+		},
+		{
+			name:  "plural with an offset and exact values",
+			text:  "{n, plural, offset:1 =0 {nobody} other {you and # others}}",
+			texts: []string{"nobody", "you and __ICU_0__ others"},
+			want:  "{n, plural, offset:1 =0 {[de] nobody} other {[de] you and # others}}",
+		},
+		{
+			name:  "select in text",
+			text:  "{gender, select, female {She} other {They}} replied to {name}",
+			texts: []string{"__ICU_0__ replied to __ICU_1__", "She", "They"},
+			want:  "[de] {gender, select, female {[de] She} other {[de] They}} replied to {name}",
+		},
+		{
+			name:  "nested",
+			text:  "{g, select, other {{n, plural, one {# reply} other {# replies}}}}",
+			texts: []string{"__ICU_0__ reply", "__ICU_0__ replies"},
+			want:  "{g, select, other {{n, plural, one {[de] # reply} other {[de] # replies}}}}",
+		},
+		{
+			name:  "quoted literal",
+			text:  "Use '{braces}' and it''s fine",
+			texts: []string{"Use __ICU_0__ and it''s fine"},
+			want:  "[de] Use '{braces}' and it''s fine",
+		},
+		{
+			name:  "unbalanced brace",
+			text:  "Oops { here",
+			texts: []string{"Oops { here"},
+			want:  "[de] Oops { here",
+		},
+		{
+			name:  "hash outside of plurals",
+			text:  "Item #{id}",
+			texts: []string{"Item #__ICU_0__"},
+			want:  "[de] Item #{id}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := parseICUMessage(tt.text, false)
+			var texts []string
+			m.messages(func(m *icuMessage) {
+				if m.translatable() {
+					texts = append(texts, m.text)
+				}
+			})
+			if !reflect.DeepEqual(texts, tt.texts) {
+				t.Errorf("texts = %q, want %q", texts, tt.texts)
+			}
+
+			got, lost := m.render(func(m *icuMessage) (string, bool) {
+				if !m.translatable() {
+					return "", false
+				}
+				return "[de] " + m.text, true
+			})
+			if got != tt.want || lost != 0 {
+				t.Errorf("render() = %q, %d lost, want %q", got, lost, tt.want)
+			}
+
+			// Untranslated, the message renders as written
+			if same, _ := m.render(func(*icuMessage) (string, bool) { return "", false }); same != tt.text {
+				t.Errorf("render() without translations = %q, want %q", same, tt.text)
+			}
+		})
+	}
+}
+
+func TestICUMessageLostArguments(t *testing.T) {
+	m := parseICUMessage("{count, plural, one {# file of {total}} other {# files of {total}}}", false)
+	got, lost := m.render(func(m *icuMessage) (string, bool) {
+		if !m.translatable() {
+			return "", false
+		}
+		// The translation drops the total
+		return strings.Replace(m.text, "__ICU_1__", "", 1), true
+	})
+	if lost != 2 {
+		t.Errorf("render() lost %d arguments, want 2", lost)
+	}
+	if want := "{count, plural, one {# file of } other {# files of }}"; got != want {
+		t.Errorf("render() = %q, want %q", got, want)
+	}
+}
+
+func TestEscapeICUApostrophes(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"l'__ICU_0__", "l''__ICU_0__"},
+		{"l''__ICU_0__", "l''__ICU_0__"},
+		{"it's __ICU_0__", "it's __ICU_0__"},
+		{"'__ICU_0__ and d'__ICU_1__", "''__ICU_0__ and d''__ICU_1__"},
+	}
+	for _, tt := range tests {
+		if got := escapeICUApostrophes(tt.text); got != tt.want {
+			t.Errorf("escapeICUApostrophes(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// idempotencyPrefix starts the Redis keys of idempotent requests:
+// idempotency:<scope>:<hash of the Idempotency-Key> holds the JSON
+// idempotencyRecord
+const idempotencyPrefix = "idempotency:"
+
+// idempotencyLockTTL bounds how long a request in progress holds its key,
+// in case its replica dies before finishing it
+const idempotencyLockTTL = 5 * time.Minute
+
+// idempotencyHeaders are the response headers replayed with the body
+var idempotencyHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotencyRecord is a request made with an Idempotency-Key and, once it
+// succeeded, its response
+type idempotencyRecord struct {
+	Fingerprint string            `json:"fingerprint"` // Hash of the request's method, URL and body
+	Done        bool              `json:"done"`
+	Status      int               `json:"status,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// serveIdempotent runs handler once per Idempotency-Key of the caller
+// identified by scope. Retries with the same key get the stored response of
+// the first successful request, so they don't repeat its work; failed
+// requests aren't stored, so they can be retried. While Redis is
+// unavailable requests run without the guarantee.
+func (s *Server) serveIdempotent(w http.ResponseWriter, r *http.Request, scope string, handler http.HandlerFunc) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		handler(w, r)
+		return
+	}
+	if len(key) > 255 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: Idempotency-Key must be at most 255 characters")
+		return
+	}
+	ctx := r.Context()
+	if !s.redisAvailable() {
+		logger(ctx).Warn("Redis unavailable, ignoring Idempotency-Key")
+		handler(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\x00")
+	h.Write(body)
+	fingerprint := hex.EncodeToString(h.Sum(nil))
+
+	keyHash := sha256.Sum256([]byte(key))
+	redisKey := idempotencyPrefix + scope + ":" + hex.EncodeToString(keyHash[:])
+	lock, _ := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+	acquired, err := s.redis.SetNX(ctx, redisKey, lock, idempotencyLockTTL).Result()
+	if err != nil {
+		logger(ctx).Warn("failed to store Idempotency-Key, ignoring it", "error", err)
+		handler(w, r)
+		return
+	}
+	if !acquired {
+		s.replayIdempotent(w, r, redisKey, fingerprint)
+		return
+	}
+
+	rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+	handler(rec, r)
+	if rec.status < 200 || rec.status > 299 {
+		if err := s.redis.Del(ctx, redisKey).Err(); err != nil {
+			logger(ctx).Warn("failed to release Idempotency-Key", "error", err)
+		}
+		return
+	}
+	record := idempotencyRecord{Fingerprint: fingerprint, Done: true, Status: rec.status, Header: map[string]string{}, Body: rec.body.Bytes()}
+	for _, name := range idempotencyHeaders {
+		if value := w.Header().Get(name); value != "" {
+			record.Header[name] = value
+		}
+	}
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = s.redis.Set(ctx, redisKey, data, s.config.IdempotencyTTL).Err()
+	}
+	if err != nil {
+		logger(ctx).Warn("failed to store idempotent response", "error", err)
+	}
+}
+
+// replayIdempotent answers a request whose Idempotency-Key is taken: with
+// the stored response, or an error when the first request is still running
+// or was a different one
+func (s *Server) replayIdempotent(w http.ResponseWriter, r *http.Request, redisKey, fingerprint string) {
+	var record idempotencyRecord
+	data, err := s.redis.Get(r.Context(), redisKey).Bytes()
+	if err == nil {
+		err = json.Unmarshal(data, &record)
+	}
+	switch {
+	case err == redis.Nil:
+		// The first request just failed, the client may retry right away
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusConflict, codeConflict, "A request with this Idempotency-Key just failed, retry it")
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, codeInternalError, "Failed to look up Idempotency-Key: "+err.Error())
+	case record.Fingerprint != fingerprint:
+		writeError(w, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "Idempotency-Key was already used for a different request")
+	case !record.Done:
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusConflict, codeConflict, "A request with this Idempotency-Key is in progress")
+	default:
+		for name, value := range record.Header {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.Header().Set("Content-Length", strconv.Itoa(len(record.Body)))
+		w.WriteHeader(record.Status)
+		w.Write(record.Body)
+	}
+}
+
+// adminIdempotent applies Idempotency-Keys to the mutations of an /admin
+// handler. The admin token is checked first, so stored responses are only
+// replayed to admins.
+func (s *Server) adminIdempotent(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Header.Get("Idempotency-Key") == "" {
+			handler(w, r)
+			return
+		}
+		if !s.authenticateAdmin(w, r) {
+			return
+		}
+		s.serveIdempotent(w, r, "admin", handler)
+	}
+}
+
+// idempotencyRecorder captures the status and body of a response while
+// writing it
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader implements http.ResponseWriter
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (r *idempotencyRecorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
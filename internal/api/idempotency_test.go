@@ -0,0 +1,174 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// idempotentHandler returns a handler serving requests idempotently with
+// the status status, counting the requests it runs in calls
+func idempotentHandler(s *Server, status int, calls *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.serveIdempotent(w, r, "web", func(w http.ResponseWriter, r *http.Request) {
+			n := calls.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", fmt.Sprintf("/jobs/%d", n))
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"call": %d}`, n)
+		})
+	}
+}
+
+// idempotentRequest sends a POST of body with the Idempotency-Key key, if
+// set, to handler
+func idempotentRequest(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestServeIdempotent(t *testing.T) {
+	type request struct {
+		key, body  string
+		wantStatus int
+		wantBody   string
+		replayed   bool
+	}
+	tests := []struct {
+		name      string
+		status    int // Of the handler
+		requests  []request
+		wantCalls int32
+	}{
+		{
+			name:   "without a key",
+			status: http.StatusAccepted,
+			requests: []request{
+				{body: "a", wantStatus: http.StatusAccepted, wantBody: `{"call": 1}`},
+				{body: "a", wantStatus: http.StatusAccepted, wantBody: `{"call": 2}`},
+			},
+			wantCalls: 2,
+		},
+		{
+			name:   "replays the response",
+			status: http.StatusAccepted,
+			requests: []request{
+				{key: "k", body: "a", wantStatus: http.StatusAccepted, wantBody: `{"call": 1}`},
+				{key: "k", body: "a", wantStatus: http.StatusAccepted, wantBody: `{"call": 1}`, replayed: true},
+				{key: "other", body: "a", wantStatus: http.StatusAccepted, wantBody: `{"call": 2}`},
+			},
+			wantCalls: 2,
+		},
+		{
+			name:   "refuses a different request",
+			status: http.StatusAccepted,
+			requests: []request{
+				{key: "k", body: "a", wantStatus: http.StatusAccepted, wantBody: `{"call": 1}`},
+				{key: "k", body: "b", wantStatus: http.StatusUnprocessableEntity},
+			},
+			wantCalls: 1,
+		},
+		{
+			name:   "doesn't store failures",
+			status: http.StatusInternalServerError,
+			requests: []request{
+				{key: "k", body: "a", wantStatus: http.StatusInternalServerError, wantBody: `{"call": 1}`},
+				{key: "k", body: "a", wantStatus: http.StatusInternalServerError, wantBody: `{"call": 2}`},
+			},
+			wantCalls: 2,
+		},
+		{
+			name:   "refuses overlong keys",
+			status: http.StatusAccepted,
+			requests: []request{
+				{key: strings.Repeat("k", 256), body: "a", wantStatus: http.StatusBadRequest},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, nil)
+			var calls atomic.Int32
+			handler := idempotentHandler(s, tt.status, &calls)
+			for i, req := range tt.requests {
+				w := idempotentRequest(handler, req.key, req.body)
+				if w.Code != req.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d: %s", i+1, w.Code, req.wantStatus, w.Body)
+				}
+				if req.wantBody != "" && w.Body.String() != req.wantBody {
+					t.Errorf("request %d: body = %s, want %s", i+1, w.Body, req.wantBody)
+				}
+				if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != req.replayed {
+					t.Errorf("request %d: replayed = %v, want %v", i+1, replayed, req.replayed)
+				}
+				if req.replayed && w.Header().Get("Location") != "/jobs/1" {
+					t.Errorf("request %d: Location = %q, want the first response's", i+1, w.Header().Get("Location"))
+				}
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("handler ran %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestServeIdempotentInProgress(t *testing.T) {
+	s, mr := newTestServer(t, &fakeProvider{}, nil)
+	var calls atomic.Int32
+	handler := idempotentHandler(s, http.StatusAccepted, &calls)
+
+	// The first request stored its lock and is still running
+	first := idempotentRequest(handler, "k", "a")
+	var key string
+	for _, k := range mr.Keys() {
+		if strings.HasPrefix(k, idempotencyPrefix+"web:") {
+			key = k
+		}
+	}
+	hash := sha256.Sum256([]byte("k"))
+	if want := idempotencyPrefix + "web:" + hex.EncodeToString(hash[:]); key != want {
+		t.Fatalf("stored key = %q, want %q", key, want)
+	}
+	var record idempotencyRecord
+	data, _ := mr.Get(key)
+	if err := json.Unmarshal([]byte(data), &record); err != nil || !record.Done || record.Status != first.Code {
+		t.Fatalf("stored record = %+v, %v", record, err)
+	}
+	record.Done = false
+	lock, _ := json.Marshal(record)
+	mr.Set(key, string(lock))
+
+	w := idempotentRequest(handler, "k", "a")
+	if w.Code != http.StatusConflict || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After %q, want 409 with a Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want once", calls.Load())
+	}
+}
+
+func TestServeIdempotentDegraded(t *testing.T) {
+	s, mr := newTestServer(t, &fakeProvider{}, nil)
+	s.redisUp.Store(false)
+	var calls atomic.Int32
+	handler := idempotentHandler(s, http.StatusAccepted, &calls)
+	idempotentRequest(handler, "k", "a")
+	idempotentRequest(handler, "k", "a")
+	if calls.Load() != 2 {
+		t.Errorf("handler ran %d times while Redis is unavailable, want 2", calls.Load())
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("keys = %q, want none", keys)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parsePrefixes parses CIDR prefixes and bare addresses, which stand for
+// themselves
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %v", v, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", v, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// containsAddr reports whether any of prefixes contains addr
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that sent r. Behind trusted
+// proxies it is the last X-Forwarded-For entry not added by one, since
+// anything further left may be forged by the client. It returns the zero
+// Addr when the address can't be parsed.
+func (s *Server) clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr = addr.Unmap()
+	if !containsAddr(s.trustedProxies, addr) {
+		return addr
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// A malformed entry ends the chain that can be trusted
+			return addr
+		}
+		addr = hop.Unmap()
+		if !containsAddr(s.trustedProxies, addr) {
+			return addr
+		}
+	}
+	return addr
+}
+
+// allowlistIPs rejects requests from clients outside IP_ALLOWLIST before
+// any handler runs. Every request is let through when it is empty.
+func (s *Server) allowlistIPs(handler http.Handler) http.Handler {
+	if len(s.ipAllowlist) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := s.clientIP(r)
+		if !addr.IsValid() || !containsAddr(s.ipAllowlist, addr) {
+			logger(r.Context()).Warn("rejected request from address outside the allowlist", "remote_addr", addr)
+			writeError(w, http.StatusForbidden, codeForbidden, "Forbidden")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// mustPrefixes parses prefixes, failing the test when any is invalid
+func mustPrefixes(t *testing.T, values ...string) []netip.Prefix {
+	t.Helper()
+	prefixes, err := parsePrefixes(values)
+	if err != nil {
+		t.Fatalf("parsePrefixes() error = %v", err)
+	}
+	return prefixes
+}
+
+func TestParsePrefixes(t *testing.T) {
+	tests := []struct {
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		{values: []string{"10.0.0.0/8", "192.168.1.7"}, want: []string{"10.0.0.0/8", "192.168.1.7/32"}},
+		{values: []string{"10.1.2.3/8"}, want: []string{"10.0.0.0/8"}},
+		{values: []string{"::ffff:192.168.1.7"}, want: []string{"192.168.1.7/32"}},
+		{values: []string{"2001:db8::/32", "::1"}, want: []string{"2001:db8::/32", "::1/128"}},
+		{values: []string{"10.0.0.0/33"}, wantErr: true},
+		{values: []string{"example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePrefixes(tt.values)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePrefixes(%q) error = %v, want an error: %v", tt.values, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parsePrefixes(%q) = %v, want %q", tt.values, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].String() != tt.want[i] {
+				t.Errorf("parsePrefixes(%q) = %v, want %q", tt.values, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string // X-Forwarded-For headers
+		want       string
+	}{
+		{"direct", "203.0.113.5:4711", nil, "203.0.113.5"},
+		{"forwarded by an untrusted client", "203.0.113.5:4711", []string{"10.0.0.1"}, "203.0.113.5"},
+		{"behind a trusted proxy", "10.0.0.2:4711", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"behind two trusted proxies", "10.0.0.2:4711", []string{"198.51.100.7, 10.0.0.3"}, "198.51.100.7"},
+		{"forged entry left of the client", "10.0.0.2:4711", []string{"10.0.0.9, 198.51.100.7"}, "198.51.100.7"},
+		{"over several headers", "10.0.0.2:4711", []string{"1.2.3.4", "198.51.100.7"}, "198.51.100.7"},
+		{"malformed entry", "10.0.0.2:4711", []string{"198.51.100.7, garbage, 10.0.0.3"}, "10.0.0.3"},
+		{"only trusted proxies", "10.0.0.2:4711", []string{"10.0.0.3"}, "10.0.0.3"},
+		{"trusted proxy without the header", "10.0.0.2:4711", nil, "10.0.0.2"},
+		{"IPv4-mapped", "[::ffff:203.0.113.5]:4711", nil, "203.0.113.5"},
+		{"IPv6", "[2001:db8::1]:4711", nil, "2001:db8::1"},
+		{"unparseable", "somewhere", nil, "invalid IP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, nil)
+			s.trustedProxies = mustPrefixes(t, "10.0.0.0/8")
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, header := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if got := s.clientIP(r).String(); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowlistIPs(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		wantStatus int
+	}{
+		{"allowed", "192.168.1.7:4711", "", http.StatusOK},
+		{"allowed through a trusted proxy", "10.0.0.2:4711", "192.168.1.7", http.StatusOK},
+		{"outside", "203.0.113.5:4711", "", http.StatusForbidden},
+		{"outside through a trusted proxy", "10.0.0.2:4711", "203.0.113.5", http.StatusForbidden},
+		{"forging an allowed address", "203.0.113.5:4711", "192.168.1.7", http.StatusForbidden},
+		{"forging behind a trusted proxy", "10.0.0.2:4711", "192.168.1.7, 203.0.113.5", http.StatusForbidden},
+		{"unparseable", "somewhere", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, nil)
+			s.ipAllowlist = mustPrefixes(t, "192.168.1.0/24")
+			s.trustedProxies = mustPrefixes(t, "10.0.0.0/8")
+			handler := s.allowlistIPs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest(http.MethodGet, "/translate", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,548 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis keys used by the job queue
+const (
+	jobQueueKey         = "jobs:queue"       // List of queued job IDs
+	jobRecordPrefix     = "jobs:id:"         // jobs:id:<id> holds the JSON Job
+	jobProcessingPrefix = "jobs:processing:" // jobs:processing:<replica>:<n> lists the job worker n of a replica is running
+	jobLeasePrefix      = "jobs:lease:"      // jobs:lease:<replica> exists while the replica's workers are alive
+)
+
+// jobPollTimeout bounds how long an idle worker blocks waiting for a job, so
+// it notices shutdown promptly
+const jobPollTimeout = 2 * time.Second
+
+// jobLeaseTTL is how long a replica holds on to the jobs its workers are
+// running without renewing its lease. The jobs of replicas that stop
+// renewing it, having crashed or been killed, are queued again.
+const jobLeaseTTL = 30 * time.Second
+
+// jobProgressInterval is how often a running job's progress is saved
+const jobProgressInterval = time.Second
+
+// Job statuses
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+)
+
+// errJobNotFound is returned when a job does not exist or has expired
+var errJobNotFound = errors.New("job not found")
+
+// CreateJobRequest is the body of POST /jobs: a batch of requests, or the
+// input and output objects of a file job
+type CreateJobRequest struct {
+	Requests    []TranslationRequest `json:"requests"`
+	CallbackURL string               `json:"callback_url,omitempty"` // Receives the finished job
+
+	InputURI    string              `json:"input_uri,omitempty"`    // s3:// or gs:// object of records to translate
+	OutputURI   string              `json:"output_uri,omitempty"`   // Object the translated records are written to
+	InputFormat string              `json:"input_format,omitempty"` // csv, jsonl or xliff, by default from the input's extension
+	Fields      []string            `json:"fields,omitempty"`       // CSV columns or JSONL fields translated, text by default; source:target translates into target
+	Options     *TranslationRequest `json:"options,omitempty"`      // How records are translated, without text
+}
+
+// JobResult is the outcome of one request of a job
+type JobResult struct {
+	*TranslationResponse
+	Error string `json:"error,omitempty"`
+}
+
+// Job is an asynchronous batch of translations. File jobs have no
+// requests and results: their records are read from InputURI and written
+// to OutputURI, and only those that fail are listed, in Errors.
+type Job struct {
+	ID          string               `json:"id"`
+	Status      string               `json:"status"`
+	KeyName     string               `json:"-"`
+	Tenant      string               `json:"-"`
+	Requests    []TranslationRequest `json:"requests,omitempty"`
+	Total       int                  `json:"total"`
+	Completed   int                  `json:"completed"`
+	Failed      int                  `json:"failed"`
+	Results     []JobResult          `json:"results,omitempty"`
+	CreatedAt   time.Time            `json:"created_at"`
+	StartedAt   *time.Time           `json:"started_at,omitempty"`
+	CompletedAt *time.Time           `json:"completed_at,omitempty"`
+
+	CallbackURL      string `json:"callback_url,omitempty"`
+	CallbackStatus   string `json:"callback_status,omitempty"` // pending, delivered or failed
+	CallbackAttempts int    `json:"callback_attempts,omitempty"`
+
+	InputURI    string              `json:"input_uri,omitempty"`
+	OutputURI   string              `json:"output_uri,omitempty"`
+	InputFormat string              `json:"input_format,omitempty"`
+	Fields      []string            `json:"fields,omitempty"`
+	Options     *TranslationRequest `json:"-"`
+	Errors      []JobRecordError    `json:"errors,omitempty"` // The first jobMaxRecordErrors
+	Error       string              `json:"error,omitempty"`  // Why a file job failed as a whole
+	Accounted   int                 `json:"-"`                // Records of a file job whose usage is recorded
+}
+
+// jobRecord is how a job is stored; unlike the API view it keeps the owner,
+// its tenant, and the options and usage of file jobs
+type jobRecord struct {
+	Job
+	KeyName   string              `json:"key_name"`
+	Tenant    string              `json:"tenant,omitempty"`
+	Options   *TranslationRequest `json:"options,omitempty"`
+	Accounted int                 `json:"accounted,omitempty"`
+}
+
+// getJob loads a job by ID
+func (s *Server) getJob(ctx context.Context, id string) (*Job, error) {
+	data, err := s.redis.Get(ctx, jobRecordPrefix+id).Result()
+	if err == redis.Nil {
+		return nil, errJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record jobRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job: %v", err)
+	}
+	record.Job.KeyName, record.Job.Tenant = record.KeyName, record.Tenant
+	record.Job.Options, record.Job.Accounted = record.Options, record.Accounted
+	return &record.Job, nil
+}
+
+// saveJob stores a job, which expires JOB_RETENTION after its last update
+func (s *Server) saveJob(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(jobRecord{Job: *job, KeyName: job.KeyName, Tenant: job.Tenant, Options: job.Options, Accounted: job.Accounted})
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, jobRecordPrefix+job.ID, data, s.config.JobRetention).Err()
+}
+
+// enqueueJob stores a new job and queues it for the workers
+func (s *Server) enqueueJob(ctx context.Context, keyName string, req CreateJobRequest) (*Job, error) {
+	id, err := randomToken(12)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		ID:          id,
+		Status:      jobQueued,
+		KeyName:     keyName,
+		Requests:    req.Requests,
+		Total:       len(req.Requests),
+		CreatedAt:   time.Now().UTC(),
+		CallbackURL: req.CallbackURL,
+		InputURI:    req.InputURI,
+		OutputURI:   req.OutputURI,
+		InputFormat: req.InputFormat,
+		Fields:      req.Fields,
+		Options:     req.Options,
+	}
+	if tenant := requestTenant(ctx); tenant != nil {
+		job.Tenant = tenant.ID
+	}
+	if req.CallbackURL != "" {
+		job.CallbackStatus = callbackPending
+	}
+	if err := s.saveJob(ctx, job); err != nil {
+		return nil, err
+	}
+	if err := s.redis.LPush(ctx, jobQueueKey, id).Err(); err != nil {
+		return nil, err
+	}
+	jobsTotal.WithLabelValues(jobQueued).Inc()
+	return job, nil
+}
+
+// startJobWorkers starts n workers processing queued jobs until ctx is
+// done, and the renewal of their replica's lease
+func (s *Server) startJobWorkers(ctx context.Context, n int) {
+	replica, err := randomToken(8)
+	if err != nil {
+		slog.Error("failed to start job workers", "error", err)
+		return
+	}
+	leased := make(chan struct{})
+	s.jobWorkers.Add(1)
+	go func() {
+		defer s.jobWorkers.Done()
+		s.renewJobLease(ctx, replica, leased)
+	}()
+	for i := 0; i < n; i++ {
+		processing := fmt.Sprintf("%s%s:%d", jobProcessingPrefix, replica, i)
+		s.jobWorkers.Add(1)
+		go func() {
+			defer s.jobWorkers.Done()
+			// Jobs are only taken under a lease, lest they be recovered
+			select {
+			case <-leased:
+			case <-ctx.Done():
+				return
+			}
+			s.runJobWorker(ctx, processing)
+		}()
+	}
+}
+
+// renewJobLease keeps the lease of a replica's workers on their jobs until
+// ctx is done, closing leased once it is first taken, and queues the jobs
+// of replicas whose lease expired again. The lease is left to expire on
+// shutdown, after the jobs are requeued.
+func (s *Server) renewJobLease(ctx context.Context, replica string, leased chan struct{}) {
+	for ctx.Err() == nil {
+		if s.redisAvailable() {
+			if err := s.redis.Set(ctx, jobLeasePrefix+replica, "1", jobLeaseTTL).Err(); err != nil {
+				slog.Error("failed to renew job lease", "error", err)
+			} else {
+				select {
+				case <-leased:
+				default:
+					close(leased)
+				}
+				s.recoverJobs(ctx)
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(jobLeaseTTL / 3):
+		}
+	}
+}
+
+// recoverJobs queues the jobs of workers whose replica's lease expired
+// again, for other workers to resume
+func (s *Server) recoverJobs(ctx context.Context) {
+	iter := s.redis.Scan(ctx, 0, jobProcessingPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		worker := strings.TrimPrefix(key, jobProcessingPrefix)
+		replica := worker[:max(strings.LastIndex(worker, ":"), 0)]
+		if n, err := s.redis.Exists(ctx, jobLeasePrefix+replica).Result(); err != nil || n > 0 {
+			continue
+		}
+		for {
+			id, err := s.redis.LMove(ctx, key, jobQueueKey, "RIGHT", "RIGHT").Result()
+			if err != nil {
+				if err != redis.Nil {
+					slog.Error("failed to recover job", "worker", worker, "error", err)
+				}
+				break
+			}
+			if job, err := s.getJob(ctx, id); err == nil && job.Status == jobRunning {
+				job.Status = jobQueued
+				if err := s.saveJob(ctx, job); err != nil {
+					slog.Error("failed to save job", "job_id", id, "error", err)
+				}
+			}
+			slog.Warn("job of a stopped worker requeued", "job_id", id, "worker", worker)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		slog.Error("failed to list running jobs", "error", err)
+	}
+}
+
+// waitForJobWorkers waits for the workers to finish their current job, or
+// for ctx to expire
+func (s *Server) waitForJobWorkers(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.jobWorkers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("job workers did not stop in time")
+	}
+}
+
+// runJobWorker takes jobs off the queue one at a time until ctx is done.
+// A job is moved to the worker's processing list while it runs, so it can be
+// recovered if the worker dies.
+func (s *Server) runJobWorker(ctx context.Context, processing string) {
+	for ctx.Err() == nil {
+		if !s.redisAvailable() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(s.config.RedisHealthInterval):
+			}
+			continue
+		}
+
+		id, err := s.redis.BLMove(ctx, jobQueueKey, processing, "RIGHT", "LEFT", jobPollTimeout).Result()
+		if err == redis.Nil || ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			slog.Error("failed to take job from queue", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(jobPollTimeout):
+			}
+			continue
+		}
+		s.runJob(ctx, processing, id)
+		if err := s.redis.LRem(context.WithoutCancel(ctx), processing, 0, id).Err(); err != nil {
+			slog.Error("failed to remove finished job from processing list", "job_id", id, "error", err)
+		}
+	}
+}
+
+// runJob processes a job's requests in order, or the records of a file job.
+// If ctx is done before the job finishes, the job is queued again for
+// another worker to resume, off the processing list.
+func (s *Server) runJob(ctx context.Context, processing, id string) {
+	// Finish Redis writes even while shutting down
+	storeCtx := context.WithoutCancel(ctx)
+
+	job, err := s.getJob(storeCtx, id)
+	if err != nil {
+		slog.Error("failed to load job", "job_id", id, "error", err)
+		return
+	}
+	log := slog.With("job_id", id, "key_id", job.KeyName)
+	if job.Status == jobCompleted || job.Status == jobFailed {
+		// Recovered from a worker that died as it finished
+		return
+	}
+	now := time.Now().UTC()
+	job.Status = jobRunning
+	if job.StartedAt == nil {
+		job.StartedAt = &now
+	}
+	if err := s.saveJob(storeCtx, job); err != nil {
+		log.Error("failed to save job", "error", err)
+	}
+	log.Info("job started", "requests", job.Total, "resumed_at", len(job.Results))
+
+	// Jobs aren't waited on interactively, so they give way to other
+	// traffic when provider budgets run low
+	translateCtx := withLowPriority(s.withAPIKeyName(ctx, job.KeyName, job.Tenant))
+	if job.InputURI != "" {
+		if !s.translateFile(ctx, translateCtx, storeCtx, log, job) {
+			s.requeueJob(storeCtx, log, processing, job)
+			return
+		}
+	} else {
+		lastSaved := time.Now()
+		for i := len(job.Results); i < len(job.Requests); i++ {
+			req := job.Requests[i]
+			response, err := s.translateDeferred(translateCtx, req)
+			if ctx.Err() != nil {
+				// Shutting down, let another worker resume from this request
+				s.requeueJob(storeCtx, log, processing, job)
+				return
+			}
+			if err != nil {
+				job.Failed++
+				job.Results = append(job.Results, JobResult{Error: err.Error()})
+			} else {
+				job.Completed++
+				job.Results = append(job.Results, JobResult{TranslationResponse: response})
+				s.recordQuotaUsage(translateCtx, response.Characters)
+				s.recordUsage(translateCtx, job.KeyName, response)
+			}
+
+			if time.Since(lastSaved) >= jobProgressInterval {
+				if err := s.saveJob(storeCtx, job); err != nil {
+					log.Error("failed to save job progress", "error", err)
+				}
+				lastSaved = time.Now()
+			}
+		}
+	}
+
+	done := time.Now().UTC()
+	job.CompletedAt = &done
+	job.Status = jobCompleted
+	if job.Error != "" || job.Total > 0 && job.Failed == job.Total {
+		job.Status = jobFailed
+	}
+	if err := s.saveJob(storeCtx, job); err != nil {
+		log.Error("failed to save job", "error", err)
+	}
+	jobsTotal.WithLabelValues(job.Status).Inc()
+	log.Info("job finished", "status", job.Status, "completed", job.Completed, "failed", job.Failed,
+		"duration_ms", done.Sub(*job.StartedAt).Milliseconds())
+
+	if job.CallbackURL != "" {
+		s.deliverCallback(ctx, job)
+		if err := s.saveJob(storeCtx, job); err != nil {
+			log.Error("failed to save job", "error", err)
+		}
+	}
+}
+
+// requeueJob queues a job again on shutdown, moving it off the worker's
+// processing list, for another worker to resume
+func (s *Server) requeueJob(ctx context.Context, log *slog.Logger, processing string, job *Job) {
+	job.Status = jobQueued
+	if err := s.saveJob(ctx, job); err != nil {
+		log.Error("failed to save job", "error", err)
+	}
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, jobQueueKey, job.ID)
+		pipe.LRem(ctx, processing, 0, job.ID)
+		return nil
+	})
+	if err != nil {
+		log.Error("failed to requeue job", "error", err)
+	}
+	log.Info("job requeued on shutdown", "completed", job.Completed+job.Failed)
+}
+
+// handleJobs serves the asynchronous job API:
+//
+//	POST /jobs             queue a batch of translation requests
+//	GET  /jobs/{id}        show a job's status and, once done, its results
+//	GET  /jobs/{id}/events stream a job's results as they complete
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	id, sub, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"), "/")
+	if sub == "events" {
+		acceptQueryAPIKey(r)
+	}
+	ctx, ok := s.authenticateRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: Invalid API key")
+		logger(r.Context()).Warn("unauthorized request", "remote_addr", s.clientIP(r))
+		return
+	}
+	keyName := apiKeyName(ctx)
+
+	// Jobs live in Redis, so there is no degraded mode
+	if !s.redisAvailable() {
+		writeError(w, http.StatusServiceUnavailable, codeServiceUnavailable, fmt.Sprintf("Jobs unavailable: %v", s.redisStatusError()))
+		return
+	}
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		s.serveIdempotent(w, r, "key:"+keyName, func(w http.ResponseWriter, r *http.Request) {
+			s.createJob(ctx, w, r, keyName)
+		})
+	case id != "" && sub == "events":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.streamJobEvents(ctx, w, r, id)
+	case sub != "":
+		handleNotFound(w, r)
+	case id != "" && r.Method == http.MethodGet:
+		job, ok := s.lookupJob(ctx, w, id)
+		if !ok {
+			return
+		}
+		job.Requests = nil
+		if job.Status != jobCompleted && job.Status != jobFailed {
+			job.Results = nil
+		}
+		// Polling clients only get the job again once it changed
+		if notModified(w, r, jobETag(job)) {
+			return
+		}
+		writeResponse(w, r, http.StatusOK, job)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// lookupJob loads a job of the caller, writing the error response and
+// returning false when it fails or the job is another key's
+func (s *Server) lookupJob(ctx context.Context, w http.ResponseWriter, id string) (*Job, bool) {
+	job, err := s.getJob(ctx, id)
+	if err == nil && job.KeyName != apiKeyName(ctx) {
+		// Don't reveal other keys' jobs
+		err = errJobNotFound
+	}
+	if err == errJobNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Job lookup failed: %v", err))
+		return nil, false
+	}
+	return job, true
+}
+
+// createJob validates and queues the job in the body of a POST /jobs
+func (s *Server) createJob(ctx context.Context, w http.ResponseWriter, r *http.Request, keyName string) {
+	var req CreateJobRequest
+	if !decodeRequestBody(w, r, &req) {
+		return
+	}
+	if req.InputURI != "" {
+		if err := s.validateFileJob(&req); err != nil {
+			writeError(w, http.StatusBadRequest, invalidRequestCode(err), fmt.Sprintf("Invalid request: %v", err))
+			return
+		}
+	} else if len(req.Requests) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: requests or input_uri are required")
+		return
+	}
+	if len(req.Requests) > s.config.JobMaxRequests {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: at most %d requests per job", s.config.JobMaxRequests))
+		return
+	}
+	if req.CallbackURL != "" {
+		if s.config.WebhookSecret == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: callbacks are disabled, WEBHOOK_SECRET is not set")
+			return
+		}
+		if err := s.validateCallbackURL(req.CallbackURL); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+			return
+		}
+	}
+	chars := 0
+	for i := range req.Requests {
+		if err := validateTranslationRequest(&req.Requests[i]); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request %d: %v", i, err))
+			return
+		}
+		chars += utf8.RuneCountInString(req.Requests[i].Text)
+	}
+
+	// Jobs count as one request against the rate limit; their characters
+	// count against the daily quota as they are translated, and those of
+	// file jobs only then
+	if ok, retry := s.checkRateLimit(ctx, 0); !ok {
+		writeRateLimited(w, retry)
+		logger(ctx).Warn("rate limited request")
+		return
+	}
+	if !s.checkQuota(ctx, w, chars) {
+		return
+	}
+
+	job, err := s.enqueueJob(ctx, keyName, req)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to queue job: %v", err))
+		return
+	}
+	if job.InputURI != "" {
+		logger(ctx).Info("file job queued", "job_id", job.ID, "input_uri", job.InputURI, "output_uri", job.OutputURI, "format", job.InputFormat)
+	} else {
+		logger(ctx).Info("job queued", "job_id", job.ID, "requests", job.Total, "characters", chars)
+	}
+
+	job.Requests = nil
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeResponse(w, r, http.StatusAccepted, job)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// jobEventsKeepAlive is how often a comment is sent on an idle job event
+// stream, so proxies don't take it for dead
+const jobEventsKeepAlive = 15 * time.Second
+
+// jobEventsRetry is how long clients wait before reconnecting to an ended
+// job event stream, in milliseconds
+const jobEventsRetry = 1000
+
+// JobResultEvent is the data of a result event of GET /jobs/{id}/events
+type JobResultEvent struct {
+	Index int `json:"index"` // Of the request in the job
+	JobResult
+}
+
+// streamJobEvents streams the results of a job as Server-Sent Events as
+// they complete, rather than once the job is done:
+//
+//	event: status  the job's status and progress, whenever its status changes
+//	event: result  a JobResultEvent, with the request's index as event ID
+//	event: done    the finished job, without its results, ending the stream
+//
+// Progress is read from the job as workers save it, every
+// jobProgressInterval. Clients reconnecting with Last-Event-ID, as
+// EventSource does, resume after the result it names; streams end before
+// WRITE_TIMEOUT and on shutdown for them to do so.
+func (s *Server) streamJobEvents(ctx context.Context, w http.ResponseWriter, r *http.Request, id string) {
+	job, ok := s.lookupJob(ctx, w, id)
+	if !ok {
+		return
+	}
+	next := 0
+	if last, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && last >= 0 {
+		next = last + 1
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	fmt.Fprintf(w, "retry: %d\n\n", jobEventsRetry)
+
+	var deadline <-chan time.Time
+	if s.config.WriteTimeout > 0 {
+		timer := time.NewTimer(s.config.WriteTimeout * 9 / 10)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	poll := time.NewTicker(jobProgressInterval)
+	defer poll.Stop()
+	lastStatus, lastWrite := "", time.Now()
+	for {
+		for ; next < len(job.Results); next++ {
+			lastWrite = time.Now()
+			writeJobEvent(w, "result", strconv.Itoa(next), JobResultEvent{Index: next, JobResult: job.Results[next]})
+		}
+		if job.Status != lastStatus {
+			lastStatus, lastWrite = job.Status, time.Now()
+			writeJobEvent(w, "status", "", map[string]interface{}{
+				"status": job.Status, "total": job.Total, "completed": job.Completed, "failed": job.Failed,
+			})
+		}
+		if time.Since(lastWrite) >= jobEventsKeepAlive {
+			lastWrite = time.Now()
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if job.Status == jobCompleted || job.Status == jobFailed {
+			job.Requests, job.Results = nil, nil
+			writeJobEvent(w, "done", "", job)
+			rc.Flush()
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopping:
+			return
+		case <-deadline:
+			return
+		case <-poll.C:
+		}
+		updated, err := s.getJob(ctx, id)
+		if err != nil {
+			// Expired or unreadable, the client can look it up again
+			logger(ctx).Warn("failed to load job for its event stream", "job_id", id, "error", err)
+			return
+		}
+		job = updated
+	}
+}
+
+// writeJobEvent writes a Server-Sent Event of data as JSON, with an ID
+// unless empty
+func writeJobEvent(w http.ResponseWriter, event, id string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		payload = []byte(`{}`)
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
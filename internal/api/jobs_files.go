@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// jobMaxRecordErrors bounds the record errors kept on a file job; the
+// count of failed records goes on
+const jobMaxRecordErrors = 100
+
+// JobRecordError is the error of a record of a file job that wasn't
+// translated, and was written to the output as it was
+type JobRecordError struct {
+	Index int    `json:"index"` // Of the record in the input, from 0 and without the CSV header
+	Error string `json:"error"`
+}
+
+// validateFileJob checks the input and output objects, format, fields and
+// translation options of a file job, filling in defaults
+func (s *Server) validateFileJob(req *CreateJobRequest) error {
+	if len(s.config.JobStorageBuckets) == 0 {
+		return errors.New("file jobs are disabled, JOB_STORAGE_BUCKETS is not set")
+	}
+	if len(req.Requests) > 0 {
+		return errors.New("requests and input_uri are mutually exclusive")
+	}
+	if req.OutputURI == "" {
+		return errors.New("output_uri is required with input_uri")
+	}
+	input, err := parseObjectURI(req.InputURI)
+	if err != nil {
+		return fmt.Errorf("input_uri: %v", err)
+	}
+	output, err := parseObjectURI(req.OutputURI)
+	if err != nil {
+		return fmt.Errorf("output_uri: %v", err)
+	}
+	for name, object := range map[string]objectURI{"input_uri": input, "output_uri": output} {
+		if !s.storageBucketAllowed(object) {
+			return fmt.Errorf("%s: bucket %s://%s is not in JOB_STORAGE_BUCKETS", name, object.scheme, object.bucket)
+		}
+	}
+	if input == output {
+		return errors.New("output_uri must differ from input_uri")
+	}
+
+	if req.InputFormat == "" {
+		req.InputFormat = recordFormatOf(input.key)
+		if req.InputFormat == "" {
+			return errors.New("input_format is required for input_uri without a .csv, .jsonl, .ndjson, .xlf or .xliff extension")
+		}
+	}
+	switch req.InputFormat {
+	case recordsCSV, recordsJSONL:
+		if len(req.Fields) == 0 {
+			req.Fields = defaultRecordFields
+		}
+		if _, err := parseRecordFields(req.Fields); err != nil {
+			return err
+		}
+	case recordsXLIFF:
+		if len(req.Fields) > 0 {
+			return errors.New("fields don't apply to xliff, whose sources are translated")
+		}
+	default:
+		return fmt.Errorf("input_format must be %q, %q or %q", recordsCSV, recordsJSONL, recordsXLIFF)
+	}
+
+	if req.Options == nil {
+		return errors.New("options are required with input_uri")
+	}
+	req.Options.Text = ""
+	if req.Options.Verify {
+		return errors.New("verify is not supported by file jobs")
+	}
+	if err := validateTranslationOptions(req.Options); err != nil {
+		return fmt.Errorf("options: %v", err)
+	}
+	return validateLanguages(*req.Options)
+}
+
+// translateFile translates the records of a file job's input object into
+// its output object, JOB_FILE_BATCH_SIZE at once. Records are streamed and
+// written in order; those that fail are written as they were, and listed
+// in the job's errors. It returns false when ctx is done before the job
+// finishes, discarding the output, as a resumed job starts over.
+func (s *Server) translateFile(ctx, translateCtx, storeCtx context.Context, log *slog.Logger, job *Job) bool {
+	job.Total, job.Completed, job.Failed, job.Errors, job.Error = 0, 0, 0, nil, ""
+	fail := func(err error) bool {
+		job.Error = err.Error()
+		log.Error("file job failed", "error", err)
+		return true
+	}
+
+	input, _ := parseObjectURI(job.InputURI)
+	output, _ := parseObjectURI(job.OutputURI)
+	r, err := s.openObject(storeCtx, input)
+	if err != nil {
+		return fail(fmt.Errorf("failed to read %s: %v", input, err))
+	}
+	defer r.Close()
+	w, err := s.createObject(storeCtx, output, recordFormats[job.InputFormat].contentType)
+	if err != nil {
+		return fail(fmt.Errorf("failed to write %s: %v", output, err))
+	}
+	fields, _ := parseRecordFields(job.Fields)
+	codec, err := newRecordCodec(job.InputFormat, fields, "", r, w, s.config.JobFileMaxXLIFFBytes, s.config.MaxBodyBytes)
+	if err != nil {
+		w.Abort()
+		return fail(fmt.Errorf("invalid %s file: %v", job.InputFormat, err))
+	}
+
+	sourceLang := job.Options.SourceLang
+	lastSaved := time.Now()
+	for done := false; !done; {
+		var batch []*fileRecord
+		for len(batch) < s.config.JobFileBatchSize {
+			rec, err := codec.next()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				w.Abort()
+				return fail(fmt.Errorf("invalid %s file at record %d: %v", job.InputFormat, job.Total+len(batch), err))
+			}
+			batch = append(batch, rec)
+		}
+
+		errs, texts, responses := s.translateRecords(translateCtx, s.translateDeferred, *job.Options, batch)
+		if ctx.Err() != nil {
+			w.Abort()
+			return false
+		}
+		// Usage of the records translated before a restart is recorded
+		// already
+		if job.Total+len(batch) > job.Accounted {
+			s.recordStringsUsage(translateCtx, texts, responses)
+			job.Accounted = job.Total + len(batch)
+		}
+		for _, response := range responses {
+			if sourceLang == "" {
+				sourceLang = response.SourceLang
+			}
+		}
+		for i, rec := range batch {
+			if err := codec.write(rec, errs[i]); err != nil {
+				w.Abort()
+				return fail(fmt.Errorf("failed to write %s: %v", output, err))
+			}
+			if errs[i] == nil {
+				job.Completed++
+				continue
+			}
+			job.Failed++
+			if len(job.Errors) < jobMaxRecordErrors {
+				job.Errors = append(job.Errors, JobRecordError{Index: job.Total + i, Error: errs[i].Error()})
+			}
+		}
+		job.Total += len(batch)
+
+		if time.Since(lastSaved) >= jobProgressInterval {
+			if err := s.saveJob(storeCtx, job); err != nil {
+				log.Error("failed to save job progress", "error", err)
+			}
+			lastSaved = time.Now()
+		}
+	}
+
+	if err := codec.close(sourceLang, job.Options.TargetLang); err != nil {
+		w.Abort()
+		return fail(fmt.Errorf("failed to write %s: %v", output, err))
+	}
+	if err := w.Close(); err != nil {
+		return fail(fmt.Errorf("failed to write %s: %v", output, err))
+	}
+	return true
+}
+
+// recordTexts returns the distinct texts of a batch of records to
+// translate, and the index of each
+func recordTexts(records []*fileRecord) ([]string, map[string]int) {
+	index := make(map[string]int)
+	var texts []string
+	for _, rec := range records {
+		if rec.err != nil {
+			continue
+		}
+		for _, text := range rec.texts {
+			if _, ok := index[text]; !ok && strings.TrimSpace(text) != "" {
+				index[text] = len(texts)
+				texts = append(texts, text)
+			}
+		}
+	}
+	return texts, index
+}
+
+// translateRecords translates the texts of a batch of records in place
+// with translate, DOCUMENT_CONCURRENCY at once and repeated texts once. It
+// returns the error of each record, whose texts are left as they were when
+// any fails, and the distinct texts translated with their translations.
+func (s *Server) translateRecords(ctx context.Context, translate func(context.Context, TranslationRequest) (*TranslationResponse, error), base TranslationRequest, records []*fileRecord) ([]error, []string, []*TranslationResponse) {
+	texts, index := recordTexts(records)
+	responses := make([]*TranslationResponse, len(texts))
+	errs := make([]error, len(texts))
+	var group errgroup.Group
+	group.SetLimit(max(s.config.DocumentConcurrency, 1))
+	for i, text := range texts {
+		i, req := i, base
+		req.Text = text
+		group.Go(func() error {
+			responses[i], errs[i] = translate(ctx, req)
+			return nil
+		})
+	}
+	group.Wait()
+
+	recordErrs := make([]error, len(records))
+	for r, rec := range records {
+		if rec.err != nil {
+			recordErrs[r] = rec.err
+			continue
+		}
+		for _, text := range rec.texts {
+			if j, ok := index[text]; ok && errs[j] != nil {
+				recordErrs[r] = errs[j]
+				break
+			}
+		}
+		if recordErrs[r] != nil {
+			continue
+		}
+		for i, text := range rec.texts {
+			if j, ok := index[text]; ok {
+				rec.set(i, responses[j].TranslatedText)
+			}
+		}
+	}
+	var translated []string
+	var succeeded []*TranslationResponse
+	for i, response := range responses {
+		if errs[i] == nil {
+			translated = append(translated, texts[i])
+			succeeded = append(succeeded, response)
+		}
+	}
+	return recordErrs, translated, succeeded
+}
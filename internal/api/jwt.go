@@ -0,0 +1,279 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+// jwtKeyNamePrefix prefixes the subject of a JWT to name the caller, keeping
+// it apart from API key names
+const jwtKeyNamePrefix = "jwt:"
+
+// Bounds of JWT validation
+const (
+	jwtLeeway        = 30 * time.Second // Clock skew tolerated for exp and nbf
+	jwksMinRefetch   = 30 * time.Second // Unknown key IDs refetch the JWKS at most this often
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// jwtMethods are the accepted signing algorithms; HMAC is excluded since
+// tokens are verified with public keys only
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// jwtClaims are the claims of a validated JWT the service uses
+type jwtClaims struct {
+	subject string
+	tenant  string
+	scopes  []string
+}
+
+// hasScope reports whether the claims grant scope
+func (c *jwtClaims) hasScope(scope string) bool {
+	for _, s := range c.scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// jwksCache holds the signing keys of the JWKS, by key ID
+type jwksCache struct {
+	mu          sync.RWMutex
+	keys        map[string]interface{}
+	fetched     time.Time
+	lastAttempt time.Time
+	group       singleflight.Group
+	httpClient  *http.Client
+	url         string        // JWT_JWKS_URL
+	refresh     time.Duration // JWT_JWKS_REFRESH
+}
+
+// jwtEnabled reports whether JWTs are accepted
+func (s *Server) jwtEnabled() bool {
+	return s.config.JWTJWKSURL != ""
+}
+
+// looksLikeJWT reports whether a bearer token is a compact JWS rather than
+// an API key
+func looksLikeJWT(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
+
+// key returns the key with the given ID, fetching the JWKS when it is older
+// than JWT_JWKS_REFRESH or doesn't have the key, e.g. after a rotation
+func (c *jwksCache) key(ctx context.Context, kid string) (interface{}, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	fresh := time.Since(c.fetched) < c.refresh
+	recent := time.Since(c.lastAttempt) < jwksMinRefetch
+	c.mu.RUnlock()
+	if ok && fresh || recent {
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		return key, nil
+	}
+
+	_, err, _ := c.group.Do("fetch", func() (interface{}, error) {
+		return nil, c.fetch(ctx)
+	})
+	c.mu.RLock()
+	key, ok = c.keys[kid]
+	c.mu.RUnlock()
+	if !ok {
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+		}
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	// Keys already known stay usable while the JWKS can't be fetched
+	if err != nil {
+		logger(ctx).Warn("failed to refresh JWKS", "error", err)
+	}
+	return key, nil
+}
+
+// fetch replaces the keys with those of the JWKS
+func (c *jwksCache) fetch(ctx context.Context) error {
+	c.mu.Lock()
+	c.lastAttempt = time.Now()
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS returned status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("invalid JWKS: %v", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, raw := range set.Keys {
+		kid, key, err := parseJWK(raw)
+		if err != nil {
+			slog.Warn("ignoring JWKS key", "kid", kid, "error", err)
+			continue
+		}
+		if key != nil {
+			keys[kid] = key
+		}
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.fetched = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// parseJWK parses an RSA, EC or Ed25519 public key of a JWKS, returning a
+// nil key for keys that aren't for signatures
+func parseJWK(raw json.RawMessage) (string, interface{}, error) {
+	var jwk struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return jwk.Kid, nil, nil
+	}
+	decode := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, e := decode(jwk.N), decode(jwk.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			return jwk.Kid, nil, errors.New("invalid RSA key")
+		}
+		return jwk.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return jwk.Kid, nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, y := decode(jwk.X), decode(jwk.Y)
+		if x == nil || y == nil || !curve.IsOnCurve(x, y) {
+			return jwk.Kid, nil, errors.New("invalid EC key")
+		}
+		return jwk.Kid, &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return jwk.Kid, nil, errors.New("invalid Ed25519 key")
+		}
+		return jwk.Kid, ed25519.PublicKey(x), nil
+	default:
+		return jwk.Kid, nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+// validateJWT verifies a JWT's signature against the JWKS and its issuer,
+// audience and validity period, returning its claims
+func (s *Server) validateJWT(ctx context.Context, token string) (*jwtClaims, error) {
+	opts := []jwt.ParserOption{jwt.WithValidMethods(jwtMethods), jwt.WithExpirationRequired(), jwt.WithLeeway(jwtLeeway)}
+	if s.config.JWTIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(s.config.JWTIssuer))
+	}
+	if s.config.JWTAudience != "" {
+		opts = append(opts, jwt.WithAudience(s.config.JWTAudience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return s.jwks.key(ctx, kid)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	result := &jwtClaims{subject: subject}
+	if s.config.JWTTenantClaim != "" {
+		result.tenant, _ = claims[s.config.JWTTenantClaim].(string)
+		if result.tenant != "" && !validTenantID.MatchString(result.tenant) {
+			return nil, fmt.Errorf("invalid tenant claim %q", result.tenant)
+		}
+	}
+	// Scopes are a space-separated string (RFC 8693) or a list
+	switch scopes := claims[s.config.JWTScopeClaim].(type) {
+	case string:
+		result.scopes = strings.Fields(scopes)
+	case []interface{}:
+		for _, scope := range scopes {
+			if scope, ok := scope.(string); ok {
+				result.scopes = append(result.scopes, scope)
+			}
+		}
+	}
+	return result, nil
+}
+
+// authenticateJWT validates the JWT of an API request, which needs
+// JWT_REQUIRED_SCOPE when set, and returns the caller's name and tenant
+func (s *Server) authenticateJWT(r *http.Request, token string) (string, string, bool) {
+	claims, err := s.validateJWT(r.Context(), token)
+	if err != nil {
+		logger(r.Context()).Warn("invalid JWT", "error", err)
+		return "", "", false
+	}
+	if s.config.JWTRequiredScope != "" && !claims.hasScope(s.config.JWTRequiredScope) {
+		logger(r.Context()).Warn("JWT lacks the required scope", "subject", claims.subject, "scope", s.config.JWTRequiredScope)
+		return "", "", false
+	}
+	return jwtKeyNamePrefix + claims.subject, claims.tenant, true
+}
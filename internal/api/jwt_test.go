@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwkInt encodes an integer of a JWK
+func jwkInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// jwksServer serves the JWKS of keys, by key ID, counting its fetches
+type jwksServer struct {
+	*httptest.Server
+	keys    atomic.Value // []map[string]string
+	fetches atomic.Int32
+}
+
+// newJWKSServer serves the public keys of the private ones, by key ID
+func newJWKSServer(t *testing.T, keys map[string]interface{}) *jwksServer {
+	t.Helper()
+	j := &jwksServer{}
+	j.set(keys)
+	j.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": j.keys.Load()})
+	}))
+	t.Cleanup(j.Close)
+	return j
+}
+
+// set replaces the keys served
+func (j *jwksServer) set(keys map[string]interface{}) {
+	var jwks []map[string]string
+	for kid, key := range keys {
+		switch key := key.(type) {
+		case *ecdsa.PrivateKey:
+			jwks = append(jwks, map[string]string{"kid": kid, "kty": "EC", "crv": "P-256", "x": jwkInt(key.X), "y": jwkInt(key.Y)})
+		case *rsa.PrivateKey:
+			jwks = append(jwks, map[string]string{"kid": kid, "kty": "RSA", "use": "sig", "n": jwkInt(key.N), "e": jwkInt(big.NewInt(int64(key.E)))})
+		}
+	}
+	j.keys.Store(jwks)
+}
+
+// signJWT signs claims with key under the key ID kid
+func signJWT(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+	return signed
+}
+
+func TestValidateJWT(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwks := newJWKSServer(t, map[string]interface{}{"ec": ecKey, "rsa": rsaKey})
+
+	now := time.Now()
+	claims := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub":    "alice",
+			"iss":    "https://idp.example.com",
+			"aud":    "translate",
+			"exp":    now.Add(time.Hour).Unix(),
+			"tenant": "shop",
+			"scope":  "translate admin",
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	tests := []struct {
+		name       string
+		token      string
+		wantTenant string
+		wantErr    string // In the error, empty when valid
+	}{
+		{name: "ES256", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(nil)), wantTenant: "shop"},
+		{name: "RS256", token: signJWT(t, jwt.SigningMethodRS256, rsaKey, "rsa", claims(nil)), wantTenant: "shop"},
+		{name: "without a tenant", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(func(c jwt.MapClaims) { delete(c, "tenant") }))},
+		{name: "expired within the leeway", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(func(c jwt.MapClaims) { c["exp"] = now.Add(-10 * time.Second).Unix() })), wantTenant: "shop"},
+		{name: "expired", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() })), wantErr: "expired"},
+		{name: "without exp", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(func(c jwt.MapClaims) { delete(c, "exp") })), wantErr: "exp"},
+		{name: "not valid yet", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(func(c jwt.MapClaims) { c["nbf"] = now.Add(time.Minute).Unix() })), wantErr: "not valid yet"},
+		{name: "HS256 with the public key", token: signJWT(t, jwt.SigningMethodHS256, []byte("secret"), "ec", claims(nil)), wantErr: "signing method"},
+		{name: "alg none", token: signJWT(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "ec", claims(nil)), wantErr: "signing method"},
+		{name: "unknown key ID", token: signJWT(t, jwt.SigningMethodES256, ecKey, "gone", claims(nil)), wantErr: "unknown key ID"},
+		{name: "no key ID", token: signJWT(t, jwt.SigningMethodES256, ecKey, "", claims(nil)), wantErr: "unknown key ID"},
+		{name: "signed by another key", token: signJWT(t, jwt.SigningMethodES256, otherKey, "ec", claims(nil)), wantErr: "signature is invalid"},
+		{name: "key ID of another algorithm", token: signJWT(t, jwt.SigningMethodES256, ecKey, "rsa", claims(nil)), wantErr: "key is of invalid type"},
+		{name: "wrong issuer", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" })), wantErr: "issuer"},
+		{name: "wrong audience", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(func(c jwt.MapClaims) { c["aud"] = "billing" })), wantErr: "audience"},
+		{name: "without a subject", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(func(c jwt.MapClaims) { delete(c, "sub") })), wantErr: "no subject"},
+		{name: "invalid tenant", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(func(c jwt.MapClaims) { c["tenant"] = "../shop" })), wantErr: "invalid tenant"},
+		{name: "tampered", token: signJWT(t, jwt.SigningMethodES256, ecKey, "ec", claims(nil)) + "x", wantErr: "signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) {
+				c.JWTJWKSURL = jwks.URL
+				c.JWTIssuer = "https://idp.example.com"
+				c.JWTAudience = "translate"
+				c.JWTTenantClaim = "tenant"
+				c.JWTScopeClaim = "scope"
+			})
+			s.jwks = &jwksCache{httpClient: jwks.Client(), url: jwks.URL, refresh: time.Hour}
+
+			got, err := s.validateJWT(context.Background(), tt.token)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("validateJWT() error = %v, want one about %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateJWT() error = %v", err)
+			}
+			if got.subject != "alice" || got.tenant != tt.wantTenant {
+				t.Errorf("validateJWT() = %+v, want subject alice and tenant %q", got, tt.wantTenant)
+			}
+			if !got.hasScope("admin") || got.hasScope("billing") {
+				t.Errorf("scopes = %q, want translate and admin", got.scopes)
+			}
+		})
+	}
+}
+
+func TestAuthenticateJWTScope(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := newJWKSServer(t, map[string]interface{}{"ec": key})
+	tests := []struct {
+		name   string
+		scope  interface{}
+		wantOK bool
+	}{
+		{"space-separated", "read translate", true},
+		{"list", []string{"read", "translate"}, true},
+		{"missing", "read", false},
+		{"none", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) {
+				c.JWTJWKSURL = jwks.URL
+				c.JWTScopeClaim = "scope"
+				c.JWTRequiredScope = "translate"
+			})
+			s.jwks = &jwksCache{httpClient: jwks.Client(), url: jwks.URL, refresh: time.Hour}
+			claims := jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+			if tt.scope != nil {
+				claims["scope"] = tt.scope
+			}
+			r := httptest.NewRequest(http.MethodPost, "/translate", nil)
+
+			name, _, ok := s.authenticateJWT(r, signJWT(t, jwt.SigningMethodES256, key, "ec", claims))
+			if ok != tt.wantOK {
+				t.Fatalf("authenticateJWT() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && name != "jwt:alice" {
+				t.Errorf("authenticateJWT() name = %q, want jwt:alice", name)
+			}
+		})
+	}
+}
+
+func TestJWKSKeyRotation(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := newJWKSServer(t, map[string]interface{}{"old": oldKey})
+	c := &jwksCache{httpClient: jwks.Client(), url: jwks.URL, refresh: time.Hour}
+	ctx := context.Background()
+
+	if _, err := c.key(ctx, "old"); err != nil {
+		t.Fatalf("key(old) error = %v", err)
+	}
+	jwks.set(map[string]interface{}{"new": newKey})
+
+	// Unknown key IDs refetch the JWKS, but at most every jwksMinRefetch
+	if _, err := c.key(ctx, "new"); err == nil {
+		t.Fatal("key(new) found a key the JWKS didn't have when last fetched")
+	}
+	if n := jwks.fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want once", n)
+	}
+	c.lastAttempt = time.Now().Add(-jwksMinRefetch)
+	if _, err := c.key(ctx, "new"); err != nil {
+		t.Fatalf("key(new) after the rotation error = %v", err)
+	}
+	if _, err := c.key(ctx, "old"); err == nil {
+		t.Error("key(old) still found a key the JWKS dropped")
+	}
+}
+
+func TestParseJWK(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tests := []struct {
+		name    string
+		jwk     string
+		wantKey bool
+		wantErr bool
+	}{
+		{"EC", `{"kid": "a", "kty": "EC", "crv": "P-256", "x": "` + jwkInt(key.X) + `", "y": "` + jwkInt(key.Y) + `"}`, true, false},
+		{"EC off the curve", `{"kid": "a", "kty": "EC", "crv": "P-256", "x": "` + jwkInt(key.X) + `", "y": "` + jwkInt(key.X) + `"}`, false, true},
+		{"encryption key", `{"kid": "a", "kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"}`, false, false},
+		{"symmetric", `{"kid": "a", "kty": "oct", "k": "c2VjcmV0"}`, false, true},
+		{"short Ed25519", `{"kid": "a", "kty": "OKP", "crv": "Ed25519", "x": "AQAB"}`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got, err := parseJWK(json.RawMessage(tt.jwk))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJWK() error = %v, want an error: %v", err, tt.wantErr)
+			}
+			if (got != nil) != tt.wantKey {
+				t.Errorf("parseJWK() = %v, want a key: %v", got, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// kafkaKeyName is the API key name usage, rate limits and quotas of Kafka
+// messages are accounted under
+const kafkaKeyName = "kafka"
+
+// kafkaSerdes maps the KAFKA_SERDE names to the media types of messages
+var kafkaSerdes = map[string]string{
+	"json":     mediaJSON,
+	"msgpack":  mediaMsgPack,
+	"protobuf": mediaProtobuf,
+}
+
+// Headers added to the messages sent to KAFKA_DLQ_TOPIC
+const (
+	kafkaHeaderErrorCode = "x-error-code"
+	kafkaHeaderError     = "x-error"
+	kafkaHeaderSource    = "x-source" // topic/partition/offset of the input message
+)
+
+// kafkaReader is the consumer group side of the Kafka client, so consumers
+// can be driven by something else than a broker
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaWriter is the producer side of the Kafka client
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaSASL returns the SASL mechanism of KAFKA_SASL_MECHANISM, validated
+// by ValidateConfig, or nil when it is unset
+func kafkaSASL(config Config) (sasl.Mechanism, error) {
+	switch config.KafkaSASLMechanism {
+	case "plain":
+		return plain.Mechanism{Username: config.KafkaSASLUsername, Password: config.KafkaSASLPassword}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, config.KafkaSASLUsername, config.KafkaSASLPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, config.KafkaSASLUsername, config.KafkaSASLPassword)
+	}
+	return nil, nil
+}
+
+// setupKafka sets up the producer of the Kafka worker mode and the
+// consumers of the input topic's group
+func (s *Server) setupKafka() error {
+	mechanism, err := kafkaSASL(s.config)
+	if err != nil {
+		return fmt.Errorf("invalid KAFKA_SASL_MECHANISM: %v", err)
+	}
+	var tlsConfig *tls.Config
+	if s.config.KafkaTLS {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	s.kafkaWriter = &kafka.Writer{
+		Addr:         kafka.TCP(s.config.KafkaBrokers...),
+		Balancer:     &kafka.Hash{}, // Results go to the partition of their key, like the requests
+		RequiredAcks: kafka.RequireAll,
+		// Messages are written one at a time before their offset is
+		// committed, so waiting to fill a batch would only add latency
+		BatchTimeout: 10 * time.Millisecond,
+		Transport: &kafka.Transport{
+			TLS:  tlsConfig,
+			SASL: mechanism,
+		},
+	}
+	dialer := &kafka.Dialer{
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		TLS:           tlsConfig,
+		SASLMechanism: mechanism,
+	}
+	s.newKafkaReader = func() kafkaReader {
+		// Without a CommitInterval offsets are committed synchronously, once
+		// each message's result is written
+		return kafka.NewReader(kafka.ReaderConfig{
+			Brokers:  s.config.KafkaBrokers,
+			GroupID:  s.config.KafkaGroupID,
+			Topic:    s.config.KafkaInputTopic,
+			Dialer:   dialer,
+			MaxBytes: int(s.config.MaxBodyBytes),
+			ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) {
+				slog.Warn("kafka consumer error", "error", fmt.Sprintf(msg, args...))
+			}),
+		})
+	}
+	return nil
+}
+
+// startKafkaConsumers starts KAFKA_CONSUMERS consumers of the input topic,
+// translating its messages into the output topic until ctx is done
+func (s *Server) startKafkaConsumers(ctx context.Context) {
+	slog.Info("consuming translation requests from Kafka", "topic", s.config.KafkaInputTopic,
+		"group", s.config.KafkaGroupID, "consumers", s.config.KafkaConsumers)
+	for i := 0; i < s.config.KafkaConsumers; i++ {
+		s.jobWorkers.Add(1)
+		go func() {
+			defer s.jobWorkers.Done()
+			s.runKafkaConsumer(ctx, s.newKafkaReader())
+		}()
+	}
+}
+
+// runKafkaConsumer translates the messages of the partitions assigned to a
+// consumer one at a time until ctx is done. A message's offset is only
+// committed once its result, or the message itself for the dead-letter
+// topic, is written, so messages are delivered at least once: after a
+// crash or a rebalance they are translated again, mostly from the cache.
+func (s *Server) runKafkaConsumer(ctx context.Context, reader kafkaReader) {
+	defer func() {
+		if err := reader.Close(); err != nil {
+			slog.Warn("failed to close kafka consumer", "error", err)
+		}
+	}()
+	// Finish the message in progress on shutdown, rather than translate it
+	// again once redelivered
+	storeCtx := context.WithoutCancel(ctx)
+	for ctx.Err() == nil {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("failed to fetch kafka message", "error", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(jobPollTimeout):
+				}
+			}
+			continue
+		}
+		if !s.handleKafkaMessage(ctx, storeCtx, msg) {
+			// Shutting down; committing a later offset would skip the
+			// message, so it is left to the consumer taking over
+			return
+		}
+		if err := reader.CommitMessages(storeCtx, msg); err != nil {
+			slog.Error("failed to commit kafka message", "partition", msg.Partition, "offset", msg.Offset, "error", err)
+		}
+	}
+}
+
+// handleKafkaMessage translates a message into the output topic, retrying
+// transient failures with backoff, and sends messages that still fail to
+// the dead-letter topic. It returns false when ctx was done before the
+// message was handled, leaving it uncommitted.
+func (s *Server) handleKafkaMessage(ctx, storeCtx context.Context, msg kafka.Message) bool {
+	log := slog.With("topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset)
+	mt := kafkaSerdes[s.config.KafkaSerde]
+
+	value, failure, ok := s.retryQueued(ctx, storeCtx, log, kafkaKeyName, mt, msg.Value, s.config.KafkaRetry)
+	if !ok {
+		return false
+	}
+	if failure == nil {
+		out := kafka.Message{
+			Topic:   s.config.KafkaOutputTopic,
+			Key:     msg.Key,
+			Value:   value,
+			Headers: append(withoutKafkaHeader(msg.Headers, "content-type"), kafka.Header{Key: "content-type", Value: []byte(mt)}),
+		}
+		if !s.writeKafkaMessage(ctx, storeCtx, log, out) {
+			return false
+		}
+		kafkaMessages.WithLabelValues("translated").Inc()
+		return true
+	}
+
+	log.Warn("kafka message failed", "code", failure.Code, "error", failure.Message)
+	if s.config.KafkaDLQTopic == "" {
+		kafkaMessages.WithLabelValues("dropped").Inc()
+		return true
+	}
+	dead := kafka.Message{
+		Topic: s.config.KafkaDLQTopic,
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: append(msg.Headers,
+			kafka.Header{Key: kafkaHeaderErrorCode, Value: []byte(failure.Code)},
+			kafka.Header{Key: kafkaHeaderError, Value: []byte(failure.Message)},
+			kafka.Header{Key: kafkaHeaderSource, Value: []byte(fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset))},
+		),
+	}
+	if !s.writeKafkaMessage(ctx, storeCtx, log, dead) {
+		return false
+	}
+	kafkaMessages.WithLabelValues("dead_lettered").Inc()
+	return true
+}
+
+// writeKafkaMessage writes a message, retrying with backoff until it is
+// written or ctx is done, as the message it answers can't be committed
+// before
+func (s *Server) writeKafkaMessage(ctx, storeCtx context.Context, log *slog.Logger, msg kafka.Message) bool {
+	for attempt := 1; ; attempt++ {
+		err := s.kafkaWriter.WriteMessages(storeCtx, msg)
+		if err == nil {
+			return true
+		}
+		delay := s.config.KafkaRetry.Backoff(attempt)
+		log.Error("failed to write kafka message, retrying", "to", msg.Topic, "backoff", delay.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
+
+// withoutKafkaHeader returns headers without those named key
+func withoutKafkaHeader(headers []kafka.Header, key string) []kafka.Header {
+	var kept []kafka.Header
+	for _, header := range headers {
+		if header.Key != key {
+			kept = append(kept, header)
+		}
+	}
+	return kept
+}
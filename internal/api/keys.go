@@ -0,0 +1,340 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis keys used by the API key store
+const (
+	apiKeyIDsKey       = "apikeys:ids"   // Set of all key IDs
+	apiKeyRecordPrefix = "apikeys:id:"   // apikeys:id:<id> holds the JSON APIKey
+	apiKeyHashPrefix   = "apikeys:hash:" // apikeys:hash:<sha256> maps an active key to its ID
+)
+
+// errKeyNotFound is returned when an API key ID does not exist
+var errKeyNotFound = errors.New("API key not found")
+
+// errKeyRevoked is returned when rotating a revoked API key
+var errKeyRevoked = errors.New("API key is revoked")
+
+// APIKey is a managed API key. Only the SHA-256 hash of the secret is stored.
+type APIKey struct {
+	ID          string     `json:"id"`
+	Owner       string     `json:"owner"`
+	Description string     `json:"description,omitempty"`
+	Tenant      string     `json:"tenant,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RotatedAt   *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	Hash        string     `json:"hash,omitempty"`
+}
+
+// CreateKeyRequest is the body of POST /admin/keys
+type CreateKeyRequest struct {
+	Owner       string `json:"owner"`
+	Description string `json:"description,omitempty"`
+	Tenant      string `json:"tenant,omitempty"` // Tenant the key belongs to, if any
+}
+
+// KeySecretResponse returns a newly issued secret. The secret is only ever
+// shown once, on creation or rotation.
+type KeySecretResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
+
+// hashAPIKey returns the hex SHA-256 of a key secret
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes encoded as unpadded base64url
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newKeySecret generates a fresh API key secret
+func newKeySecret() (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	return "sst_" + token, nil
+}
+
+// lookupAPIKey returns the ID of the active managed key matching secret, or
+// an empty string when there is none
+func (s *Server) lookupAPIKey(ctx context.Context, secret string) (string, error) {
+	id, err := s.redis.Get(ctx, apiKeyHashPrefix+hashAPIKey(secret)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return id, err
+}
+
+// getAPIKey loads a managed key by ID
+func (s *Server) getAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	return readAPIKey(ctx, s.redis, id)
+}
+
+// readAPIKey loads a managed key by ID with c, a client or transaction
+func readAPIKey(ctx context.Context, c redis.Cmdable, id string) (*APIKey, error) {
+	data, err := c.Get(ctx, apiKeyRecordPrefix+id).Result()
+	if err == redis.Nil {
+		return nil, errKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var key APIKey
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal API key: %v", err)
+	}
+	return &key, nil
+}
+
+// saveAPIKey stores key's record and updates its hash mapping, removing the
+// mapping for oldHash when the secret changed
+func (s *Server) saveAPIKey(ctx context.Context, key *APIKey, oldHash string) error {
+	_, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return queueSaveAPIKey(ctx, pipe, key, oldHash)
+	})
+	return err
+}
+
+// queueSaveAPIKey queues the commands of saveAPIKey on pipe
+func queueSaveAPIKey(ctx context.Context, pipe redis.Pipeliner, key *APIKey, oldHash string) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, apiKeyRecordPrefix+key.ID, data, 0)
+	pipe.SAdd(ctx, apiKeyIDsKey, key.ID)
+	if oldHash != "" && oldHash != key.Hash {
+		pipe.Del(ctx, apiKeyHashPrefix+oldHash)
+	}
+	if key.RevokedAt == nil {
+		pipe.Set(ctx, apiKeyHashPrefix+key.Hash, key.ID, 0)
+	} else {
+		pipe.Del(ctx, apiKeyHashPrefix+key.Hash)
+	}
+	return nil
+}
+
+// apiKeyUpdateAttempts bounds how often updateAPIKey retries a key record
+// that keeps changing
+const apiKeyUpdateAttempts = 5
+
+// updateAPIKey applies update to a key's record and saves it, unless update
+// fails. The record is watched from reading to saving and the update retried
+// when it changed in between, so concurrent rotations and revocations don't
+// undo each other.
+func (s *Server) updateAPIKey(ctx context.Context, id string, update func(key *APIKey) error) (*APIKey, error) {
+	var key *APIKey
+	for attempt := 0; attempt < apiKeyUpdateAttempts; attempt++ {
+		err := s.redis.Watch(ctx, func(tx *redis.Tx) error {
+			var err error
+			if key, err = readAPIKey(ctx, tx, id); err != nil {
+				return err
+			}
+			oldHash := key.Hash
+			if err := update(key); err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return queueSaveAPIKey(ctx, pipe, key, oldHash)
+			})
+			return err
+		}, apiKeyRecordPrefix+id)
+		if err != redis.TxFailedErr {
+			return key, err
+		}
+	}
+	return nil, errors.New("API key kept changing, retry")
+}
+
+// createAPIKey issues a new managed key, optionally of a tenant, and returns
+// its secret
+func (s *Server) createAPIKey(ctx context.Context, owner, description, tenant string) (*KeySecretResponse, error) {
+	id, err := randomToken(9)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := newKeySecret()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		ID:          id,
+		Owner:       owner,
+		Description: description,
+		Tenant:      tenant,
+		CreatedAt:   time.Now().UTC(),
+		Hash:        hashAPIKey(secret),
+	}
+	if err := s.saveAPIKey(ctx, key, ""); err != nil {
+		return nil, err
+	}
+	key.Hash = ""
+	return &KeySecretResponse{Key: secret, APIKey: *key}, nil
+}
+
+// rotateAPIKey replaces a key's secret, invalidating the old one immediately
+func (s *Server) rotateAPIKey(ctx context.Context, id string) (*KeySecretResponse, error) {
+	secret, err := newKeySecret()
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.updateAPIKey(ctx, id, func(key *APIKey) error {
+		if key.RevokedAt != nil {
+			return errKeyRevoked
+		}
+		now := time.Now().UTC()
+		key.Hash = hashAPIKey(secret)
+		key.RotatedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	key.Hash = ""
+	return &KeySecretResponse{Key: secret, APIKey: *key}, nil
+}
+
+// revokeAPIKey disables a key. The record is kept for auditing.
+func (s *Server) revokeAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	key, err := s.updateAPIKey(ctx, id, func(key *APIKey) error {
+		if key.RevokedAt == nil {
+			now := time.Now().UTC()
+			key.RevokedAt = &now
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	key.Hash = ""
+	return key, nil
+}
+
+// listAPIKeys returns every managed key ordered by creation time
+func (s *Server) listAPIKeys(ctx context.Context) ([]APIKey, error) {
+	ids, err := s.redis.SMembers(ctx, apiKeyIDsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := s.getAPIKey(ctx, id)
+		if err == errKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		key.Hash = ""
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// handleAdminKeys serves the API key management API:
+//
+//	GET    /admin/keys             list keys
+//	POST   /admin/keys             create a key
+//	GET    /admin/keys/{id}        show a key
+//	DELETE /admin/keys/{id}        revoke a key
+//	POST   /admin/keys/{id}/rotate issue a new secret for a key
+func (s *Server) handleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/keys"), "/")
+	id, action, _ := strings.Cut(path, "/")
+
+	var response interface{}
+	var err error
+	status := http.StatusOK
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		response, err = s.listAPIKeys(ctx)
+	case id == "" && r.Method == http.MethodPost:
+		var req CreateKeyRequest
+		if !decodeRequestBody(w, r, &req) {
+			return
+		}
+		if req.Owner == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Owner field is required")
+			return
+		}
+		if req.Tenant != "" && !validTenantID.MatchString(req.Tenant) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: tenant must be up to 63 lowercase letters, digits, '-' or '_'")
+			return
+		}
+		response, err = s.createAPIKey(ctx, req.Owner, req.Description, req.Tenant)
+		status = http.StatusCreated
+		if err == nil {
+			logger(ctx).Info("created API key", "id", response.(*KeySecretResponse).APIKey.ID, "owner", req.Owner, "tenant", req.Tenant)
+		}
+	case id != "" && action == "" && r.Method == http.MethodGet:
+		var key *APIKey
+		key, err = s.getAPIKey(ctx, id)
+		if err == nil {
+			key.Hash = ""
+			response = key
+		}
+	case id != "" && action == "" && r.Method == http.MethodDelete:
+		response, err = s.revokeAPIKey(ctx, id)
+		if err == nil {
+			logger(ctx).Info("revoked API key", "id", id)
+		}
+	case id != "" && action == "rotate" && r.Method == http.MethodPost:
+		response, err = s.rotateAPIKey(ctx, id)
+		if err == nil {
+			logger(ctx).Info("rotated API key", "id", id)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err == errKeyNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, err.Error())
+		return
+	}
+	if err == errKeyRevoked {
+		writeError(w, http.StatusConflict, codeConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Key operation failed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
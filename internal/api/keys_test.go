@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRotateRevokeRace(t *testing.T) {
+	s, mr := newTestServer(t, &fakeProvider{}, nil)
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		created, err := s.createAPIKey(ctx, "ops", "", "")
+		if err != nil {
+			t.Fatalf("createAPIKey() error = %v", err)
+		}
+		id := created.APIKey.ID
+
+		var wg sync.WaitGroup
+		var rotated *KeySecretResponse
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rotated, _ = s.rotateAPIKey(ctx, id)
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := s.revokeAPIKey(ctx, id); err != nil {
+				t.Errorf("revokeAPIKey() error = %v", err)
+			}
+		}()
+		wg.Wait()
+
+		key, err := s.getAPIKey(ctx, id)
+		if err != nil {
+			t.Fatalf("getAPIKey() error = %v", err)
+		}
+		if key.RevokedAt == nil {
+			t.Fatalf("key %d is active again after a concurrent rotation", i)
+		}
+		if mr.Exists(apiKeyHashPrefix + key.Hash) {
+			t.Fatalf("key %d can still authenticate after its revocation", i)
+		}
+		if rotated != nil {
+			if found, _ := s.lookupAPIKey(ctx, rotated.Key); found != "" {
+				t.Fatalf("the rotated secret of key %d still authenticates", i)
+			}
+		}
+	}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	s, mr := newTestServer(t, &fakeProvider{}, nil)
+	ctx := context.Background()
+
+	created, err := s.createAPIKey(ctx, "ops", "CI", "shop")
+	if err != nil {
+		t.Fatalf("createAPIKey() error = %v", err)
+	}
+	id := created.APIKey.ID
+	if created.APIKey.Hash != "" {
+		t.Errorf("createAPIKey() returned the hash %q", created.APIKey.Hash)
+	}
+	if !strings.HasPrefix(created.Key, "sst_") {
+		t.Errorf("createAPIKey() secret = %q, want an sst_ secret", created.Key)
+	}
+	// Only the hash of the secret is stored
+	for _, key := range mr.Keys() {
+		value, _ := mr.Get(key)
+		if strings.Contains(key, created.Key) || strings.Contains(value, created.Key) {
+			t.Errorf("%s holds the secret", key)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		apply   func() (*KeySecretResponse, error) // Nil to only check the secrets
+		wantErr error
+		// The secrets that authenticate, and those that don't, afterwards,
+		// "new" standing for the secret apply returned
+		valid, invalid []string
+	}{
+		{name: "created", valid: []string{created.Key}},
+		{
+			name:    "rotated",
+			apply:   func() (*KeySecretResponse, error) { return s.rotateAPIKey(ctx, id) },
+			valid:   []string{"new"},
+			invalid: []string{created.Key},
+		},
+		{
+			name: "revoked",
+			apply: func() (*KeySecretResponse, error) {
+				_, err := s.revokeAPIKey(ctx, id)
+				return nil, err
+			},
+			invalid: []string{created.Key},
+		},
+		{
+			name: "revoked again",
+			apply: func() (*KeySecretResponse, error) {
+				_, err := s.revokeAPIKey(ctx, id)
+				return nil, err
+			},
+		},
+		{
+			name:    "rotated once revoked",
+			apply:   func() (*KeySecretResponse, error) { return s.rotateAPIKey(ctx, id) },
+			wantErr: errKeyRevoked,
+		},
+		{
+			name:    "rotated unknown",
+			apply:   func() (*KeySecretResponse, error) { return s.rotateAPIKey(ctx, "missing") },
+			wantErr: errKeyNotFound,
+		},
+		{
+			name: "revoked unknown",
+			apply: func() (*KeySecretResponse, error) {
+				_, err := s.revokeAPIKey(ctx, "missing")
+				return nil, err
+			},
+			wantErr: errKeyNotFound,
+		},
+	}
+	secrets := []string{created.Key} // Every secret issued
+	for _, tt := range tests {
+		var issued *KeySecretResponse
+		if tt.apply != nil {
+			issued, err = tt.apply()
+			if err != tt.wantErr {
+				t.Fatalf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+		}
+		if issued != nil {
+			if issued.APIKey.Hash != "" {
+				t.Errorf("%s: returned the hash %q", tt.name, issued.APIKey.Hash)
+			}
+			secrets = append(secrets, issued.Key)
+		}
+		for _, secret := range tt.valid {
+			if secret == "new" {
+				secret = issued.Key
+			}
+			if got, err := s.lookupAPIKey(ctx, secret); err != nil || got != id {
+				t.Errorf("%s: lookupAPIKey() = %q, %v, want %q", tt.name, got, err, id)
+			}
+		}
+		for _, secret := range tt.invalid {
+			if got, err := s.lookupAPIKey(ctx, secret); err != nil || got != "" {
+				t.Errorf("%s: lookupAPIKey() = %q, %v, want none", tt.name, got, err)
+			}
+		}
+	}
+
+	// A revoked key has no secret left, and keeps its record
+	for _, secret := range secrets {
+		if got, _ := s.lookupAPIKey(ctx, secret); got != "" {
+			t.Errorf("lookupAPIKey() of a revoked key's secret = %q, want none", got)
+		}
+	}
+	key, err := s.getAPIKey(ctx, id)
+	if err != nil {
+		t.Fatalf("getAPIKey() error = %v", err)
+	}
+	if key.RevokedAt == nil || key.RotatedAt == nil || key.Owner != "ops" || key.Tenant != "shop" {
+		t.Errorf("getAPIKey() = %+v, want the revoked key of ops", key)
+	}
+}
+
+func TestAdminKeys(t *testing.T) {
+	s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) { c.AdminToken = "admin" })
+	ctx := context.Background()
+	active, err := s.createAPIKey(ctx, "ops", "", "")
+	if err != nil {
+		t.Fatalf("createAPIKey() error = %v", err)
+	}
+	revoked, err := s.createAPIKey(ctx, "ops", "", "")
+	if err != nil {
+		t.Fatalf("createAPIKey() error = %v", err)
+	}
+	if _, err := s.revokeAPIKey(ctx, revoked.APIKey.ID); err != nil {
+		t.Fatalf("revokeAPIKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		token      string
+		wantStatus int
+		wantSecret bool // Whether the response has a secret
+	}{
+		{"list", http.MethodGet, "/admin/keys", "", "admin", http.StatusOK, false},
+		{"create", http.MethodPost, "/admin/keys", `{"owner": "ops", "tenant": "shop"}`, "admin", http.StatusCreated, true},
+		{"create without owner", http.MethodPost, "/admin/keys", `{"description": "CI"}`, "admin", http.StatusBadRequest, false},
+		{"create with invalid tenant", http.MethodPost, "/admin/keys", `{"owner": "ops", "tenant": "Shop!"}`, "admin", http.StatusBadRequest, false},
+		{"show", http.MethodGet, "/admin/keys/" + active.APIKey.ID, "", "admin", http.StatusOK, false},
+		{"show unknown", http.MethodGet, "/admin/keys/missing", "", "admin", http.StatusNotFound, false},
+		{"rotate", http.MethodPost, "/admin/keys/" + active.APIKey.ID + "/rotate", "", "admin", http.StatusOK, true},
+		{"rotate revoked", http.MethodPost, "/admin/keys/" + revoked.APIKey.ID + "/rotate", "", "admin", http.StatusConflict, false},
+		{"rotate unknown", http.MethodPost, "/admin/keys/missing/rotate", "", "admin", http.StatusNotFound, false},
+		{"revoke", http.MethodDelete, "/admin/keys/" + active.APIKey.ID, "", "admin", http.StatusOK, false},
+		{"revoke unknown", http.MethodDelete, "/admin/keys/missing", "", "admin", http.StatusNotFound, false},
+		{"unsupported method", http.MethodPut, "/admin/keys/" + active.APIKey.ID, "", "admin", http.StatusMethodNotAllowed, false},
+		{"wrong token", http.MethodGet, "/admin/keys", "", "guess", http.StatusUnauthorized, false},
+		{"managed key as token", http.MethodGet, "/admin/keys", "", active.Key, http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			body := w.Body.String()
+			if strings.Contains(body, `"hash"`) {
+				t.Errorf("response has the key hash: %s", body)
+			}
+			if got := strings.Contains(body, `"key":"sst_`); got != tt.wantSecret {
+				t.Errorf("response has a secret: %v, want %v: %s", got, tt.wantSecret, body)
+			}
+		})
+	}
+}
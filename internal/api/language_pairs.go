@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// languagePair is a source:target pattern of LANGUAGE_PAIRS_ALLOW,
+// LANGUAGE_PAIRS_DENY or a tenant's pair lists. Either side is * for any
+// language, or a tag matching itself and its regional variants, so pt
+// matches pt-BR but pt-BR doesn't match pt-PT.
+type languagePair struct {
+	source, target string
+}
+
+// parseLanguagePair parses a source:target pattern
+func parseLanguagePair(pattern string) (languagePair, error) {
+	source, target, ok := strings.Cut(strings.TrimSpace(pattern), ":")
+	if !ok {
+		return languagePair{}, fmt.Errorf("%q is not a source:target pair", pattern)
+	}
+	pair := languagePair{source: strings.TrimSpace(source), target: strings.TrimSpace(target)}
+	for _, side := range []*string{&pair.source, &pair.target} {
+		if *side == "*" {
+			continue
+		}
+		tag, err := language.Parse(*side)
+		if err != nil {
+			return languagePair{}, fmt.Errorf("%q: invalid language %q", pattern, *side)
+		}
+		*side = tag.String()
+	}
+	return pair, nil
+}
+
+// validateLanguagePairs checks a list of patterns, returning the error of
+// the first invalid one
+func validateLanguagePairs(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := parseLanguagePair(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// languageMatches reports whether code is the pattern's language or one of
+// its variants. An empty code, a source not detected yet, only matches *.
+func languageMatches(pattern, code string) bool {
+	if pattern == "*" {
+		return true
+	}
+	code = normalizeLanguageTag(code)
+	return strings.EqualFold(code, pattern) ||
+		len(code) > len(pattern) && code[len(pattern)] == '-' && strings.EqualFold(code[:len(pattern)], pattern)
+}
+
+// matches reports whether the pair's pattern matches translating from
+// source into target
+func (pair languagePair) matches(source, target string) bool {
+	return languageMatches(pair.source, source) && languageMatches(pair.target, target)
+}
+
+// languagePairError is returned for translations of a language pair the
+// caller may not translate
+type languagePairError struct {
+	source, target string
+}
+
+// Error implements error
+func (e languagePairError) Error() string {
+	return fmt.Sprintf("language pair %s is not allowed", e.pair())
+}
+
+// pair returns the denied pair as source:target, with * for a source not
+// detected yet
+func (e languagePairError) pair() string {
+	if e.source == "" {
+		return "*:" + e.target
+	}
+	return e.source + ":" + e.target
+}
+
+// languagePairRules returns the caller's allowed and denied pairs: its
+// tenant's when it sets either list, otherwise the server's
+func (s *Server) languagePairRules(ctx context.Context) (allow, deny []string) {
+	if tenant := requestTenant(ctx); tenant != nil && (len(tenant.AllowedLanguagePairs) > 0 || len(tenant.DeniedLanguagePairs) > 0) {
+		return tenant.AllowedLanguagePairs, tenant.DeniedLanguagePairs
+	}
+	c := s.currentConfig()
+	return c.LanguagePairsAllow, c.LanguagePairsDeny
+}
+
+// checkLanguagePair returns a languagePairError when the caller may not
+// translate from source into target: the pair matches a denied pattern, or
+// there are allowed patterns and it matches none. An empty source, not
+// detected yet, is only denied by patterns of any source, and is allowed
+// when an allowed pattern matches target; it is checked again once
+// detected.
+func (s *Server) checkLanguagePair(ctx context.Context, source, target string) error {
+	allow, deny := s.languagePairRules(ctx)
+	for _, pattern := range deny {
+		// Invalid patterns are rejected when configured
+		if pair, err := parseLanguagePair(pattern); err == nil && pair.matches(source, target) {
+			return languagePairError{source: source, target: target}
+		}
+	}
+	if len(allow) == 0 {
+		return nil
+	}
+	for _, pattern := range allow {
+		pair, err := parseLanguagePair(pattern)
+		if err != nil {
+			continue
+		}
+		if pair.matches(source, target) || source == "" && languageMatches(pair.target, target) {
+			return nil
+		}
+	}
+	return languagePairError{source: source, target: target}
+}
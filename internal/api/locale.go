@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/text/language"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+const (
+	// languageSupportRefresh is how long a provider's supported languages are
+	// used before being listed again
+	languageSupportRefresh = time.Hour
+	// languageSupportRetry is how long a failed listing is waited out before
+	// the next attempt
+	languageSupportRetry = time.Minute
+)
+
+// normalizeLanguageTag returns the canonical form of a BCP 47 tag, as pt-BR
+// for pt_br or zh-Hant-TW for zh-hant-tw, or code unchanged when it isn't
+// one, for validateLanguages to reject
+func normalizeLanguageTag(code string) string {
+	if code == "" {
+		return ""
+	}
+	tag, err := language.Parse(code)
+	if err != nil {
+		return code
+	}
+	return tag.String()
+}
+
+// supportedLanguages are the languages a provider translates
+type supportedLanguages struct {
+	codes   []string         // As the provider lists them
+	matcher language.Matcher // Of codes, nil until they were listed once
+	expires time.Time        // When to list them again
+}
+
+// languageCache holds the supported languages of each provider, by
+// provider name
+type languageCache struct {
+	mu      sync.Mutex
+	entries map[string]*supportedLanguages
+	group   singleflight.Group
+}
+
+// get returns the languages p supports, listing them when they weren't yet
+// or are due for a refresh. A failed listing keeps those listed before.
+func (c *languageCache) get(ctx context.Context, p provider.Provider) *supportedLanguages {
+	name := p.Name()
+	c.mu.Lock()
+	entry := c.entries[name]
+	c.mu.Unlock()
+	if entry != nil && time.Now().Before(entry.expires) {
+		return entry
+	}
+
+	v, _, _ := c.group.Do(name, func() (interface{}, error) {
+		// Shared with concurrent requests, so not canceled with this one
+		languages, err := p.Languages(context.WithoutCancel(ctx), "")
+		if err != nil {
+			logger(ctx).Warn("failed to list supported languages", "provider", name, "error", err)
+			failed := &supportedLanguages{expires: time.Now().Add(languageSupportRetry)}
+			if entry != nil {
+				failed.codes, failed.matcher = entry.codes, entry.matcher
+			}
+			c.store(name, failed)
+			return failed, nil
+		}
+
+		listed := &supportedLanguages{expires: time.Now().Add(languageSupportRefresh)}
+		tags := make([]language.Tag, 0, len(languages))
+		for _, l := range languages {
+			tag, err := language.Parse(l.Code)
+			if err != nil {
+				continue
+			}
+			tags = append(tags, tag)
+			listed.codes = append(listed.codes, l.Code)
+		}
+		if len(tags) > 0 {
+			listed.matcher = language.NewMatcher(tags)
+		}
+		c.store(name, listed)
+		return listed, nil
+	})
+	return v.(*supportedLanguages)
+}
+
+// store replaces the supported languages of the named provider
+func (c *languageCache) store(name string, entry *supportedLanguages) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = entry
+}
+
+// supportedLanguage returns the code p translates the language tag code
+// with: the closest variant p supports, as pt for pt-BR or zh-TW for
+// zh-Hant-TW, falling back to the tag itself when p has nothing close
+// enough, its languages couldn't be listed or LANGUAGE_FALLBACK is off
+func (s *Server) supportedLanguage(ctx context.Context, p provider.Provider, code string) string {
+	if code == "" || !s.config.LanguageFallback {
+		return code
+	}
+	tag, err := language.Parse(code)
+	if err != nil {
+		return code
+	}
+	supported := s.languageSupport.get(ctx, p)
+	if supported.matcher == nil {
+		return code
+	}
+	// Regional variants match their language with high confidence; other
+	// scripts, as sr-Latn for sr, don't
+	_, i, confidence := supported.matcher.Match(tag)
+	if confidence < language.High {
+		return code
+	}
+	if used := supported.codes[i]; !sameLanguage(used, code) {
+		languageFallbacks.Inc()
+		logger(ctx).Debug("translating with a supported language variant", "requested", code, "used", used, "provider", p.Name())
+		return used
+	}
+	return code
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the request ID in requests and responses
+const requestIDHeader = "X-Request-ID"
+
+// requestInfoKey is the context key holding the current request's requestInfo
+type requestInfoKey struct{}
+
+// requestInfo collects per-request details that handlers fill in and that are
+// included in the request's log lines
+type requestInfo struct {
+	ID         string
+	KeyID      string
+	Tenant     string
+	SourceLang string
+	TargetLang string
+	Provider   string
+	CacheHit   *bool
+}
+
+// SetupLogging installs the default slog logger, writing JSON (or text when
+// LOG_FORMAT=text) to stdout at the configured level
+func SetupLogging(c *Config) {
+	setLogLevel(c.LogLevel)
+	opts := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler = slog.NewJSONHandler(os.Stdout, opts)
+	if strings.EqualFold(c.LogFormat, "text") {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(requestHandler{handler}))
+}
+
+// requestHandler annotates records logged with a context, such as those of
+// slog.WarnContext in the provider package, like logger does
+type requestHandler struct {
+	slog.Handler
+}
+
+// Handle implements slog.Handler
+func (h requestHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.Add(requestAttrs(ctx)...)
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler
+func (h requestHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler
+func (h requestHandler) WithGroup(name string) slog.Handler {
+	return requestHandler{h.Handler.WithGroup(name)}
+}
+
+// logLevel is the least level logged, which can change on reload
+var logLevel = new(slog.LevelVar)
+
+// setLogLevel changes the least level logged, falling back to info for
+// unknown levels
+func setLogLevel(name string) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		level = slog.LevelInfo
+	}
+	logLevel.Set(level)
+}
+
+// getRequestInfo returns the requestInfo of ctx, or nil outside a request
+func getRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// logger returns the default logger annotated with ctx's request ID, API
+// key and tenant, when known
+func logger(ctx context.Context) *slog.Logger {
+	if attrs := requestAttrs(ctx); len(attrs) > 0 {
+		return slog.Default().With(attrs...)
+	}
+	return slog.Default()
+}
+
+// requestAttrs returns the log attributes of ctx's request, if any
+func requestAttrs(ctx context.Context) []any {
+	info := getRequestInfo(ctx)
+	if info == nil {
+		return nil
+	}
+	attrs := []any{"request_id", info.ID}
+	if info.KeyID != "" {
+		attrs = append(attrs, "key_id", info.KeyID)
+	}
+	if info.Tenant != "" {
+		attrs = append(attrs, "tenant", info.Tenant)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		attrs = append(attrs, "trace_id", sc.TraceID().String())
+	}
+	return attrs
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether an incoming request ID is safe to reuse
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush implements http.Flusher for streamed responses, as of
+// /jobs/{id}/events, which the handler instrumentation only passes on for
+// writers implementing it
+func (r *statusRecorder) Flush() {
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker for the WebSocket upgrades of
+// /ws/translate, which the handler instrumentation only passes on for
+// writers implementing it
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+// withRequestLogging assigns each request an ID, reusing a valid incoming
+// X-Request-ID, returns it in the X-Request-ID response header and logs the
+// request once it completes
+func withRequestLogging(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{ID: r.Header.Get(requestIDHeader)}
+		if !validRequestID(info.ID) {
+			info.ID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, info.ID)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		handler.ServeHTTP(rec, r.WithContext(ctx))
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"latency_ms", time.Since(start).Milliseconds(),
+		}
+		if info.SourceLang != "" || info.TargetLang != "" {
+			attrs = append(attrs, "source_lang", info.SourceLang, "target_lang", info.TargetLang)
+		}
+		if info.Provider != "" {
+			attrs = append(attrs, "provider", info.Provider)
+		}
+		if info.CacheHit != nil {
+			attrs = append(attrs, "cache_hit", *info.CacheHit)
+		}
+		logger(ctx).Info("request completed", attrs...)
+	})
+}
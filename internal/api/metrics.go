@@ -0,0 +1,305 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/provider"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Prometheus metrics exposed on /metrics
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_http_requests_total",
+		Help: "HTTP requests by endpoint, method and status code.",
+	}, []string{"endpoint", "method", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "translation_http_request_duration_seconds",
+		Help:    "HTTP request latency by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint", "method", "code"})
+
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_cache_requests_total",
+		Help: "Translation cache lookups by result (hit, stale, miss, error, bypass while the cache is unavailable, or skip when the request set no_cache).",
+	}, []string{"result"})
+
+	popularRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_cache_popular_refreshes_total",
+		Help: "Popular cache entries re-translated before expiring, by outcome (refreshed or failed).",
+	}, []string{"outcome"})
+
+	skippedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_skipped_total",
+		Help: "Texts returned untranslated, by reason (same_language, whitespace, url, email, numeric, emoji or punctuation).",
+	}, []string{"reason"})
+
+	languageFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "translation_language_fallbacks_total",
+		Help: "Languages sent to the provider as a variant it supports of the requested one, as pt for pt-BR.",
+	})
+
+	verificationSimilarity = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "translation_verification_similarity",
+		Help:    "Similarity of back-translations to the text, for requests with verify.",
+		Buckets: []float64{.1, .2, .3, .4, .5, .6, .7, .8, .9, 1},
+	})
+
+	verificationRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_verification_retries_total",
+		Help: "Translations retried with another provider for scoring under VERIFY_MIN_SIMILARITY, by outcome (improved, kept or failed).",
+	}, []string{"outcome"})
+
+	coalescedRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "translation_coalesced_requests_total",
+		Help: "Translations served by an identical concurrent request's provider call.",
+	})
+
+	tmLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_memory_lookups_total",
+		Help: "Translation memory lookups by result (hit, miss, fuzzy_hit, fuzzy_miss or error).",
+	}, []string{"result"})
+
+	historyWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_history_records_total",
+		Help: "Translation history records by outcome (written, failed or dropped while the writer was behind).",
+	}, []string{"outcome"})
+
+	qualityEstimations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_quality_estimations_total",
+		Help: "Quality estimations of recorded translations by outcome (scored, unscored without a reference, failed or dropped while the estimator was behind).",
+	}, []string{"outcome"})
+
+	moderationChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_moderation_checks_total",
+		Help: "Moderation checks by stage (input or output) and result (clean, flagged, rejected or error when the moderation API failed).",
+	}, []string{"stage", "result"})
+
+	pageFetches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_page_fetches_total",
+		Help: "Pages fetched for POST /translate/page by outcome (fetched, blocked by the allowlist or address checks, or failed).",
+	}, []string{"outcome"})
+
+	proxyResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_proxy_responses_total",
+		Help: "Upstream responses of the reverse-proxy mode to translate, by result (translated, failed and served untranslated, or too_large to translate).",
+	}, []string{"result"})
+
+	proxyFragments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_proxy_fragments_total",
+		Help: "Segments of proxied responses by result (hit when their translation was cached for the URL, miss otherwise).",
+	}, []string{"result"})
+
+	wsConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "translation_websocket_connections",
+		Help: "WebSocket connections open on /ws/translate.",
+	})
+
+	wsMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_websocket_messages_total",
+		Help: "Messages received over /ws/translate by result (translated or failed).",
+	}, []string{"result"})
+
+	kafkaMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_kafka_messages_total",
+		Help: "Messages of KAFKA_INPUT_TOPIC by result (translated, dead_lettered to KAFKA_DLQ_TOPIC, or dropped without one).",
+	}, []string{"result"})
+
+	sqsMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_sqs_messages_total",
+		Help: "Messages of SQS_INPUT_QUEUE_URL by result (translated, retried after a backoff, dead_lettered to SQS_DLQ_QUEUE_URL, or released to the redrive policy without one).",
+	}, []string{"result"})
+
+	pubsubMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_pubsub_messages_total",
+		Help: "Messages of PUBSUB_SUBSCRIPTION by result (translated, dead_lettered to PUBSUB_DLQ_TOPIC, or nacked without one).",
+	}, []string{"result"})
+
+	natsRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_nats_requests_total",
+		Help: "Requests served over NATS by operation (translate or detect) and outcome (success or error).",
+	}, []string{"operation", "outcome"})
+
+	htmlSanitized = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_html_sanitized_total",
+		Help: "Disallowed elements and attributes removed from HTML translations, by what was removed (element or attribute).",
+	}, []string{"removed"})
+
+	reviewsQueued = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_reviews_queued_total",
+		Help: "Translations queued for review by reason (requested, low_confidence or low_quality).",
+	}, []string{"reason"})
+
+	reviewDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_review_decisions_total",
+		Help: "Reviewed translations by outcome (approved, corrected or dismissed).",
+	}, []string{"outcome"})
+
+	qualityScores = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "translation_quality_score",
+		Help:    "Quality estimation scores of recorded translations.",
+		Buckets: []float64{.1, .2, .3, .4, .5, .6, .7, .8, .9, 1},
+	})
+
+	jobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_jobs_total",
+		Help: "Asynchronous jobs by status (queued, completed or failed).",
+	}, []string{"status"})
+
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_webhook_deliveries_total",
+		Help: "Job callback deliveries by outcome (delivered or failed).",
+	}, []string{"outcome"})
+
+	piiRedactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_pii_redactions_total",
+		Help: "Distinct values redacted from texts before provider calls, by detector.",
+	}, []string{"detector"})
+
+	providerRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_provider_requests_total",
+		Help: "Provider calls by provider, operation and outcome (success or error).",
+	}, []string{"provider", "operation", "outcome"})
+
+	budgetRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_provider_budget_rejections_total",
+		Help: "Provider calls turned away by an exhausted character budget, by provider, window (minute or day) and priority (normal or low).",
+	}, []string{"provider", "window", "priority"})
+
+	providerUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "translation_provider_up",
+		Help: "Whether the last background health check of a provider succeeded (1) or failed (0).",
+	}, []string{"provider"})
+
+	providerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "translation_provider_request_duration_seconds",
+		Help:    "Provider call latency by provider and operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider", "operation"})
+
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "translation_redis_operation_duration_seconds",
+		Help:    "Redis command latency by command, with pipelines reported as \"pipeline\".",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "translation_redis_errors_total",
+		Help: "Failed Redis commands by command, excluding cache misses.",
+	}, []string{"operation"})
+)
+
+// instrumentHandler records request counts and latency for an endpoint and
+// traces each request, continuing any incoming traceparent
+func instrumentHandler(endpoint string, handler http.HandlerFunc) http.Handler {
+	labels := prometheus.Labels{"endpoint": endpoint}
+	return otelhttp.NewHandler(
+		promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels),
+			promhttp.InstrumentHandlerDuration(httpDuration.MustCurryWith(labels), handler)),
+		endpoint)
+}
+
+// metricsHandler serves the Prometheus metrics endpoint
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// instrumentedProvider records call counts and latency of a Provider in
+// Prometheus and traces each call
+type instrumentedProvider struct {
+	provider.Wrapper
+}
+
+// start begins a call of operation, returning the span context and a function
+// recording the call's outcome
+func (p *instrumentedProvider) start(ctx context.Context, operation string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "provider."+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("translation.provider", p.Name())))
+	return ctx, func(err error) {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+		providerRequests.WithLabelValues(p.Name(), operation, outcome).Inc()
+		providerDuration.WithLabelValues(p.Name(), operation).Observe(time.Since(start).Seconds())
+		endSpan(span, err)
+	}
+}
+
+// Translate implements Provider
+func (p *instrumentedProvider) Translate(ctx context.Context, req provider.Request) (*provider.Result, error) {
+	ctx, finish := p.start(ctx, "translate")
+	result, err := p.Provider.Translate(ctx, req)
+	finish(err)
+	return result, err
+}
+
+// Detect implements Provider
+func (p *instrumentedProvider) Detect(ctx context.Context, text string) (*provider.Detection, error) {
+	ctx, finish := p.start(ctx, "detect")
+	detection, err := p.Provider.Detect(ctx, text)
+	finish(err)
+	return detection, err
+}
+
+// Languages implements Provider
+func (p *instrumentedProvider) Languages(ctx context.Context, target string) ([]provider.Language, error) {
+	ctx, finish := p.start(ctx, "languages")
+	languages, err := p.Provider.Languages(ctx, target)
+	finish(err)
+	return languages, err
+}
+
+// redisStartKey is the context key holding a Redis command's start time
+type redisStartKey struct{}
+
+// redisMetricsHook is a go-redis hook recording command latency and errors
+type redisMetricsHook struct{}
+
+// BeforeProcess implements redis.Hook
+func (redisMetricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+// AfterProcess implements redis.Hook
+func (redisMetricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeRedis(ctx, cmd.Name(), cmd.Err())
+	return nil
+}
+
+// BeforeProcessPipeline implements redis.Hook
+func (redisMetricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+// AfterProcessPipeline implements redis.Hook
+func (redisMetricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && cmdErr != redis.Nil {
+			err = cmdErr
+		}
+	}
+	observeRedis(ctx, "pipeline", err)
+	return nil
+}
+
+// observeRedis records a finished Redis operation
+func observeRedis(ctx context.Context, operation string, err error) {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		redisDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+	if err != nil && err != redis.Nil {
+		redisErrors.WithLabelValues(operation).Inc()
+	}
+}
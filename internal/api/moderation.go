@@ -0,0 +1,306 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Actions of the MODERATION_INPUT and MODERATION_OUTPUT stages
+const (
+	moderationFlag   = "flag"   // Report what was found in the response
+	moderationMask   = "mask"   // Replace wordlist matches with asterisks, flagging the rest
+	moderationReject = "reject" // Fail the translation with CONTENT_REJECTED
+)
+
+// moderationWordlistCategory is the category of wordlist matches
+const moderationWordlistCategory = "profanity"
+
+// Moderation reports what the moderation stages found in a translation
+type Moderation struct {
+	Input  *ModerationResult `json:"input,omitempty"`  // Of the text, in its source language
+	Output *ModerationResult `json:"output,omitempty"` // Of the translation
+}
+
+// ModerationResult is what a moderation stage found in a text
+type ModerationResult struct {
+	Categories []string `json:"categories"`       // What the text was flagged for, profanity for wordlist matches
+	Masked     int      `json:"masked,omitempty"` // Wordlist matches replaced with asterisks
+}
+
+// contentRejectedError is returned for translations a moderation stage
+// rejected
+type contentRejectedError struct {
+	stage      string // input or output
+	categories []string
+}
+
+// Error implements error
+func (e contentRejectedError) Error() string {
+	return fmt.Sprintf("the %s was rejected by moderation: %s", e.stage, strings.Join(e.categories, ", "))
+}
+
+// moderator finds unwanted content in texts, with wordlists by language
+// and an OpenAI-compatible moderation API
+type moderator struct {
+	wordlists map[string]*regexp.Regexp // By base language, * for every language
+	endpoint  string                    // Base URL of the moderation API, without /moderations; unused when empty
+	apiKey    string
+	model     string
+	redactor  *piiRedactor // Redacts texts before they're sent to the moderation API, nil to send them as they are
+}
+
+// newModerator reads the wordlists of MODERATION_WORDLISTS, returning nil
+// when neither wordlists nor a moderation API are configured
+func newModerator(config Config, redactor *piiRedactor) (*moderator, error) {
+	if len(config.ModerationWordlists) == 0 && config.ModerationURL == "" {
+		return nil, nil
+	}
+	m := &moderator{
+		wordlists: make(map[string]*regexp.Regexp, len(config.ModerationWordlists)),
+		endpoint:  strings.TrimSuffix(config.ModerationURL, "/"),
+		apiKey:    config.ModerationAPIKey,
+		model:     config.ModerationModel,
+		redactor:  redactor,
+	}
+	for lang, path := range config.ModerationWordlists {
+		pattern, err := readModerationWordlist(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the moderation wordlist of %s: %v", lang, err)
+		}
+		if pattern != nil {
+			m.wordlists[moderationLanguage(lang)] = pattern
+		}
+	}
+	return m, nil
+}
+
+// readModerationWordlist reads a wordlist file, one word or phrase a line
+// with # starting comments, into a case-insensitive pattern matching any of
+// them, or nil when it lists none
+func readModerationWordlist(path string) (*regexp.Regexp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// The words of phrases may be apart by any whitespace
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			fields[i] = regexp.QuoteMeta(field)
+		}
+		words = append(words, strings.Join(fields, `\s+`))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, nil
+	}
+	// Longer words first, so phrases win over the words they start with
+	sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+	return regexp.Compile(`(?i)(?:` + strings.Join(words, "|") + `)`)
+}
+
+// moderationLanguage returns the wordlist key of a language: its base
+// language, as pt for pt-BR
+func moderationLanguage(lang string) string {
+	if lang == "*" {
+		return lang
+	}
+	base, _, _ := strings.Cut(strings.ToLower(normalizeLanguageTag(lang)), "-")
+	return base
+}
+
+// wordMatches returns the locations of whole-word wordlist matches in text,
+// of the wordlists of lang and *, or of every wordlist when lang is empty
+func (m *moderator) wordMatches(text, lang string) [][]int {
+	var patterns []*regexp.Regexp
+	if lang == "" {
+		for _, pattern := range m.wordlists {
+			patterns = append(patterns, pattern)
+		}
+	} else {
+		for _, key := range []string{moderationLanguage(lang), "*"} {
+			if pattern := m.wordlists[key]; pattern != nil {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	var matches [][]int
+	for _, pattern := range patterns {
+		for _, loc := range pattern.FindAllStringIndex(text, -1) {
+			if isWordBoundary(text, loc[0], true) && isWordBoundary(text, loc[1], false) {
+				matches = append(matches, loc)
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
+	return matches
+}
+
+// isWordBoundary reports whether the byte offset i of text is at the start,
+// when start is set, or end of a word
+func isWordBoundary(text string, i int, start bool) bool {
+	var r rune
+	if start {
+		if i == 0 {
+			return true
+		}
+		r, _ = utf8.DecodeLastRuneInString(text[:i])
+	} else {
+		if i == len(text) {
+			return true
+		}
+		r, _ = utf8.DecodeRuneInString(text[i:])
+	}
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// maskMatches replaces the matched words of text with as many asterisks as
+// they have characters, skipping matches overlapping an earlier one
+func maskMatches(text string, matches [][]int) (string, int) {
+	var b strings.Builder
+	last, masked := 0, 0
+	for _, loc := range matches {
+		if loc[0] < last {
+			continue
+		}
+		b.WriteString(text[last:loc[0]])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(text[loc[0]:loc[1]])))
+		last = loc[1]
+		masked++
+	}
+	b.WriteString(text[last:])
+	return b.String(), masked
+}
+
+// flaggedCategories asks the moderation API what text is flagged for,
+// returning nil when it isn't. Personal data is redacted first, as it is
+// for providers.
+func (m *moderator) flaggedCategories(ctx context.Context, text string) ([]string, error) {
+	text, _ = m.redactor.redact(text, nil)
+	body := map[string]interface{}{"input": text}
+	if m.model != "" {
+		body["model"] = m.model
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := postJSON(ctx, m.endpoint+"/moderations", m.apiKey, data, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Results) == 0 {
+		return nil, fmt.Errorf("no moderation result returned")
+	}
+	if !result.Results[0].Flagged {
+		return nil, nil
+	}
+	var categories []string
+	for category, flagged := range result.Results[0].Categories {
+		if flagged {
+			categories = append(categories, category)
+		}
+	}
+	if len(categories) == 0 {
+		categories = []string{"flagged"}
+	}
+	sort.Strings(categories)
+	return categories, nil
+}
+
+// moderate runs a moderation stage on text in lang, any language when
+// empty, returning the text, masked with mask, and what was found, or nil
+// when nothing was. A failing moderation API lets the text through.
+func (s *Server) moderate(ctx context.Context, stage, action, text, lang string) (string, *ModerationResult, error) {
+	m := s.moderator
+	if m == nil || action == "" {
+		return text, nil, nil
+	}
+	result := &ModerationResult{}
+	if matches := m.wordMatches(text, lang); len(matches) > 0 {
+		result.Categories = append(result.Categories, moderationWordlistCategory)
+		if action == moderationMask {
+			text, result.Masked = maskMatches(text, matches)
+		}
+	}
+	if m.endpoint != "" {
+		categories, err := m.flaggedCategories(ctx, text)
+		if err != nil {
+			moderationChecks.WithLabelValues(stage, "error").Inc()
+			logger(ctx).Warn("moderation API failed, letting the text through", "stage", stage, "error", err)
+		}
+		for _, category := range categories {
+			if !slices.Contains(result.Categories, category) {
+				result.Categories = append(result.Categories, category)
+			}
+		}
+	}
+	if len(result.Categories) == 0 {
+		moderationChecks.WithLabelValues(stage, "clean").Inc()
+		return text, nil, nil
+	}
+	if action == moderationReject {
+		moderationChecks.WithLabelValues(stage, "rejected").Inc()
+		return text, nil, contentRejectedError{stage: stage, categories: result.Categories}
+	}
+	moderationChecks.WithLabelValues(stage, "flagged").Inc()
+	logger(ctx).Info("moderation flagged a text", "stage", stage, "categories", result.Categories, "masked", result.Masked)
+	return text, result, nil
+}
+
+// moderateInput runs MODERATION_INPUT on the text of req, masking it in
+// place, before anything is translated
+func (s *Server) moderateInput(ctx context.Context, req *TranslationRequest) (*Moderation, error) {
+	text, result, err := s.moderate(ctx, "input", s.currentConfig().ModerationInput, req.Text, req.SourceLang)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	req.Text = text
+	return &Moderation{Input: result}, nil
+}
+
+// moderateOutput runs MODERATION_OUTPUT on the translation of response,
+// adding what it found to moderation. Translation memory matches are
+// trusted and untranslated texts were moderated as input.
+func (s *Server) moderateOutput(ctx context.Context, response *TranslationResponse, moderation *Moderation) error {
+	if response.Memory != nil || response.Skipped {
+		response.Moderation = moderation
+		return nil
+	}
+	text, result, err := s.moderate(ctx, "output", s.currentConfig().ModerationOutput, response.TranslatedText, response.TargetLang)
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		response.TranslatedText = text
+		if moderation == nil {
+			moderation = &Moderation{}
+		}
+		moderation.Output = result
+	}
+	response.Moderation = moderation
+	return nil
+}
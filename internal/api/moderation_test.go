@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFlaggedCategoriesRedactsPII(t *testing.T) {
+	tests := []struct {
+		name      string
+		names     []string
+		custom    string
+		text      string
+		wantInput string // Of the moderation API
+	}{
+		{"not redacted", nil, "", "Write to jane@example.com", "Write to jane@example.com"},
+		{"email", []string{"email"}, "", "Write to jane@example.com", "Write to __PII_0__"},
+		{"custom pattern", nil, `ACME-\d+`, "Ticket ACME-1234 is open", "Ticket __PII_0__ is open"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var input string
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Input string `json:"input"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+				input = body.Input
+				w.Write([]byte(`{"results": [{"flagged": true, "categories": {"harassment": true}}]}`))
+			}))
+			defer api.Close()
+
+			redactor, err := newPIIRedactor(Config{PIIRedaction: tt.names, PIIPattern: tt.custom})
+			if err != nil {
+				t.Fatalf("newPIIRedactor() error = %v", err)
+			}
+			m, err := newModerator(Config{ModerationURL: api.URL}, redactor)
+			if err != nil {
+				t.Fatalf("newModerator() error = %v", err)
+			}
+			categories, err := m.flaggedCategories(context.Background(), tt.text)
+			if err != nil {
+				t.Fatalf("flaggedCategories() error = %v", err)
+			}
+			if len(categories) != 1 || categories[0] != "harassment" {
+				t.Errorf("flaggedCategories() = %q, want harassment", categories)
+			}
+			if input != tt.wantInput {
+				t.Errorf("moderation API got %q, want %q", input, tt.wantInput)
+			}
+		})
+	}
+}
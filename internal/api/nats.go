@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// natsKeyName is the API key name usage, rate limits and quotas of NATS
+// requests are accounted under
+const natsKeyName = "nats"
+
+// natsServiceName is the name the service registers under with the NATS
+// service API, as listed by `nats micro ls`
+const natsServiceName = "ss-translate"
+
+// natsSemVer matches the versions the NATS service API accepts
+var natsSemVer = regexp.MustCompile(`^\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// DetectionRequest is the body of requests to the NATS detect subject
+type DetectionRequest struct {
+	Text string `json:"text"`
+}
+
+// DetectionResponse answers requests to the NATS detect subject with the
+// most likely language of the text, and the others it may be in
+type DetectionResponse struct {
+	DetectedLanguage
+	Alternatives []DetectedLanguage `json:"alternatives,omitempty"`
+}
+
+// setupNATS connects to the servers of NATS_URL. The connection is retried
+// in the background while they are unreachable, so the service starts
+// without them.
+func (s *Server) setupNATS() error {
+	opts := []nats.Option{
+		nats.Name(natsServiceName),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				slog.Warn("disconnected from nats", "error", err)
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			slog.Info("connected to nats", "server", nc.ConnectedUrlRedacted())
+		}),
+	}
+	if s.config.NATSCredsFile != "" {
+		opts = append(opts, nats.UserCredentials(s.config.NATSCredsFile))
+	}
+	if s.config.NATSToken != "" {
+		opts = append(opts, nats.Token(s.config.NATSToken))
+	}
+	nc, err := nats.Connect(strings.Join(s.config.NATSURL, ","), opts...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+	s.nats = nc
+	return nil
+}
+
+// natsServiceVersion returns Version for the NATS service API, which only
+// takes semantic versions
+func natsServiceVersion() string {
+	version := strings.TrimPrefix(Version, "v")
+	if !natsSemVer.MatchString(version) {
+		return "0.0.0-" + Version
+	}
+	return version
+}
+
+// startNATSService serves requests on the subjects under
+// NATS_SUBJECT_PREFIX until ctx is done:
+//
+//	<prefix>.translate  a POST /translate body, answered with a /translate response
+//	<prefix>.detect     a DetectionRequest, answered with a DetectionResponse
+//
+// Replicas share the requests in the queue group NATS_QUEUE_GROUP, each
+// serving up to NATS_CONCURRENCY at once. On shutdown the subscriptions are
+// drained and the requests in flight answered.
+func (s *Server) startNATSService(ctx context.Context) {
+	svc, err := micro.AddService(s.nats, micro.Config{
+		Name:        natsServiceName,
+		Version:     natsServiceVersion(),
+		Description: "Translation and language detection",
+		QueueGroup:  s.config.NATSQueueGroup,
+	})
+	if err != nil {
+		slog.Error("failed to start the nats service", "error", err)
+		return
+	}
+
+	// A slot per request in flight; callbacks wait for one, holding up the
+	// subscription, so requests beyond NATS_CONCURRENCY queue in NATS
+	slots := make(chan struct{}, s.config.NATSConcurrency)
+	// Answer the requests in flight on shutdown, their callers are waiting
+	storeCtx := context.WithoutCancel(ctx)
+	handler := func(operation string, serve func(context.Context, string, []byte) ([]byte, *queueFailure)) micro.Handler {
+		return micro.HandlerFunc(func(req micro.Request) {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				respondNATS(req, operation, "", nil, &queueFailure{ErrorResponse: &ErrorResponse{Code: codeServiceUnavailable, Message: "The service is shutting down"}})
+				return
+			}
+			go func() {
+				defer func() { <-slots }()
+				mt := natsMediaType(req)
+				body, failure := serve(storeCtx, mt, req.Data())
+				respondNATS(req, operation, mt, body, failure)
+			}()
+		})
+	}
+	group := svc.AddGroup(s.config.NATSSubjectPrefix)
+	for operation, serve := range map[string]func(context.Context, string, []byte) ([]byte, *queueFailure){
+		"translate": s.translateNATS,
+		"detect":    s.detectNATS,
+	} {
+		if err := group.AddEndpoint(operation, handler(operation, serve)); err != nil {
+			slog.Error("failed to add nats endpoint", "operation", operation, "error", err)
+		}
+	}
+	slog.Info("serving translation requests over NATS", "subjects", s.config.NATSSubjectPrefix+".>",
+		"queue_group", s.config.NATSQueueGroup, "concurrency", s.config.NATSConcurrency)
+
+	s.jobWorkers.Add(1)
+	go func() {
+		defer s.jobWorkers.Done()
+		<-ctx.Done()
+		if err := svc.Stop(); err != nil {
+			slog.Warn("failed to stop the nats service", "error", err)
+		}
+		// Holding every slot, the requests in flight are answered
+		for i := 0; i < cap(slots); i++ {
+			slots <- struct{}{}
+		}
+	}()
+}
+
+// natsMediaType returns the media type of a request's Content-Type header,
+// answered in kind, defaulting to JSON
+func natsMediaType(req micro.Request) string {
+	switch mt, _ := mediaType(req.Headers().Get("Content-Type")); mt {
+	case mediaMsgPack, mediaProtobuf:
+		return mt
+	}
+	return mediaJSON
+}
+
+// translateNATS translates a request to the translate subject
+func (s *Server) translateNATS(ctx context.Context, mt string, data []byte) ([]byte, *queueFailure) {
+	return s.translateQueued(ctx, natsKeyName, mt, data)
+}
+
+// detectNATS detects the language of a request to the detect subject,
+// applying the rate limits and quota of the nats key to its text
+func (s *Server) detectNATS(ctx context.Context, mt string, data []byte) ([]byte, *queueFailure) {
+	if mt == mediaProtobuf {
+		return nil, &queueFailure{ErrorResponse: &ErrorResponse{Code: codeUnsupportedMediaType, Message: "Detection requests can't be sent as protobuf"}}
+	}
+	var req DetectionRequest
+	body, err := bodyJSON(mt, data, nil)
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err == nil && req.Text == "" {
+		err = errors.New("text is required")
+	}
+	if err != nil {
+		return nil, &queueFailure{ErrorResponse: &ErrorResponse{Code: codeInvalidRequest, Message: fmt.Sprintf("Invalid request: %v", err)}}
+	}
+
+	ctx, release := s.withQuotaReservation(s.withAPIKeyName(ctx, natsKeyName, ""))
+	defer release()
+	w := &messageWriter{header: http.Header{}}
+	chars := utf8.RuneCountInString(req.Text)
+	if !s.authorizeTranslation(ctx, w, chars) {
+		return nil, newQueueFailure(w)
+	}
+	p, err := s.providerFor(ctx)
+	if err != nil {
+		s.writeTranslationError(ctx, w, err)
+		return nil, newQueueFailure(w)
+	}
+	detection, err := p.Detect(ctx, req.Text)
+	if err != nil {
+		s.writeTranslationError(ctx, w, err)
+		return nil, newQueueFailure(w)
+	}
+	s.recordQuotaUsage(ctx, chars)
+
+	response := &DetectionResponse{DetectedLanguage: DetectedLanguage{Language: detection.Language, Confidence: detection.Confidence}}
+	for _, d := range detection.Alternatives {
+		response.Alternatives = append(response.Alternatives, DetectedLanguage{Language: d.Language, Confidence: d.Confidence})
+	}
+	encoded, err := encodeBody(mt, response)
+	if err != nil {
+		return nil, &queueFailure{ErrorResponse: &ErrorResponse{Code: codeInternalError, Message: fmt.Sprintf("Failed to encode response: %v", err)}}
+	}
+	return encoded, nil
+}
+
+// respondNATS answers a request with body, encoded as mt, or with failure.
+// Errors are JSON ErrorResponses, with their code and message in the
+// Nats-Service-Error-Code and Nats-Service-Error headers of the service API
+// and a Retry-After header when rate limited.
+func respondNATS(req micro.Request, operation, mt string, body []byte, failure *queueFailure) {
+	var err error
+	if failure != nil {
+		natsRequests.WithLabelValues(operation, "error").Inc()
+		headers := micro.Headers{"Content-Type": {mediaJSON}}
+		if failure.retryAfter > 0 {
+			headers["Retry-After"] = []string{strconv.Itoa(int(failure.retryAfter.Seconds()))}
+		}
+		payload, _ := json.Marshal(failure.ErrorResponse)
+		err = req.Error(failure.Code, failure.Message, payload, micro.WithHeaders(headers))
+	} else {
+		natsRequests.WithLabelValues(operation, "success").Inc()
+		err = req.Respond(body, micro.WithHeaders(micro.Headers{"Content-Type": {mt}}))
+	}
+	if err != nil {
+		slog.Warn("failed to answer nats request", "subject", req.Subject(), "error", err)
+	}
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/api/option"
+)
+
+// Schemes of the object URIs of file jobs
+const (
+	schemeS3  = "s3"
+	schemeGCS = "gs"
+)
+
+// objectURI is an s3://bucket/key or gs://bucket/key object URI
+type objectURI struct {
+	scheme string
+	bucket string
+	key    string
+}
+
+// String returns the URI
+func (u objectURI) String() string {
+	return u.scheme + "://" + u.bucket + "/" + u.key
+}
+
+// parseObjectURI parses an S3 or GCS object URI
+func parseObjectURI(value string) (objectURI, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme != schemeS3 && u.Scheme != schemeGCS || u.Host == "" {
+		return objectURI{}, fmt.Errorf("%q is not an s3:// or gs:// URI", value)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		return objectURI{}, fmt.Errorf("%q does not name an object", value)
+	}
+	return objectURI{scheme: u.Scheme, bucket: u.Host, key: key}, nil
+}
+
+// parseBucketURI parses an s3://bucket or gs://bucket entry of
+// JOB_STORAGE_BUCKETS
+func parseBucketURI(value string) (objectURI, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme != schemeS3 && u.Scheme != schemeGCS || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+		return objectURI{}, fmt.Errorf("%q is not an s3:// or gs:// bucket", value)
+	}
+	return objectURI{scheme: u.Scheme, bucket: u.Host}, nil
+}
+
+// storageBucketAllowed reports whether an object is in a bucket of
+// JOB_STORAGE_BUCKETS
+func (s *Server) storageBucketAllowed(object objectURI) bool {
+	for _, entry := range s.config.JobStorageBuckets {
+		if bucket, err := parseBucketURI(entry); err == nil && bucket.scheme == object.scheme && bucket.bucket == object.bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// setupObjectStorage sets up the S3 and GCS clients of the buckets of
+// JOB_STORAGE_BUCKETS: S3 with the standard AWS credential chain and
+// region, GCS with GOOGLE_APPLICATION_CREDENTIALS_JSON or the default
+// credentials
+func (s *Server) setupObjectStorage(ctx context.Context) error {
+	schemes := make(map[string]bool)
+	for _, entry := range s.config.JobStorageBuckets {
+		if bucket, err := parseBucketURI(entry); err == nil {
+			schemes[bucket.scheme] = true
+		}
+	}
+	if schemes[schemeS3] {
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load AWS config for S3: %v", err)
+		}
+		s.s3 = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = s.config.S3ForcePathStyle
+		})
+	}
+	if schemes[schemeGCS] {
+		var opts []option.ClientOption
+		if credJSON := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS_JSON"); credJSON != "" {
+			opts = append(opts, option.WithCredentialsJSON([]byte(credJSON)))
+		}
+		client, err := storage.NewClient(ctx, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to create Cloud Storage client: %v", err)
+		}
+		s.gcs = client
+	}
+	return nil
+}
+
+// objectWriter writes an object, which only exists once closed
+type objectWriter interface {
+	io.Writer
+	// Close finishes writing the object
+	Close() error
+	// Abort discards what was written, leaving no object or the one that
+	// was there before
+	Abort()
+}
+
+// openObject opens an object for reading, streaming it from the store
+func (s *Server) openObject(ctx context.Context, object objectURI) (io.ReadCloser, error) {
+	switch {
+	case object.scheme == schemeS3 && s.s3 != nil:
+		out, err := s.s3.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(object.bucket), Key: aws.String(object.key)})
+		if err != nil {
+			return nil, err
+		}
+		return out.Body, nil
+	case object.scheme == schemeGCS && s.gcs != nil:
+		return s.gcs.Bucket(object.bucket).Object(object.key).NewReader(ctx)
+	}
+	return nil, fmt.Errorf("no client for %s:// buckets", object.scheme)
+}
+
+// createObject starts writing an object of a content type. S3 objects are
+// uploaded in parts as they are written, GCS objects in chunks.
+func (s *Server) createObject(ctx context.Context, object objectURI, contentType string) (objectWriter, error) {
+	switch {
+	case object.scheme == schemeS3 && s.s3 != nil:
+		pr, pw := io.Pipe()
+		w := &s3ObjectWriter{pw: pw, done: make(chan error, 1)}
+		go func() {
+			_, err := manager.NewUploader(s.s3).Upload(ctx, &s3.PutObjectInput{
+				Bucket:      aws.String(object.bucket),
+				Key:         aws.String(object.key),
+				Body:        pr,
+				ContentType: aws.String(contentType),
+			})
+			// Unblocks writes when the upload fails before reading everything
+			pr.CloseWithError(err)
+			w.done <- err
+		}()
+		return w, nil
+	case object.scheme == schemeGCS && s.gcs != nil:
+		// Writes are canceled with their context
+		ctx, cancel := context.WithCancel(ctx)
+		w := s.gcs.Bucket(object.bucket).Object(object.key).NewWriter(ctx)
+		w.ContentType = contentType
+		return &gcsObjectWriter{Writer: w, cancel: cancel}, nil
+	}
+	return nil, fmt.Errorf("no client for %s:// buckets", object.scheme)
+}
+
+// s3ObjectWriter feeds an upload of the S3 upload manager, which aborts the
+// multipart upload when its body fails
+type s3ObjectWriter struct {
+	pw   *io.PipeWriter
+	done chan error // The upload's outcome
+}
+
+// errObjectAborted ends the uploads of aborted objects
+var errObjectAborted = errors.New("object aborted")
+
+// Write implements objectWriter
+func (w *s3ObjectWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close implements objectWriter
+func (w *s3ObjectWriter) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+// Abort implements objectWriter
+func (w *s3ObjectWriter) Abort() {
+	w.pw.CloseWithError(errObjectAborted)
+	<-w.done
+}
+
+// gcsObjectWriter writes a GCS object, which is only created once the
+// writer is closed
+type gcsObjectWriter struct {
+	*storage.Writer
+	cancel context.CancelFunc
+}
+
+// Close implements objectWriter
+func (w *gcsObjectWriter) Close() error {
+	defer w.cancel()
+	return w.Writer.Close()
+}
+
+// Abort implements objectWriter
+func (w *gcsObjectWriter) Abort() {
+	w.cancel()
+	w.Writer.Close()
+}
@@ -0,0 +1,336 @@
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	swaggerui "github.com/swaggo/files/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIYAML is the OpenAPI 3 description of the HTTP API, served at
+// /openapi.json and used to validate JSON request bodies
+//
+//go:embed openapi.yaml
+var openAPIYAML []byte
+
+// openAPIJSON is openAPIYAML converted to JSON
+var openAPIJSON []byte
+
+// requestSchemas are the JSON request body schemas of the API's operations
+var requestSchemas []operationSchema
+
+func init() {
+	var spec interface{}
+	if err := yaml.Unmarshal(openAPIYAML, &spec); err != nil {
+		panic(fmt.Sprintf("invalid openapi.yaml: %v", err))
+	}
+	var err error
+	if openAPIJSON, err = json.Marshal(spec); err != nil {
+		panic(fmt.Sprintf("invalid openapi.yaml: %v", err))
+	}
+
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPIJSON, &doc); err != nil {
+		panic(fmt.Sprintf("invalid openapi.yaml: %v", err))
+	}
+	for path, item := range doc.Paths {
+		for method, raw := range item {
+			if method == "parameters" {
+				continue
+			}
+			var op struct {
+				RequestBody struct {
+					Content map[string]struct {
+						Schema *schema `json:"schema"`
+					} `json:"content"`
+				} `json:"requestBody"`
+			}
+			if err := json.Unmarshal(raw, &op); err != nil {
+				panic(fmt.Sprintf("invalid openapi.yaml operation %s %s: %v", method, path, err))
+			}
+			if body, ok := op.RequestBody.Content["application/json"]; ok && body.Schema != nil {
+				requestSchemas = append(requestSchemas, operationSchema{
+					method:   strings.ToUpper(method),
+					segments: strings.Split(path, "/"),
+					schema:   body.Schema.resolve(doc.Components.Schemas),
+				})
+			}
+		}
+	}
+}
+
+// openAPIDocument is the part of the spec validation needs
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// schema is the subset of OpenAPI schema objects validation understands;
+// other keywords are documentation only
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	AllOf                []*schema          `json:"allOf"`
+	Enum                 []interface{}      `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	MinItems             *int               `json:"minItems"`
+	Pattern              string             `json:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+// resolve replaces the $refs of s and its subschemas with the component
+// schemas they name, in place, and compiles patterns
+func (s *schema) resolve(components map[string]*schema) *schema {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		target, ok := components[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			panic(fmt.Sprintf("invalid openapi.yaml: unknown schema %s", s.Ref))
+		}
+		return target.resolve(components)
+	}
+	for name, property := range s.Properties {
+		s.Properties[name] = property.resolve(components)
+	}
+	for i, sub := range s.AllOf {
+		s.AllOf[i] = sub.resolve(components)
+	}
+	s.AdditionalProperties = s.AdditionalProperties.resolve(components)
+	s.Items = s.Items.resolve(components)
+	if s.Pattern != "" && s.pattern == nil {
+		s.pattern = regexp.MustCompile(s.Pattern)
+	}
+	return s
+}
+
+// schemaViolation is a value that doesn't match its schema
+type schemaViolation struct {
+	Field   string `json:"field,omitempty"` // Path of the value, such as requests[0].target_lang; empty for the body
+	Message string `json:"message"`
+}
+
+// validate appends the violations of value, decoded with UseNumber, at
+// field
+func (s *schema) validate(field string, value interface{}, violations []schemaViolation) []schemaViolation {
+	violation := func(format string, args ...interface{}) []schemaViolation {
+		return append(violations, schemaViolation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	for _, sub := range s.AllOf {
+		violations = sub.validate(field, value, violations)
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return violation("must be an object")
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				violations = append(violations, schemaViolation{Field: joinField(field, name), Message: "is required"})
+			}
+		}
+		for name, v := range object {
+			// Like decoding into a struct, null leaves a field unset
+			if v == nil {
+				continue
+			}
+			if property, ok := s.Properties[name]; ok {
+				violations = property.validate(joinField(field, name), v, violations)
+			} else if s.AdditionalProperties != nil {
+				violations = s.AdditionalProperties.validate(joinField(field, name), v, violations)
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return violation("must be an array")
+		}
+		if s.MinItems != nil && len(array) < *s.MinItems {
+			if *s.MinItems == 1 {
+				return violation("must not be empty")
+			}
+			return violation("must have at least %d items", *s.MinItems)
+		}
+		if s.Items != nil {
+			for i, v := range array {
+				violations = s.Items.validate(fmt.Sprintf("%s[%d]", field, i), v, violations)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return violation("must be a string")
+		}
+		length := len([]rune(str))
+		if s.MinLength != nil && length < *s.MinLength {
+			if *s.MinLength == 1 {
+				return violation("must not be empty")
+			}
+			return violation("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return violation("must be at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			return violation("must match %s", s.Pattern)
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		f, err := n.Float64()
+		if _, intErr := n.Int64(); !ok || err != nil || s.Type == "integer" && intErr != nil {
+			return violation("must be %s", map[string]string{"integer": "an integer", "number": "a number"}[s.Type])
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return violation("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return violation("must be at most %v", *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return violation("must be a boolean")
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		allowed := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			allowed[i] = fmt.Sprint(e)
+			if fmt.Sprint(value) == allowed[i] {
+				return violations
+			}
+		}
+		return violation("must be one of %s", strings.Join(allowed, ", "))
+	}
+	return violations
+}
+
+// joinField appends a property name to a field path
+func joinField(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+// operationSchema is the request body schema of an operation
+type operationSchema struct {
+	method   string
+	segments []string // Of the path, with {parameter} segments matching any value
+	schema   *schema
+}
+
+// matches reports whether the operation handles method and path
+func (o operationSchema) matches(method, path string) bool {
+	if o.method != method {
+		return false
+	}
+	segments := strings.Split(path, "/")
+	if len(segments) != len(o.segments) {
+		return false
+	}
+	for i, segment := range o.segments {
+		if strings.HasPrefix(segment, "{") {
+			if segments[i] == "" {
+				return false
+			}
+		} else if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// requestSchema returns the JSON body schema of the operation handling r,
+// or nil
+func requestSchema(r *http.Request) *schema {
+	for _, o := range requestSchemas {
+		if o.matches(r.Method, r.URL.Path) {
+			return o.schema
+		}
+	}
+	return nil
+}
+
+// validateRequestBody checks a JSON body against the schema of the
+// operation handling r, returning its violations sorted by field
+func validateRequestBody(r *http.Request, body []byte) ([]schemaViolation, error) {
+	s := requestSchema(r)
+	if s == nil {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	violations := s.validate("", value, nil)
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+	return violations, nil
+}
+
+// writeValidationError writes the 400 response listing a body's violations
+func writeValidationError(w http.ResponseWriter, violations []schemaViolation) {
+	writeErrorDetails(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: the body does not match its schema",
+		map[string][]schemaViolation{"violations": violations})
+}
+
+// handleOpenAPI serves the OpenAPI description of the API
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
+
+// swaggerInitializer configures Swagger UI to load the API's description,
+// relative to /docs/ so the UI works behind a path prefix
+const swaggerInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: "../openapi.json",
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// docsHandler serves Swagger UI for the API under /docs/
+func docsHandler() http.Handler {
+	files := http.StripPrefix("/docs/", http.FileServer(http.FS(swaggerui.FS)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/docs":
+			http.Redirect(w, r, "/docs/", http.StatusMovedPermanently)
+		case "/docs/swagger-initializer.js":
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+			w.Write([]byte(swaggerInitializer))
+		default:
+			files.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SetOverrideRequest is the body of PUT /admin/translations
+type SetOverrideRequest struct {
+	SourceLang string `json:"source_lang,omitempty"` // Empty to apply to auto-detected requests
+	TargetLang string `json:"target_lang"`
+	SourceText string `json:"source_text"`
+	TargetText string `json:"target_text"`
+	SetBy      string `json:"set_by"` // Who approved the translation, for the audit trail
+	Reason     string `json:"reason,omitempty"`
+}
+
+// newOverride validates the body of PUT /admin/translations, returning the
+// pinned entry
+func newOverride(req SetOverrideRequest) (TMEntry, error) {
+	if req.SourceText == "" || req.TargetText == "" {
+		return TMEntry{}, errors.New("source_text and target_text are required")
+	}
+	if req.TargetLang == "" {
+		return TMEntry{}, errors.New("target_lang is required")
+	}
+	if err := validateLanguages(TranslationRequest{SourceLang: req.SourceLang, TargetLang: req.TargetLang}); err != nil {
+		return TMEntry{}, err
+	}
+	if req.SetBy = strings.TrimSpace(req.SetBy); req.SetBy == "" {
+		return TMEntry{}, errors.New("set_by is required")
+	}
+	return TMEntry{
+		SourceLang: strings.ToLower(req.SourceLang),
+		TargetLang: strings.ToLower(req.TargetLang),
+		SourceText: req.SourceText,
+		TargetText: req.TargetText,
+		Provenance: tmProvenanceHuman,
+		Origin:     tmOriginOverride,
+		UpdatedAt:  time.Now().UTC(),
+		Pinned:     true,
+		SetBy:      req.SetBy,
+		Reason:     req.Reason,
+	}, nil
+}
+
+// handleAdminTranslations serves the manual translation override API.
+// Overrides are pinned translation memory entries, so they win over cached
+// and machine translations, and every change is kept as a version with who
+// made it and when.
+//
+//	GET    /admin/translations?source_lang=en&target_lang=de          list overrides, optionally of one language pair
+//	PUT    /admin/translations                                        pin a translation
+//	DELETE /admin/translations?source_lang=en&target_lang=de&text=... remove an override
+func (s *Server) handleAdminTranslations(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+	ctx := r.Context()
+	if !s.redisAvailable() {
+		writeError(w, http.StatusServiceUnavailable, codeServiceUnavailable, "Translation overrides unavailable: Redis is down")
+		return
+	}
+	query := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		overrides := []TMEntry{}
+		err := s.scanTMEntries(ctx, query.Get("source_lang"), query.Get("target_lang"), func(entry TMEntry) error {
+			if entry.Pinned {
+				overrides = append(overrides, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Override operation failed: %v", err))
+			return
+		}
+		sort.Slice(overrides, func(i, j int) bool {
+			return overrides[i].UpdatedAt.After(overrides[j].UpdatedAt)
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(overrides)
+	case http.MethodPut:
+		var req SetOverrideRequest
+		if !decodeRequestBody(w, r, &req) {
+			return
+		}
+		entry, err := newOverride(req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, invalidRequestCode(err), fmt.Sprintf("Invalid request: %v", err))
+			return
+		}
+		stored, ok := s.putTMEntry(ctx, w, entry)
+		if !ok {
+			return
+		}
+		logger(ctx).Info("pinned translation override", "source_lang", stored.SourceLang, "target_lang", stored.TargetLang, "set_by", stored.SetBy)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stored)
+	case http.MethodDelete:
+		sourceLang, targetLang, text := query.Get("source_lang"), query.Get("target_lang"), query.Get("text")
+		if targetLang == "" || text == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: target_lang and text are required")
+			return
+		}
+		entry, err := s.getTMEntry(ctx, sourceLang, targetLang, text)
+		if err == nil && !entry.Pinned {
+			err = errTMEntryNotFound
+		}
+		if err == nil {
+			err = s.deleteTMEntry(ctx, sourceLang, targetLang, text)
+		}
+		if err == errTMEntryNotFound {
+			writeError(w, http.StatusNotFound, codeNotFound, "Override not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Override operation failed: %v", err))
+			return
+		}
+		logger(ctx).Info("removed translation override", "source_lang", sourceLang, "target_lang", targetLang)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
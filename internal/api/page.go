@@ -0,0 +1,667 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// maxPageRedirects is how many redirects a page fetch follows
+const maxPageRedirects = 5
+
+// Outputs of POST /translate/page
+const (
+	pageOutputHTML     = "html"     // The rewritten page
+	pageOutputSegments = "segments" // The translated segments as JSON
+)
+
+// PageRequest is the body of POST /translate/page
+type PageRequest struct {
+	URL        string `json:"url"`
+	SourceLang string `json:"source_lang,omitempty"`
+	TargetLang string `json:"target_lang"`
+	Output     string `json:"output,omitempty"` // html or segments, html when empty
+	Formality  string `json:"formality,omitempty"`
+	Tone       string `json:"tone,omitempty"`
+	Context    string `json:"context,omitempty"`
+	Domain     string `json:"domain,omitempty"`
+
+	CacheTTLSeconds int  `json:"cache_ttl_seconds,omitempty"`
+	NoCache         bool `json:"no_cache,omitempty"`
+	NoStore         bool `json:"no_store,omitempty"`
+}
+
+// PageResponse is the response to PageRequest with output segments
+type PageResponse struct {
+	URL        string        `json:"url"` // Of the page fetched, after redirects
+	SourceLang string        `json:"source_lang,omitempty"`
+	TargetLang string        `json:"target_lang"`
+	Segments   []PageSegment `json:"segments"`   // In the order of the page
+	Strings    int           `json:"strings"`    // Distinct segments translated
+	CacheHits  int           `json:"cache_hits"` // Segments served from the cache
+}
+
+// PageSegment is a translated piece of a page
+type PageSegment struct {
+	Text           string `json:"text"`
+	TranslatedText string `json:"translated_text"`
+	Format         string `json:"format"`              // text, or html for text with inline markup
+	Element        string `json:"element"`             // The element holding it
+	Attribute      string `json:"attribute,omitempty"` // For attribute values, as alt
+}
+
+// pageSkippedElements are the elements whose content is never translated
+var pageSkippedElements = map[string]bool{
+	"code": true, "iframe": true, "math": true, "noscript": true, "object": true, "script": true,
+	"style": true, "svg": true, "template": true, "textarea": true,
+}
+
+// pageInlineElements are the elements translated with the text around them,
+// as markup within a segment
+var pageInlineElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "br": true, "cite": true, "data": true,
+	"del": true, "dfn": true, "em": true, "i": true, "img": true, "ins": true, "kbd": true, "mark": true,
+	"q": true, "s": true, "samp": true, "small": true, "span": true, "strong": true, "sub": true,
+	"sup": true, "time": true, "u": true, "var": true, "wbr": true,
+}
+
+// pageAttributes are the attributes whose values are translated
+var pageAttributes = []string{"alt", "aria-label", "placeholder", "title"}
+
+// pageMetaNames are the names and properties of the meta elements whose
+// content is translated
+var pageMetaNames = map[string]bool{
+	"description": true, "og:description": true, "og:title": true, "twitter:description": true, "twitter:title": true,
+}
+
+// blockedAddressError is returned for page and callback URLs resolving to
+// an address that may not be connected to
+type blockedAddressError struct {
+	addr netip.Addr
+}
+
+// Error implements error
+func (e blockedAddressError) Error() string {
+	return fmt.Sprintf("%s is not a public address", e.addr)
+}
+
+// pageURLError is returned for page URLs, or redirects, that aren't allowed
+type pageURLError struct {
+	reason string
+}
+
+// Error implements error
+func (e pageURLError) Error() string {
+	return e.reason
+}
+
+// nonPublicPrefixes are the ranges of special-purpose addresses, besides
+// loopback, private and link-local ones, that pages may not be fetched from
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, which may reach private IPv4 addresses
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// publicAddress reports whether addr may be connected to without
+// PAGE_FETCH_ALLOW_PRIVATE or WEBHOOK_ALLOW_PRIVATE
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// newPublicDialer returns a dialer refusing connections to addresses that
+// aren't public with a blockedAddressError, unless allowPrivate is set
+func newPublicDialer(timeout time.Duration, allowPrivate bool) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !allowPrivate && !publicAddress(addr.Addr()) {
+				return blockedAddressError{addr.Addr()}
+			}
+			return nil
+		},
+	}
+}
+
+// newPageClient returns the client pages are fetched with. The address of
+// every connection is checked once resolved, so host names can't be made
+// to resolve to internal addresses, and so is the URL of every redirect.
+// Proxy settings are ignored as the proxy would connect instead.
+func (s *Server) newPageClient() *http.Client {
+	dialer := newPublicDialer(s.config.PageFetchTimeout, s.config.PageFetchAllowPrivate)
+	return &http.Client{
+		Timeout: s.config.PageFetchTimeout,
+		Transport: &http.Transport{
+			DialContext:            dialer.DialContext,
+			TLSHandshakeTimeout:    s.config.PageFetchTimeout,
+			ResponseHeaderTimeout:  s.config.PageFetchTimeout,
+			MaxResponseHeaderBytes: 1 << 20,
+			MaxIdleConns:           10,
+			IdleConnTimeout:        time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxPageRedirects {
+				return pageURLError{fmt.Sprintf("stopped after %d redirects", maxPageRedirects)}
+			}
+			return s.checkPageURL(req.URL)
+		},
+	}
+}
+
+// checkPageURL returns a pageURLError unless u is an http or https URL of
+// a host PAGE_FETCH_ALLOWLIST allows
+func (s *Server) checkPageURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return pageURLError{fmt.Sprintf("%s URLs are not supported", u.Scheme)}
+	}
+	if u.User != nil {
+		return pageURLError{"URLs with credentials are not supported"}
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	for _, pattern := range s.currentConfig().PageFetchAllowlist {
+		pattern = strings.ToLower(pattern)
+		if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+domain) {
+				return nil
+			}
+		} else if host == pattern {
+			return nil
+		}
+	}
+	return pageURLError{fmt.Sprintf("%s is not in PAGE_FETCH_ALLOWLIST", host)}
+}
+
+// fetchPage fetches the HTML page at u, returning it as UTF-8 and its URL
+// after redirects
+func (s *Server) fetchPage(ctx context.Context, u *url.URL) ([]byte, *url.URL, error) {
+	if err := s.checkPageURL(u); err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9")
+	req.Header.Set("User-Agent", "ss-translate/"+Version)
+	resp, err := s.pageClient.Do(req)
+	if err != nil {
+		// Refusals of redirects and addresses are returned as they are,
+		// rather than as failed requests
+		var urlErr pageURLError
+		var addrErr blockedAddressError
+		switch {
+		case errors.As(err, &urlErr):
+			return nil, nil, urlErr
+		case errors.As(err, &addrErr):
+			return nil, nil, addrErr
+		}
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return nil, nil, fmt.Errorf("the page returned %s", resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, nil, fmt.Errorf("the page is %q, not HTML", mediaType)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s.config.PageMaxBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) > s.config.PageMaxBytes {
+		return nil, nil, fmt.Errorf("the page is larger than PAGE_MAX_BYTES (%d bytes)", s.config.PageMaxBytes)
+	}
+	if data, err = decodeHTML(data, contentType); err != nil {
+		return nil, nil, err
+	}
+	return data, resp.Request.URL, nil
+}
+
+// decodeHTML returns the HTML page data as UTF-8, decoding other charsets
+// by contentType or the page's meta elements
+func decodeHTML(data []byte, contentType string) ([]byte, error) {
+	reader, err := charset.NewReader(bytes.NewReader(data), contentType)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// pageSegment is a piece of a page to translate, with where it goes
+type pageSegment struct {
+	PageSegment
+	apply func(translation string)
+}
+
+// pageSegments returns the translatable pieces of the parsed page doc in
+// their order: blocks with inline markup, translated as HTML, and other
+// text and the values of pageAttributes and meta descriptions, translated
+// as text. Elements marked translate="no" or with the notranslate class
+// are skipped with their content.
+func pageSegments(doc *html.Node) []*pageSegment {
+	var segments []*pageSegment
+	addText := func(element, attribute, text string, apply func(string)) {
+		// Translate the text without its surrounding whitespace, which is
+		// kept as it was
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			return
+		}
+		start := strings.Index(text, trimmed)
+		leading, trailing := text[:start], text[start+len(trimmed):]
+		segments = append(segments, &pageSegment{
+			PageSegment: PageSegment{Text: trimmed, Format: provider.FormatText, Element: element, Attribute: attribute},
+			apply:       func(translation string) { apply(leading + translation + trailing) },
+		})
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			if n.Parent != nil && n.Parent.Type == html.ElementNode {
+				addText(n.Parent.Data, "", n.Data, func(translation string) { n.Data = translation })
+			}
+			return
+		case html.ElementNode:
+			if pageSkippedElements[n.Data] || noTranslate(n) {
+				return
+			}
+			for i := range n.Attr {
+				attr := &n.Attr[i]
+				if translatedAttribute(n, attr.Key) {
+					addText(n.Data, attr.Key, attr.Val, func(translation string) { attr.Val = translation })
+				}
+			}
+			if !pageInlineElements[n.Data] && hasInlineMarkup(n) {
+				if segment := htmlSegment(n); segment != nil {
+					segments = append(segments, segment)
+					return
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return segments
+}
+
+// noTranslate reports whether the element n is marked not to be translated
+func noTranslate(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		switch {
+		case attr.Key == "translate" && strings.EqualFold(attr.Val, "no"):
+			return true
+		case attr.Key == "class" && slices.Contains(strings.Fields(attr.Val), "notranslate"):
+			return true
+		}
+	}
+	return false
+}
+
+// translatedAttribute reports whether the attribute key of the element n
+// is translated
+func translatedAttribute(n *html.Node, key string) bool {
+	switch {
+	case slices.Contains(pageAttributes, key):
+		return true
+	case n.DataAtom == atom.Input && key == "value":
+		kind := strings.ToLower(attributeValue(n, "type"))
+		return kind == "button" || kind == "submit" || kind == "reset"
+	case n.DataAtom == atom.Meta && key == "content":
+		return pageMetaNames[strings.ToLower(attributeValue(n, "name"))] || pageMetaNames[strings.ToLower(attributeValue(n, "property"))]
+	}
+	return false
+}
+
+// attributeValue returns the value of the attribute key of n, or "" when it
+// has none
+func attributeValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// hasInlineMarkup reports whether the content of n is text with inline
+// elements, and nothing else: no blocks, skipped or untranslated elements,
+// which would break the segment up
+func hasInlineMarkup(n *html.Node) bool {
+	inline, text := false, false
+	var check func(n *html.Node) bool
+	check = func(n *html.Node) bool {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.Type {
+			case html.TextNode:
+				text = text || strings.TrimSpace(c.Data) != ""
+			case html.ElementNode:
+				if !pageInlineElements[c.Data] || noTranslate(c) || !check(c) {
+					return false
+				}
+				inline = true
+			case html.CommentNode:
+			default:
+				return false
+			}
+		}
+		return true
+	}
+	return check(n) && inline && text
+}
+
+// htmlSegment returns the content of the element n as a segment translated
+// as HTML, or nil when it can't be rendered
+func htmlSegment(n *html.Node) *pageSegment {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&b, c); err != nil {
+			return nil
+		}
+	}
+	text := b.String()
+	trimmed := strings.TrimSpace(text)
+	start := strings.Index(text, trimmed)
+	leading, trailing := text[:start], text[start+len(trimmed):]
+	return &pageSegment{
+		PageSegment: PageSegment{Text: trimmed, Format: provider.FormatHTML, Element: n.Data},
+		apply: func(translation string) {
+			nodes, err := html.ParseFragment(strings.NewReader(leading+translation+trailing), n)
+			if err != nil {
+				return
+			}
+			for c := n.FirstChild; c != nil; c = n.FirstChild {
+				n.RemoveChild(c)
+			}
+			for _, c := range nodes {
+				n.AppendChild(c)
+			}
+		},
+	}
+}
+
+// distinctSegments are the distinct texts of segments by format, as texts
+// and markup are translated apart
+type distinctSegments struct {
+	texts map[string][]string // By format, in the order of the segments
+	index map[PageSegment]int // Of each text and format within texts
+	chars int
+}
+
+// newDistinctSegments groups the distinct texts of segments
+func newDistinctSegments(segments []*pageSegment) *distinctSegments {
+	d := &distinctSegments{texts: map[string][]string{}, index: make(map[PageSegment]int)}
+	for _, segment := range segments {
+		key := PageSegment{Text: segment.Text, Format: segment.Format}
+		if _, ok := d.index[key]; !ok {
+			d.index[key] = len(d.texts[segment.Format])
+			d.texts[segment.Format] = append(d.texts[segment.Format], segment.Text)
+			d.chars += utf8.RuneCountInString(segment.Text)
+		}
+	}
+	return d
+}
+
+// translateSegments translates each distinct text of segments once with
+// the settings of base, setting the translation of every segment and
+// recording the usage. It returns the responses of the distinct texts.
+func (s *Server) translateSegments(ctx context.Context, base TranslationRequest, segments []*pageSegment, distinct *distinctSegments) ([]*TranslationResponse, error) {
+	responses := make(map[string][]*TranslationResponse, len(distinct.texts))
+	var all []*TranslationResponse
+	for _, format := range []string{provider.FormatText, provider.FormatHTML} {
+		if len(distinct.texts[format]) == 0 {
+			continue
+		}
+		formatReq := base
+		formatReq.Format = format
+		translated, err := s.translateStrings(ctx, formatReq, distinct.texts[format])
+		if err != nil {
+			return nil, err
+		}
+		s.recordStringsUsage(ctx, distinct.texts[format], translated)
+		responses[format] = translated
+		all = append(all, translated...)
+	}
+	for _, segment := range segments {
+		segment.TranslatedText = responses[segment.Format][distinct.index[PageSegment{Text: segment.Text, Format: segment.Format}]].TranslatedText
+	}
+	return all, nil
+}
+
+// preparePage readies the translated page doc to be served: it gets the
+// target language and a UTF-8 charset, as it is served in UTF-8, and when
+// served from elsewhere than pageURL, a base URL resolving its relative
+// links against it unless it has one. pageURL is nil for pages served from
+// their own URL.
+func preparePage(doc *html.Node, pageURL *url.URL, targetLang string) {
+	var root, head, base *html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Html:
+				root = n
+			case atom.Head:
+				head = n
+			case atom.Base:
+				if base == nil {
+					base = n
+				}
+			case atom.Meta:
+				for i := range n.Attr {
+					switch attr := &n.Attr[i]; {
+					case attr.Key == "charset":
+						attr.Val = "utf-8"
+					case attr.Key == "content" && strings.EqualFold(attributeValue(n, "http-equiv"), "content-type"):
+						attr.Val = "text/html; charset=utf-8"
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	if root != nil {
+		setAttribute(root, "lang", targetLang)
+	}
+	if head != nil && base == nil && pageURL != nil {
+		head.InsertBefore(&html.Node{
+			Type:     html.ElementNode,
+			Data:     "base",
+			DataAtom: atom.Base,
+			Attr:     []html.Attribute{{Key: "href", Val: pageURL.String()}},
+		}, head.FirstChild)
+	}
+}
+
+// setAttribute sets the attribute key of n to value
+func setAttribute(n *html.Node, key, value string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+// handlePage fetches a page of a host of PAGE_FETCH_ALLOWLIST, translates
+// its text, and returns the rewritten page or its segments. Repeated
+// segments are translated once.
+func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, ok := s.authenticateRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: Invalid API key")
+		logger(r.Context()).Warn("unauthorized request", "remote_addr", s.clientIP(r))
+		return
+	}
+	if len(s.currentConfig().PageFetchAllowlist) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Page translation is disabled")
+		return
+	}
+
+	var req PageRequest
+	if !decodeRequestBody(w, r, &req) {
+		return
+	}
+	base := TranslationRequest{
+		SourceLang:      req.SourceLang,
+		TargetLang:      req.TargetLang,
+		Formality:       req.Formality,
+		Tone:            req.Tone,
+		Context:         req.Context,
+		Domain:          req.Domain,
+		CacheTTLSeconds: req.CacheTTLSeconds,
+		NoCache:         req.NoCache,
+		NoStore:         req.NoStore,
+	}
+	if err := validateTranslationOptions(&base); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	if err := validateLanguages(base); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidLang, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	switch req.Output {
+	case "":
+		req.Output = pageOutputHTML
+	case pageOutputHTML, pageOutputSegments:
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: output must be %q or %q", pageOutputHTML, pageOutputSegments))
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: url must be an absolute http or https URL")
+		return
+	}
+
+	data, pageURL, err := s.fetchPage(ctx, u)
+	switch err.(type) {
+	case nil:
+	case pageURLError, blockedAddressError:
+		pageFetches.WithLabelValues("blocked").Inc()
+		logger(ctx).Warn("refused to fetch a page", "url", req.URL, "error", err)
+		writeError(w, http.StatusForbidden, codeForbidden, fmt.Sprintf("Page not allowed: %v", err))
+		return
+	default:
+		pageFetches.WithLabelValues("failed").Inc()
+		writeError(w, http.StatusBadGateway, codeFetchFailed, fmt.Sprintf("Failed to fetch the page: %v", err))
+		return
+	}
+	pageFetches.WithLabelValues("fetched").Inc()
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, codeFetchFailed, fmt.Sprintf("Failed to parse the page: %v", err))
+		return
+	}
+
+	segments := pageSegments(doc)
+	distinct := newDistinctSegments(segments)
+	if len(distinct.index) > s.config.DocumentMaxStrings {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: page has %d distinct segments, the maximum is %d", len(distinct.index), s.config.DocumentMaxStrings))
+		return
+	}
+	if !s.authorizeTranslation(ctx, w, distinct.chars) {
+		return
+	}
+	responses, err := s.translateSegments(ctx, base, segments, distinct)
+	if err != nil {
+		s.writeTranslationError(ctx, w, err)
+		return
+	}
+
+	response := PageResponse{
+		URL:        pageURL.String(),
+		SourceLang: base.SourceLang,
+		TargetLang: base.TargetLang,
+		Segments:   make([]PageSegment, 0, len(segments)),
+		Strings:    len(distinct.index),
+	}
+	for _, translated := range responses {
+		if translated.CacheHit {
+			response.CacheHits++
+		}
+		if response.SourceLang == "" {
+			response.SourceLang = translated.SourceLang
+		}
+	}
+	for _, segment := range segments {
+		response.Segments = append(response.Segments, segment.PageSegment)
+	}
+	logger(ctx).Info("translated page", "url", response.URL, "segments", len(segments), "characters", distinct.chars, "cache_hits", response.CacheHits)
+
+	if req.Output == pageOutputSegments {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	for _, segment := range segments {
+		segment.apply(segment.TranslatedText)
+	}
+	preparePage(doc, pageURL, base.TargetLang)
+	var page bytes.Buffer
+	if err := html.Render(&page, doc); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to render the page: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", base.TargetLang)
+	// The page is a third party's, served from the service's origin: the
+	// sandbox gives it an opaque origin and keeps its scripts from running
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(page.Bytes())
+}
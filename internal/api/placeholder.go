@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// defaultPlaceholderPattern matches common interpolation variables:
+// {{var}}, {name}, printf verbs such as %s or %1$d, and :param
+const defaultPlaceholderPattern = `\{\{\s*[\w.]+\s*\}\}|\{[\w.]+\}|%(?:\d+\$)?[sdif@]|\B:[A-Za-z_]\w*`
+
+// tokenMask substitutes opaque tokens, __<PREFIX>_<n>__, for spans of text
+// that must survive translation unchanged. Each step masking text uses its
+// own prefix so their tokens never collide.
+type tokenMask struct {
+	prefix string
+	// pattern matches the mask's tokens, tolerating the case and spacing
+	// changes providers sometimes make
+	pattern *regexp.Regexp
+}
+
+// newTokenMask creates a mask whose tokens use prefix
+func newTokenMask(prefix string) tokenMask {
+	return tokenMask{
+		prefix:  prefix,
+		pattern: regexp.MustCompile(`(?i)__\s*` + prefix + `_\s*(\d+)\s*__`),
+	}
+}
+
+// token returns the token substituted for the i-th masked span
+func (m tokenMask) token(i int) string {
+	return fmt.Sprintf("__%s_%d__", m.prefix, i)
+}
+
+// restore replaces every token in text with its value, returning the text
+// and how many values were missing from it
+func (m tokenMask) restore(text string, values []string) (string, int) {
+	seen := make([]bool, len(values))
+	restored := m.pattern.ReplaceAllStringFunc(text, func(token string) string {
+		i, err := strconv.Atoi(m.pattern.FindStringSubmatch(token)[1])
+		if err != nil || i >= len(values) {
+			return token
+		}
+		seen[i] = true
+		return values[i]
+	})
+	missing := 0
+	for _, ok := range seen {
+		if !ok {
+			missing++
+		}
+	}
+	return restored, missing
+}
+
+// placeholderMask masks interpolation variables
+var placeholderMask = newTokenMask("PH")
+
+// protectPlaceholders replaces every match of pattern in text with a token
+// providers leave untranslated, returning the masked text and the original
+// placeholders in token order
+func protectPlaceholders(pattern *regexp.Regexp, text string) (string, []string) {
+	var placeholders []string
+	masked := pattern.ReplaceAllStringFunc(text, func(match string) string {
+		placeholders = append(placeholders, match)
+		return placeholderMask.token(len(placeholders) - 1)
+	})
+	return masked, placeholders
+}
+
+// placeholderProvider shields interpolation variables from translation
+type placeholderProvider struct {
+	provider.Wrapper
+	pattern *regexp.Regexp
+}
+
+// newPlaceholderProvider wraps p so matches of pattern survive translation
+func newPlaceholderProvider(p provider.Provider, pattern string) (*placeholderProvider, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid placeholder pattern: %v", err)
+	}
+	return &placeholderProvider{Wrapper: provider.Wrapper{Provider: p}, pattern: re}, nil
+}
+
+// Translate implements Provider
+func (p *placeholderProvider) Translate(ctx context.Context, req provider.Request) (*provider.Result, error) {
+	masked, placeholders := protectPlaceholders(p.pattern, req.Text)
+	if len(placeholders) == 0 {
+		return p.Provider.Translate(ctx, req)
+	}
+
+	req.Text = masked
+	result, err := p.Provider.Translate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var missing int
+	result.TranslatedText, missing = placeholderMask.restore(result.TranslatedText, placeholders)
+	if missing > 0 {
+		logger(ctx).Warn("placeholders lost in translation", "provider", p.Name(), "missing", missing, "placeholders", len(placeholders))
+	}
+	return result, nil
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/cache"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// popularKey is the sorted set counting the hits of each cache key
+	popularKey = "cache:popular"
+	// popularRequestPrefix starts the keys holding the JSON popularRequest
+	// of each tracked cache key, as cache:popular:req:<cache key>
+	popularRequestPrefix = "cache:popular:req:"
+	// popularLockKey is held by the replica refreshing popular entries
+	popularLockKey = "cache:popular:lock"
+)
+
+// popularRequest is what a popular entry's translation is redone from: the
+// request and the caller it was cached for
+type popularRequest struct {
+	Request TranslationRequest `json:"request"`
+	KeyName string             `json:"key_name,omitempty"`
+	Tenant  string             `json:"tenant,omitempty"`
+}
+
+// trackPopularity counts an access to the cache entry of req under key,
+// without delaying the request
+func (s *Server) trackPopularity(ctx context.Context, req TranslationRequest, key string) {
+	if s.config.CachePopularTop <= 0 || req.NoStore || !s.redisAvailable() {
+		return
+	}
+	tenant := ""
+	if t := requestTenant(ctx); t != nil {
+		tenant = t.ID
+	}
+	req.NoCache = false
+	data, err := json.Marshal(popularRequest{Request: req, KeyName: apiKeyName(ctx), Tenant: tenant})
+	if err != nil {
+		logger(ctx).Warn("failed to marshal popular request", "error", err)
+		return
+	}
+	ttl := s.cacheTTL(req, key)
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		pipe := s.redis.Pipeline()
+		pipe.ZIncrBy(ctx, popularKey, 1, key)
+		pipe.Set(ctx, popularRequestPrefix+key, data, ttl)
+		if _, err := pipe.Exec(ctx); err != nil {
+			logger(ctx).Warn("failed to track cache entry popularity", "cache_key", key, "error", err)
+		}
+	}()
+}
+
+// refreshPopular re-translates the CACHE_POPULAR_TOP most accessed cache
+// entries every CACHE_POPULAR_INTERVAL when they expire within
+// CACHE_POPULAR_AHEAD, or already expired, so hot strings stay
+// cached. One replica refreshes per interval.
+func (s *Server) refreshPopular(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if !s.redisAvailable() {
+				continue
+			}
+			// Held slightly less than an interval, so the next tick of the
+			// replica holding it doesn't find it still taken
+			if acquired, err := s.redis.SetNX(ctx, popularLockKey, 1, interval-interval/10).Result(); err != nil || !acquired {
+				continue
+			}
+			s.refreshPopularEntries(ctx)
+		}
+	}()
+}
+
+// refreshPopularEntries refreshes the popular entries due for it, then
+// halves every count so popularity follows recent traffic
+func (s *Server) refreshPopularEntries(ctx context.Context) {
+	top := s.config.CachePopularTop
+	keys, err := s.redis.ZRevRange(ctx, popularKey, 0, int64(top)-1).Result()
+	if err != nil {
+		slog.Warn("failed to list popular cache entries", "error", err)
+		return
+	}
+
+	var refreshed, failed int
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return
+		}
+		switch err := s.refreshPopularEntry(ctx, key); {
+		case err == errRefreshSkipped:
+		case err != nil:
+			failed++
+			popularRefreshes.WithLabelValues("failed").Inc()
+			slog.Warn("failed to refresh popular cache entry", "cache_key", key, "error", err)
+		default:
+			refreshed++
+			popularRefreshes.WithLabelValues("refreshed").Inc()
+		}
+	}
+	if refreshed > 0 || failed > 0 {
+		slog.Info("refreshed popular cache entries", "refreshed", refreshed, "failed", failed)
+	}
+
+	// Forget all but the counts that could make the top soon
+	pipe := s.redis.TxPipeline()
+	pipe.ZUnionStore(ctx, popularKey, &redis.ZStore{Keys: []string{popularKey}, Weights: []float64{0.5}})
+	pipe.ZRemRangeByScore(ctx, popularKey, "-inf", "(0.5")
+	pipe.ZRemRangeByRank(ctx, popularKey, 0, -10*int64(top)-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Warn("failed to decay popular cache entries", "error", err)
+	}
+}
+
+// errRefreshSkipped is returned by refreshPopularEntry for entries that
+// don't expire soon, are being refreshed already or are no longer tracked
+var errRefreshSkipped = errors.New("refresh skipped")
+
+// refreshPopularEntry re-translates the popular entry under key, unless it
+// isn't due or a stale-while-revalidate refresh of it is running
+func (s *Server) refreshPopularEntry(ctx context.Context, key string) error {
+	var popular popularRequest
+	data, err := s.redis.Get(ctx, popularRequestPrefix+key).Bytes()
+	if err == redis.Nil {
+		// Not accessed for a whole TTL
+		return s.forgetPopular(ctx, key)
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &popular)
+	}
+	if err != nil {
+		return err
+	}
+
+	cached, err := s.cache.Get(ctx, key)
+	switch {
+	case err == nil:
+		var entry cacheEntry
+		if err := json.Unmarshal(cached, &entry); err == nil && !entry.ExpiresAt.IsZero() &&
+			time.Until(entry.ExpiresAt) > s.config.CachePopularAhead {
+			return errRefreshSkipped
+		}
+	case errors.Is(err, cache.ErrMiss):
+	default:
+		return err
+	}
+
+	// The caller's glossary or the normalizations may have changed since,
+	// moving its translations to another key
+	req := popular.Request
+	ctx = withLowPriority(s.withAPIKeyName(ctx, popular.KeyName, popular.Tenant))
+	terms := s.glossaryTerms(ctx, req)
+	if s.translationCacheKey(ctx, req, terms) != key {
+		return s.forgetPopular(ctx, key)
+	}
+
+	if _, running := s.refreshing.LoadOrStore(key, struct{}{}); running {
+		return errRefreshSkipped
+	}
+	defer s.refreshing.Delete(key)
+	ctx, cancel := context.WithTimeout(ctx, s.config.CacheRefreshTimeout)
+	defer cancel()
+	_, err = s.translateAndCache(ctx, req, key, terms)
+	return err
+}
+
+// forgetPopular stops tracking the cache entry under key
+func (s *Server) forgetPopular(ctx context.Context, key string) error {
+	if err := s.redis.ZRem(ctx, popularKey, key).Err(); err != nil {
+		return err
+	}
+	return errRefreshSkipped
+}
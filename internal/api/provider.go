@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dphase/ss-translate/internal/provider"
+	"golang.org/x/oauth2/google"
+)
+
+// newProvider creates the provider registered under name, held to its
+// character budgets. Every call is instrumented, transient failures are
+// retried, repeated failures open a circuit breaker, calls in flight are
+// bounded across providers, texts over the provider's limit are chunked,
+// placeholders are protected from translation and personal data is
+// redacted.
+func (s *Server) newProvider(ctx context.Context, name string) (provider.Provider, error) {
+	raw, err := s.createProvider(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.decorateProvider(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &budgetedProvider{Wrapper: provider.Wrapper{Provider: p}, server: s}, nil
+}
+
+// decorateProvider wraps a newly created provider in the decorators
+// newProvider describes
+func (s *Server) decorateProvider(raw provider.Provider) (provider.Provider, error) {
+	var err error
+	limit := s.config.ChunkMaxBytes
+	if limiter, ok := raw.(TextLimiter); ok && limit == 0 {
+		limit = limiter.MaxTextBytes()
+	}
+
+	var p provider.Provider = &instrumentedProvider{provider.Wrapper{Provider: raw}}
+	p = provider.NewRetry(p, s.config.Retry)
+	if s.config.CircuitBreakerThreshold > 0 {
+		p = provider.NewCircuitBreaker(p, s.config.CircuitBreakerThreshold, s.config.CircuitBreakerCooldown)
+	}
+	if s.providerLimit != nil {
+		// Outside the breaker, as saturation says nothing about the provider
+		p = provider.NewLimited(p, s.providerLimit)
+	}
+	if limit > 0 {
+		p = &chunkingProvider{Wrapper: provider.Wrapper{Provider: p}, limit: limit, concurrency: s.config.ChunkConcurrency}
+	}
+	if s.config.PlaceholderProtection {
+		if p, err = newPlaceholderProvider(p, s.config.PlaceholderPattern); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.config.PIIRedaction) > 0 || s.config.PIIPattern != "" {
+		if p, err = newRedactingProvider(p, s.config.PIIRedaction, s.config.PIIPattern); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// createProvider creates the provider registered under name
+func (s *Server) createProvider(ctx context.Context, name string) (provider.Provider, error) {
+	switch name {
+	case "google":
+		creds, err := provider.GoogleCredentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return s.createGoogleProvider(ctx, creds, s.config.GoogleProjectID, s.config.GoogleModel, s.config.GoogleGlossary,
+			s.config.GoogleDomainModels)
+	case "aws":
+		return provider.NewAWS(ctx, s.config.AWSRegion, s.config.AWSTerminologies, s.config.AWSDomainTerminologies)
+	case "azure":
+		return provider.NewAzure(s.config.AzureEndpoint, s.config.AzureKey, s.config.AzureRegion)
+	case "libretranslate":
+		return provider.NewLibreTranslate(s.config.LibreTranslateURL, s.config.LibreTranslateAPIKey)
+	case "llm":
+		prompts, err := readDomainPrompts(s.config.LLMDomainPrompts)
+		if err != nil {
+			return nil, err
+		}
+		return provider.NewLLM(s.config.LLMEndpoint, s.config.LLMAPIKey, s.config.LLMModel,
+			s.config.LLMTemperature, s.config.LLMPromptTemplate, prompts, s.config.LLMLanguages)
+	default:
+		return nil, fmt.Errorf("unknown translation provider: %q", name)
+	}
+}
+
+// createGoogleProvider creates a Google provider of the configured API
+// version with creds. The project, models and glossary only apply to v3.
+func (s *Server) createGoogleProvider(ctx context.Context, creds *google.Credentials, project, model, glossary string, domainModels map[string]string) (provider.Provider, error) {
+	switch s.config.GoogleAPIVersion {
+	case "v2":
+		return provider.NewGoogle(ctx, creds)
+	case "v3":
+		return provider.NewGoogleV3(ctx, creds, project, s.config.GoogleLocation, model, glossary, domainModels)
+	default:
+		return nil, fmt.Errorf("unknown Google Translate API version: %q", s.config.GoogleAPIVersion)
+	}
+}
+
+// handleProviderStats reports per-provider canary statistics
+func (s *Server) handleProviderStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	c, ok := s.provider.(*provider.Canary)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Canary routing is not enabled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(c.Stats())
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/language"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// Proxy mode settings
+const (
+	proxyKeyName         = "proxy" // Usage of proxied translations is recorded under
+	proxyCachePrefix     = "proxy:"
+	proxyDefaultSelector = "$..*" // Every string of JSON responses
+)
+
+// proxyTargetKey is the context key of the language a proxied response is
+// translated into
+type proxyTargetKey struct{}
+
+// proxyLanguages matches the Accept-Language of proxied requests with
+// PROXY_SOURCE_LANG and PROXY_LANGUAGES
+type proxyLanguages struct {
+	matcher language.Matcher
+	targets []string // Of the matcher after the source language
+}
+
+// newProxyLanguages returns the matcher of PROXY_SOURCE_LANG and
+// PROXY_LANGUAGES, validated by ValidateConfig
+func newProxyLanguages(config Config) *proxyLanguages {
+	tags := []language.Tag{language.Make(config.ProxySourceLang)}
+	l := &proxyLanguages{}
+	for _, code := range config.ProxyLanguages {
+		tags = append(tags, language.Make(code))
+		l.targets = append(l.targets, normalizeLanguageTag(code))
+	}
+	l.matcher = language.NewMatcher(tags)
+	return l
+}
+
+// target returns the language to translate the response of r into, the
+// best match of its Accept-Language, or "" when it prefers the source
+// language or accepts none of PROXY_LANGUAGES
+func (l *proxyLanguages) target(r *http.Request) string {
+	header := r.Header.Get("Accept-Language")
+	if header == "" {
+		return ""
+	}
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil || len(tags) == 0 {
+		return ""
+	}
+	_, i, confidence := l.matcher.Match(tags...)
+	if confidence == language.No || i == 0 {
+		return ""
+	}
+	return l.targets[i-1]
+}
+
+// ProxyHandler returns the handler of the PROXY_PORT listener, which
+// proxies PROXY_UPSTREAM and translates its HTML and JSON responses into
+// the language of each request's Accept-Language, or nil when
+// PROXY_UPSTREAM is unset. Requests pass through untranslated for the
+// source language and languages outside PROXY_LANGUAGES.
+func (s *Server) ProxyHandler() http.Handler {
+	if s.proxyUpstream == nil {
+		return nil
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(s.proxyUpstream)
+			r.SetXForwarded()
+			if r.In.Context().Value(proxyTargetKey{}) != nil {
+				// Let the transport ask for gzip and decompress it, so the
+				// response can be translated
+				r.Out.Header.Del("Accept-Encoding")
+			}
+		},
+		ModifyResponse: s.translateProxyResponse,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			logger(r.Context()).Warn("proxy upstream failed", "upstream", s.config.ProxyUpstream, "error", err)
+			writeError(w, http.StatusBadGateway, codeFetchFailed, "The upstream failed")
+		},
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := s.withAPIKeyName(r.Context(), proxyKeyName, "")
+		if r.Method == http.MethodGet {
+			if target := s.proxyLanguages.target(r); target != "" {
+				ctx = context.WithValue(ctx, proxyTargetKey{}, target)
+			}
+		}
+		proxy.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return withRequestLogging(s.allowlistIPs(s.withCompression(instrumentHandler("proxy", handler))))
+}
+
+// proxyContentKind returns html or json for the responses proxy mode
+// translates by their Content-Type, or "" for the rest
+func proxyContentKind(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return "html"
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return "json"
+	}
+	return ""
+}
+
+// translateProxyResponse translates a successful HTML or JSON response of
+// the upstream, unless too large. Responses that fail to translate are
+// served as they were.
+func (s *Server) translateProxyResponse(resp *http.Response) error {
+	kind := proxyContentKind(resp.Header.Get("Content-Type"))
+	if kind == "" {
+		return nil
+	}
+	// Caches must keep the translations apart
+	resp.Header.Add("Vary", "Accept-Language")
+	ctx := resp.Request.Context()
+	target, _ := ctx.Value(proxyTargetKey{}).(string)
+	if target == "" || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	if resp.ContentLength > s.config.ProxyMaxBytes {
+		proxyResponses.WithLabelValues("too_large").Inc()
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s.config.ProxyMaxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > s.config.ProxyMaxBytes {
+		proxyResponses.WithLabelValues("too_large").Inc()
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	uri := resp.Request.URL.RequestURI()
+	var translated []byte
+	if kind == "html" {
+		translated, err = s.translateProxyHTML(ctx, target, uri, data, resp.Header.Get("Content-Type"))
+	} else {
+		translated, err = s.translateProxyJSON(ctx, target, uri, data)
+	}
+	if err != nil {
+		proxyResponses.WithLabelValues("failed").Inc()
+		logger(ctx).Warn("failed to translate a proxied response, serving it untranslated", "path", uri, "target_lang", target, "error", err)
+		translated = data
+	} else {
+		proxyResponses.WithLabelValues("translated").Inc()
+		if kind == "html" {
+			resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+		}
+		resp.Header.Set("Content-Language", target)
+		// Validators of the upstream's representation don't hold for its
+		// translation
+		resp.Header.Del("ETag")
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(translated))
+	resp.ContentLength = int64(len(translated))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(translated)))
+	return nil
+}
+
+// translateProxyHTML translates an HTML page of the upstream at uri into
+// target like POST /translate/page, serving it in UTF-8
+func (s *Server) translateProxyHTML(ctx context.Context, target, uri string, data []byte, contentType string) ([]byte, error) {
+	data, err := decodeHTML(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	segments := pageSegments(doc)
+	if err := s.translateProxySegments(ctx, target, uri, segments); err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		segment.apply(segment.TranslatedText)
+	}
+	preparePage(doc, nil, target)
+	var page bytes.Buffer
+	if err := html.Render(&page, doc); err != nil {
+		return nil, err
+	}
+	return page.Bytes(), nil
+}
+
+// translateProxyJSON translates the string values PROXY_JSON_SELECTORS
+// select in a JSON response of the upstream at uri into target
+func (s *Server) translateProxyJSON(ctx context.Context, target, uri string, data []byte) ([]byte, error) {
+	root, err := parseJSONDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	var segments []*pageSegment
+	selected := make(map[*jsonNode]bool)
+	for _, steps := range s.proxySelectors {
+		for _, n := range selectNodes(root, steps) {
+			text, ok := n.value.(string)
+			if !ok || selected[n] || strings.TrimSpace(text) == "" {
+				continue
+			}
+			selected[n] = true
+			n := n
+			segments = append(segments, &pageSegment{
+				PageSegment: PageSegment{Text: text, Format: provider.FormatText},
+				apply:       func(translation string) { n.value = translation },
+			})
+		}
+	}
+	if err := s.translateProxySegments(ctx, target, uri, segments); err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		segment.apply(segment.TranslatedText)
+	}
+	var out bytes.Buffer
+	if err := root.encode(&out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// proxyCacheKey returns the cache key of the translation of a segment of
+// the upstream's response at uri, apart from the /translate cache as it
+// depends on the URL
+func proxyCacheKey(target, uri string, segment *pageSegment) string {
+	return fmt.Sprintf("%s%s:%s:%s:%s", proxyCachePrefix, target, uri, segment.Format, textHash(segment.Text))
+}
+
+// translateProxySegments translates the segments of the upstream's
+// response at uri into target, looking the translations of the URL up in
+// the cache first and caching new ones for PROXY_CACHE_TTL
+func (s *Server) translateProxySegments(ctx context.Context, target, uri string, segments []*pageSegment) error {
+	if len(segments) == 0 {
+		return nil
+	}
+	keys := make([]string, len(segments))
+	for i, segment := range segments {
+		keys[i] = proxyCacheKey(target, uri, segment)
+	}
+	cached, err := s.cache.MGet(ctx, keys...)
+	var misses []*pageSegment
+	for i, segment := range segments {
+		if err == nil && cached[i] != nil {
+			segment.TranslatedText = string(cached[i])
+			continue
+		}
+		misses = append(misses, segment)
+	}
+	proxyFragments.WithLabelValues("hit").Add(float64(len(segments) - len(misses)))
+	proxyFragments.WithLabelValues("miss").Add(float64(len(misses)))
+	if len(misses) == 0 {
+		return nil
+	}
+
+	distinct := newDistinctSegments(misses)
+	if len(distinct.index) > s.config.DocumentMaxStrings {
+		return fmt.Errorf("%d distinct segments, the maximum is %d", len(distinct.index), s.config.DocumentMaxStrings)
+	}
+	base := TranslationRequest{SourceLang: s.config.ProxySourceLang, TargetLang: target}
+	if err := validateTranslationOptions(&base); err != nil {
+		return err
+	}
+	if _, err := s.translateSegments(ctx, base, misses, distinct); err != nil {
+		return err
+	}
+	stored := make(map[string]bool, len(misses))
+	for _, segment := range misses {
+		key := proxyCacheKey(target, uri, segment)
+		if stored[key] {
+			continue
+		}
+		stored[key] = true
+		if err := s.cache.Set(ctx, key, []byte(segment.TranslatedText), s.config.ProxyCacheTTL); err != nil {
+			logger(ctx).Warn("failed to cache a proxied translation", "error", err)
+			break
+		}
+	}
+	return nil
+}
+
+// parseProxyUpstream parses PROXY_UPSTREAM, validated by ValidateConfig
+func parseProxyUpstream(config Config) *url.URL {
+	if config.ProxyUpstream == "" {
+		return nil
+	}
+	u, _ := url.Parse(config.ProxyUpstream)
+	return u
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+)
+
+// pubsubKeyName is the API key name usage, rate limits and quotas of Pub/Sub
+// messages are accounted under
+const pubsubKeyName = "pubsub"
+
+// Attributes added to results and to the messages sent to PUBSUB_DLQ_TOPIC
+const (
+	pubsubAttrSourceMessageID = "source_message_id" // ID of the input message
+	pubsubAttrErrorCode       = "error_code"
+	pubsubAttrError           = "error"
+)
+
+// setupPubSub sets up the client of the Pub/Sub worker mode and its topics,
+// with GOOGLE_APPLICATION_CREDENTIALS_JSON or the default credentials
+func (s *Server) setupPubSub(ctx context.Context) error {
+	project := s.config.PubSubProjectID
+	if project == "" {
+		project = pubsub.DetectProjectID
+	}
+	var opts []option.ClientOption
+	if credJSON := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS_JSON"); credJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(credJSON)))
+	}
+	client, err := pubsub.NewClient(ctx, project, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create Pub/Sub client: %v", err)
+	}
+	s.pubsub = client
+	s.pubsubOutput = s.pubsubTopic(s.config.PubSubOutputTopic)
+	if s.config.PubSubDLQTopic != "" {
+		s.pubsubDLQ = s.pubsubTopic(s.config.PubSubDLQTopic)
+	}
+	return nil
+}
+
+// pubsubTopic returns a topic of the worker mode's project. Messages are
+// published in order per ordering key, so the results of a document key
+// come out in the order its requests were delivered.
+func (s *Server) pubsubTopic(id string) *pubsub.Topic {
+	topic := s.pubsub.Topic(id)
+	topic.EnableMessageOrdering = true
+	return topic
+}
+
+// startPubSubConsumer receives the messages of the subscription until ctx
+// is done, translating up to PUBSUB_MAX_OUTSTANDING of them at once. When
+// the subscription has message ordering enabled, the messages of an
+// ordering key are translated one at a time, in order.
+func (s *Server) startPubSubConsumer(ctx context.Context) {
+	slog.Info("consuming translation requests from Pub/Sub", "subscription", s.config.PubSubSubscription,
+		"max_outstanding", s.config.PubSubMaxOutstanding)
+	sub := s.pubsub.Subscription(s.config.PubSubSubscription)
+	sub.ReceiveSettings.MaxOutstandingMessages = s.config.PubSubMaxOutstanding
+	s.jobWorkers.Add(1)
+	go func() {
+		defer s.jobWorkers.Done()
+		// Finish the messages in progress on shutdown, rather than translate
+		// them again once redelivered
+		storeCtx := context.WithoutCancel(ctx)
+		for ctx.Err() == nil {
+			// Receive extends the ack deadline of messages while they are
+			// translated, and returns once they are acked or nacked
+			err := sub.Receive(ctx, func(msgCtx context.Context, msg *pubsub.Message) {
+				s.handlePubSubMessage(msgCtx, storeCtx, msg)
+			})
+			if err != nil && ctx.Err() == nil {
+				slog.Error("failed to receive pubsub messages", "subscription", s.config.PubSubSubscription, "error", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(jobPollTimeout):
+				}
+			}
+		}
+	}()
+}
+
+// handlePubSubMessage translates a message into the output topic and acks
+// it, retrying transient failures with backoff. Messages that still fail, or
+// are invalid, are sent to the dead-letter topic when there is one, and
+// otherwise nacked for the subscription's retry and dead-letter policies.
+// Messages are nacked when ctx is done before they are handled.
+func (s *Server) handlePubSubMessage(ctx, storeCtx context.Context, msg *pubsub.Message) {
+	log := slog.With("subscription", s.config.PubSubSubscription, "message_id", msg.ID, "ordering_key", msg.OrderingKey)
+
+	value, failure, ok := s.retryQueued(ctx, storeCtx, log, pubsubKeyName, mediaJSON, msg.Data, s.config.PubSubRetry)
+	if !ok {
+		msg.Nack()
+		return
+	}
+	if failure == nil {
+		if !s.publishPubSubMessage(ctx, storeCtx, log, s.pubsubOutput, msg, value, nil) {
+			msg.Nack()
+			return
+		}
+		msg.Ack()
+		pubsubMessages.WithLabelValues("translated").Inc()
+		return
+	}
+
+	log.Warn("pubsub message failed", "code", failure.Code, "error", failure.Message)
+	if s.pubsubDLQ == nil {
+		msg.Nack()
+		pubsubMessages.WithLabelValues("nacked").Inc()
+		return
+	}
+	attributes := map[string]string{
+		pubsubAttrErrorCode: failure.Code,
+		pubsubAttrError:     failure.Message,
+	}
+	if !s.publishPubSubMessage(ctx, storeCtx, log, s.pubsubDLQ, msg, msg.Data, attributes) {
+		msg.Nack()
+		return
+	}
+	msg.Ack()
+	pubsubMessages.WithLabelValues("dead_lettered").Inc()
+}
+
+// publishPubSubMessage publishes data to a topic as the answer to msg, with
+// msg's attributes, ordering key and ID and extra attributes. Publishing is
+// retried with backoff until it succeeds or ctx is done, as msg can't be
+// acked before.
+func (s *Server) publishPubSubMessage(ctx, storeCtx context.Context, log *slog.Logger, topic *pubsub.Topic, msg *pubsub.Message, data []byte, extra map[string]string) bool {
+	attributes := make(map[string]string, len(msg.Attributes)+len(extra)+1)
+	for name, value := range msg.Attributes {
+		attributes[name] = value
+	}
+	attributes[pubsubAttrSourceMessageID] = msg.ID
+	for name, value := range extra {
+		attributes[name] = value
+	}
+	out := &pubsub.Message{Data: data, Attributes: attributes, OrderingKey: msg.OrderingKey}
+
+	for attempt := 1; ; attempt++ {
+		_, err := topic.Publish(storeCtx, out).Get(storeCtx)
+		if err == nil {
+			return true
+		}
+		if msg.OrderingKey != "" {
+			// A failed publish pauses the messages of its ordering key
+			topic.ResumePublish(msg.OrderingKey)
+		}
+		delay := s.config.PubSubRetry.Backoff(attempt)
+		log.Error("failed to publish pubsub message, retrying", "to", topic.ID(), "backoff", delay.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+	}
+}
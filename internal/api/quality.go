@@ -0,0 +1,356 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// Bounds of quality estimation
+const (
+	qualityQueueSize = 1000 // Records waiting to be scored; more are recorded unscored
+	qualityTimeout   = 10 * time.Second
+	// chrFOrder and chrFBeta are the character n-gram order and recall
+	// weight of chrF, as in chrF2
+	chrFOrder = 6
+	chrFBeta  = 2
+)
+
+// externalClient calls the embeddings API or QE model of quality
+// estimation and the moderation API
+var externalClient = &http.Client{Timeout: qualityTimeout}
+
+// qualitySample is a translation to score
+type qualitySample struct {
+	SourceLang  string `json:"source_lang"`
+	TargetLang  string `json:"target_lang"`
+	Source      string `json:"source"`
+	Translation string `json:"translation"`
+	Reference   string `json:"reference,omitempty"` // Approved translation of the source, when there is one
+
+	backTranslation string // Of verified requests
+}
+
+// redacted returns the sample with the personal data of its texts replaced
+// with tokens by r, the same value getting the same token in each
+func (sample qualitySample) redacted(r *piiRedactor) qualitySample {
+	var values []string
+	sample.Source, values = r.redact(sample.Source, values)
+	sample.Translation, values = r.redact(sample.Translation, values)
+	sample.Reference, _ = r.redact(sample.Reference, values)
+	return sample
+}
+
+// qualityEstimator scores a translation from 0, unusable, to 1, perfect.
+// Estimators return errNoQualityReference for samples they can't score.
+type qualityEstimator interface {
+	estimate(ctx context.Context, sample qualitySample) (float64, error)
+}
+
+// errNoQualityReference is returned by estimators needing a reference for
+// samples without one
+var errNoQualityReference = errors.New("no reference to score against")
+
+// newQualityEstimator returns the estimator QUALITY_ESTIMATOR names, or nil
+// when it is empty. External estimators are sent samples redacted by
+// redactor.
+func newQualityEstimator(config Config, redactor *piiRedactor) qualityEstimator {
+	switch config.QualityEstimator {
+	case "chrf":
+		return chrFEstimator{}
+	case "embedding":
+		return embeddingEstimator{endpoint: strings.TrimSuffix(config.QualityEstimationURL, "/"), apiKey: config.QualityEstimationAPIKey, model: config.QualityEstimationModel, redactor: redactor}
+	case "http":
+		return httpEstimator{url: config.QualityEstimationURL, apiKey: config.QualityEstimationAPIKey, redactor: redactor}
+	}
+	return nil
+}
+
+// chrFEstimator scores the chrF of translations against their reference,
+// or of the back-translation against the source for verified requests
+// without one
+type chrFEstimator struct{}
+
+// estimate implements qualityEstimator
+func (chrFEstimator) estimate(ctx context.Context, sample qualitySample) (float64, error) {
+	switch {
+	case sample.Reference != "":
+		return chrF(sample.Translation, sample.Reference), nil
+	case sample.backTranslation != "":
+		return chrF(sample.backTranslation, sample.Source), nil
+	}
+	return 0, errNoQualityReference
+}
+
+// chrF returns the character n-gram F-score of hypothesis against
+// reference, ignoring whitespace, from 0 to 1
+func chrF(hypothesis, reference string) float64 {
+	hyp := []rune(strings.Join(strings.Fields(hypothesis), ""))
+	ref := []rune(strings.Join(strings.Fields(reference), ""))
+	var precision, recall float64
+	orders := 0
+	for n := 1; n <= chrFOrder && n <= len(hyp) && n <= len(ref); n++ {
+		counts := make(map[string]int)
+		for i := 0; i+n <= len(ref); i++ {
+			counts[string(ref[i:i+n])]++
+		}
+		matches := 0
+		for i := 0; i+n <= len(hyp); i++ {
+			if gram := string(hyp[i : i+n]); counts[gram] > 0 {
+				counts[gram]--
+				matches++
+			}
+		}
+		precision += float64(matches) / float64(len(hyp)-n+1)
+		recall += float64(matches) / float64(len(ref)-n+1)
+		orders++
+	}
+	if orders == 0 {
+		if len(hyp) == len(ref) {
+			return 1
+		}
+		return 0
+	}
+	precision, recall = precision/float64(orders), recall/float64(orders)
+	if precision+recall == 0 {
+		return 0
+	}
+	beta2 := float64(chrFBeta * chrFBeta)
+	return (1 + beta2) * precision * recall / (beta2*precision + recall)
+}
+
+// embeddingEstimator scores the cosine similarity of the embeddings of the
+// source and translation, from an OpenAI-compatible embeddings API serving
+// a multilingual model
+type embeddingEstimator struct {
+	endpoint string // Base URL, without /embeddings
+	apiKey   string
+	model    string
+	redactor *piiRedactor
+}
+
+// estimate implements qualityEstimator
+func (e embeddingEstimator) estimate(ctx context.Context, sample qualitySample) (float64, error) {
+	sample = sample.redacted(e.redactor)
+	body, err := json.Marshal(map[string]interface{}{
+		"model": e.model,
+		"input": []string{sample.Source, sample.Translation},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := postJSON(ctx, e.endpoint+"/embeddings", e.apiKey, body, &result); err != nil {
+		return 0, err
+	}
+	if len(result.Data) != 2 || len(result.Data[0].Embedding) != len(result.Data[1].Embedding) {
+		return 0, errors.New("unexpected embeddings returned")
+	}
+	a, b := result.Data[0].Embedding, result.Data[1].Embedding
+	if result.Data[0].Index == 1 {
+		a, b = b, a
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0, errors.New("empty embedding returned")
+	}
+	// Unrelated texts can score below zero
+	return math.Max(0, dot/math.Sqrt(normA*normB)), nil
+}
+
+// httpEstimator scores translations with an external QE model, which is
+// POSTed the sample as JSON and answers with {"score": 0.87}
+type httpEstimator struct {
+	url      string
+	apiKey   string
+	redactor *piiRedactor
+}
+
+// estimate implements qualityEstimator
+func (e httpEstimator) estimate(ctx context.Context, sample qualitySample) (float64, error) {
+	sample = sample.redacted(e.redactor)
+	body, err := json.Marshal(sample)
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		Score *float64 `json:"score"`
+	}
+	if err := postJSON(ctx, e.url, e.apiKey, body, &result); err != nil {
+		return 0, err
+	}
+	if result.Score == nil || *result.Score < 0 || *result.Score > 1 {
+		return 0, errors.New("the model returned no score between 0 and 1")
+	}
+	return *result.Score, nil
+}
+
+// postJSON POSTs a JSON body to an external API at url, decoding the JSON
+// answer into result
+func postJSON(ctx context.Context, url, apiKey string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := externalClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return &provider.HTTPError{StatusCode: resp.StatusCode, Message: resp.Status}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+	return nil
+}
+
+// qualityJob is a history record waiting for its quality score
+type qualityJob struct {
+	record HistoryRecord
+	sample qualitySample
+}
+
+// startQualityEstimation starts the workers scoring translations for the
+// history
+func (s *Server) startQualityEstimation() {
+	s.qualityJobs = make(chan qualityJob, qualityQueueSize)
+	s.qualityStop = make(chan struct{})
+	for i := 0; i < s.config.QualityEstimationWorkers; i++ {
+		s.qualityWorkers.Add(1)
+		go s.scoreQuality()
+	}
+}
+
+// queueQualityEstimation queues a provider translation to be scored before
+// it is recorded, reporting false when it should be recorded unscored:
+// estimation is off, or the workers can't keep up
+func (s *Server) queueQualityEstimation(record HistoryRecord, response *TranslationResponse) bool {
+	if s.qualityEstimator == nil || response.CacheHit || response.Memory != nil || response.Skipped {
+		return false
+	}
+	job := qualityJob{record: record, sample: qualitySample{
+		SourceLang:  record.SourceLang,
+		TargetLang:  record.TargetLang,
+		Source:      record.SourceText,
+		Translation: record.TranslatedText,
+	}}
+	if response.Verification != nil {
+		job.sample.backTranslation = response.Verification.BackTranslation
+	}
+	select {
+	case s.qualityJobs <- job:
+		return true
+	default:
+		qualityEstimations.WithLabelValues("dropped").Inc()
+		return false
+	}
+}
+
+// scoreQuality scores queued translations and records them until
+// stopQualityEstimation is called, then records the ones still queued
+// unscored
+func (s *Server) scoreQuality() {
+	defer s.qualityWorkers.Done()
+	for {
+		select {
+		case job := <-s.qualityJobs:
+			s.estimateQuality(&job)
+			s.queueHistoryRecord(job.record)
+		case <-s.qualityStop:
+			for {
+				select {
+				case job := <-s.qualityJobs:
+					s.queueHistoryRecord(job.record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// estimateQuality sets the quality score of a job's record, looking up the
+// approved translation of its text as the reference. Records the estimator
+// fails to score are recorded without one.
+func (s *Server) estimateQuality(job *qualityJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), qualityTimeout)
+	defer cancel()
+	if s.redisAvailable() {
+		if entry, err := s.getTMEntry(ctx, job.sample.SourceLang, job.sample.TargetLang, job.sample.Source); err == nil {
+			job.sample.Reference = entry.TargetText
+		}
+	}
+	score, err := s.qualityEstimator.estimate(ctx, job.sample)
+	switch {
+	case errors.Is(err, errNoQualityReference):
+		qualityEstimations.WithLabelValues("unscored").Inc()
+		return
+	case err != nil:
+		qualityEstimations.WithLabelValues("failed").Inc()
+		logger(ctx).Warn("failed to estimate translation quality", "estimator", s.config.QualityEstimator, "error", err)
+		return
+	}
+	score = math.Round(score*1000) / 1000
+	job.record.QualityScore = &score
+	qualityEstimations.WithLabelValues("scored").Inc()
+	qualityScores.Observe(score)
+
+	if threshold := s.currentConfig().ReviewMaxQuality; threshold > 0 && score <= threshold {
+		r := job.record
+		s.queueReview(ctx, Review{
+			CreatedAt:      time.Now().UTC(),
+			Reasons:        []string{reviewReasonLowQuality},
+			KeyName:        r.KeyName,
+			SourceLang:     r.SourceLang,
+			TargetLang:     r.TargetLang,
+			SourceText:     r.SourceText,
+			TranslatedText: r.TranslatedText,
+			Provider:       r.Provider,
+			QualityScore:   &score,
+		})
+	}
+}
+
+// stopQualityEstimation records the translations still queued for scoring,
+// giving up when ctx expires
+func (s *Server) stopQualityEstimation(ctx context.Context) {
+	if s.qualityStop == nil {
+		return
+	}
+	close(s.qualityStop)
+	done := make(chan struct{})
+	go func() {
+		s.qualityWorkers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
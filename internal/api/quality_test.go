@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestQualityEstimatorsRedactPII(t *testing.T) {
+	sample := qualitySample{
+		SourceLang:  "en",
+		TargetLang:  "de",
+		Source:      "Write to jane@example.com",
+		Translation: "Schreiben Sie an jane@example.com",
+		Reference:   "Schreib an jane@example.com",
+	}
+	tests := []struct {
+		estimator string
+		answer    string
+		want      float64
+		wantTexts []string // Sent to the API
+	}{
+		{
+			"embedding",
+			`{"data": [{"index": 0, "embedding": [1, 0]}, {"index": 1, "embedding": [1, 0]}]}`,
+			1,
+			[]string{"Write to __PII_0__", "Schreiben Sie an __PII_0__"},
+		},
+		{
+			"http",
+			`{"score": 0.5}`,
+			0.5,
+			[]string{"Write to __PII_0__", "Schreiben Sie an __PII_0__", "Schreib an __PII_0__"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.estimator, func(t *testing.T) {
+			var texts []string
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Input       []string `json:"input"`
+					Source      string   `json:"source"`
+					Translation string   `json:"translation"`
+					Reference   string   `json:"reference"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+				texts = body.Input
+				if body.Source != "" {
+					texts = []string{body.Source, body.Translation, body.Reference}
+				}
+				w.Write([]byte(tt.answer))
+			}))
+			defer api.Close()
+
+			redactor, err := newPIIRedactor(Config{PIIRedaction: []string{"email"}})
+			if err != nil {
+				t.Fatalf("newPIIRedactor() error = %v", err)
+			}
+			e := newQualityEstimator(Config{QualityEstimator: tt.estimator, QualityEstimationURL: api.URL}, redactor)
+			score, err := e.estimate(context.Background(), sample)
+			if err != nil {
+				t.Fatalf("estimate() error = %v", err)
+			}
+			if score != tt.want {
+				t.Errorf("estimate() = %v, want %v", score, tt.want)
+			}
+			if !reflect.DeepEqual(texts, tt.wantTexts) {
+				t.Errorf("API got %q, want %q", texts, tt.wantTexts)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/provider"
+	"github.com/dphase/ss-translate/translatepb"
+)
+
+// queueFailure is why the message of a worker mode failed to translate
+type queueFailure struct {
+	*ErrorResponse
+	// Whether the message may translate when retried, as a provider or the
+	// service failed rather than the request
+	transient bool
+	// How long a rate-limited message waits before it is retried, which
+	// isn't counted as a failed attempt
+	retryAfter time.Duration
+}
+
+// newQueueFailure returns the failure of the error response written to w
+func newQueueFailure(w *messageWriter) *queueFailure {
+	failure := &queueFailure{ErrorResponse: w.errorResponse()}
+	switch failure.Code {
+	case codeRateLimited:
+		seconds, _ := strconv.Atoi(w.header.Get("Retry-After"))
+		failure.retryAfter = time.Duration(max(seconds, 1)) * time.Second
+	case codeProviderError, codeProviderUnavailable, codeServiceUnavailable, codeInternalError:
+		failure.transient = true
+	}
+	return failure
+}
+
+// translateQueued decodes and translates the message of a worker mode, a
+// TranslationRequest encoded as mt, accounting it to keyName like an API
+// request of the key, and returns the encoded TranslationResponse
+func (s *Server) translateQueued(ctx context.Context, keyName, mt string, value []byte) ([]byte, *queueFailure) {
+	var req TranslationRequest
+	body, err := bodyJSON(mt, value, &translatepb.TranslationRequest{})
+	if err == nil {
+		err = json.NewDecoder(bytes.NewReader(body)).Decode(&req)
+	}
+	if err != nil {
+		return nil, &queueFailure{ErrorResponse: &ErrorResponse{Code: codeInvalidRequest, Message: fmt.Sprintf("Invalid request: %v", err)}}
+	}
+
+	w := &messageWriter{header: http.Header{}}
+	response, ok := s.translateStreamRequest(s.withAPIKeyName(ctx, keyName, ""), w, req)
+	if !ok {
+		return nil, newQueueFailure(w)
+	}
+	encoded, err := encodeBody(mt, response)
+	if err != nil {
+		return nil, &queueFailure{ErrorResponse: &ErrorResponse{Code: codeInternalError, Message: fmt.Sprintf("Failed to encode response: %v", err)}}
+	}
+	return encoded, nil
+}
+
+// retryQueued translates a message like translateQueued, retrying transient
+// failures with the backoff of policy, up to its attempts, and waiting as
+// long as rate limits ask without using them up. It returns false when ctx
+// was done before the message translated or failed for good.
+func (s *Server) retryQueued(ctx, storeCtx context.Context, log *slog.Logger, keyName, mt string, value []byte, policy provider.RetryPolicy) ([]byte, *queueFailure, bool) {
+	for attempt := 1; ; {
+		encoded, failure := s.translateQueued(storeCtx, keyName, mt, value)
+		if failure == nil {
+			return encoded, nil, true
+		}
+		delay := failure.retryAfter
+		if delay == 0 {
+			if !failure.transient || attempt >= policy.MaxAttempts {
+				return nil, failure, true
+			}
+			delay = policy.Backoff(attempt)
+			attempt++
+		}
+		log.Warn("message failed, retrying", "code", failure.Code, "backoff", delay.String(), "error", failure.Message)
+		select {
+		case <-ctx.Done():
+			return nil, failure, false
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// quotaKeyTTL keeps a day's counter around a little past its rollover
+const quotaKeyTTL = 48 * time.Hour
+
+// QuotaExceededDetails are the details of QUOTA_EXCEEDED errors, returned
+// when a key's daily character quota is used up
+type QuotaExceededDetails struct {
+	Quota    int64     `json:"quota"`
+	Used     int64     `json:"used"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// quotaKey returns the Redis counter key for a scope's usage on the UTC day
+// containing t
+func quotaKey(scope string, t time.Time) string {
+	return fmt.Sprintf("quota:chars:%s:%s", scope, t.UTC().Format("20060102"))
+}
+
+// nextQuotaReset returns the start of the next UTC day after t
+func nextQuotaReset(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// quotaReservation holds the characters checkQuota reserved against the
+// quota for a request, until recordQuotaUsage charges them or the request
+// ends and the rest are released
+type quotaReservation struct {
+	mu       sync.Mutex
+	key      string // The counter they are reserved on
+	reserved int64
+}
+
+// quotaReservationKey is the context key of a request's quotaReservation
+type quotaReservationKey struct{}
+
+// withQuotaReservation returns a context the quota checks of a request
+// reserve characters under, and the function releasing those that weren't
+// charged, as when the translation failed, once the request is served
+func (s *Server) withQuotaReservation(ctx context.Context) (context.Context, func()) {
+	reservation := &quotaReservation{}
+	return context.WithValue(ctx, quotaReservationKey{}, reservation), func() {
+		reservation.mu.Lock()
+		key, reserved := reservation.key, reservation.reserved
+		reservation.reserved = 0
+		reservation.mu.Unlock()
+		if reserved == 0 || s.redis == nil {
+			return
+		}
+		if err := s.redis.DecrBy(context.WithoutCancel(ctx), key, reserved).Err(); err != nil {
+			logger(ctx).Warn("failed to release reserved quota", "characters", reserved, "error", err)
+		}
+	}
+}
+
+// releaseQuotaReservations gives every request a quota reservation,
+// released once it is served
+func (s *Server) releaseQuotaReservations(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, release := s.withQuotaReservation(r.Context())
+		defer release()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// checkQuota writes a quota exceeded response and returns false when serving
+// chars more characters would take the caller, or its tenant, over its daily
+// quota. Otherwise the characters are reserved, so concurrent requests
+// can't all pass the check, until recordQuotaUsage charges them or the
+// request ends. The check fails open when Redis is unavailable or in
+// degraded mode.
+func (s *Server) checkQuota(ctx context.Context, w http.ResponseWriter, chars int) bool {
+	quota := s.scopeDailyQuota(ctx)
+	if quota <= 0 || !s.redisAvailable() {
+		return true
+	}
+
+	now := time.Now()
+	key := quotaKey(scopeName(ctx), now)
+	reservation, _ := ctx.Value(quotaReservationKey{}).(*quotaReservation)
+	var used int64
+	if reservation == nil {
+		var err error
+		used, err = s.redis.Get(ctx, key).Int64()
+		if err != nil && err != redis.Nil {
+			logger(ctx).Error("quota check failed, allowing request", "error", err)
+			return true
+		}
+		if used+int64(chars) <= quota {
+			return true
+		}
+	} else {
+		pipe := s.redis.TxPipeline()
+		incr := pipe.IncrBy(ctx, key, int64(chars))
+		pipe.Expire(ctx, key, quotaKeyTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			logger(ctx).Error("quota check failed, allowing request", "error", err)
+			return true
+		}
+		used = incr.Val() - int64(chars)
+		if incr.Val() <= quota {
+			reservation.mu.Lock()
+			reservation.key = key
+			reservation.reserved += int64(chars)
+			reservation.mu.Unlock()
+			return true
+		}
+		if err := s.redis.DecrBy(context.WithoutCancel(ctx), key, int64(chars)).Err(); err != nil {
+			logger(ctx).Warn("failed to release reserved quota", "characters", chars, "error", err)
+		}
+	}
+
+	logger(ctx).Warn("daily character quota exceeded", "used", used, "quota", quota)
+	writeErrorDetails(w, http.StatusTooManyRequests, codeQuotaExceeded, fmt.Sprintf("Daily quota of %d characters exceeded", quota), QuotaExceededDetails{
+		Quota:    quota,
+		Used:     used,
+		ResetsAt: nextQuotaReset(now),
+	})
+	return false
+}
+
+// recordQuotaUsage adds chars to the caller's, or its tenant's, usage for
+// today, charging the characters reserved for the request first
+func (s *Server) recordQuotaUsage(ctx context.Context, chars int) {
+	if s.scopeDailyQuota(ctx) <= 0 || !s.redisAvailable() {
+		return
+	}
+
+	charge := int64(chars)
+	if reservation, ok := ctx.Value(quotaReservationKey{}).(*quotaReservation); ok {
+		reservation.mu.Lock()
+		reserved := min(charge, reservation.reserved)
+		reservation.reserved -= reserved
+		reservation.mu.Unlock()
+		charge -= reserved
+	}
+	if charge == 0 {
+		return
+	}
+	key := quotaKey(scopeName(ctx), time.Now())
+	pipe := s.redis.TxPipeline()
+	pipe.IncrBy(ctx, key, charge)
+	pipe.Expire(ctx, key, quotaKeyTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger(ctx).Warn("failed to record quota usage", "error", err)
+	}
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// quotaContext returns the context of a request of the key web, with a
+// quota reservation when reserve is set, and the function releasing it
+func quotaContext(s *Server, reserve bool) (context.Context, func()) {
+	ctx := s.withAPIKeyName(context.Background(), "web", "")
+	if !reserve {
+		return ctx, func() {}
+	}
+	return s.withQuotaReservation(ctx)
+}
+
+func TestCheckQuota(t *testing.T) {
+	tests := []struct {
+		name     string
+		quota    int64
+		used     int64
+		chars    int
+		reserve  bool
+		want     bool
+		wantUsed int64 // Of the counter once checked
+	}{
+		{name: "no quota", chars: 1000, want: true},
+		{name: "within the quota", quota: 100, used: 40, chars: 60, want: true, wantUsed: 40},
+		{name: "over the quota", quota: 100, used: 50, chars: 60, wantUsed: 50},
+		{name: "reserved within the quota", quota: 100, used: 40, chars: 60, reserve: true, want: true, wantUsed: 100},
+		{name: "reserved over the quota", quota: 100, used: 50, chars: 60, reserve: true, wantUsed: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mr := newTestServer(t, &fakeProvider{}, func(c *Config) { c.DailyCharQuota = tt.quota })
+			key := quotaKey("web", time.Now())
+			if tt.used > 0 {
+				mr.Set(key, strconv.FormatInt(tt.used, 10))
+			}
+			ctx, release := quotaContext(s, tt.reserve)
+			defer release()
+
+			w := httptest.NewRecorder()
+			if got := s.checkQuota(ctx, w, tt.chars); got != tt.want {
+				t.Fatalf("checkQuota() = %v, want %v", got, tt.want)
+			}
+			if used := counter(t, mr, key); used != tt.wantUsed {
+				t.Errorf("used = %d, want %d", used, tt.wantUsed)
+			}
+			if tt.want {
+				return
+			}
+
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want 429", w.Code)
+			}
+			var body struct {
+				Code    string               `json:"code"`
+				Details QuotaExceededDetails `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid body %q: %v", w.Body, err)
+			}
+			if body.Code != codeQuotaExceeded || body.Details.Quota != tt.quota || body.Details.Used != tt.used {
+				t.Errorf("body = %+v, want quota %d used %d", body, tt.quota, tt.used)
+			}
+			if !body.Details.ResetsAt.After(time.Now()) {
+				t.Errorf("resets_at = %v, want it in the future", body.Details.ResetsAt)
+			}
+		})
+	}
+}
+
+func TestQuotaReservation(t *testing.T) {
+	tests := []struct {
+		name     string
+		reserved int
+		charged  int // By recordQuotaUsage
+		wantUsed int64
+	}{
+		{"charged as reserved", 10, 10, 10},
+		{"refunded when nothing is charged", 10, 0, 0},
+		{"partly charged", 10, 4, 4},
+		{"charged more than reserved", 10, 15, 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mr := newTestServer(t, &fakeProvider{}, func(c *Config) { c.DailyCharQuota = 100 })
+			key := quotaKey("web", time.Now())
+			ctx, release := quotaContext(s, true)
+
+			if !s.checkQuota(ctx, httptest.NewRecorder(), tt.reserved) {
+				t.Fatal("checkQuota() = false")
+			}
+			if tt.charged > 0 {
+				s.recordQuotaUsage(ctx, tt.charged)
+			}
+			release()
+			if used := counter(t, mr, key); used != tt.wantUsed {
+				t.Errorf("used = %d, want %d", used, tt.wantUsed)
+			}
+			// Releasing again changes nothing
+			release()
+			if used := counter(t, mr, key); used != tt.wantUsed {
+				t.Errorf("used after releasing twice = %d, want %d", used, tt.wantUsed)
+			}
+		})
+	}
+}
+
+func TestQuotaConcurrentRequests(t *testing.T) {
+	s, mr := newTestServer(t, &fakeProvider{}, func(c *Config) { c.DailyCharQuota = 100 })
+	const requests, chars = 20, 30
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, release := quotaContext(s, true)
+			defer release()
+			if !s.checkQuota(ctx, httptest.NewRecorder(), chars) {
+				return
+			}
+			mu.Lock()
+			allowed++
+			mu.Unlock()
+			s.recordQuotaUsage(ctx, chars)
+		}()
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Errorf("%d requests allowed, want 3", allowed)
+	}
+	if used := counter(t, mr, quotaKey("web", time.Now())); used != 90 {
+		t.Errorf("used = %d, want 90", used)
+	}
+}
+
+func TestQuotaUnreserved(t *testing.T) {
+	s, mr := newTestServer(t, &fakeProvider{}, func(c *Config) { c.DailyCharQuota = 100 })
+	ctx, _ := quotaContext(s, false)
+	s.recordQuotaUsage(ctx, 30)
+	s.recordQuotaUsage(ctx, 30)
+	key := quotaKey("web", time.Now())
+	if used := counter(t, mr, key); used != 60 {
+		t.Errorf("used = %d, want 60", used)
+	}
+	if ttl := mr.TTL(key); ttl <= 0 || ttl > quotaKeyTTL {
+		t.Errorf("TTL = %v, want up to %v", ttl, quotaKeyTTL)
+	}
+}
+
+func TestQuotaTenant(t *testing.T) {
+	s, mr := newTestServer(t, &fakeProvider{}, func(c *Config) { c.DailyCharQuota = 1000 })
+	quota := int64(10)
+	data, _ := json.Marshal(Tenant{ID: "shop", DailyCharQuota: &quota})
+	mr.Set(tenantRecordPrefix+"shop", string(data))
+
+	ctx, release := s.withQuotaReservation(s.withAPIKeyName(context.Background(), "web", "shop"))
+	defer release()
+	if s.checkQuota(ctx, httptest.NewRecorder(), 11) {
+		t.Error("checkQuota() = true over the tenant's quota")
+	}
+	if !s.checkQuota(ctx, httptest.NewRecorder(), 10) {
+		t.Fatal("checkQuota() = false within the tenant's quota")
+	}
+	if used := counter(t, mr, quotaKey(tenantScopePrefix+"shop", time.Now())); used != 10 {
+		t.Errorf("tenant used = %d, want 10", used)
+	}
+}
+
+func TestQuotaDegraded(t *testing.T) {
+	s, mr := newTestServer(t, &fakeProvider{}, func(c *Config) { c.DailyCharQuota = 10 })
+	s.redisUp.Store(false)
+	ctx, release := quotaContext(s, true)
+	defer release()
+	if !s.checkQuota(ctx, httptest.NewRecorder(), 100) {
+		t.Error("checkQuota() = false while Redis is unavailable")
+	}
+	s.recordQuotaUsage(ctx, 100)
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("keys = %q, want none", keys)
+	}
+}
+
+func TestQuotaTranslateRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		used       int64
+		wantStatus int
+		wantUsed   int64
+	}{
+		{"charged", nil, 0, http.StatusOK, 11},
+		{"refunded when the provider fails", errors.New("unavailable"), 0, http.StatusInternalServerError, 0},
+		{"over the quota", nil, 90, http.StatusTooManyRequests, 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeProvider{err: tt.err}
+			s, mr := newTestServer(t, fake, func(c *Config) {
+				c.DailyCharQuota = 100
+				c.APIKeys = parseAPIKeys("web:k1")
+			})
+			key := quotaKey("web", time.Now())
+			if tt.used > 0 {
+				mr.Set(key, strconv.FormatInt(tt.used, 10))
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/translate", strings.NewReader(`{"text": "Hello world", "source_lang": "en", "target_lang": "de"}`))
+			r.Header.Set("Authorization", "Bearer k1")
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if used := counter(t, mr, key); used != tt.wantUsed {
+				t.Errorf("used = %d, want %d", used, tt.wantUsed)
+			}
+		})
+	}
+}
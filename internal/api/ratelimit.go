@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript atomically refills token buckets stored as Redis hashes
+// and draws from all of them, or from none when any is short. It returns
+// {allowed, retry_after_ms}, the wait until every bucket has enough.
+//
+// KEYS    bucket keys
+// ARGV[1] current time in milliseconds
+// ARGV[2] onwards, the refill rate in tokens per second, capacity and
+// tokens requested of each bucket in turn
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+
+local buckets = {}
+local allowed = 1
+local retry = 0
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i * 3 - 1])
+	local capacity = tonumber(ARGV[i * 3])
+	local cost = tonumber(ARGV[i * 3 + 1])
+
+	local bucket = redis.call("HMGET", key, "tokens", "ts")
+	local tokens = tonumber(bucket[1]) or capacity
+	local ts = tonumber(bucket[2]) or now
+	tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate / 1000)
+
+	if tokens < cost then
+		allowed = 0
+		retry = math.max(retry, math.ceil((cost - tokens) * 1000 / rate))
+	end
+	buckets[i] = {tokens = tokens, rate = rate, capacity = capacity, cost = cost}
+end
+
+for i, key in ipairs(KEYS) do
+	local b = buckets[i]
+	local tokens = b.tokens
+	if allowed == 1 then
+		tokens = tokens - b.cost
+	end
+	redis.call("HSET", key, "tokens", tokens, "ts", now)
+	redis.call("PEXPIRE", key, math.ceil(b.capacity * 1000 / b.rate) + 1000)
+end
+return {allowed, retry}
+`)
+
+// tokenBucket is a bucket of a rate limit and the tokens a request draws
+// from it
+type tokenBucket struct {
+	key      string
+	rate     float64 // Tokens refilled per second
+	capacity float64
+	cost     float64
+}
+
+// takeTokens draws the cost of every bucket from it, or nothing when any
+// is short, returning false and how long until all have enough
+func (s *Server) takeTokens(ctx context.Context, buckets ...tokenBucket) (bool, time.Duration, error) {
+	keys := make([]string, len(buckets))
+	args := []interface{}{time.Now().UnixMilli()}
+	for i, b := range buckets {
+		keys[i] = b.key
+		args = append(args, b.rate, b.capacity, b.cost)
+	}
+	res, err := tokenBucketScript.Run(ctx, s.redis, keys, args...).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+// checkRateLimit applies the caller's request and character limits, shared
+// by the keys of a tenant. It returns false and the time to wait when a
+// limit is exceeded, drawing from neither. Rate limiting fails open when
+// Redis is unavailable or in degraded mode.
+func (s *Server) checkRateLimit(ctx context.Context, chars int) (bool, time.Duration) {
+	if !s.redisAvailable() {
+		return true, 0
+	}
+
+	limits, scope := s.scopeRateLimits(ctx), scopeName(ctx)
+	var buckets []tokenBucket
+	if limits.rps > 0 {
+		buckets = append(buckets, tokenBucket{key: "ratelimit:req:" + scope, rate: limits.rps, capacity: limits.burst, cost: 1})
+	}
+	if limits.charsPerMin > 0 {
+		perMin := float64(limits.charsPerMin)
+		buckets = append(buckets, tokenBucket{key: "ratelimit:chars:" + scope, rate: perMin / 60, capacity: perMin, cost: float64(chars)})
+	}
+	if len(buckets) == 0 {
+		return true, 0
+	}
+	ok, retry, err := s.takeTokens(ctx, buckets...)
+	if err != nil {
+		logger(ctx).Error("rate limiter failed, allowing request", "error", err)
+		return true, 0
+	}
+	return ok, retry
+}
+
+// retryAfterSeconds rounds a delay up to the whole seconds of a Retry-After
+// header, at least one
+func retryAfterSeconds(retry time.Duration) int {
+	seconds := int(math.Ceil(retry.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// writeRateLimited writes a 429 response with a Retry-After header rounded up
+// to whole seconds
+func writeRateLimited(w http.ResponseWriter, retry time.Duration) {
+	seconds := retryAfterSeconds(retry)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeErrorDetails(w, http.StatusTooManyRequests, codeRateLimited, fmt.Sprintf("Rate limit exceeded, retry in %d seconds", seconds),
+		map[string]int{"retry_after_seconds": seconds})
+}
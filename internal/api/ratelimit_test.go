@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckRateLimit(t *testing.T) {
+	type call struct {
+		chars int
+		want  bool
+	}
+	tests := []struct {
+		name        string
+		rps         float64
+		burst       int
+		charsPerMin int
+		calls       []call
+	}{
+		{"no limits", 0, 0, 0, []call{{1000, true}, {1000, true}}},
+		{"requests", 1, 2, 0, []call{{10, true}, {10, true}, {10, false}}},
+		{"characters", 0, 0, 100, []call{{60, true}, {40, true}, {1, false}}},
+		// The request rejected for its characters leaves its request token,
+		// so the third gets through
+		{"short of characters, not requests", 1, 2, 100, []call{{80, true}, {80, false}, {10, true}, {10, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) {
+				c.RateLimitRPS = tt.rps
+				c.RateLimitBurst = tt.burst
+				c.RateLimitCharsPerMin = tt.charsPerMin
+			})
+			ctx := s.withAPIKeyName(context.Background(), "web", "")
+			for i, c := range tt.calls {
+				ok, retry := s.checkRateLimit(ctx, c.chars)
+				if ok != c.want {
+					t.Fatalf("call %d: checkRateLimit(%d) = %v, want %v", i, c.chars, ok, c.want)
+				}
+				if !ok && (retry <= 0 || retry > time.Minute) {
+					t.Errorf("call %d: retry = %v, want up to a minute", i, retry)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorizeTranslationOverCharacterLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		chars      int
+		wantStatus int
+	}{
+		{"within", 100, http.StatusOK},
+		{"over a minute's", 101, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) {
+				c.RateLimitCharsPerMin = 100
+			})
+			ctx := s.withAPIKeyName(context.Background(), "web", "")
+			w := httptest.NewRecorder()
+			s.authorizeTranslation(ctx, w, tt.chars)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
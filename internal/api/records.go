@@ -0,0 +1,383 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Formats of the record files of file jobs and POST /translate/file
+const (
+	recordsCSV   = "csv"
+	recordsJSONL = "jsonl"
+	recordsXLIFF = "xliff"
+)
+
+// recordFormats are the extensions and content types of the record file
+// formats, by name
+var recordFormats = map[string]struct {
+	extensions  []string
+	contentType string
+}{
+	recordsCSV:   {extensions: []string{".csv"}, contentType: "text/csv"},
+	recordsJSONL: {extensions: []string{".jsonl", ".ndjson"}, contentType: "application/x-ndjson"},
+	recordsXLIFF: {extensions: []string{".xlf", ".xliff"}, contentType: "application/xliff+xml"},
+}
+
+// recordFormatOf returns the record format of a file name's extension, or
+// "" for other extensions
+func recordFormatOf(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	for format, f := range recordFormats {
+		for _, e := range f.extensions {
+			if e == ext {
+				return format
+			}
+		}
+	}
+	return ""
+}
+
+// defaultRecordFields are the fields translated when none are given
+var defaultRecordFields = []string{"text"}
+
+// recordField is a field of the records to translate, and the one its
+// translation is written to: itself, or another given as source:target
+type recordField struct {
+	source string
+	target string
+}
+
+// parseRecordFields parses fields given as source or source:target.
+// Translations may not be written twice to the same field, nor to one
+// that is translated itself.
+func parseRecordFields(fields []string) ([]recordField, error) {
+	parsed := make([]recordField, len(fields))
+	sources := make(map[string]bool, len(fields))
+	for i, field := range fields {
+		source, target, ok := strings.Cut(field, ":")
+		if !ok {
+			target = source
+		}
+		if source == "" || target == "" {
+			return nil, errors.New("fields must not be empty")
+		}
+		if sources[source] {
+			return nil, fmt.Errorf("field %q is given twice", source)
+		}
+		sources[source] = true
+		parsed[i] = recordField{source: source, target: target}
+	}
+	targets := make(map[string]bool, len(parsed))
+	for _, field := range parsed {
+		if targets[field.target] || field.target != field.source && sources[field.target] {
+			return nil, fmt.Errorf("fields write to %q twice", field.target)
+		}
+		targets[field.target] = true
+	}
+	return parsed, nil
+}
+
+// fileRecord is a record of a record file: a CSV row, a JSONL line or an
+// XLIFF unit
+type fileRecord struct {
+	texts []string                       // Of the translated fields, in order; empty for those missing
+	set   func(i int, translated string) // Replaces the text of field i
+	err   error                          // Why the record couldn't be read; it is written back as it was
+	value interface{}                    // The record, as the codec reads and writes it
+}
+
+// recordCodec reads the records of a file and writes them, translated, to
+// the output file
+type recordCodec interface {
+	// next returns the next record, or io.EOF after the last
+	next() (*fileRecord, error)
+	// write writes a record to the output, in the order they were read,
+	// with the error that kept it from being translated, if any
+	write(rec *fileRecord, err error) error
+	// close writes the rest of the output, translated from sourceLang,
+	// which may be empty when unknown, to targetLang
+	close(sourceLang, targetLang string) error
+}
+
+// newRecordCodec starts reading a record file from r, translating its
+// fields into w. CSV and JSONL records are written with their error in
+// errorField, unless it is empty. XLIFF files are read whole, up to
+// maxFileBytes; records of the other formats are streamed, their lines up
+// to maxRecordBytes.
+func newRecordCodec(format string, fields []recordField, errorField string, r io.Reader, w io.Writer, maxFileBytes, maxRecordBytes int64) (recordCodec, error) {
+	switch format {
+	case recordsCSV:
+		return newCSVCodec(fields, errorField, r, w)
+	case recordsJSONL:
+		return newJSONLCodec(fields, errorField, r, w, maxRecordBytes), nil
+	case recordsXLIFF:
+		return newXLIFFCodec(r, w, maxFileBytes)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// csvCodec reads CSV files with a header row, whose columns the fields
+// name. Rows are written back with every column, translated in place or
+// into the target columns, which are added after the others when missing.
+type csvCodec struct {
+	r       *csv.Reader
+	w       *csv.Writer
+	columns []int // Of the fields, in order
+	targets []int // Columns their translations are written to
+	errors  int   // The column of record errors, or -1
+	width   int   // Rows are padded to when columns were added
+}
+
+// newCSVCodec reads the header of a CSV file and writes it to the output,
+// with the columns it adds
+func newCSVCodec(fields []recordField, errorField string, r io.Reader, w io.Writer) (*csvCodec, error) {
+	c := &csvCodec{r: csv.NewReader(r), w: csv.NewWriter(w), errors: -1}
+	// Rows missing trailing columns are taken as empty there
+	c.r.FieldsPerRecord = -1
+	header, err := c.r.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], utf8BOM)
+	}
+	columnOf := func(name string) int {
+		for i, column := range header {
+			if column == name {
+				return i
+			}
+		}
+		return -1
+	}
+	width := len(header)
+	add := func(name string) int {
+		if column := columnOf(name); column >= 0 {
+			return column
+		}
+		header = append(header, name)
+		return len(header) - 1
+	}
+	for _, field := range fields {
+		column := columnOf(field.source)
+		if column < 0 {
+			return nil, fmt.Errorf("no %q column", field.source)
+		}
+		c.columns = append(c.columns, column)
+		c.targets = append(c.targets, add(field.target))
+	}
+	if errorField != "" {
+		c.errors = add(errorField)
+	}
+	if len(header) > width {
+		c.width = len(header)
+	}
+	if err := c.w.Write(header); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// next implements recordCodec
+func (c *csvCodec) next() (*fileRecord, error) {
+	row, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	rec := &fileRecord{texts: make([]string, len(c.columns)), value: row}
+	for i, column := range c.columns {
+		if column < len(row) {
+			rec.texts[i] = row[column]
+		}
+	}
+	rec.set = func(i int, translated string) {
+		rec.value = setColumn(rec.value.([]string), c.targets[i], translated)
+	}
+	return rec, nil
+}
+
+// write implements recordCodec
+func (c *csvCodec) write(rec *fileRecord, err error) error {
+	row := rec.value.([]string)
+	if c.errors >= 0 {
+		message := ""
+		if err != nil {
+			message = err.Error()
+		}
+		row = setColumn(row, c.errors, message)
+	}
+	if len(row) < c.width {
+		row = append(row, make([]string, c.width-len(row))...)
+	}
+	return c.w.Write(row)
+}
+
+// setColumn sets a column of a row, which may be missing trailing columns
+func setColumn(row []string, column int, value string) []string {
+	if column >= len(row) {
+		row = append(row, make([]string, column+1-len(row))...)
+	}
+	row[column] = value
+	return row
+}
+
+// close implements recordCodec
+func (c *csvCodec) close(sourceLang, targetLang string) error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlCodec reads JSON Lines files, whose objects' string values the
+// fields name by dotted path, such as title or body.text. Lines are written
+// back with their members in order, translated in place or into the target
+// members, which are added to the top level when missing; lines that aren't
+// JSON objects are written back as they were.
+type jsonlCodec struct {
+	scanner    *bufio.Scanner
+	w          *bufio.Writer
+	fields     []recordField
+	index      map[string]int // Of each field, by source
+	errorField string
+}
+
+// newJSONLCodec starts reading a JSON Lines file
+func newJSONLCodec(fields []recordField, errorField string, r io.Reader, w io.Writer, maxRecordBytes int64) *jsonlCodec {
+	c := &jsonlCodec{scanner: bufio.NewScanner(r), w: bufio.NewWriter(w), fields: fields, index: make(map[string]int, len(fields)), errorField: errorField}
+	c.scanner.Buffer(nil, int(maxRecordBytes))
+	for i, field := range fields {
+		c.index[field.source] = i
+	}
+	return c
+}
+
+// next implements recordCodec
+func (c *jsonlCodec) next() (*fileRecord, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	line := bytes.Clone(c.scanner.Bytes())
+	rec := &fileRecord{texts: make([]string, len(c.fields)), value: line}
+	if len(bytes.TrimSpace(line)) == 0 {
+		return rec, nil
+	}
+	root, err := parseJSONDocument(bytes.TrimPrefix(line, []byte(utf8BOM)))
+	if err == nil && root.kind != '{' {
+		err = errors.New("not an object")
+	}
+	if err != nil {
+		rec.err = fmt.Errorf("invalid JSON: %v", err)
+		return rec, nil
+	}
+	rec.value = root
+	units := make([]resourceUnit, len(c.fields))
+	for _, unit := range (&jsonResource{root: root}).units() {
+		if i, ok := c.index[unit.key]; ok {
+			units[i] = unit
+			rec.texts[i] = unit.text
+		}
+	}
+	rec.set = func(i int, translated string) {
+		if c.fields[i].target == c.fields[i].source {
+			units[i].set(translated)
+		} else {
+			setJSONMember(root, c.fields[i].target, translated)
+		}
+	}
+	return rec, nil
+}
+
+// write implements recordCodec
+func (c *jsonlCodec) write(rec *fileRecord, err error) error {
+	switch value := rec.value.(type) {
+	case *jsonNode:
+		if c.errorField != "" && err != nil {
+			setJSONMember(value, c.errorField, err.Error())
+		}
+		var buf bytes.Buffer
+		if err := value.encode(&buf); err != nil {
+			return err
+		}
+		c.w.Write(buf.Bytes())
+	case []byte:
+		c.w.Write(value)
+	}
+	return c.w.WriteByte('\n')
+}
+
+// close implements recordCodec
+func (c *jsonlCodec) close(sourceLang, targetLang string) error {
+	return c.w.Flush()
+}
+
+// setJSONMember sets a member of an object to a string, adding it after
+// the others when missing
+func setJSONMember(object *jsonNode, key, value string) {
+	for _, m := range object.members {
+		if m.key == key {
+			*m.node = jsonNode{value: value}
+			return
+		}
+	}
+	object.members = append(object.members, jsonMember{key: key, node: &jsonNode{value: value}})
+}
+
+// xliffCodec reads XLIFF files, whose units are the records; they have a
+// single field, their source. The file is written with the translations as
+// targets once every unit is translated.
+type xliffCodec struct {
+	file  resourceFile
+	units []resourceUnit
+	w     io.Writer
+}
+
+// newXLIFFCodec reads and parses an XLIFF file of up to maxFileBytes
+func newXLIFFCodec(r io.Reader, w io.Writer, maxFileBytes int64) (*xliffCodec, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxFileBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxFileBytes {
+		return nil, fmt.Errorf("file exceeds %d bytes", maxFileBytes)
+	}
+	file, err := parseXLIFFResource(data)
+	if err != nil {
+		return nil, err
+	}
+	return &xliffCodec{file: file, units: file.units(), w: w}, nil
+}
+
+// next implements recordCodec
+func (c *xliffCodec) next() (*fileRecord, error) {
+	if len(c.units) == 0 {
+		return nil, io.EOF
+	}
+	unit := c.units[0]
+	c.units = c.units[1:]
+	return &fileRecord{texts: []string{unit.text}, set: func(_ int, translated string) { unit.set(translated) }}, nil
+}
+
+// write implements recordCodec
+func (c *xliffCodec) write(rec *fileRecord, err error) error {
+	return nil
+}
+
+// close implements recordCodec
+func (c *xliffCodec) close(sourceLang, targetLang string) error {
+	out, err := c.file.encode(sourceLang, targetLang)
+	if err != nil {
+		return err
+	}
+	_, err = c.w.Write(out)
+	return err
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// piiMask masks redacted personal data
+var piiMask = newTokenMask("PII")
+
+// piiDetector finds one kind of personal data
+type piiDetector struct {
+	pattern *regexp.Regexp
+	// valid filters out false positives of the pattern, if set
+	valid func(match string) bool
+}
+
+// piiDetectors are the built-in detectors, by the name PII_REDACTION
+// selects them with, in the order they run
+var piiDetectors = []struct {
+	name string
+	piiDetector
+}{
+	{"email", piiDetector{pattern: regexp.MustCompile(`[\w.%+-]+@[\w-]+(?:\.[\w-]+)*\.[A-Za-z]{2,}`)}},
+	{"credit_card", piiDetector{pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhnValid}},
+	{"phone", piiDetector{
+		pattern: regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?(?:\(\d{1,4}\)[\s.-]?)?|\(\d{1,4}\)[\s.-]?|\b)\d{2,4}(?:[\s.-]?\d{2,4}){1,4}\b`),
+		valid:   phoneValid,
+	}},
+}
+
+// isoDate matches dates that look like phone numbers to the phone pattern
+var isoDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// phoneValid reports whether a phone pattern match has enough digits to be
+// a phone number and isn't a date
+func phoneValid(match string) bool {
+	digits := 0
+	for _, r := range match {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= 7 && digits <= 15 && !isoDate.MatchString(match)
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum of card
+// numbers
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// piiRedactor replaces personal data with tokens, the same value always
+// getting the same token
+type piiRedactor struct {
+	names     []string
+	detectors []piiDetector
+}
+
+// newPIIRedactor creates the redactor of PII_REDACTION and PII_PATTERN,
+// returning nil when neither is set
+func newPIIRedactor(config Config) (*piiRedactor, error) {
+	if len(config.PIIRedaction) == 0 && config.PIIPattern == "" {
+		return nil, nil
+	}
+	return compilePIIRedactor(config.PIIRedaction, config.PIIPattern)
+}
+
+// compilePIIRedactor creates a redactor of the personal data found by the
+// named detectors, and matches of the custom pattern if set
+func compilePIIRedactor(names []string, custom string) (*piiRedactor, error) {
+	r := &piiRedactor{}
+	for _, name := range names {
+		found := false
+		for _, d := range piiDetectors {
+			if d.name == name {
+				r.names = append(r.names, name)
+				r.detectors = append(r.detectors, d.piiDetector)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown PII detector: %q", name)
+		}
+	}
+	if custom != "" {
+		re, err := regexp.Compile(custom)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PII pattern: %v", err)
+		}
+		r.names = append(r.names, "custom")
+		r.detectors = append(r.detectors, piiDetector{pattern: re})
+	}
+	return r, nil
+}
+
+// redact replaces the personal data in text with tokens, returning the
+// redacted text and the values in token order. The values of texts
+// redacted before are passed in, and keep their tokens. A nil redactor
+// returns text as it is.
+func (r *piiRedactor) redact(text string, values []string) (string, []string) {
+	if r == nil {
+		return text, values
+	}
+	tokens := make(map[string]string, len(values))
+	for i, value := range values {
+		tokens[value] = piiMask.token(i)
+	}
+	for i, d := range r.detectors {
+		text = d.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if piiMask.pattern.MatchString(match) || d.valid != nil && !d.valid(match) {
+				return match
+			}
+			token, ok := tokens[match]
+			if !ok {
+				values = append(values, match)
+				token = piiMask.token(len(values) - 1)
+				tokens[match] = token
+				piiRedactions.WithLabelValues(r.names[i]).Inc()
+			}
+			return token
+		})
+	}
+	return text, values
+}
+
+// redactingProvider replaces personal data with tokens before texts reach
+// the provider, and restores it in translations
+type redactingProvider struct {
+	provider.Wrapper
+	*piiRedactor
+}
+
+// newRedactingProvider wraps p so the personal data found by the named
+// detectors, and matches of the custom pattern if set, never reach it
+func newRedactingProvider(p provider.Provider, names []string, custom string) (*redactingProvider, error) {
+	r, err := compilePIIRedactor(names, custom)
+	if err != nil {
+		return nil, err
+	}
+	return &redactingProvider{Wrapper: provider.Wrapper{Provider: p}, piiRedactor: r}, nil
+}
+
+// Translate implements Provider
+func (p *redactingProvider) Translate(ctx context.Context, req provider.Request) (*provider.Result, error) {
+	redacted, values := p.redact(req.Text, nil)
+	inText := len(values)
+	// The context is prompted with the text, so it is redacted too, sharing
+	// its tokens; it isn't translated, so nothing of it is restored
+	redactedContext, values := p.redact(req.Context, values)
+	if len(values) == 0 {
+		return p.Provider.Translate(ctx, req)
+	}
+
+	req.Text, req.Context = redacted, redactedContext
+	result, err := p.Provider.Translate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var missing int
+	result.TranslatedText, missing = piiMask.restore(result.TranslatedText, values[:inText])
+	if missing > 0 {
+		logger(ctx).Warn("redacted values lost in translation", "provider", p.Name(), "missing", missing, "values", inText)
+	}
+	return result, nil
+}
+
+// Detect implements Provider, detecting the language of the redacted text
+func (p *redactingProvider) Detect(ctx context.Context, text string) (*provider.Detection, error) {
+	redacted, _ := p.redact(text, nil)
+	return p.Provider.Detect(ctx, strings.TrimSpace(redacted))
+}
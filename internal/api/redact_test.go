@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"4111 1111 1111 1111", true},
+		{"4111-1111-1111-1112", false},
+		{"5500005555555559", true},
+		{"424242", false}, // Too short to be a card
+	}
+	for _, tt := range tests {
+		if got := luhnValid(tt.number); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestPhoneValid(t *testing.T) {
+	tests := []struct {
+		match string
+		want  bool
+	}{
+		{"+49 30 1234567", true},
+		{"(555) 123-4567", true},
+		{"2024-05-01", false},
+		{"12 34", false},
+		{"1234567890123456", false},
+	}
+	for _, tt := range tests {
+		if got := phoneValid(tt.match); got != tt.want {
+			t.Errorf("phoneValid(%q) = %v, want %v", tt.match, got, tt.want)
+		}
+	}
+}
+
+func TestRedactingProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		custom  string
+		text    string
+		context string
+		// wantSent is the text the provider gets, wantContext its context
+		wantSent    string
+		wantContext string
+	}{
+		{
+			name:     "email",
+			names:    []string{"email"},
+			text:     "Write to jane.doe@example.com today",
+			wantSent: "Write to __PII_0__ today",
+		},
+		{
+			name:     "same value, same token",
+			names:    []string{"email"},
+			text:     "a@example.com, b@example.com and a@example.com",
+			wantSent: "__PII_0__, __PII_1__ and __PII_0__",
+		},
+		{
+			name:     "card and phone",
+			names:    []string{"email", "credit_card", "phone"},
+			text:     "Card 4111 1111 1111 1111, call +49 30 1234567",
+			wantSent: "Card __PII_0__, call __PII_1__",
+		},
+		{
+			name:     "not a card",
+			names:    []string{"credit_card"},
+			text:     "Order 4111 1111 1111 1112",
+			wantSent: "Order 4111 1111 1111 1112",
+		},
+		{
+			name:     "custom pattern",
+			custom:   `ACME-\d+`,
+			text:     "Ticket ACME-1234 is open",
+			wantSent: "Ticket __PII_0__ is open",
+		},
+		{
+			name:        "context shares the text's tokens",
+			names:       []string{"email"},
+			text:        "Reply to a@example.com",
+			context:     "Support ticket from a@example.com, copied to b@example.com",
+			wantSent:    "Reply to __PII_0__",
+			wantContext: "Support ticket from __PII_0__, copied to __PII_1__",
+		},
+		{
+			name:        "personal data only in the context",
+			names:       []string{"email"},
+			text:        "Thanks for writing",
+			context:     "Reply to a@example.com",
+			wantSent:    "Thanks for writing",
+			wantContext: "Reply to __PII_0__",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeProvider{}
+			p, err := newRedactingProvider(fake, tt.names, tt.custom)
+			if err != nil {
+				t.Fatalf("newRedactingProvider() error = %v", err)
+			}
+
+			result, err := p.Translate(context.Background(), provider.Request{Text: tt.text, Context: tt.context, TargetLang: "de"})
+			if err != nil {
+				t.Fatalf("Translate() error = %v", err)
+			}
+			sent := fake.Requests()[0]
+			if sent.Text != tt.wantSent {
+				t.Errorf("provider got text %q, want %q", sent.Text, tt.wantSent)
+			}
+			if sent.Context != tt.wantContext {
+				t.Errorf("provider got context %q, want %q", sent.Context, tt.wantContext)
+			}
+			if want := "[de] " + tt.text; result.TranslatedText != want {
+				t.Errorf("TranslatedText = %q, want %q", result.TranslatedText, want)
+			}
+		})
+	}
+}
+
+func TestRedactingProviderDetect(t *testing.T) {
+	fake := &fakeProvider{}
+	p, err := newRedactingProvider(fake, []string{"email"}, "")
+	if err != nil {
+		t.Fatalf("newRedactingProvider() error = %v", err)
+	}
+	if _, err := p.Detect(context.Background(), "Mail jane@example.com "); err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+	if got := fake.detections; len(got) != 1 || got[0] != "Mail __PII_0__" {
+		t.Errorf("provider detected %q, want the redacted text", got)
+	}
+}
+
+func TestNewRedactingProviderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		names  []string
+		custom string
+	}{
+		{"unknown detector", []string{"passport"}, ""},
+		{"invalid pattern", nil, "("},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRedactingProvider(&fakeProvider{}, tt.names, tt.custom); err == nil {
+				t.Error("newRedactingProvider() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// redisAvailable reports whether Redis is reachable and may be used
+func (s *Server) redisAvailable() bool {
+	return s.redis != nil && s.redisUp.Load()
+}
+
+// redisStatusError returns the error of the last failed health check, or nil
+// when Redis is available
+func (s *Server) redisStatusError() error {
+	s.redisLastError.Lock()
+	defer s.redisLastError.Unlock()
+	return s.redisLastError.err
+}
+
+// checkRedis pings Redis and updates the degraded-mode state, logging
+// transitions
+func (s *Server) checkRedis(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, s.config.RedisHealthTimeout)
+	defer cancel()
+	err := s.redis.Ping(ctx).Err()
+
+	s.redisLastError.Lock()
+	s.redisLastError.err = err
+	s.redisLastError.Unlock()
+
+	wasUp := s.redisUp.Swap(err == nil)
+	switch {
+	case err == nil && !wasUp:
+		slog.Info("connected to Redis")
+	case err != nil && wasUp:
+		slog.Error("lost connection to Redis, running in degraded mode without cache", "error", err)
+	}
+}
+
+// monitorRedis checks Redis every interval until ctx is done, so the service
+// leaves degraded mode once Redis becomes reachable again
+func (s *Server) monitorRedis(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkRedis(ctx)
+		}
+	}
+}
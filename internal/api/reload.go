@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// reloadableSettings are the settings applied by a reload, by environment
+// variable and Config field. The rest only take effect on restart.
+var reloadableSettings = []struct{ env, field string }{
+	{"CACHE_TTL", "TTL"},
+	{"CACHE_MIN_TTL", "CacheMinTTL"},
+	{"CACHE_MAX_TTL", "CacheMaxTTL"},
+	{"CACHE_TTL_OVERRIDES", "CacheTTLOverrides"},
+	{"CACHE_SOFT_TTL", "CacheSoftTTL"},
+	{"RATE_LIMIT_RPS", "RateLimitRPS"},
+	{"RATE_LIMIT_BURST", "RateLimitBurst"},
+	{"RATE_LIMIT_CHARS_PER_MIN", "RateLimitCharsPerMin"},
+	{"DAILY_CHAR_QUOTA", "DailyCharQuota"},
+	{"CANARY_PERCENT", "CanaryPercent"},
+	{"TM_FUZZY_THRESHOLD", "TMFuzzyThreshold"},
+	{"VERIFY_MIN_SIMILARITY", "VerifyMinSimilarity"},
+	{"MODERATION_INPUT", "ModerationInput"},
+	{"MODERATION_OUTPUT", "ModerationOutput"},
+	{"REVIEW_MIN_CONFIDENCE", "ReviewMinConfidence"},
+	{"REVIEW_MAX_QUALITY", "ReviewMaxQuality"},
+	{"PAGE_FETCH_ALLOWLIST", "PageFetchAllowlist"},
+	{"LANGUAGE_PAIRS_ALLOW", "LanguagePairsAllow"},
+	{"LANGUAGE_PAIRS_DENY", "LanguagePairsDeny"},
+	{"LOG_LEVEL", "LogLevel"},
+}
+
+// currentConfig returns the configuration reloadable settings are read from
+func (s *Server) currentConfig() *Config {
+	return s.liveConfig.Load()
+}
+
+// ReloadResponse is the body of POST /admin/reload
+type ReloadResponse struct {
+	Changed []string `json:"changed"`           // Reloadable settings whose value changed
+	Errors  []string `json:"errors,omitempty"`  // Invalid settings, which rejected the reload
+	Ignored []string `json:"ignored,omitempty"` // Changed settings that need a restart
+}
+
+// reloadConfig reads the config file and environment again and applies the
+// reloadable settings. Nothing is applied when any setting is invalid.
+// The cache, connections and providers are kept.
+func (s *Server) reloadConfig() ReloadResponse {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	response := ReloadResponse{Changed: []string{}}
+	path := configFile
+	unloadConfigFile()
+	if err := LoadConfigFile(path); err != nil {
+		response.Errors = []string{err.Error()}
+		return response
+	}
+	fresh := ReadConfig()
+	if response.Errors = ValidateConfig(&fresh); len(response.Errors) > 0 {
+		return response
+	}
+
+	live := s.currentConfig()
+	next := *live
+	current, updated, target := reflect.ValueOf(live).Elem(), reflect.ValueOf(&fresh).Elem(), reflect.ValueOf(&next).Elem()
+	reloadable := make(map[string]bool, len(reloadableSettings))
+	for _, setting := range reloadableSettings {
+		reloadable[setting.field] = true
+		if !reflect.DeepEqual(current.FieldByName(setting.field).Interface(), updated.FieldByName(setting.field).Interface()) {
+			target.FieldByName(setting.field).Set(updated.FieldByName(setting.field))
+			response.Changed = append(response.Changed, setting.env)
+		}
+	}
+	for i := 0; i < current.NumField(); i++ {
+		name := current.Type().Field(i).Name
+		if !reloadable[name] && !reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			response.Ignored = append(response.Ignored, name)
+		}
+	}
+
+	s.liveConfig.Store(&next)
+	setLogLevel(next.LogLevel)
+	return response
+}
+
+// ReloadOnSignal reloads the configuration on every SIGHUP until ctx is done
+func (s *Server) ReloadOnSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				logReload(s.reloadConfig())
+			}
+		}
+	}()
+}
+
+// logReload logs the outcome of a reload
+func logReload(response ReloadResponse) {
+	if len(response.Errors) > 0 {
+		slog.Error("configuration reload rejected", "errors", response.Errors)
+		return
+	}
+	if len(response.Ignored) > 0 {
+		slog.Warn("changed settings need a restart to take effect", "fields", response.Ignored)
+	}
+	slog.Info("configuration reloaded", "changed", response.Changed)
+}
+
+// handleAdminReload reloads the configuration, like SIGHUP
+func (s *Server) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := s.reloadConfig()
+	logReload(response)
+	w.Header().Set("Content-Type", "application/json")
+	if len(response.Errors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// resourceFile is a parsed localization resource file
+type resourceFile interface {
+	// units returns the file's translatable strings
+	units() []resourceUnit
+	// encode serializes the file as the translation from sourceLang, which
+	// may be empty when unknown, to targetLang
+	encode(sourceLang, targetLang string) ([]byte, error)
+}
+
+// resourceConfigurer is implemented by resource files that take
+// format-specific request parameters
+type resourceConfigurer interface {
+	configure(params url.Values) error
+}
+
+// resourceUnit is a translatable string of a resource file
+type resourceUnit struct {
+	key  string // Identifies the string within the file
+	text string
+	set  func(translated string) // Replaces the string in the file
+}
+
+// splicedResource is a resource file whose strings are replaced in place,
+// leaving every other byte of it as it was
+type splicedResource struct {
+	data     []byte
+	spans    [][2]int // Byte ranges of the strings, in order
+	values   []string // Raw content of each span
+	list     []resourceUnit
+	reencode func([]byte) ([]byte, error) // Converts the output back to the file's encoding, if not UTF-8
+}
+
+// add adds the string key of raw content data[start:end] whose text is
+// text. encode converts its translation back to raw content.
+func (f *splicedResource) add(key string, start, end int, text string, encode func(translated string) string) {
+	i := f.keep(start, end)
+	f.list = append(f.list, resourceUnit{key: key, text: text, set: func(translated string) {
+		f.values[i] = encode(translated)
+	}})
+}
+
+// keep adds data[start:end] as a span that may be replaced by setting its
+// value, returning its index. Spans must be added in order.
+func (f *splicedResource) keep(start, end int) int {
+	f.spans = append(f.spans, [2]int{start, end})
+	f.values = append(f.values, string(f.data[start:end]))
+	return len(f.spans) - 1
+}
+
+// units implements resourceFile
+func (f *splicedResource) units() []resourceUnit {
+	return f.list
+}
+
+// encode implements resourceFile
+func (f *splicedResource) encode(sourceLang, targetLang string) ([]byte, error) {
+	var out bytes.Buffer
+	last := 0
+	for i, span := range f.spans {
+		out.Write(f.data[last:span[0]])
+		out.WriteString(f.values[i])
+		last = span[1]
+	}
+	out.Write(f.data[last:])
+	if f.reencode != nil {
+		return f.reencode(out.Bytes())
+	}
+	return out.Bytes(), nil
+}
+
+// markupMask masks markup in the strings of XML resource files
+var markupMask = newTokenMask("TAG")
+
+// resourceFormat reads a localization resource file format
+type resourceFormat struct {
+	contentType string
+	extensions  []string
+	parse       func(data []byte) (resourceFile, error)
+}
+
+// resourceFormats are the supported resource file formats, by name
+var resourceFormats = map[string]resourceFormat{
+	"json": {contentType: "application/json", extensions: []string{".json"}, parse: parseJSONResource},
+	"arb":  {contentType: "application/json", extensions: []string{".arb"}, parse: parseARBResource},
+	"yaml": {contentType: "application/yaml", extensions: []string{".yaml", ".yml"}, parse: parseYAMLResource},
+
+	"android":     {contentType: "application/xml", extensions: []string{".xml"}, parse: parseAndroidResource},
+	"strings":     {contentType: "text/plain", extensions: []string{".strings"}, parse: parseIOSStrings},
+	"stringsdict": {contentType: "application/xml", extensions: []string{".stringsdict"}, parse: parseIOSStringsDict},
+
+	"po":    {contentType: "text/x-gettext-translation", extensions: []string{".po", ".pot"}, parse: parsePOResource},
+	"xliff": {contentType: "application/xliff+xml", extensions: []string{".xlf", ".xliff"}, parse: parseXLIFFResource},
+}
+
+// resourceFormatNames returns the names of the resource formats, sorted
+func resourceFormatNames() []string {
+	names := make([]string, 0, len(resourceFormats))
+	for name := range resourceFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// detectResourceFormat names the format of a file from its extension or,
+// for uploads without a file name, its content
+func detectResourceFormat(filename string, data []byte) string {
+	if ext := strings.ToLower(filepath.Ext(filename)); ext != "" {
+		for name, format := range resourceFormats {
+			for _, e := range format.extensions {
+				if e == ext {
+					return name
+				}
+			}
+		}
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte(utf8BOM)))
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}) || bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		// Only .strings files are commonly UTF-16
+		return "strings"
+	case bytes.HasPrefix(trimmed, []byte("<")) && bytes.Contains(trimmed, []byte("<plist")):
+		return "stringsdict"
+	case bytes.HasPrefix(trimmed, []byte("<")) && bytes.Contains(trimmed, []byte("<xliff")):
+		return "xliff"
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return "android"
+	case bytes.HasPrefix(trimmed, []byte(`"`)) || bytes.HasPrefix(trimmed, []byte("/")):
+		return "strings"
+	case bytes.HasPrefix(trimmed, []byte("msgid ")) || bytes.Contains(trimmed, []byte("\nmsgid ")):
+		return "po"
+	case bytes.HasPrefix(trimmed, []byte("{")) && bytes.Contains(trimmed, []byte(`"@@locale"`)):
+		return "arb"
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return "json"
+	default:
+		return "yaml"
+	}
+}
+
+// handleResources translates the strings of a localization resource file
+// (flat or nested JSON, YAML, Flutter ARB, Android strings.xml, iOS
+// .strings and .stringsdict, gettext PO or XLIFF), keeping its keys and ICU
+// arguments, and returns the file for the target locale. The file is
+// uploaded like subtitles; its format is the format parameter, or detected
+// from the file name or content. With output=xliff or xliff2 the strings
+// are returned as an XLIFF 1.2 or 2.0 file instead.
+func (s *Server) handleResources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, ok := s.authenticateRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: Invalid API key")
+		logger(r.Context()).Warn("unauthorized request", "remote_addr", s.clientIP(r))
+		return
+	}
+
+	data, filename, ok := readUploadedFile(w, r)
+	if !ok {
+		return
+	}
+	base := TranslationRequest{
+		SourceLang: r.FormValue("source_lang"),
+		TargetLang: r.FormValue("target_lang"),
+		Formality:  r.FormValue("formality"),
+		Tone:       r.FormValue("tone"),
+		Domain:     r.FormValue("domain"),
+	}
+	if err := validateTranslationOptions(&base); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	if err := validateLanguages(base); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidLang, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+
+	name := r.FormValue("format")
+	if name == "" {
+		name = detectResourceFormat(filename, data)
+	}
+	format, ok := resourceFormats[name]
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: format must be one of %s", strings.Join(resourceFormatNames(), ", ")))
+		return
+	}
+	file, err := format.parse(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid %s file: %v", name, err))
+		return
+	}
+	output := r.FormValue("output")
+	if output != "" && output != xliff12 && output != xliff20 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: output must be %q or %q", xliff12, xliff20))
+		return
+	}
+	if c, ok := file.(resourceConfigurer); ok {
+		if err := c.configure(r.Form); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+			return
+		}
+	}
+
+	// Translate each distinct message once, with its ICU arguments masked.
+	// The branches of plural and select arguments are messages of their
+	// own, and messages that are nothing but arguments are left as they are.
+	units := file.units()
+	messages := make([]*icuMessage, len(units))
+	index := make(map[string]int)
+	var texts []string
+	chars := 0
+	for i, unit := range units {
+		messages[i] = parseICUMessage(unit.text, false)
+		added := false
+		messages[i].messages(func(m *icuMessage) {
+			if _, ok := index[m.text]; ok || !m.translatable() {
+				return
+			}
+			index[m.text] = len(texts)
+			texts = append(texts, m.text)
+			added = true
+		})
+		if added {
+			chars += utf8.RuneCountInString(unit.text)
+		}
+	}
+	if len(texts) > s.config.DocumentMaxStrings {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: file has %d distinct strings, the maximum is %d", len(texts), s.config.DocumentMaxStrings))
+		return
+	}
+	if !s.authorizeTranslation(ctx, w, chars) {
+		return
+	}
+
+	responses, err := s.translateStrings(ctx, base, texts)
+	if err != nil {
+		s.writeTranslationError(ctx, w, err)
+		return
+	}
+	s.recordStringsUsage(ctx, texts, responses)
+
+	sourceLang := base.SourceLang
+	lost := 0
+	exported := make([]xliffUnit, len(units))
+	for i, unit := range units {
+		exported[i] = xliffUnit{key: unit.key, source: unit.text, target: unit.text}
+		translated := false
+		text, missing := messages[i].render(func(m *icuMessage) (string, bool) {
+			j, ok := index[m.text]
+			if !ok || !m.translatable() {
+				return "", false
+			}
+			translated = true
+			if sourceLang == "" {
+				sourceLang = responses[j].SourceLang
+			}
+			return responses[j].TranslatedText, true
+		})
+		if !translated {
+			continue
+		}
+		lost += missing
+		unit.set(text)
+		exported[i].target = text
+	}
+	if lost > 0 {
+		logger(ctx).Warn("ICU arguments lost in translation", "missing", lost)
+	}
+
+	contentType := format.contentType
+	var out []byte
+	if output != "" {
+		contentType = resourceFormats["xliff"].contentType
+		out, err = encodeXLIFF(output, filename, sourceLang, base.TargetLang, exported)
+	} else {
+		out, err = file.encode(sourceLang, base.TargetLang)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to encode %s file: %v", name, err))
+		return
+	}
+	logger(ctx).Info("translated resource file", "format", name, "output", output, "strings", len(texts), "characters", chars)
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
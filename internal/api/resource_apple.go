@@ -0,0 +1,255 @@
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+// parseIOSStrings parses an iOS .strings file of "key" = "value"; pairs.
+// Values are translatable; keys and comments are kept. UTF-16 files are
+// returned in UTF-16.
+func parseIOSStrings(data []byte) (resourceFile, error) {
+	f := &splicedResource{}
+	for _, bom := range []struct {
+		prefix []byte
+		endian unicode.Endianness
+	}{{[]byte{0xFF, 0xFE}, unicode.LittleEndian}, {[]byte{0xFE, 0xFF}, unicode.BigEndian}} {
+		if !bytes.HasPrefix(data, bom.prefix) {
+			continue
+		}
+		decoded, err := unicode.UTF16(bom.endian, unicode.ExpectBOM).NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+		endian := bom.endian
+		f.reencode = func(b []byte) ([]byte, error) {
+			return unicode.UTF16(endian, unicode.UseBOM).NewEncoder().Bytes(b)
+		}
+	}
+	f.data = data
+
+	s := string(data)
+	i := len(s) - len(strings.TrimPrefix(s, utf8BOM))
+	for {
+		var err error
+		if i, err = skipStringsSpace(s, i); err != nil {
+			return nil, err
+		}
+		if i == len(s) {
+			break
+		}
+		keyStart, keyEnd, next, err := readStringsQuoted(s, i)
+		if err != nil {
+			return nil, err
+		}
+		key := unescapeIOS(s[keyStart:keyEnd])
+		i = next
+		if i, err = expectStrings(s, i, '='); err != nil {
+			return nil, err
+		}
+		if i, err = skipStringsSpace(s, i); err != nil {
+			return nil, err
+		}
+		start, end, next, err := readStringsQuoted(s, i)
+		if err != nil {
+			return nil, err
+		}
+		if i, err = expectStrings(s, next, ';'); err != nil {
+			return nil, err
+		}
+		if text := unescapeIOS(s[start:end]); strings.TrimSpace(text) != "" {
+			f.add(key, start, end, text, escapeIOS)
+		}
+	}
+	return f, nil
+}
+
+// skipStringsSpace skips the whitespace and comments of a .strings file
+// from offset i
+func skipStringsSpace(s string, i int) (int, error) {
+	for i < len(s) {
+		switch {
+		case s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r':
+			i++
+		case strings.HasPrefix(s[i:], "//"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				return len(s), nil
+			}
+			i += end + 1
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return 0, fmt.Errorf("unterminated comment at offset %d", i)
+			}
+			i += end + 4
+		default:
+			return i, nil
+		}
+	}
+	return i, nil
+}
+
+// readStringsQuoted reads the quoted string at offset i, returning the
+// range of its content and the offset after it
+func readStringsQuoted(s string, i int) (start, end, next int, err error) {
+	if i >= len(s) || s[i] != '"' {
+		return 0, 0, 0, fmt.Errorf("expected quoted string at offset %d", i)
+	}
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			return i + 1, j, j + 1, nil
+		}
+	}
+	return 0, 0, 0, fmt.Errorf("unterminated string at offset %d", i)
+}
+
+// expectStrings skips to the offset after the separator c
+func expectStrings(s string, i int, c byte) (int, error) {
+	i, err := skipStringsSpace(s, i)
+	if err != nil {
+		return 0, err
+	}
+	if i >= len(s) || s[i] != c {
+		return 0, fmt.Errorf("expected %q at offset %d", c, i)
+	}
+	return i + 1, nil
+}
+
+// unescapeIOS resolves the backslash escapes of a .strings value
+func unescapeIOS(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'U', 'u':
+			if i+4 < len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+					b.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			b.WriteString(s[i-1 : i+1])
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// iosEscaper escapes .strings values
+var iosEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+
+// escapeIOS escapes s for a .strings value
+func escapeIOS(s string) string {
+	return iosEscaper.Replace(s)
+}
+
+// stringsDictVariable matches the variables of a stringsdict format key,
+// such as %#@songs@
+var stringsDictVariable = regexp.MustCompile(`%#@[^@\s]+@`)
+
+// stringsDictTranslatable are the keys of translatable stringsdict values:
+// the format key and the plural categories
+var stringsDictTranslatable = map[string]bool{
+	"NSStringLocalizedFormatKey": true,
+	"zero":                       true,
+	"one":                        true,
+	"two":                        true,
+	"few":                        true,
+	"many":                       true,
+	"other":                      true,
+}
+
+// parseIOSStringsDict parses an iOS .stringsdict plist. The format keys
+// and the strings of each plural category are translatable, keyed by the
+// path of dictionary keys leading to them.
+func parseIOSStringsDict(data []byte) (resourceFile, error) {
+	f := &splicedResource{data: data}
+	dec := newXMLDecoder(data)
+	var key string
+	var path []string // Keys of the dictionaries around the current one
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if end, ok := tok.(xml.EndElement); ok && end.Name.Local == "dict" && len(path) > 0 {
+			path = path[:len(path)-1]
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch el.Name.Local {
+		case "dict":
+			path = append(path, key)
+		case "key":
+			if err := dec.DecodeElement(&key, &el); err != nil {
+				return nil, err
+			}
+			continue
+		case "string":
+			if !stringsDictTranslatable[key] {
+				break
+			}
+			start, end, ok, err := xmlElementContent(dec, data)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				text, encode := decodeStringsDictValue(string(data[start:end]))
+				if strings.TrimSpace(markupMask.pattern.ReplaceAllString(text, "")) != "" {
+					f.add(strings.TrimPrefix(strings.Join(append(path, key), "/"), "/"), start, end, text, encode)
+				}
+			}
+		}
+		key = ""
+	}
+	return f, nil
+}
+
+// decodeStringsDictValue returns the text of the raw content of a plist
+// string, with its variables masked, and the function encoding a
+// translation of it back to raw content
+func decodeStringsDictValue(raw string) (string, func(string) string) {
+	var variables []string
+	text := stringsDictVariable.ReplaceAllStringFunc(html.UnescapeString(raw), func(v string) string {
+		variables = append(variables, v)
+		return markupMask.token(len(variables) - 1)
+	})
+	return text, func(translated string) string {
+		restored, _ := markupMask.restore(xmlTextEscaper.Replace(translated), variables)
+		return restored
+	}
+}
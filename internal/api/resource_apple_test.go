@@ -0,0 +1,118 @@
+package api
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestIOSStrings(t *testing.T) {
+	data := `/* Greeting on the home screen */
+"greeting" = "Hello \"friend\"";
+// Not translated: empty
+"empty" = "";
+"multi\nline key"="Line one\nLine two";
+"unicode" = "Caf\U00E9";
+`
+	want := [][2]string{
+		{"greeting", `Hello "friend"`},
+		{"multi\nline key", "Line one\nLine two"},
+		{"unicode", "Café"},
+	}
+	out := `/* Greeting on the home screen */
+"greeting" = "[de] Hello \"friend\"";
+// Not translated: empty
+"empty" = "";
+"multi\nline key"="[de] Line one\nLine two";
+"unicode" = "[de] Café";
+`
+	if got := translateResource(t, parseIOSStrings, data, nil, want); got != out {
+		t.Errorf("encode() =\n%s\nwant\n%s", got, out)
+	}
+}
+
+func TestIOSStringsUTF16(t *testing.T) {
+	encoder := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder()
+	data, err := encoder.String(`"greeting" = "Hello";`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := translateResource(t, parseIOSStrings, data, nil, [][2]string{{"greeting", "Hello"}})
+	want, _ := encoder.String(`"greeting" = "[de] Hello";`)
+	if got != want {
+		t.Errorf("encode() = %q, want it in UTF-16 as %q", got, want)
+	}
+}
+
+func TestIOSStringsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"missing semicolon", `"a" = "b"`},
+		{"missing equals", `"a" "b";`},
+		{"unterminated string", `"a" = "b;`},
+		{"unterminated comment", `/* "a" = "b";`},
+		{"unquoted key", `a = "b";`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseIOSStrings([]byte(tt.data)); err == nil {
+				t.Error("parseIOSStrings() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestIOSStringsDict(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>songs</key>
+	<dict>
+		<key>NSStringLocalizedFormatKey</key>
+		<string>%#@count@ in &quot;%@&quot;</string>
+		<key>count</key>
+		<dict>
+			<key>NSStringFormatSpecTypeKey</key>
+			<string>NSStringPluralRuleType</string>
+			<key>NSStringFormatValueTypeKey</key>
+			<string>d</string>
+			<key>one</key>
+			<string>%d song</string>
+			<key>other</key>
+			<string>%d songs &amp; more</string>
+		</dict>
+	</dict>
+</dict>
+</plist>`
+	want := [][2]string{
+		{"songs/NSStringLocalizedFormatKey", `__TAG_0__ in "%@"`},
+		{"songs/count/one", "%d song"},
+		{"songs/count/other", "%d songs & more"},
+	}
+	out := `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>songs</key>
+	<dict>
+		<key>NSStringLocalizedFormatKey</key>
+		<string>[de] %#@count@ in "%@"</string>
+		<key>count</key>
+		<dict>
+			<key>NSStringFormatSpecTypeKey</key>
+			<string>NSStringPluralRuleType</string>
+			<key>NSStringFormatValueTypeKey</key>
+			<string>d</string>
+			<key>one</key>
+			<string>[de] %d song</string>
+			<key>other</key>
+			<string>[de] %d songs &amp; more</string>
+		</dict>
+	</dict>
+</dict>
+</plist>`
+	if got := translateResource(t, parseIOSStringsDict, data, nil, want); got != out {
+		t.Errorf("encode() =\n%s\nwant\n%s", got, out)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jsonResource is a flat or nested JSON resource file, as used by
+// i18next, vue-i18n and similar libraries. Every string value is
+// translatable.
+type jsonResource struct {
+	root *jsonNode
+}
+
+// parseJSONResource parses a JSON resource file
+func parseJSONResource(data []byte) (resourceFile, error) {
+	root, err := parseJSONDocument(bytes.TrimPrefix(data, []byte(utf8BOM)))
+	if err != nil {
+		return nil, err
+	}
+	if root.kind != '{' {
+		return nil, fmt.Errorf("top level must be an object")
+	}
+	return &jsonResource{root: root}, nil
+}
+
+// units implements resourceFile. Keys are the dotted paths of the strings.
+func (f *jsonResource) units() []resourceUnit {
+	var units []resourceUnit
+	var walk func(n *jsonNode, path string)
+	walk = func(n *jsonNode, path string) {
+		for _, m := range n.members {
+			key := m.key
+			if path != "" {
+				key = path + "." + m.key
+			}
+			walk(m.node, key)
+		}
+		for i, item := range n.items {
+			walk(item, fmt.Sprintf("%s[%d]", path, i))
+		}
+		if text, ok := n.value.(string); ok && strings.TrimSpace(text) != "" {
+			units = append(units, jsonUnit(path, n, text))
+		}
+	}
+	walk(f.root, "")
+	return units
+}
+
+// jsonUnit returns the unit of the string value n
+func jsonUnit(key string, n *jsonNode, text string) resourceUnit {
+	return resourceUnit{key: key, text: text, set: func(translated string) { n.value = translated }}
+}
+
+// encode implements resourceFile
+func (f *jsonResource) encode(sourceLang, targetLang string) ([]byte, error) {
+	return encodeJSONResource(f.root)
+}
+
+// encodeJSONResource writes root indented by two spaces, as resource files
+// usually are
+func encodeJSONResource(root *jsonNode) ([]byte, error) {
+	var compact bytes.Buffer
+	if err := root.encode(&compact); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, compact.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// arbResource is a Flutter Application Resource Bundle. Messages are the
+// top-level strings; @-prefixed keys hold metadata, which is kept as it is.
+type arbResource struct {
+	root *jsonNode
+}
+
+// parseARBResource parses an ARB file
+func parseARBResource(data []byte) (resourceFile, error) {
+	root, err := parseJSONDocument(bytes.TrimPrefix(data, []byte(utf8BOM)))
+	if err != nil {
+		return nil, err
+	}
+	if root.kind != '{' {
+		return nil, fmt.Errorf("top level must be an object")
+	}
+	return &arbResource{root: root}, nil
+}
+
+// units implements resourceFile
+func (f *arbResource) units() []resourceUnit {
+	var units []resourceUnit
+	for _, m := range f.root.members {
+		if text, ok := m.node.value.(string); ok && !strings.HasPrefix(m.key, "@") && strings.TrimSpace(text) != "" {
+			units = append(units, jsonUnit(m.key, m.node, text))
+		}
+	}
+	return units
+}
+
+// encode implements resourceFile, setting @@locale to the target locale
+func (f *arbResource) encode(sourceLang, targetLang string) ([]byte, error) {
+	locale := &jsonNode{value: strings.ReplaceAll(targetLang, "-", "_")}
+	found := false
+	for i, m := range f.root.members {
+		if m.key == "@@locale" {
+			f.root.members[i].node = locale
+			found = true
+		}
+	}
+	if !found {
+		f.root.members = append([]jsonMember{{key: "@@locale", node: locale}}, f.root.members...)
+	}
+	return encodeJSONResource(f.root)
+}
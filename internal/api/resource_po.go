@@ -0,0 +1,356 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PO translation modes, selecting the entries that are translated
+const (
+	poModeUntranslated = "untranslated" // Entries without a translation
+	poModeFuzzy        = "fuzzy"        // Those and entries flagged fuzzy
+	poModeAll          = "all"          // Every entry
+)
+
+// poEntry is an entry of a PO file. Its fields point to their values,
+// which continuation lines append to while parsing.
+type poEntry struct {
+	start       int // First line of the entry
+	flagsLine   int // Line of the #, flags, -1 without one
+	msgstrStart int // Lines of the msgstr fields, -1 without them
+	msgstrEnd   int
+	obsolete    bool
+
+	flags       []string
+	msgctxt     *string
+	msgid       *string
+	msgidPlural *string
+	msgstr      map[int]*string // By plural index, 0 without plurals
+
+	// Translations of msgid and msgid_plural, once translated
+	singular, plural *string
+}
+
+// translated reports whether the entry has a translation
+func (e *poEntry) translated() bool {
+	for _, s := range e.msgstr {
+		if *s != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzy reports whether the entry is flagged fuzzy
+func (e *poEntry) fuzzy() bool {
+	for _, flag := range e.flags {
+		if flag == "fuzzy" {
+			return true
+		}
+	}
+	return false
+}
+
+// poResource is a gettext PO or POT file. New translations replace the
+// msgstr fields of the entries they are for; every other line, from
+// comments to contexts, stays as it was.
+type poResource struct {
+	lines   []string
+	newline string
+	entries []*poEntry
+
+	mode      string
+	markFuzzy bool // Flag new translations fuzzy, for review
+}
+
+// parsePOResource parses a PO file
+func parsePOResource(data []byte) (resourceFile, error) {
+	text := strings.TrimPrefix(string(data), utf8BOM)
+	f := &poResource{newline: "\n", mode: poModeUntranslated}
+	if strings.Contains(text, "\r\n") {
+		f.newline = "\r\n"
+	}
+	f.lines = strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var entry *poEntry
+	var field *string // Field that continuation lines append to
+	finish := func(end int) {
+		if entry != nil {
+			if entry.msgstrStart >= 0 && entry.msgstrEnd < 0 {
+				entry.msgstrEnd = end
+			}
+			if entry.msgid != nil {
+				f.entries = append(f.entries, entry)
+			}
+		}
+		entry, field = nil, nil
+	}
+	for i, line := range f.lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			finish(i)
+			continue
+		}
+		if entry == nil {
+			entry = &poEntry{start: i, flagsLine: -1, msgstrStart: -1, msgstrEnd: -1, msgstr: make(map[int]*string)}
+		}
+
+		// The msgstr fields end at the first line after them
+		if entry.msgstrStart >= 0 && entry.msgstrEnd < 0 && !strings.HasPrefix(line, "msgstr") && !strings.HasPrefix(line, `"`) {
+			entry.msgstrEnd = i
+		}
+
+		switch {
+		case strings.HasPrefix(line, "#~"):
+			entry.obsolete = true
+		case strings.HasPrefix(line, "#,"):
+			entry.flagsLine = i
+			for _, flag := range strings.Split(line[2:], ",") {
+				if flag = strings.TrimSpace(flag); flag != "" {
+					entry.flags = append(entry.flags, flag)
+				}
+			}
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, `"`):
+			if field == nil {
+				return nil, fmt.Errorf("line %d: string outside of a field", i+1)
+			}
+			s, err := unquotePO(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			*field += s
+		default:
+			keyword, value, _ := strings.Cut(line, " ")
+			s, err := unquotePO(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			field = &s
+			switch {
+			case keyword == "msgctxt":
+				entry.msgctxt = field
+			case keyword == "msgid":
+				entry.msgid = field
+			case keyword == "msgid_plural":
+				entry.msgidPlural = field
+			case keyword == "msgstr" || strings.HasPrefix(keyword, "msgstr["):
+				n := 0
+				if keyword != "msgstr" {
+					if n, err = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(keyword, "msgstr["), "]")); err != nil {
+						return nil, fmt.Errorf("line %d: invalid plural index", i+1)
+					}
+				}
+				if entry.msgstrStart < 0 {
+					entry.msgstrStart = i
+				}
+				entry.msgstr[n] = field
+			default:
+				return nil, fmt.Errorf("line %d: unknown keyword %q", i+1, keyword)
+			}
+		}
+	}
+	finish(len(f.lines))
+	return f, nil
+}
+
+// unquotePO decodes a quoted PO string
+func unquotePO(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("expected quoted string")
+	}
+	s = s[1 : len(s)-1]
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// poEscaper escapes the content of PO strings
+var poEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+
+// quotePO encodes a PO field. Multi-line values are split after each line
+// break, as gettext does.
+func quotePO(keyword, s string) []string {
+	parts := strings.SplitAfter(s, "\n")
+	if parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) <= 1 {
+		return []string{keyword + ` "` + poEscaper.Replace(s) + `"`}
+	}
+	lines := []string{keyword + ` ""`}
+	for _, part := range parts {
+		lines = append(lines, `"`+poEscaper.Replace(part)+`"`)
+	}
+	return lines
+}
+
+// configure implements resourceConfigurer
+func (f *poResource) configure(params url.Values) error {
+	switch mode := params.Get("po_mode"); mode {
+	case "":
+	case poModeUntranslated, poModeFuzzy, poModeAll:
+		f.mode = mode
+	default:
+		return fmt.Errorf("po_mode must be %q, %q or %q", poModeUntranslated, poModeFuzzy, poModeAll)
+	}
+	if v := params.Get("po_mark_fuzzy"); v != "" {
+		markFuzzy, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid po_mark_fuzzy: %v", err)
+		}
+		f.markFuzzy = markFuzzy
+	}
+	return nil
+}
+
+// units implements resourceFile. The header entry and obsolete entries
+// are never translated.
+func (f *poResource) units() []resourceUnit {
+	var units []resourceUnit
+	for _, e := range f.entries {
+		if e.obsolete || *e.msgid == "" || e.msgstrStart < 0 {
+			continue
+		}
+		switch {
+		case f.mode == poModeAll:
+		case f.mode == poModeFuzzy && e.fuzzy():
+		case !e.translated():
+		default:
+			continue
+		}
+
+		e := e
+		key := *e.msgid
+		if e.msgctxt != nil {
+			key = *e.msgctxt + "|" + key
+		}
+		units = append(units, resourceUnit{key: key, text: *e.msgid, set: func(translated string) { e.singular = &translated }})
+		if e.msgidPlural != nil && *e.msgidPlural != "" {
+			units = append(units, resourceUnit{key: key + "|plural", text: *e.msgidPlural, set: func(translated string) { e.plural = &translated }})
+		}
+	}
+	return units
+}
+
+// poHeaderLanguage matches the Language field of a PO header
+var poHeaderLanguage = regexp.MustCompile(`(?m)^Language: .*$`)
+
+// encode implements resourceFile. The header's Language is set to the
+// target language, and a POT file's charset to UTF-8.
+func (f *poResource) encode(sourceLang, targetLang string) ([]byte, error) {
+	// Replacement lines by line, applied back to front
+	type replacement struct {
+		start, end int
+		lines      []string
+	}
+	var replacements []replacement
+
+	for _, e := range f.entries {
+		if *e.msgid == "" && !e.obsolete && e.msgstrStart >= 0 && e.msgstr[0] != nil {
+			header := poHeaderLanguage.ReplaceAllString(*e.msgstr[0], "Language: "+targetLang)
+			header = strings.Replace(header, "charset=CHARSET", "charset=UTF-8", 1)
+			if header != *e.msgstr[0] {
+				replacements = append(replacements, replacement{e.msgstrStart, e.msgstrEnd, quotePO("msgstr", header)})
+			}
+			continue
+		}
+		if e.singular == nil {
+			continue
+		}
+
+		// Flag machine translations fuzzy when asked to. Otherwise the new
+		// translation replaces a fuzzy one, with its previous msgid.
+		flags := make([]string, 0, len(e.flags)+1)
+		for _, flag := range e.flags {
+			if flag != "fuzzy" {
+				flags = append(flags, flag)
+			}
+		}
+		if f.markFuzzy {
+			flags = append([]string{"fuzzy"}, flags...)
+		}
+		var flagLines []string
+		if len(flags) > 0 {
+			flagLines = []string{"#, " + strings.Join(flags, ", ")}
+		}
+		var msgstr []string
+		if e.msgidPlural == nil {
+			msgstr = quotePO("msgstr", *e.singular)
+		} else {
+			indexes := make([]int, 0, len(e.msgstr))
+			for n := range e.msgstr {
+				indexes = append(indexes, n)
+			}
+			sort.Ints(indexes)
+			if len(indexes) < 2 {
+				indexes = []int{0, 1}
+			}
+			plural := *e.singular
+			if e.plural != nil {
+				plural = *e.plural
+			}
+			for _, n := range indexes {
+				value := plural
+				if n == 0 {
+					value = *e.singular
+				}
+				msgstr = append(msgstr, quotePO(fmt.Sprintf("msgstr[%d]", n), value)...)
+			}
+		}
+
+		// Comments before the first field are kept, bar previous msgids
+		// when the fuzzy flag is dropped
+		var kept []string
+		first := e.start
+		for i := e.start; i < e.msgstrStart; i++ {
+			line := strings.TrimSpace(f.lines[i])
+			if !strings.HasPrefix(line, "#") {
+				break
+			}
+			first = i + 1
+			if i == e.flagsLine || (strings.HasPrefix(line, "#|") && !f.markFuzzy) {
+				continue
+			}
+			if strings.HasPrefix(line, "#|") && len(flagLines) > 0 {
+				kept = append(kept, flagLines...)
+				flagLines = nil
+			}
+			kept = append(kept, f.lines[i])
+		}
+		kept = append(kept, flagLines...)
+		replacements = append(replacements, replacement{e.start, first, kept})
+		replacements = append(replacements, replacement{e.msgstrStart, e.msgstrEnd, msgstr})
+	}
+
+	lines := f.lines
+	for i := len(replacements) - 1; i >= 0; i-- {
+		r := replacements[i]
+		tail := append(append([]string{}, r.lines...), lines[r.end:]...)
+		lines = append(lines[:r.start], tail...)
+	}
+	return []byte(strings.Join(lines, f.newline)), nil
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+)
+
+// testPO has a header, an untranslated entry, a fuzzy one, a translated one
+// with a context, an untranslated plural and an obsolete entry
+const testPO = `msgid ""
+msgstr ""
+"Language: en\n"
+"Content-Type: text/plain; charset=CHARSET\n"
+
+#: src/app.c:10
+msgid "Hello"
+msgstr ""
+
+#, fuzzy, c-format
+#| msgid "Save %s"
+msgid "Save %s now"
+msgstr "Jetzt %s speichern"
+
+msgctxt "menu"
+msgid "Open"
+msgstr "Öffnen"
+
+msgid "One file"
+msgid_plural "%d files"
+msgstr[0] ""
+msgstr[1] ""
+
+#~ msgid "Gone"
+#~ msgstr ""
+`
+
+func TestPOResource(t *testing.T) {
+	tests := []struct {
+		name   string
+		params url.Values
+		want   [][2]string
+		out    string
+	}{
+		{
+			name: "untranslated",
+			want: [][2]string{{"Hello", "Hello"}, {"One file", "One file"}, {"One file|plural", "%d files"}},
+			out: `msgid ""
+msgstr ""
+"Language: de\n"
+"Content-Type: text/plain; charset=UTF-8\n"
+
+#: src/app.c:10
+msgid "Hello"
+msgstr "[de] Hello"
+
+#, fuzzy, c-format
+#| msgid "Save %s"
+msgid "Save %s now"
+msgstr "Jetzt %s speichern"
+
+msgctxt "menu"
+msgid "Open"
+msgstr "Öffnen"
+
+msgid "One file"
+msgid_plural "%d files"
+msgstr[0] "[de] One file"
+msgstr[1] "[de] %d files"
+
+#~ msgid "Gone"
+#~ msgstr ""
+`,
+		},
+		{
+			name:   "fuzzy marked fuzzy",
+			params: url.Values{"po_mode": {"fuzzy"}, "po_mark_fuzzy": {"true"}},
+			want:   [][2]string{{"Hello", "Hello"}, {"Save %s now", "Save %s now"}, {"One file", "One file"}, {"One file|plural", "%d files"}},
+			out: `msgid ""
+msgstr ""
+"Language: de\n"
+"Content-Type: text/plain; charset=UTF-8\n"
+
+#: src/app.c:10
+#, fuzzy
+msgid "Hello"
+msgstr "[de] Hello"
+
+#, fuzzy, c-format
+#| msgid "Save %s"
+msgid "Save %s now"
+msgstr "[de] Save %s now"
+
+msgctxt "menu"
+msgid "Open"
+msgstr "Öffnen"
+
+#, fuzzy
+msgid "One file"
+msgid_plural "%d files"
+msgstr[0] "[de] One file"
+msgstr[1] "[de] %d files"
+
+#~ msgid "Gone"
+#~ msgstr ""
+`,
+		},
+		{
+			name:   "all",
+			params: url.Values{"po_mode": {"all"}},
+			want: [][2]string{
+				{"Hello", "Hello"}, {"Save %s now", "Save %s now"}, {"menu|Open", "Open"},
+				{"One file", "One file"}, {"One file|plural", "%d files"},
+			},
+			out: `msgid ""
+msgstr ""
+"Language: de\n"
+"Content-Type: text/plain; charset=UTF-8\n"
+
+#: src/app.c:10
+msgid "Hello"
+msgstr "[de] Hello"
+
+#, c-format
+msgid "Save %s now"
+msgstr "[de] Save %s now"
+
+msgctxt "menu"
+msgid "Open"
+msgstr "[de] Open"
+
+msgid "One file"
+msgid_plural "%d files"
+msgstr[0] "[de] One file"
+msgstr[1] "[de] %d files"
+
+#~ msgid "Gone"
+#~ msgstr ""
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := translateResource(t, parsePOResource, testPO, tt.params, tt.want); got != tt.out {
+				t.Errorf("encode() =\n%s\nwant\n%s", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestPOQuoting(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"single line", `Say "hi"`, []string{`msgstr "Say \"hi\""`}},
+		{"one line break", "Line\n", []string{`msgstr "Line\n"`}},
+		{"multi-line", "One\nTwo", []string{`msgstr ""`, `"One\n"`, `"Two"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := quotePO("msgstr", tt.text)
+			if len(lines) != len(tt.want) {
+				t.Fatalf("quotePO() = %q, want %q", lines, tt.want)
+			}
+			for i := range lines {
+				if lines[i] != tt.want[i] {
+					t.Errorf("quotePO() = %q, want %q", lines, tt.want)
+				}
+			}
+			// The value survives a round trip
+			var value string
+			for i, line := range lines {
+				if i == 0 {
+					line = line[len("msgstr "):]
+				}
+				s, err := unquotePO(line)
+				if err != nil {
+					t.Fatalf("unquotePO(%q) error = %v", line, err)
+				}
+				value += s
+			}
+			if value != tt.text {
+				t.Errorf("unquoted %q, want %q", value, tt.text)
+			}
+		})
+	}
+}
+
+func TestPOResourceErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unquoted", "msgid Hello\nmsgstr \"\"\n"},
+		{"unknown keyword", "msgid \"Hello\"\nmsgtext \"\"\n"},
+		{"string outside of a field", "\"Hello\"\n"},
+		{"invalid plural index", "msgid \"a\"\nmsgid_plural \"b\"\nmsgstr[x] \"\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parsePOResource([]byte(tt.data)); err == nil {
+				t.Error("parsePOResource() succeeded, want an error")
+			}
+		})
+	}
+}
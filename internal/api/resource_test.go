@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// translateResource parses data with parse, configured with params when
+// set, checks its strings are want, as key and text pairs, and returns the
+// file with every string translated to "[de] <text>"
+func translateResource(t *testing.T, parse func([]byte) (resourceFile, error), data string, params url.Values, want [][2]string) string {
+	t.Helper()
+	f, err := parse([]byte(data))
+	if err != nil {
+		t.Fatalf("parse error = %v", err)
+	}
+	if params != nil {
+		if err := f.(resourceConfigurer).configure(params); err != nil {
+			t.Fatalf("configure() error = %v", err)
+		}
+	}
+	var got [][2]string
+	for _, unit := range f.units() {
+		got = append(got, [2]string{unit.key, unit.text})
+		unit.set("[de] " + unit.text)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("units() = %q, want %q", got, want)
+	}
+	out, err := f.encode("en", "de")
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	return string(out)
+}
+
+func TestDetectResourceFormat(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     string
+		want     string
+	}{
+		{"messages.po", "", "po"},
+		{"template.pot", "", "po"},
+		{"Localizable.strings", "", "strings"},
+		{"Localizable.stringsdict", "", "stringsdict"},
+		{"app.xlf", "", "xliff"},
+		{"strings.xml", "", "android"},
+		{"en.json", "", "json"},
+		{"", "msgid \"\"\nmsgstr \"\"\n", "po"},
+		{"", "\"greeting\" = \"Hello\";", "strings"},
+		{"", "\xFF\xFE\"\x00", "strings"},
+		{"", `<?xml version="1.0"?><xliff version="1.2"></xliff>`, "xliff"},
+		{"", `<?xml version="1.0"?><plist version="1.0"><dict/></plist>`, "stringsdict"},
+		{"", `<resources></resources>`, "android"},
+		{"", `{"@@locale": "en"}`, "arb"},
+		{"", "greeting: Hello", "yaml"},
+	}
+	for _, tt := range tests {
+		if got := detectResourceFormat(tt.filename, []byte(tt.data)); got != tt.want {
+			t.Errorf("detectResourceFormat(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
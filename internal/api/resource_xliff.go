@@ -0,0 +1,237 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// XLIFF versions, as names of the xliff output
+const (
+	xliff12 = "xliff"
+	xliff20 = "xliff2"
+)
+
+// xliffResource is an XLIFF 1.2 or 2.0 file. Segments without a target
+// are translatable: their translation is written to a new or the empty
+// target, and the target language to the file.
+type xliffResource struct {
+	splicedResource
+	version2  bool
+	langSpans []int // Spans of the tags carrying the target language
+}
+
+// xliffSegment is a 1.2 trans-unit or 2.0 segment being parsed
+type xliffSegment struct {
+	id          string
+	translate   bool
+	sourceStart int // Content of the source
+	sourceEnd   int
+	afterSource int // Offset after the source's end tag
+	targetStart int // Target element, -1 without one
+	targetEnd   int
+	hasTarget   bool // The target has content
+}
+
+// parseXLIFFResource parses an XLIFF 1.2 or 2.0 file
+func parseXLIFFResource(data []byte) (resourceFile, error) {
+	f := &xliffResource{splicedResource: splicedResource{data: data}}
+	dec := newXMLDecoder(data)
+	var segment *xliffSegment
+	unitID, unitTranslate := "", true
+	segments := 0
+	for {
+		offset := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if end, ok := tok.(xml.EndElement); ok && segment != nil &&
+			(end.Name.Local == "trans-unit" && !f.version2 || end.Name.Local == "segment" && f.version2) {
+			if err := f.addSegment(segment); err != nil {
+				return nil, err
+			}
+			segment = nil
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch el.Name.Local {
+		case "xliff":
+			f.version2 = strings.HasPrefix(xmlAttr(el, "version"), "2")
+			if f.version2 {
+				f.langSpans = append(f.langSpans, f.keep(offset, int(dec.InputOffset())))
+			}
+		case "file":
+			if !f.version2 {
+				f.langSpans = append(f.langSpans, f.keep(offset, int(dec.InputOffset())))
+			}
+		case "trans-unit":
+			segment = &xliffSegment{id: xmlAttr(el, "id"), translate: xmlAttr(el, "translate") != "no", targetStart: -1}
+		case "unit":
+			unitID, unitTranslate, segments = xmlAttr(el, "id"), xmlAttr(el, "translate") != "no", 0
+		case "segment":
+			segments++
+			id := unitID
+			if segments > 1 {
+				id = fmt.Sprintf("%s/%d", unitID, segments)
+			}
+			segment = &xliffSegment{id: id, translate: unitTranslate, targetStart: -1}
+		case "alt-trans", "seg-source", "ignorable", "notes", "note":
+			// Suggestions, segmentation and comments aren't translated
+			if err := dec.Skip(); err != nil {
+				return nil, err
+			}
+		case "source":
+			if segment == nil {
+				break
+			}
+			start, end, _, err := xmlElementContent(dec, data)
+			if err != nil {
+				return nil, err
+			}
+			segment.sourceStart, segment.sourceEnd, segment.afterSource = start, end, int(dec.InputOffset())
+		case "target":
+			if segment == nil {
+				break
+			}
+			segment.targetStart = offset
+			start, end, ok, err := xmlElementContent(dec, data)
+			if err != nil {
+				return nil, err
+			}
+			segment.targetEnd = int(dec.InputOffset())
+			segment.hasTarget = ok && strings.TrimSpace(string(data[start:end])) != ""
+		}
+	}
+	return f, nil
+}
+
+// addSegment adds the string of a segment that needs translating
+func (f *xliffResource) addSegment(s *xliffSegment) error {
+	if !s.translate || s.hasTarget || s.sourceEnd <= s.sourceStart {
+		return nil
+	}
+	text, encode := decodeXMLText(string(f.data[s.sourceStart:s.sourceEnd]))
+	if strings.TrimSpace(markupMask.pattern.ReplaceAllString(text, "")) == "" {
+		return nil
+	}
+	element := func(translated string) string {
+		return "<target>" + encode(translated) + "</target>"
+	}
+	if s.targetStart < 0 {
+		// Insert a target after the source
+		f.add(s.id, s.afterSource, s.afterSource, text, element)
+	} else {
+		f.add(s.id, s.targetStart, s.targetEnd, text, element)
+	}
+	return nil
+}
+
+// encode implements resourceFile
+func (f *xliffResource) encode(sourceLang, targetLang string) ([]byte, error) {
+	attr := "target-language"
+	if f.version2 {
+		attr = "trgLang"
+	}
+	for _, i := range f.langSpans {
+		f.values[i] = setXMLAttr(f.values[i], attr, targetLang)
+	}
+	return f.splicedResource.encode(sourceLang, targetLang)
+}
+
+// setXMLAttr sets an attribute in the raw start tag of an element
+func setXMLAttr(tag, name, value string) string {
+	value = html.EscapeString(value)
+	pattern := regexp.MustCompile(`(\s` + regexp.QuoteMeta(name) + `\s*=\s*)("[^"]*"|'[^']*')`)
+	if pattern.MatchString(tag) {
+		return pattern.ReplaceAllLiteralString(tag, " "+name+`="`+value+`"`)
+	}
+	end := strings.TrimSuffix(strings.TrimSuffix(tag, ">"), "/")
+	return end + " " + name + `="` + value + `"` + tag[len(end):]
+}
+
+// xliffUnit is a string of an XLIFF export
+type xliffUnit struct {
+	key, source, target string
+}
+
+// XLIFF 1.2 export documents
+type (
+	xliff12Doc struct {
+		XMLName xml.Name    `xml:"urn:oasis:names:tc:xliff:document:1.2 xliff"`
+		Version string      `xml:"version,attr"`
+		File    xliff12File `xml:"file"`
+	}
+	xliff12File struct {
+		Original       string        `xml:"original,attr"`
+		SourceLanguage string        `xml:"source-language,attr"`
+		TargetLanguage string        `xml:"target-language,attr"`
+		Datatype       string        `xml:"datatype,attr"`
+		Units          []xliff12Unit `xml:"body>trans-unit"`
+	}
+	xliff12Unit struct {
+		ID      string `xml:"id,attr"`
+		Resname string `xml:"resname,attr,omitempty"`
+		Source  string `xml:"source"`
+		Target  string `xml:"target"`
+	}
+)
+
+// XLIFF 2.0 export documents
+type (
+	xliff20Doc struct {
+		XMLName xml.Name    `xml:"urn:oasis:names:tc:xliff:document:2.0 xliff"`
+		Version string      `xml:"version,attr"`
+		SrcLang string      `xml:"srcLang,attr"`
+		TrgLang string      `xml:"trgLang,attr"`
+		File    xliff20File `xml:"file"`
+	}
+	xliff20File struct {
+		ID       string        `xml:"id,attr"`
+		Original string        `xml:"original,attr,omitempty"`
+		Units    []xliff20Unit `xml:"unit"`
+	}
+	xliff20Unit struct {
+		ID     string `xml:"id,attr"`
+		Name   string `xml:"name,attr,omitempty"`
+		Source string `xml:"segment>source"`
+		Target string `xml:"segment>target"`
+	}
+)
+
+// encodeXLIFF exports the strings of the file original as an XLIFF
+// document of the given version
+func encodeXLIFF(version, original, sourceLang, targetLang string, units []xliffUnit) ([]byte, error) {
+	if original == "" {
+		original = "resource"
+	}
+	var doc interface{}
+	if version == xliff20 {
+		d := xliff20Doc{Version: "2.0", SrcLang: sourceLang, TrgLang: targetLang, File: xliff20File{ID: "f1", Original: original}}
+		for i, u := range units {
+			d.File.Units = append(d.File.Units, xliff20Unit{ID: fmt.Sprint(i + 1), Name: u.key, Source: u.source, Target: u.target})
+		}
+		doc = d
+	} else {
+		d := xliff12Doc{Version: "1.2", File: xliff12File{Original: original, SourceLanguage: sourceLang, TargetLanguage: targetLang, Datatype: "plaintext"}}
+		for i, u := range units {
+			d.File.Units = append(d.File.Units, xliff12Unit{ID: fmt.Sprint(i + 1), Resname: u.key, Source: u.source, Target: u.target})
+		}
+		doc = d
+	}
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
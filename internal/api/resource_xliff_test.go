@@ -0,0 +1,173 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestXLIFFResource(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want [][2]string
+		out  string
+	}{
+		{
+			name: "1.2",
+			data: `<?xml version="1.0" encoding="UTF-8"?>
+<xliff version="1.2" xmlns="urn:oasis:names:tc:xliff:document:1.2">
+  <file original="app" source-language="en" datatype="plaintext">
+    <body>
+      <trans-unit id="greeting">
+        <source>Hello &amp; welcome</source>
+      </trans-unit>
+      <trans-unit id="empty">
+        <source>Save</source>
+        <target></target>
+      </trans-unit>
+      <trans-unit id="done">
+        <source>Open</source>
+        <target>Öffnen</target>
+      </trans-unit>
+      <trans-unit id="brand" translate="no">
+        <source>Acme</source>
+      </trans-unit>
+      <trans-unit id="link">
+        <source>Read the <g id="1">terms</g></source>
+        <alt-trans><target>Alt</target></alt-trans>
+      </trans-unit>
+    </body>
+  </file>
+</xliff>`,
+			want: [][2]string{{"greeting", "Hello & welcome"}, {"empty", "Save"}, {"link", "Read the __TAG_0__terms__TAG_1__"}},
+			out: `<?xml version="1.0" encoding="UTF-8"?>
+<xliff version="1.2" xmlns="urn:oasis:names:tc:xliff:document:1.2">
+  <file original="app" source-language="en" datatype="plaintext" target-language="de">
+    <body>
+      <trans-unit id="greeting">
+        <source>Hello &amp; welcome</source><target>[de] Hello &amp; welcome</target>
+      </trans-unit>
+      <trans-unit id="empty">
+        <source>Save</source>
+        <target>[de] Save</target>
+      </trans-unit>
+      <trans-unit id="done">
+        <source>Open</source>
+        <target>Öffnen</target>
+      </trans-unit>
+      <trans-unit id="brand" translate="no">
+        <source>Acme</source>
+      </trans-unit>
+      <trans-unit id="link">
+        <source>Read the <g id="1">terms</g></source><target>[de] Read the <g id="1">terms</g></target>
+        <alt-trans><target>Alt</target></alt-trans>
+      </trans-unit>
+    </body>
+  </file>
+</xliff>`,
+		},
+		{
+			name: "2.0",
+			data: `<xliff version="2.0" xmlns="urn:oasis:names:tc:xliff:document:2.0" srcLang="en" trgLang="fr">
+  <file id="f1">
+    <unit id="intro">
+      <segment><source>First.</source></segment>
+      <segment><source>Second.</source><target>Zweitens.</target></segment>
+      <segment><source>Third.</source></segment>
+    </unit>
+    <unit id="code" translate="no">
+      <segment><source>x = 1</source></segment>
+    </unit>
+  </file>
+</xliff>`,
+			want: [][2]string{{"intro", "First."}, {"intro/3", "Third."}},
+			out: `<xliff version="2.0" xmlns="urn:oasis:names:tc:xliff:document:2.0" srcLang="en" trgLang="de">
+  <file id="f1">
+    <unit id="intro">
+      <segment><source>First.</source><target>[de] First.</target></segment>
+      <segment><source>Second.</source><target>Zweitens.</target></segment>
+      <segment><source>Third.</source><target>[de] Third.</target></segment>
+    </unit>
+    <unit id="code" translate="no">
+      <segment><source>x = 1</source></segment>
+    </unit>
+  </file>
+</xliff>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := translateResource(t, parseXLIFFResource, tt.data, nil, tt.want); got != tt.out {
+				t.Errorf("encode() =\n%s\nwant\n%s", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestSetXMLAttr(t *testing.T) {
+	tests := []struct {
+		tag, name, value string
+		want             string
+	}{
+		{`<file original="a">`, "target-language", "de", `<file original="a" target-language="de">`},
+		{`<file target-language='fr' original="a">`, "target-language", "de", `<file target-language="de" original="a">`},
+		{`<file/>`, "target-language", "pt-BR", `<file target-language="pt-BR"/>`},
+		{`<file>`, "original", `a&b`, `<file original="a&amp;b">`},
+	}
+	for _, tt := range tests {
+		if got := setXMLAttr(tt.tag, tt.name, tt.value); got != tt.want {
+			t.Errorf("setXMLAttr(%q, %q, %q) = %q, want %q", tt.tag, tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeXLIFF(t *testing.T) {
+	units := []xliffUnit{{key: "greeting", source: "Hello", target: "Hallo"}}
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{xliff12, `<?xml version="1.0" encoding="UTF-8"?>
+<xliff xmlns="urn:oasis:names:tc:xliff:document:1.2" version="1.2">
+  <file original="resource" source-language="en" target-language="de" datatype="plaintext">
+    <body>
+      <trans-unit id="1" resname="greeting">
+        <source>Hello</source>
+        <target>Hallo</target>
+      </trans-unit>
+    </body>
+  </file>
+</xliff>
+`},
+		{xliff20, `<?xml version="1.0" encoding="UTF-8"?>
+<xliff xmlns="urn:oasis:names:tc:xliff:document:2.0" version="2.0" srcLang="en" trgLang="de">
+  <file id="f1" original="resource">
+    <unit id="1" name="greeting">
+      <segment>
+        <source>Hello</source>
+        <target>Hallo</target>
+      </segment>
+    </unit>
+  </file>
+</xliff>
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			out, err := encodeXLIFF(tt.version, "", "en", "de", units)
+			if err != nil {
+				t.Fatalf("encodeXLIFF() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("encodeXLIFF() =\n%s\nwant\n%s", out, tt.want)
+			}
+			// Exports parse back with their strings translated
+			f, err := parseXLIFFResource(out)
+			if err != nil {
+				t.Fatalf("parseXLIFFResource() error = %v", err)
+			}
+			if units := f.units(); len(units) != 0 {
+				t.Errorf("units() of a translated export = %d, want none", len(units))
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// xmlMarkup matches the markup within the content of an XML element
+var xmlMarkup = regexp.MustCompile(`<!\[CDATA\[[\s\S]*?\]\]>|<[^>]*>`)
+
+// xmlTextEscaper escapes text for the content of an XML element
+var xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// xmlElementContent consumes the element whose start tag dec has just read,
+// returning the byte range of its content within data. ok is false for
+// empty elements.
+func xmlElementContent(dec *xml.Decoder, data []byte) (start, end int, ok bool, err error) {
+	start = int(dec.InputOffset())
+	empty := bytes.HasSuffix(data[:start], []byte("/>"))
+	if err := dec.Skip(); err != nil {
+		return 0, 0, false, err
+	}
+	if empty {
+		return 0, 0, false, nil
+	}
+	end = bytes.LastIndex(data[:dec.InputOffset()], []byte("</"))
+	return start, end, end > start, nil
+}
+
+// newXMLDecoder returns a decoder for a resource file, which understands
+// HTML entities such as &nbsp; too
+func newXMLDecoder(data []byte) *xml.Decoder {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Entity = xml.HTMLEntity
+	return dec
+}
+
+// parseAndroidResource parses an Android strings.xml file. The strings,
+// string-array items and plurals items not marked translatable="false" are
+// translatable.
+func parseAndroidResource(data []byte) (resourceFile, error) {
+	f := &splicedResource{data: data}
+	dec := newXMLDecoder(data)
+	inArray := false // Within a translatable string-array or plurals
+	var arrayName string
+	var arrayItems int
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "string-array", "plurals":
+				if !androidTranslatable(t) {
+					if err := dec.Skip(); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				inArray = true
+				arrayName, arrayItems = xmlAttr(t, "name"), 0
+			case "string", "item":
+				// Items are keyed by their quantity or index
+				key := xmlAttr(t, "name")
+				if t.Name.Local == "item" {
+					if !inArray {
+						continue
+					}
+					index := xmlAttr(t, "quantity")
+					if index == "" {
+						index = strconv.Itoa(arrayItems)
+					}
+					key = arrayName + "[" + index + "]"
+					arrayItems++
+				}
+				if !androidTranslatable(t) {
+					if err := dec.Skip(); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				start, end, ok, err := xmlElementContent(dec, data)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					text, encode := decodeAndroidString(string(data[start:end]))
+					if strings.TrimSpace(markupMask.pattern.ReplaceAllString(text, "")) != "" {
+						f.add(key, start, end, text, encode)
+					}
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "string-array" || t.Name.Local == "plurals" {
+				inArray = false
+			}
+		}
+	}
+	return f, nil
+}
+
+// xmlAttr returns the value of an element's attribute, ignoring its
+// namespace
+func xmlAttr(el xml.StartElement, name string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// androidTranslatable reports whether an element isn't marked
+// translatable="false"
+func androidTranslatable(el xml.StartElement) bool {
+	return xmlAttr(el, "translatable") != "false"
+}
+
+// decodeXMLText returns the text of the raw content of an XML element, with
+// its markup masked, and the function encoding a translation of it back to
+// raw content
+func decodeXMLText(raw string) (string, func(string) string) {
+	var tags []string
+	masked := xmlMarkup.ReplaceAllStringFunc(raw, func(tag string) string {
+		tags = append(tags, tag)
+		return markupMask.token(len(tags) - 1)
+	})
+	return html.UnescapeString(masked), func(translated string) string {
+		restored, _ := markupMask.restore(xmlTextEscaper.Replace(translated), tags)
+		return restored
+	}
+}
+
+// decodeAndroidString returns the text of the raw content of an Android
+// string, with its markup masked, and the function encoding a translation
+// of it back to raw content
+func decodeAndroidString(raw string) (string, func(string) string) {
+	text, encode := decodeXMLText(raw)
+
+	// A string in double quotes keeps its whitespace
+	quoted := len(text) >= 2 && strings.HasPrefix(text, `"`) && strings.HasSuffix(text, `"`) && !strings.HasSuffix(text, `\"`)
+	if quoted {
+		text = text[1 : len(text)-1]
+	}
+	text = unescapeAndroid(text)
+
+	return text, func(translated string) string {
+		translated = escapeAndroid(translated)
+		if quoted {
+			translated = `"` + translated + `"`
+		}
+		return encode(translated)
+	}
+}
+
+// unescapeAndroid resolves the backslash escapes of Android strings
+func unescapeAndroid(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// androidEscaper escapes the characters Android strings need escaped
+var androidEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `'`, `\'`, "\n", `\n`, "\t", `\t`)
+
+// escapeAndroid escapes s for an Android string
+func escapeAndroid(s string) string {
+	s = androidEscaper.Replace(s)
+	if strings.HasPrefix(s, "@") || strings.HasPrefix(s, "?") {
+		// Would otherwise be read as a resource reference
+		s = `\` + s
+	}
+	return s
+}
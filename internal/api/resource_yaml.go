@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlResource is a YAML resource file, such as a Rails locale file. Every
+// string value is translatable; comments and key order are kept.
+type yamlResource struct {
+	doc yaml.Node
+}
+
+// parseYAMLResource parses a YAML resource file
+func parseYAMLResource(data []byte) (resourceFile, error) {
+	f := &yamlResource{}
+	if err := yaml.Unmarshal(data, &f.doc); err != nil {
+		return nil, err
+	}
+	if f.doc.Kind != yaml.DocumentNode || len(f.doc.Content) == 0 || f.doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("top level must be a mapping")
+	}
+	return f, nil
+}
+
+// units implements resourceFile
+func (f *yamlResource) units() []resourceUnit {
+	var units []resourceUnit
+	var walk func(n *yaml.Node, path string)
+	walk = func(n *yaml.Node, path string) {
+		switch n.Kind {
+		case yaml.MappingNode:
+			// Keys alternate with their values
+			for i := 1; i < len(n.Content); i += 2 {
+				key := n.Content[i-1].Value
+				if path != "" {
+					key = path + "." + key
+				}
+				walk(n.Content[i], key)
+			}
+		case yaml.SequenceNode:
+			for i, item := range n.Content {
+				walk(item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		case yaml.ScalarNode:
+			if n.ShortTag() == "!!str" && strings.TrimSpace(n.Value) != "" {
+				units = append(units, resourceUnit{key: path, text: n.Value, set: func(translated string) { n.Value = translated }})
+			}
+		}
+	}
+	walk(f.doc.Content[0], "")
+	return units
+}
+
+// encode implements resourceFile. A Rails-style root key naming the source
+// language is renamed to the target language.
+func (f *yamlResource) encode(sourceLang, targetLang string) ([]byte, error) {
+	root := f.doc.Content[0]
+	if len(root.Content) == 2 && sourceLang != "" && strings.EqualFold(root.Content[0].Value, sourceLang) {
+		root.Content[0].Value = targetLang
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&f.doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,379 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis keys of the review queue
+const (
+	reviewQueueKey     = "reviews:queue" // Sorted set of pending review IDs, scored by when they were queued in microseconds
+	reviewRecordPrefix = "reviews:id:"   // reviews:id:<id> holds the JSON Review, expiring after REVIEW_RETENTION
+)
+
+// Bounds of review listings
+const (
+	reviewDefaultLimit = 50
+	reviewMaxLimit     = 500
+	reviewScanBatch    = 200 // Queued IDs loaded per round trip while filtering
+)
+
+// Reasons translations are queued for review
+const (
+	reviewReasonRequested     = "requested"      // The request asked for a review
+	reviewReasonLowConfidence = "low_confidence" // The source language was detected under REVIEW_MIN_CONFIDENCE
+	reviewReasonLowQuality    = "low_quality"    // The quality estimation scored at most REVIEW_MAX_QUALITY
+)
+
+// tmOriginReview is the origin of translation memory entries approved or
+// corrected in the review queue
+const tmOriginReview = "review"
+
+// errReviewNotFound is returned for reviews not in the queue
+var errReviewNotFound = errors.New("review not found")
+
+// Review is a translation waiting for a reviewer to approve or correct it
+type Review struct {
+	ID                  string    `json:"id"`
+	CreatedAt           time.Time `json:"created_at"`
+	Reasons             []string  `json:"reasons"`
+	KeyName             string    `json:"key_name,omitempty"`
+	SourceLang          string    `json:"source_lang"`
+	TargetLang          string    `json:"target_lang"`
+	SourceText          string    `json:"source_text"`
+	TranslatedText      string    `json:"translated_text"`
+	Provider            string    `json:"provider"`
+	DetectionConfidence float64   `json:"detection_confidence,omitempty"`
+	QualityScore        *float64  `json:"quality_score,omitempty"`
+}
+
+// ReviewListResponse is the body returned by GET /admin/reviews
+type ReviewListResponse struct {
+	Reviews    []Review `json:"reviews"`
+	NextCursor string   `json:"next_cursor,omitempty"` // Pass as cursor for the next, newer page
+}
+
+// ReviewDecisionRequest is the body of POST /admin/reviews/<id>
+type ReviewDecisionRequest struct {
+	Translation string `json:"translation,omitempty"` // The corrected translation, approving the queued one when empty
+	Reviewer    string `json:"reviewer,omitempty"`    // Who reviewed it, for the log
+}
+
+// reviewID returns the ID of the review of text between two languages, so
+// a text is queued once
+func reviewID(sourceLang, targetLang, text string) string {
+	sum := sha256.Sum256([]byte(sourceLang + "\x00" + targetLang + "\x00" + text))
+	return hex.EncodeToString(sum[:16])
+}
+
+// flagForReview queues a translation for review when the request asked for
+// one or its detected source language is under REVIEW_MIN_CONFIDENCE.
+// Translation memory matches and untranslated texts are never queued.
+func (s *Server) flagForReview(ctx context.Context, req TranslationRequest, response *TranslationResponse) {
+	if response.Memory != nil || response.Skipped || response.SourceLang == "" {
+		return
+	}
+	var reasons []string
+	if req.Review {
+		reasons = append(reasons, reviewReasonRequested)
+	}
+	if threshold := s.currentConfig().ReviewMinConfidence; req.SourceLang == "" && response.DetectionConfidence > 0 && response.DetectionConfidence < threshold {
+		reasons = append(reasons, reviewReasonLowConfidence)
+	}
+	if len(reasons) == 0 {
+		return
+	}
+	s.queueReview(ctx, Review{
+		CreatedAt:           time.Now().UTC(),
+		Reasons:             reasons,
+		KeyName:             apiKeyName(ctx),
+		SourceLang:          response.SourceLang,
+		TargetLang:          response.TargetLang,
+		SourceText:          req.Text,
+		TranslatedText:      response.TranslatedText,
+		Provider:            response.Provider,
+		DetectionConfidence: response.DetectionConfidence,
+	})
+}
+
+// queueReview adds a review to the queue. A text already queued keeps its
+// translation, gaining the new reasons. Reviews are lost while Redis is
+// down.
+func (s *Server) queueReview(ctx context.Context, review Review) {
+	if !s.redisAvailable() {
+		return
+	}
+	review.ID = reviewID(review.SourceLang, review.TargetLang, review.SourceText)
+	key := reviewRecordPrefix + review.ID
+	added := review.Reasons
+	ttl := s.config.ReviewRetention
+	existing, err := s.getReview(ctx, review.ID)
+	switch {
+	case err == nil:
+		added = nil
+		for _, reason := range review.Reasons {
+			if !slices.Contains(existing.Reasons, reason) {
+				added = append(added, reason)
+			}
+		}
+		if len(added) == 0 {
+			return
+		}
+		existing.Reasons = append(existing.Reasons, added...)
+		if existing.QualityScore == nil {
+			existing.QualityScore = review.QualityScore
+		}
+		review, ttl = *existing, redis.KeepTTL
+	case !errors.Is(err, errReviewNotFound):
+		logger(ctx).Warn("failed to queue translation for review", "error", err)
+		return
+	}
+
+	data, err := json.Marshal(review)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-s.config.ReviewRetention).UnixMicro()
+	pipe := s.redis.TxPipeline()
+	pipe.Set(ctx, key, data, ttl)
+	pipe.ZAdd(ctx, reviewQueueKey, &redis.Z{Score: float64(review.CreatedAt.UnixMicro()), Member: review.ID})
+	pipe.ZRemRangeByScore(ctx, reviewQueueKey, "-inf", "("+strconv.FormatInt(cutoff, 10))
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger(ctx).Warn("failed to queue translation for review", "error", err)
+		return
+	}
+	for _, reason := range added {
+		reviewsQueued.WithLabelValues(reason).Inc()
+	}
+}
+
+// getReview loads a queued review
+func (s *Server) getReview(ctx context.Context, id string) (*Review, error) {
+	data, err := s.redis.Get(ctx, reviewRecordPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, errReviewNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var review Review
+	if err := json.Unmarshal(data, &review); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal review: %v", err)
+	}
+	return &review, nil
+}
+
+// removeReview takes a review off the queue
+func (s *Server) removeReview(ctx context.Context, id string) error {
+	pipe := s.redis.TxPipeline()
+	del := pipe.Del(ctx, reviewRecordPrefix+id)
+	pipe.ZRem(ctx, reviewQueueKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if del.Val() == 0 {
+		return errReviewNotFound
+	}
+	return nil
+}
+
+// reviewFilter is a parsed GET /admin/reviews query
+type reviewFilter struct {
+	sourceLang, targetLang, reason string
+	after                          string // Cursor, the queue score of the last review of the previous page
+	limit                          int
+}
+
+// matches reports whether a review passes the filter
+func (f reviewFilter) matches(review Review) bool {
+	return (f.sourceLang == "" || strings.EqualFold(review.SourceLang, f.sourceLang)) &&
+		(f.targetLang == "" || strings.EqualFold(review.TargetLang, f.targetLang)) &&
+		(f.reason == "" || slices.Contains(review.Reasons, f.reason))
+}
+
+// parseReviewFilter parses the filters, cursor and limit of a review listing
+func parseReviewFilter(params url.Values) (reviewFilter, error) {
+	f := reviewFilter{sourceLang: params.Get("source_lang"), targetLang: params.Get("target_lang"), reason: params.Get("reason"), limit: reviewDefaultLimit}
+	switch f.reason {
+	case "", reviewReasonRequested, reviewReasonLowConfidence, reviewReasonLowQuality:
+	default:
+		return f, fmt.Errorf("reason must be %s, %s or %s", reviewReasonRequested, reviewReasonLowConfidence, reviewReasonLowQuality)
+	}
+	if v := params.Get("cursor"); v != "" {
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return f, errors.New("invalid cursor")
+		}
+		f.after = v
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > reviewMaxLimit {
+			return f, fmt.Errorf("limit must be between 1 and %d", reviewMaxLimit)
+		}
+		f.limit = n
+	}
+	return f, nil
+}
+
+// listReviews returns a page of the queued reviews passing f, oldest first.
+// IDs whose review expired are dropped from the queue on the way.
+func (s *Server) listReviews(ctx context.Context, f reviewFilter) (*ReviewListResponse, error) {
+	response := &ReviewListResponse{Reviews: []Review{}}
+	min := "-inf"
+	if f.after != "" {
+		min = "(" + f.after
+	}
+	for {
+		queued, err := s.redis.ZRangeByScoreWithScores(ctx, reviewQueueKey, &redis.ZRangeBy{Min: min, Max: "+inf", Count: reviewScanBatch}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(queued) == 0 {
+			return response, nil
+		}
+		keys := make([]string, len(queued))
+		for i, z := range queued {
+			keys[i] = reviewRecordPrefix + z.Member.(string)
+		}
+		values, err := s.redis.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		var expired []interface{}
+		for i, value := range values {
+			data, ok := value.(string)
+			if !ok {
+				expired = append(expired, queued[i].Member)
+				continue
+			}
+			var review Review
+			if err := json.Unmarshal([]byte(data), &review); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal review: %v", err)
+			}
+			if !f.matches(review) {
+				continue
+			}
+			if len(response.Reviews) == f.limit {
+				// One more review tells there is a next page
+				response.NextCursor = strconv.FormatInt(response.Reviews[f.limit-1].CreatedAt.UnixMicro(), 10)
+				break
+			}
+			response.Reviews = append(response.Reviews, review)
+		}
+		if len(expired) > 0 {
+			s.redis.ZRem(ctx, reviewQueueKey, expired...)
+		}
+		if response.NextCursor != "" || len(queued) < reviewScanBatch {
+			return response, nil
+		}
+		min = "(" + strconv.FormatInt(int64(queued[len(queued)-1].Score), 10)
+	}
+}
+
+// decideReview approves or corrects a review into the translation memory as
+// a human translation, taking it off the queue
+func (s *Server) decideReview(ctx context.Context, w http.ResponseWriter, review *Review, decision ReviewDecisionRequest) (*TMEntry, bool) {
+	outcome := "approved"
+	translation := review.TranslatedText
+	if corrected := strings.TrimSpace(decision.Translation); corrected != "" && decision.Translation != review.TranslatedText {
+		outcome, translation = "corrected", decision.Translation
+	}
+	stored, ok := s.putTMEntry(ctx, w, TMEntry{
+		SourceLang: strings.ToLower(review.SourceLang),
+		TargetLang: strings.ToLower(review.TargetLang),
+		SourceText: review.SourceText,
+		TargetText: translation,
+		Provenance: tmProvenanceHuman,
+		Origin:     tmOriginReview,
+		UpdatedAt:  time.Now().UTC(),
+	})
+	if !ok {
+		return nil, false
+	}
+	if err := s.removeReview(ctx, review.ID); err != nil && !errors.Is(err, errReviewNotFound) {
+		logger(ctx).Warn("failed to remove decided review from the queue", "id", review.ID, "error", err)
+	}
+	reviewDecisions.WithLabelValues(outcome).Inc()
+	logger(ctx).Info("reviewed translation", "id", review.ID, "outcome", outcome, "reviewer", decision.Reviewer)
+	return stored, true
+}
+
+// handleAdminReviews serves the review queue:
+//
+//	GET    /admin/reviews?source_lang=en&target_lang=de&reason=low_quality  list queued reviews, oldest first
+//	GET    /admin/reviews/<id>                                              show a review
+//	POST   /admin/reviews/<id>                                              approve or correct it into the translation memory
+//	DELETE /admin/reviews/<id>                                              dismiss it
+func (s *Server) handleAdminReviews(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+	ctx := r.Context()
+	if !s.redisAvailable() {
+		writeError(w, http.StatusServiceUnavailable, codeServiceUnavailable, "Review queue unavailable: Redis is down")
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/reviews"), "/")
+
+	var response interface{}
+	var err error
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		filter, ferr := parseReviewFilter(r.URL.Query())
+		if ferr != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", ferr))
+			return
+		}
+		response, err = s.listReviews(ctx, filter)
+	case id != "" && r.Method == http.MethodGet:
+		response, err = s.getReview(ctx, id)
+	case id != "" && r.Method == http.MethodPost:
+		var decision ReviewDecisionRequest
+		if !decodeRequestBody(w, r, &decision) {
+			return
+		}
+		var review *Review
+		if review, err = s.getReview(ctx, id); err == nil {
+			stored, ok := s.decideReview(ctx, w, review, decision)
+			if !ok {
+				return
+			}
+			response = stored
+		}
+	case id != "" && r.Method == http.MethodDelete:
+		err = s.removeReview(ctx, id)
+		if err == nil {
+			reviewDecisions.WithLabelValues("dismissed").Inc()
+			logger(ctx).Info("dismissed review", "id", id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err == errReviewNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Review queue operation failed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// defaultHTMLAllowedTags are the elements kept in HTML translations when
+// HTML_ALLOWED_TAGS is unset: text-level and structural markup without
+// scripting, forms or embedded content other than images
+var defaultHTMLAllowedTags = []string{
+	"a", "abbr", "b", "bdi", "bdo", "blockquote", "br", "caption", "cite", "code", "col", "colgroup",
+	"dd", "del", "dfn", "div", "dl", "dt", "em", "figcaption", "figure", "h1", "h2", "h3", "h4", "h5", "h6",
+	"hr", "i", "img", "ins", "kbd", "li", "mark", "ol", "p", "pre", "q", "s", "samp", "small", "span",
+	"strong", "sub", "sup", "table", "tbody", "td", "tfoot", "th", "thead", "time", "tr", "u", "ul", "var", "wbr",
+}
+
+// defaultHTMLAllowedAttributes are the attributes kept on allowed elements
+// when HTML_ALLOWED_ATTRIBUTES is unset
+var defaultHTMLAllowedAttributes = []string{
+	"alt", "cite", "class", "colspan", "datetime", "dir", "height", "href", "hreflang", "id", "lang",
+	"rel", "rowspan", "span", "src", "start", "target", "title", "translate", "width",
+}
+
+// htmlDroppedContent are the elements removed with their content when they
+// aren't allowed, rather than unwrapped: their content is code, or isn't
+// text read in place
+var htmlDroppedContent = map[string]bool{
+	"applet": true, "embed": true, "head": true, "iframe": true, "math": true, "noembed": true,
+	"noframes": true, "noscript": true, "object": true, "plaintext": true, "script": true,
+	"select": true, "style": true, "svg": true, "template": true, "textarea": true, "title": true, "xmp": true,
+}
+
+// htmlURLAttributes are the attributes holding URLs, whose schemes are
+// checked
+var htmlURLAttributes = map[string]bool{
+	"action": true, "background": true, "cite": true, "formaction": true, "href": true,
+	"longdesc": true, "poster": true, "src": true, "usemap": true, "xlink:href": true,
+}
+
+// htmlAllowedSchemes are the URL schemes kept in URL attributes; relative
+// URLs are kept too
+var htmlAllowedSchemes = map[string]bool{"http": true, "https": true, "mailto": true, "tel": true}
+
+// htmlPolicy is the allowlist HTML translations are sanitized against
+type htmlPolicy struct {
+	tags              map[string]bool
+	attributes        map[string]bool
+	attributePrefixes []string // Of allowed attributes written as data-*
+}
+
+// newHTMLPolicy returns the allowlist of HTML_ALLOWED_TAGS and
+// HTML_ALLOWED_ATTRIBUTES, or nil when HTML_SANITIZATION is off
+func newHTMLPolicy(config Config) *htmlPolicy {
+	if !config.HTMLSanitization {
+		return nil
+	}
+	tags, attributes := config.HTMLAllowedTags, config.HTMLAllowedAttributes
+	if len(tags) == 0 {
+		tags = defaultHTMLAllowedTags
+	}
+	if len(attributes) == 0 {
+		attributes = defaultHTMLAllowedAttributes
+	}
+	p := &htmlPolicy{tags: make(map[string]bool, len(tags)), attributes: make(map[string]bool, len(attributes))}
+	for _, tag := range tags {
+		p.tags[strings.ToLower(tag)] = true
+	}
+	for _, attribute := range attributes {
+		attribute = strings.ToLower(attribute)
+		if prefix, ok := strings.CutSuffix(attribute, "*"); ok {
+			p.attributePrefixes = append(p.attributePrefixes, prefix)
+		} else {
+			p.attributes[attribute] = true
+		}
+	}
+	return p
+}
+
+// sanitizeTranslation sanitizes the translation of an HTML request against
+// HTML_ALLOWED_TAGS and HTML_ALLOWED_ATTRIBUTES, as providers may return
+// markup the text didn't have
+func (s *Server) sanitizeTranslation(ctx context.Context, req TranslationRequest, response *TranslationResponse) {
+	if s.htmlPolicy == nil || req.Format != provider.FormatHTML {
+		return
+	}
+	text, elements, attributes := s.htmlPolicy.sanitize(response.TranslatedText)
+	if elements == 0 && attributes == 0 {
+		return
+	}
+	response.TranslatedText = text
+	htmlSanitized.WithLabelValues("element").Add(float64(elements))
+	htmlSanitized.WithLabelValues("attribute").Add(float64(attributes))
+	logger(ctx).Warn("removed disallowed markup from a translation", "provider", response.Provider, "elements", elements, "attributes", attributes)
+}
+
+// sanitize removes the elements and attributes of text the policy doesn't
+// allow, returning the text and how many of each it removed. Disallowed
+// elements are unwrapped, keeping their text, except for those of
+// htmlDroppedContent; comments and doctypes are removed. Event handlers and
+// URLs of other schemes than htmlAllowedSchemes are removed whatever the
+// policy. Markup left as it was is kept as written.
+func (p *htmlPolicy) sanitize(text string) (string, int, int) {
+	var b strings.Builder
+	elements, attributes := 0, 0
+	// The element whose content is being dropped, and how deep within it
+	dropping, depth := "", 0
+
+	z := html.NewTokenizer(strings.NewReader(text))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// The end of the text; reading a string never fails otherwise
+			break
+		}
+		raw := string(z.Raw())
+		token := z.Token()
+
+		if dropping != "" {
+			switch {
+			case tt == html.StartTagToken && token.Data == dropping:
+				depth++
+			case tt == html.EndTagToken && token.Data == dropping:
+				if depth--; depth == 0 {
+					dropping = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			// Text the tokenizer read raw, as within an unwrapped element,
+			// may hold markup
+			b.WriteString(strings.ReplaceAll(raw, "<", "&lt;"))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if !p.tags[token.Data] {
+				elements++
+				if tt == html.StartTagToken && htmlDroppedContent[token.Data] {
+					dropping, depth = token.Data, 1
+				}
+				continue
+			}
+			kept := token.Attr[:0]
+			for _, attr := range token.Attr {
+				if p.allowedAttribute(attr) {
+					kept = append(kept, attr)
+				}
+			}
+			if removed := len(token.Attr) - len(kept); removed > 0 {
+				attributes += removed
+				token.Attr = kept
+				raw = token.String()
+			}
+			b.WriteString(raw)
+		case html.EndTagToken:
+			if !p.tags[token.Data] {
+				continue
+			}
+			b.WriteString(raw)
+		case html.CommentToken, html.DoctypeToken:
+			elements++
+		}
+	}
+	return b.String(), elements, attributes
+}
+
+// allowedAttribute reports whether the policy keeps attr
+func (p *htmlPolicy) allowedAttribute(attr html.Attribute) bool {
+	key := strings.ToLower(attr.Key)
+	if strings.HasPrefix(key, "on") {
+		return false
+	}
+	allowed := p.attributes[key]
+	for _, prefix := range p.attributePrefixes {
+		allowed = allowed || strings.HasPrefix(key, prefix)
+	}
+	if !allowed {
+		return false
+	}
+	return !htmlURLAttributes[key] || allowedURL(attr.Val)
+}
+
+// allowedURL reports whether url is relative or of a scheme of
+// htmlAllowedSchemes. Browsers ignore whitespace and control characters
+// within schemes, as in java&#9;script:, so they are ignored here too.
+func allowedURL(url string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, url)
+	scheme, _, ok := strings.Cut(cleaned, ":")
+	if !ok || strings.ContainsAny(scheme, "/?#") {
+		return true
+	}
+	return htmlAllowedSchemes[strings.ToLower(scheme)]
+}
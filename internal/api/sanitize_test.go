@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+func TestHTMLPolicySanitize(t *testing.T) {
+	tests := []struct {
+		name           string
+		text           string
+		want           string
+		wantElements   int
+		wantAttributes int
+	}{
+		{name: "allowed markup", text: `<p class="x">Hallo <b>Welt</b><br/></p>`, want: `<p class="x">Hallo <b>Welt</b><br/></p>`},
+		{name: "kept as written", text: `<P CLASS='x'>Hallo &amp; Tschüss</P>`, want: `<P CLASS='x'>Hallo &amp; Tschüss</P>`},
+		{name: "script dropped with its content", text: `Hallo<script>alert(1)</script> Welt`, want: `Hallo Welt`, wantElements: 1},
+		{name: "nested dropped elements", text: `<svg><svg><p>a</p></svg>b</svg>c`, want: `c`, wantElements: 1},
+		{name: "style dropped", text: `<style>p{}</style><p>a</p>`, want: `<p>a</p>`, wantElements: 1},
+		{name: "other elements unwrapped", text: `<form><input name="a">Senden</form>`, want: `Senden`, wantElements: 2},
+		{name: "raw text elements dropped", text: `<textarea></textarea><xmp><img src=x onerror=alert(1)></xmp>`, want: ``, wantElements: 2},
+		{name: "event handler", text: `<img src="a.png" onerror="alert(1)" ONLOAD="x">`, want: `<img src="a.png">`, wantAttributes: 2},
+		{name: "attribute outside the allowlist", text: `<p style="color: red">a</p>`, want: `<p>a</p>`, wantAttributes: 1},
+		{name: "javascript URL", text: `<a href="javascript:alert(1)">a</a>`, want: `<a>a</a>`, wantAttributes: 1},
+		{name: "obfuscated javascript URL", text: `<a href="java&#9;script:alert(1)">a</a>`, want: `<a>a</a>`, wantAttributes: 1},
+		{name: "data URL", text: `<img src="data:image/svg+xml,&lt;svg onload=alert(1)&gt;">`, want: `<img>`, wantAttributes: 1},
+		{name: "allowed URLs", text: `<a href="https://example.com/a?b=c:d">a</a><a href="/x:y">b</a><a href="mailto:a@example.com">c</a>`,
+			want: `<a href="https://example.com/a?b=c:d">a</a><a href="/x:y">b</a><a href="mailto:a@example.com">c</a>`},
+		{name: "comment", text: `a<!-- <script>alert(1)</script> -->b`, want: `ab`, wantElements: 1},
+		{name: "doctype", text: `<!DOCTYPE html><p>a</p>`, want: `<p>a</p>`, wantElements: 1},
+		{name: "stray end tag", text: `a</script>b`, want: `ab`},
+	}
+	p := newHTMLPolicy(Config{HTMLSanitization: true})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, elements, attributes := p.sanitize(tt.text)
+			if got != tt.want || elements != tt.wantElements || attributes != tt.wantAttributes {
+				t.Errorf("sanitize(%q) = %q, %d, %d, want %q, %d, %d", tt.text, got, elements, attributes, tt.want, tt.wantElements, tt.wantAttributes)
+			}
+		})
+	}
+}
+
+func TestHTMLPolicyConfigured(t *testing.T) {
+	p := newHTMLPolicy(Config{
+		HTMLSanitization:      true,
+		HTMLAllowedTags:       []string{"P", "a"},
+		HTMLAllowedAttributes: []string{"href", "data-*", "onclick"},
+	})
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`<p data-id="1" class="x">a</p>`, `<p data-id="1">a</p>`},
+		{`<b>a</b>`, `a`},
+		// Event handlers and URL schemes are checked whatever the allowlist
+		{`<a href="vbscript:x" onclick="x">a</a>`, `<a>a</a>`},
+	}
+	for _, tt := range tests {
+		if got, _, _ := p.sanitize(tt.text); got != tt.want {
+			t.Errorf("sanitize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	if p := newHTMLPolicy(Config{}); p != nil {
+		t.Errorf("newHTMLPolicy() without HTML_SANITIZATION = %v, want nil", p)
+	}
+}
+
+func TestAllowedURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/", true},
+		{"HTTP://example.com/", true},
+		{"mailto:a@example.com", true},
+		{"tel:+4930123", true},
+		{"/relative:path", true},
+		{"page?at=10:30", true},
+		{"#top", true},
+		{"", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{" javascript:alert(1)", false},
+		{"java\tscript:alert(1)", false},
+		{"java\x00script:alert(1)", false},
+		{"data:text/html,<script>", false},
+		{"vbscript:msgbox", false},
+	}
+	for _, tt := range tests {
+		if got := allowedURL(tt.url); got != tt.want {
+			t.Errorf("allowedURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeTranslation(t *testing.T) {
+	const translated = `<b onclick="x">Hallo</b>`
+	tests := []struct {
+		name         string
+		sanitization bool
+		format       string
+		want         string
+	}{
+		{"HTML", true, provider.FormatHTML, `<b>Hallo</b>`},
+		{"text", true, provider.FormatText, translated},
+		{"disabled", false, provider.FormatHTML, translated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) { c.HTMLSanitization = tt.sanitization })
+			s.htmlPolicy = newHTMLPolicy(s.config)
+			response := &TranslationResponse{TranslatedText: translated}
+
+			s.sanitizeTranslation(context.Background(), TranslationRequest{Format: tt.format}, response)
+			if response.TranslatedText != tt.want {
+				t.Errorf("TranslatedText = %q, want %q", response.TranslatedText, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+// Package api implements the translation service: its HTTP API and the
+// translation, caching and accounting behind it
+package api
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dphase/ss-translate/internal/cache"
+	"github.com/dphase/ss-translate/internal/provider"
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/sync/singleflight"
+)
+
+// Server holds the configuration, clients and state of the service. The
+// handlers and everything they call are its methods, so a service can be
+// set up with its own dependencies, e.g. a fake provider in tests.
+type Server struct {
+	config     Config                 // Settings read at startup
+	liveConfig atomic.Pointer[Config] // config with the reloadable settings last applied, replaced as a whole on reload
+	reloadMu   sync.Mutex             // Serializes reloads
+
+	redis *redis.Client
+	// redisUp tracks whether the last Redis health check succeeded. While it
+	// is false the service runs in degraded mode: the cache is bypassed and
+	// rate limiting, quotas and usage accounting are skipped.
+	redisUp        atomic.Bool
+	redisLastError struct { // Error of the last failed Redis health check
+		sync.Mutex
+		err error
+	}
+
+	cache            cache.Cache
+	translationGroup singleflight.Group   // Coalesces concurrent cache misses
+	refreshing       sync.Map             // Cache keys being refreshed in the background
+	cacheSeed        []TranslationRequest // Entries of CACHE_WARM_FILE, warmed on Start
+	warming          atomic.Bool          // Set while the cache is being warmed
+
+	provider        provider.Provider    // Provider used for translations, possibly a failover chain
+	providers       []provider.Provider  // Every configured provider, in order of preference
+	providerHealths []*providerHealth    // State of every provider while PROVIDER_HEALTH_INTERVAL is set, in the order of providers
+	tenantProviders *tenantProviderCache // Providers of tenants with their own credentials
+	languageSupport *languageCache       // Languages of each provider, for LANGUAGE_FALLBACK
+	credentialsAEAD cipher.AEAD          // Encrypts tenant credentials at rest; they are disabled while it is nil
+	redactor        *piiRedactor         // Of PII_REDACTION and PII_PATTERN, for texts sent to external APIs; nil while neither is set
+	moderator       *moderator           // Wordlists and moderation API of MODERATION_INPUT and MODERATION_OUTPUT, nil when neither is configured
+	htmlPolicy      *htmlPolicy          // Allowlist HTML translations are sanitized against, nil while HTML_SANITIZATION is off
+	pageClient      *http.Client         // Fetches the pages of POST /translate/page
+	webhookClient   *http.Client         // Delivers job callbacks
+	proxyUpstream   *url.URL             // Of the reverse-proxy mode, nil while PROXY_UPSTREAM is unset
+	proxyLanguages  *proxyLanguages      // Matches Accept-Language with PROXY_LANGUAGES
+	proxySelectors  [][]selectorStep     // Of PROXY_JSON_SELECTORS
+	kafkaWriter     kafkaWriter          // Of the Kafka worker mode, nil while KAFKA_BROKERS is unset
+	newKafkaReader  func() kafkaReader   // Starts a consumer of KAFKA_INPUT_TOPIC's group
+	sqs             sqsAPI               // Of the SQS worker mode, nil while SQS_INPUT_QUEUE_URL is unset
+	pubsub          *pubsub.Client       // Of the Pub/Sub worker mode, nil while PUBSUB_SUBSCRIPTION is unset
+	pubsubOutput    *pubsub.Topic        // PUBSUB_OUTPUT_TOPIC
+	pubsubDLQ       *pubsub.Topic        // PUBSUB_DLQ_TOPIC, nil when unset
+	nats            *nats.Conn           // Of the NATS transport, nil while NATS_URL is unset
+	s3              *s3.Client           // Of file jobs, nil while JOB_STORAGE_BUCKETS has no s3:// bucket
+	gcs             *storage.Client      // Of file jobs, nil while JOB_STORAGE_BUCKETS has no gs:// bucket
+
+	providerLimit *provider.ConcurrencyLimit // Bounds provider calls in flight, nil while PROVIDER_MAX_IN_FLIGHT is zero
+
+	jwks           *jwksCache // Key set JWTs are verified with
+	ipAllowlist    []netip.Prefix
+	trustedProxies []netip.Prefix
+
+	// History writer state, set up when HISTORY_DATABASE_URL is set
+	historyPool    *pgxpool.Pool
+	historyRecords chan HistoryRecord
+	historyStop    chan struct{}
+	historyDone    chan struct{}
+
+	// Quality estimation state, set up with the history when QUALITY_ESTIMATOR is set
+	qualityEstimator qualityEstimator
+	qualityJobs      chan qualityJob
+	qualityStop      chan struct{}
+	qualityWorkers   sync.WaitGroup
+
+	jobWorkers        sync.WaitGroup              // Running workers, so shutdown can wait for them
+	webSockets        sync.Map                    // Open /ws/translate connections, to close on shutdown
+	webSocketsOpen    sync.WaitGroup              // Of webSockets, so shutdown can wait for them
+	closingWebSockets atomic.Bool                 // Set once shutdown starts closing webSockets
+	warmedUp          atomic.Bool                 // Set once startup has finished, cleared when shutdown starts
+	stopping          chan struct{}               // Closed when shutdown starts, ending job event streams
+	shutdownTracing   func(context.Context) error // Flushes buffered spans
+}
+
+// NewServer connects the clients and sets up the providers for a validated
+// configuration. Redis being unreachable is not an error: the server starts
+// in degraded mode and keeps retrying until ctx is done.
+func NewServer(ctx context.Context, config Config) (*Server, error) {
+	s := &Server{config: config, stopping: make(chan struct{})}
+	s.liveConfig.Store(&s.config)
+
+	// Set up tracing before any instrumented clients are used
+	var err error
+	s.shutdownTracing, err = s.setupTracing(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %v", err)
+	}
+
+	if s.ipAllowlist, err = parsePrefixes(s.config.IPAllowlist); err != nil {
+		return nil, fmt.Errorf("invalid IP_ALLOWLIST: %v", err)
+	}
+	if s.trustedProxies, err = parsePrefixes(s.config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Set up Redis client, with TLS unless USE_REDIS_UNSECURE is set
+	var redisTLS *tls.Config
+	if os.Getenv("USE_REDIS_UNSECURE") == "" {
+		redisTLS = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			// For production, you should verify the Redis server's certificate
+			// InsecureSkipVerify: false,
+		}
+	}
+	if s.config.RedisMasterName != "" {
+		// Sentinel mode follows the master across failovers
+		slog.Info("connecting to Redis/Valkey via Sentinel", "master", s.config.RedisMasterName, "sentinels", s.config.RedisSentinelAddresses)
+		s.redis = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       s.config.RedisMasterName,
+			SentinelAddrs:    s.config.RedisSentinelAddresses,
+			SentinelPassword: s.config.RedisSentinelPassword,
+			Password:         s.config.RedisPassword,
+			DB:               s.config.RedisDB,
+			TLSConfig:        redisTLS,
+		})
+	} else {
+		slog.Info("connecting to Redis/Valkey", "address", s.config.RedisAddress)
+		s.redis = redis.NewClient(&redis.Options{
+			Addr:      s.config.RedisAddress,
+			Password:  s.config.RedisPassword,
+			DB:        s.config.RedisDB,
+			TLSConfig: redisTLS,
+		})
+	}
+
+	s.redis.AddHook(redisMetricsHook{})
+	s.redis.AddHook(redisTracingHook{})
+
+	// Test Redis connection. When it is unreachable, start in degraded mode
+	// and keep retrying in the background.
+	s.checkRedis(ctx)
+	if !s.redisUp.Load() {
+		slog.Error("failed to connect to Redis, starting in degraded mode without cache", "error", s.redisStatusError())
+	}
+	go s.monitorRedis(ctx, s.config.RedisHealthInterval)
+
+	// Set up translation cache
+	if s.cache, err = s.newCache(s.config.CacheBackend); err != nil {
+		return nil, fmt.Errorf("failed to set up cache: %v", err)
+	}
+	slog.Info("using cache backend", "backend", s.config.CacheBackend)
+	if s.config.CacheWarmFile != "" {
+		if s.cacheSeed, err = loadCacheSeed(s.config.CacheWarmFile); err != nil {
+			return nil, fmt.Errorf("invalid CACHE_WARM_FILE: %v", err)
+		}
+	}
+
+	if s.redactor, err = newPIIRedactor(s.config); err != nil {
+		return nil, err
+	}
+	if s.moderator, err = newModerator(s.config, s.redactor); err != nil {
+		return nil, err
+	}
+	s.htmlPolicy = newHTMLPolicy(s.config)
+	s.pageClient = s.newPageClient()
+	s.webhookClient = s.newWebhookClient()
+	if s.proxyUpstream = parseProxyUpstream(s.config); s.proxyUpstream != nil {
+		s.proxyLanguages = newProxyLanguages(s.config)
+		selectors := s.config.ProxyJSONSelectors
+		if len(selectors) == 0 {
+			selectors = []string{proxyDefaultSelector}
+		}
+		for _, selector := range selectors {
+			steps, _ := parseSelector(selector)
+			s.proxySelectors = append(s.proxySelectors, steps)
+		}
+	}
+
+	if len(s.config.KafkaBrokers) > 0 {
+		if err := s.setupKafka(); err != nil {
+			return nil, err
+		}
+	}
+	if s.config.SQSInputQueueURL != "" {
+		if err := s.setupSQS(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if s.config.PubSubSubscription != "" {
+		if err := s.setupPubSub(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.config.NATSURL) > 0 {
+		if err := s.setupNATS(); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.config.JobStorageBuckets) > 0 {
+		if err := s.setupObjectStorage(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// Set up the translation history
+	if s.config.HistoryDatabaseURL != "" {
+		if err := s.setupHistory(ctx); err != nil {
+			return nil, fmt.Errorf("failed to set up translation history: %v", err)
+		}
+		slog.Info("recording translation history", "retention", s.config.HistoryRetention.String())
+	}
+
+	// Load the keys JWTs are signed with; failures are retried on use
+	s.jwks = &jwksCache{
+		httpClient: &http.Client{Timeout: jwksFetchTimeout},
+		url:        s.config.JWTJWKSURL,
+		refresh:    s.config.JWKSRefresh,
+	}
+	if s.jwtEnabled() {
+		if err := s.jwks.fetch(ctx); err != nil {
+			slog.Warn("failed to fetch JWKS", "url", s.config.JWTJWKSURL, "error", err)
+		}
+	}
+
+	// Set up translation provider
+	if s.config.ProviderMaxInFlight > 0 {
+		s.providerLimit = provider.NewConcurrencyLimit(s.config.ProviderMaxInFlight, s.config.ProviderQueueSize, s.config.ProviderQueueTimeout)
+	}
+	s.tenantProviders = &tenantProviderCache{server: s, entries: make(map[string]*tenantProviderEntry)}
+	s.languageSupport = &languageCache{entries: make(map[string]*supportedLanguages)}
+	if s.config.TenantCredentialsKey != "" {
+		if err := s.setupTenantCredentials(s.config.TenantCredentialsKey); err != nil {
+			return nil, fmt.Errorf("invalid TENANT_CREDENTIALS_KEY: %v", err)
+		}
+	}
+	names := s.config.Providers
+	if len(names) == 0 {
+		names = []string{s.config.Provider}
+	}
+	for _, name := range names {
+		p, err := s.newProvider(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up translation provider %s: %v", name, err)
+		}
+		s.providers = append(s.providers, p)
+	}
+	s.provider = s.providers[0]
+	if len(s.providers) > 1 {
+		s.provider = provider.NewFailover(s.providers)
+	}
+	if s.config.CanaryProvider != "" {
+		canary, err := s.newProvider(ctx, s.config.CanaryProvider)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up canary provider %s: %v", s.config.CanaryProvider, err)
+		}
+		s.providers = append(s.providers, canary)
+		s.provider = provider.NewCanary(s.provider, canary, func() float64 { return s.currentConfig().CanaryPercent })
+		slog.Info("canary routing enabled", "provider", canary.Name(), "percent", s.config.CanaryPercent)
+	}
+	slog.Info("using translation provider", "provider", s.provider.Name())
+	return s, nil
+}
+
+// Start runs the background work of the server until ctx is done: the job
+// workers, Kafka, SQS and Pub/Sub consumers, the NATS service, provider health checks and warming of
+// the cache from CACHE_WARM_FILE. The server reports ready from then on,
+// until ctx is done.
+func (s *Server) Start(ctx context.Context) {
+	s.startJobWorkers(ctx, s.config.JobWorkers)
+	if s.newKafkaReader != nil {
+		s.startKafkaConsumers(ctx)
+	}
+	if s.sqs != nil {
+		s.startSQSConsumers(ctx)
+	}
+	if s.pubsub != nil {
+		s.startPubSubConsumer(ctx)
+	}
+	if s.nats != nil {
+		s.startNATSService(ctx)
+	}
+	if s.config.ProviderHealthInterval > 0 {
+		s.monitorProviders(ctx, s.config.ProviderHealthInterval)
+	}
+	if len(s.cacheSeed) > 0 {
+		slog.Info("warming cache", "file", s.config.CacheWarmFile, "entries", len(s.cacheSeed))
+		s.warmCache(ctx, s.cacheSeed)
+		s.cacheSeed = nil
+	}
+	if s.config.CachePopularTop > 0 {
+		s.refreshPopular(ctx, s.config.CachePopularInterval)
+	}
+	s.warmedUp.Store(true)
+	go func() {
+		<-ctx.Done()
+		s.warmedUp.Store(false)
+		close(s.stopping)
+	}()
+}
+
+// Close closes the WebSocket connections and waits for the job workers,
+// then writes the queued history and releases the clients. The server must
+// not be used afterwards.
+func (s *Server) Close(ctx context.Context) {
+	s.closeWebSockets(ctx)
+	s.waitForJobWorkers(ctx)
+	s.shutdown(ctx)
+}
+
+// Handler returns the handler serving every endpoint, with request logging,
+// the IP allowlist, gzip compression and body limits applied
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/translate", instrumentHandler("translate", s.handleTranslation))
+	mux.Handle("/translate/compare", instrumentHandler("compare", s.handleCompare))
+	mux.Handle("/translate/document", instrumentHandler("document", s.handleDocument))
+	mux.Handle("/translate/subtitles", instrumentHandler("subtitles", s.handleSubtitles))
+	mux.Handle("/translate/resources", instrumentHandler("resources", s.handleResources))
+	mux.Handle("/translate/file", instrumentHandler("file", s.handleRecordFile))
+	mux.Handle("/translate/page", instrumentHandler("page", s.handlePage))
+	mux.Handle("/ws/translate", instrumentHandler("websocket", s.handleWebSocket))
+	mux.Handle("/jobs", instrumentHandler("jobs", s.handleJobs))
+	mux.Handle("/jobs/", instrumentHandler("jobs", s.handleJobs))
+	mux.Handle("/providers/stats", instrumentHandler("provider_stats", s.handleProviderStats))
+	mux.Handle("/health", instrumentHandler("health", s.handleHealth))
+	mux.Handle("/livez", instrumentHandler("livez", handleLivez))
+	mux.Handle("/readyz", instrumentHandler("readyz", s.handleReadyz))
+	mux.Handle("/version", instrumentHandler("version", s.handleVersion))
+	mux.Handle("/openapi.json", instrumentHandler("openapi", handleOpenAPI))
+	docs := instrumentHandler("docs", docsHandler().ServeHTTP)
+	mux.Handle("/docs", docs)
+	mux.Handle("/docs/", docs)
+	mux.Handle("/admin/keys", instrumentHandler("admin_keys", s.adminIdempotent(s.handleAdminKeys)))
+	mux.Handle("/admin/keys/", instrumentHandler("admin_keys", s.adminIdempotent(s.handleAdminKeys)))
+	mux.Handle("/admin/tenants", instrumentHandler("admin_tenants", s.adminIdempotent(s.handleAdminTenants)))
+	mux.Handle("/admin/tenants/", instrumentHandler("admin_tenants", s.adminIdempotent(s.handleAdminTenants)))
+	mux.Handle("/admin/usage", instrumentHandler("admin_usage", s.adminIdempotent(s.handleAdminUsage)))
+	mux.Handle("/admin/cache", instrumentHandler("admin_cache", s.adminIdempotent(s.handleAdminCache)))
+	mux.Handle("/admin/cache/warm", instrumentHandler("admin_cache_warm", s.adminIdempotent(s.handleAdminCacheWarm)))
+	mux.Handle("/admin/glossaries", instrumentHandler("admin_glossaries", s.adminIdempotent(s.handleAdminGlossaries)))
+	mux.Handle("/admin/glossaries/", instrumentHandler("admin_glossaries", s.adminIdempotent(s.handleAdminGlossaries)))
+	mux.Handle("/admin/translations", instrumentHandler("admin_translations", s.adminIdempotent(s.handleAdminTranslations)))
+	mux.Handle("/admin/tm", instrumentHandler("admin_tm", s.adminIdempotent(s.handleAdminTM)))
+	mux.Handle("/admin/tm/", instrumentHandler("admin_tm", s.adminIdempotent(s.handleAdminTM)))
+	mux.Handle("/admin/history", instrumentHandler("admin_history", s.adminIdempotent(s.handleAdminHistory)))
+	mux.Handle("/admin/reviews", instrumentHandler("admin_reviews", s.adminIdempotent(s.handleAdminReviews)))
+	mux.Handle("/admin/reviews/", instrumentHandler("admin_reviews", s.adminIdempotent(s.handleAdminReviews)))
+	mux.Handle("/admin/reload", instrumentHandler("admin_reload", s.adminIdempotent(s.handleAdminReload)))
+	mux.Handle("/metrics", metricsHandler())
+	mux.Handle("/", instrumentHandler("not_found", handleNotFound))
+	return withRequestLogging(s.allowlistIPs(s.withCompression(s.limitRequestBody(s.releaseQuotaReservations(mux)))))
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dphase/ss-translate/internal/cache"
+	"github.com/dphase/ss-translate/internal/provider"
+	"github.com/go-redis/redis/v8"
+)
+
+// fakeProvider is a provider.Provider translating texts to "[<target>]
+// <text>", failing with err while it is set
+type fakeProvider struct {
+	name string
+
+	mu         sync.Mutex
+	err        error
+	requests   []provider.Request
+	detections []string // Texts detected
+}
+
+// Name implements provider.Provider
+func (p *fakeProvider) Name() string {
+	if p.name == "" {
+		return "fake"
+	}
+	return p.name
+}
+
+// Requests returns the translation requests made so far
+func (p *fakeProvider) Requests() []provider.Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]provider.Request(nil), p.requests...)
+}
+
+// Translate implements provider.Provider
+func (p *fakeProvider) Translate(ctx context.Context, req provider.Request) (*provider.Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, req)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &provider.Result{TranslatedText: "[" + req.TargetLang + "] " + req.Text, SourceLang: "en"}, nil
+}
+
+// Detect implements provider.Provider
+func (p *fakeProvider) Detect(ctx context.Context, text string) (*provider.Detection, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.detections = append(p.detections, text)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &provider.Detection{Language: "en", Confidence: 1}, nil
+}
+
+// Languages implements provider.Provider
+func (p *fakeProvider) Languages(ctx context.Context, target string) ([]provider.Language, error) {
+	return []provider.Language{{Code: "en", Name: "English"}, {Code: "de", Name: "German"}}, nil
+}
+
+// newTestServer returns a server with the default configuration changed by
+// configure, translating with p and caching in memory, its Redis an
+// in-process miniredis
+func newTestServer(t *testing.T, p provider.Provider, configure func(*Config)) (*Server, *miniredis.Miniredis) {
+	t.Helper()
+	config := ReadConfig()
+	if configure != nil {
+		configure(&config)
+	}
+	mr := miniredis.RunT(t)
+	s := &Server{
+		config:    config,
+		stopping:  make(chan struct{}),
+		redis:     redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		cache:     cache.NewMemory(1000),
+		provider:  p,
+		providers: []provider.Provider{p},
+	}
+	s.tenantProviders = &tenantProviderCache{server: s, entries: make(map[string]*tenantProviderEntry)}
+	s.languageSupport = &languageCache{entries: make(map[string]*supportedLanguages)}
+	s.liveConfig.Store(&s.config)
+	s.redisUp.Store(true)
+	t.Cleanup(func() { s.redis.Close() })
+	return s, mr
+}
+
+// counter returns the integer at key, zero while it isn't set
+func counter(t *testing.T, mr *miniredis.Miniredis, key string) int64 {
+	t.Helper()
+	if !mr.Exists(key) {
+		return 0
+	}
+	value, err := mr.Get(key)
+	if err != nil {
+		t.Fatalf("GET %s: %v", key, err)
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		t.Fatalf("GET %s = %q: %v", key, value, err)
+	}
+	return n
+}
+
+func TestTranslateServesRepeatsFromTheCache(t *testing.T) {
+	fake := &fakeProvider{}
+	s, _ := newTestServer(t, fake, nil)
+	ctx := context.Background()
+
+	first, err := s.Translate(ctx, TranslationRequest{Text: "Hello world", SourceLang: "en", TargetLang: "de"})
+	if err != nil {
+		t.Fatalf("Translate() error = %v", err)
+	}
+	if first.TranslatedText != "[de] Hello world" || first.CacheHit {
+		t.Fatalf("first Translate() = %q, cache hit %v", first.TranslatedText, first.CacheHit)
+	}
+	second, err := s.Translate(ctx, TranslationRequest{Text: "Hello world", SourceLang: "en", TargetLang: "de"})
+	if err != nil {
+		t.Fatalf("Translate() error = %v", err)
+	}
+	if second.TranslatedText != first.TranslatedText || !second.CacheHit {
+		t.Errorf("second Translate() = %q, cache hit %v, want the cached translation", second.TranslatedText, second.CacheHit)
+	}
+	if n := len(fake.Requests()); n != 1 {
+		t.Errorf("provider called %d times, want once", n)
+	}
+}
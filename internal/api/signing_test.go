@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedTranslateRequest returns a POST of body signed with secret at the
+// time at
+func signedTranslateRequest(secret, body string, at time.Time) *http.Request {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	r := httptest.NewRequest(http.MethodPost, "/translate", strings.NewReader(body))
+	r.Header.Set("X-Timestamp", timestamp)
+	r.Header.Set("X-Signature", "sha256="+hex.EncodeToString(signRequest(secret, timestamp, []byte(body))))
+	return r
+}
+
+func TestAuthenticateSignature(t *testing.T) {
+	const body = `{"text": "Hello", "target_lang": "de"}`
+	now := time.Now()
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		wantKey string // Empty when rejected
+	}{
+		{"valid", func() *http.Request { return signedTranslateRequest("s3cret", body, now) }, "spa"},
+		{"second key", func() *http.Request { return signedTranslateRequest("other", body, now) }, "ios"},
+		{"within the window", func() *http.Request { return signedTranslateRequest("s3cret", body, now.Add(-4*time.Minute)) }, "spa"},
+		{"without the sha256= prefix", func() *http.Request {
+			r := signedTranslateRequest("s3cret", body, now)
+			r.Header.Set("X-Signature", strings.TrimPrefix(r.Header.Get("X-Signature"), "sha256="))
+			return r
+		}, "spa"},
+		{"unknown secret", func() *http.Request { return signedTranslateRequest("guess", body, now) }, ""},
+		{"expired", func() *http.Request { return signedTranslateRequest("s3cret", body, now.Add(-6*time.Minute)) }, ""},
+		{"from the future", func() *http.Request { return signedTranslateRequest("s3cret", body, now.Add(6*time.Minute)) }, ""},
+		{"tampered body", func() *http.Request {
+			r := signedTranslateRequest("s3cret", body, now)
+			r.Body = io.NopCloser(strings.NewReader(strings.Replace(body, "de", "fr", 1)))
+			return r
+		}, ""},
+		{"tampered timestamp", func() *http.Request {
+			r := signedTranslateRequest("s3cret", body, now)
+			r.Header.Set("X-Timestamp", strconv.FormatInt(now.Unix()+1, 10))
+			return r
+		}, ""},
+		{"without a timestamp", func() *http.Request {
+			r := signedTranslateRequest("s3cret", body, now)
+			r.Header.Del("X-Timestamp")
+			return r
+		}, ""},
+		{"signature not hex", func() *http.Request {
+			r := signedTranslateRequest("s3cret", body, now)
+			r.Header.Set("X-Signature", "sha256=not-hex")
+			return r
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) {
+				c.SigningKeys = map[string]string{"spa": "s3cret", "ios": "other"}
+				c.SignatureMaxAge = 5 * time.Minute
+			})
+			r := tt.request()
+
+			name, ok := s.authenticateSignature(r)
+			if ok != (tt.wantKey != "") || name != tt.wantKey {
+				t.Fatalf("authenticateSignature() = %q, %v, want %q", name, ok, tt.wantKey)
+			}
+			if ok {
+				// The body is left for the handler
+				if got, _ := io.ReadAll(r.Body); string(got) != body {
+					t.Errorf("body after authentication = %q, want %q", got, body)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateSignatureReplay(t *testing.T) {
+	const body = `{"text": "Hello", "target_lang": "de"}`
+	tests := []struct {
+		name       string
+		redisUp    bool
+		wantReplay bool // Whether the replay is accepted
+	}{
+		{"rejected", true, false},
+		// Without Redis, only the time window bounds replays
+		{"Redis unavailable", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) {
+				c.SigningKeys = map[string]string{"spa": "s3cret"}
+				c.SignatureMaxAge = 5 * time.Minute
+			})
+			s.redisUp.Store(tt.redisUp)
+			now := time.Now()
+
+			if _, ok := s.authenticateSignature(signedTranslateRequest("s3cret", body, now)); !ok {
+				t.Fatal("authenticateSignature() rejected the first request")
+			}
+			if _, ok := s.authenticateSignature(signedTranslateRequest("s3cret", body, now)); ok != tt.wantReplay {
+				t.Errorf("authenticateSignature() of the replay = %v, want %v", ok, tt.wantReplay)
+			}
+			// A new timestamp is a new signature
+			if _, ok := s.authenticateSignature(signedTranslateRequest("s3cret", body, now.Add(time.Second))); !ok {
+				t.Error("authenticateSignature() rejected a request signed anew")
+			}
+		})
+	}
+}
+
+func TestSignedTranslateRequest(t *testing.T) {
+	s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) {
+		c.SigningKeys = map[string]string{"spa": "s3cret"}
+		c.SignatureMaxAge = 5 * time.Minute
+	})
+	const body = `{"text": "Hello", "source_lang": "en", "target_lang": "de"}`
+	tests := []struct {
+		name       string
+		request    *http.Request
+		wantStatus int
+	}{
+		{"signed", signedTranslateRequest("s3cret", body, time.Now()), http.StatusOK},
+		{"badly signed", signedTranslateRequest("guess", body, time.Now()), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.request.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, tt.request)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
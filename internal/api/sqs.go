@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// sqsKeyName is the API key name usage, rate limits and quotas of SQS
+// messages are accounted under
+const sqsKeyName = "sqs"
+
+// Message attributes added to results and to the messages sent to
+// SQS_DLQ_QUEUE_URL
+const (
+	sqsAttrSourceMessageID = "source_message_id" // ID of the input message
+	sqsAttrErrorCode       = "error_code"
+	sqsAttrError           = "error"
+)
+
+// sqsMaxAttributes is the most message attributes SQS accepts on a message
+const sqsMaxAttributes = 10
+
+// sqsAPI is the part of the SQS client the worker mode uses, so consumers
+// can be driven by something else than SQS
+type sqsAPI interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// sqsQueueRegion returns the region of an SQS queue URL of the form
+// https://sqs.<region>.amazonaws.com/<account>/<queue>, or "" for others
+func sqsQueueRegion(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) < 4 || parts[0] != "sqs" || parts[2] != "amazonaws" {
+		return ""
+	}
+	return parts[1]
+}
+
+// isFIFOQueue reports whether a queue URL names a FIFO queue
+func isFIFOQueue(queueURL string) bool {
+	return strings.HasSuffix(queueURL, ".fifo")
+}
+
+// setupSQS sets up the SQS client of the worker mode, with the standard AWS
+// credential chain and the region of the input queue's URL
+func (s *Server) setupSQS(ctx context.Context) error {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region := sqsQueueRegion(s.config.SQSInputQueueURL); region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config for SQS: %v", err)
+	}
+	s.sqs = sqs.NewFromConfig(cfg)
+	return nil
+}
+
+// startSQSConsumers starts SQS_CONSUMERS receive loops of the input queue,
+// translating its messages into the output queue until ctx is done
+func (s *Server) startSQSConsumers(ctx context.Context) {
+	slog.Info("consuming translation requests from SQS", "queue", s.config.SQSInputQueueURL, "consumers", s.config.SQSConsumers)
+	for i := 0; i < s.config.SQSConsumers; i++ {
+		s.jobWorkers.Add(1)
+		go func() {
+			defer s.jobWorkers.Done()
+			s.runSQSConsumer(ctx)
+		}()
+	}
+}
+
+// runSQSConsumer receives batches of messages until ctx is done and
+// translates each batch's messages concurrently, or in order for FIFO
+// queues. Messages are only deleted once handled, so a message whose
+// consumer dies is received again after its visibility timeout.
+func (s *Server) runSQSConsumer(ctx context.Context) {
+	// Finish the batch in progress on shutdown, rather than translate it
+	// again once visible
+	storeCtx := context.WithoutCancel(ctx)
+	fifo := isFIFOQueue(s.config.SQSInputQueueURL)
+	for ctx.Err() == nil {
+		out, err := s.sqs.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(s.config.SQSInputQueueURL),
+			MaxNumberOfMessages:   int32(s.config.SQSBatchSize),
+			WaitTimeSeconds:       int32(s.config.SQSWaitTime.Seconds()),
+			VisibilityTimeout:     int32(s.config.SQSVisibilityTimeout.Seconds()),
+			AttributeNames:        []types.QueueAttributeName{types.QueueAttributeName(types.MessageSystemAttributeNameApproximateReceiveCount), types.QueueAttributeName(types.MessageSystemAttributeNameMessageGroupId)},
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("failed to receive sqs messages", "queue", s.config.SQSInputQueueURL, "error", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(jobPollTimeout):
+				}
+			}
+			continue
+		}
+		if fifo {
+			for _, msg := range out.Messages {
+				s.handleSQSMessage(ctx, storeCtx, msg)
+			}
+			continue
+		}
+		var wg sync.WaitGroup
+		for _, msg := range out.Messages {
+			wg.Add(1)
+			go func(msg types.Message) {
+				defer wg.Done()
+				s.handleSQSMessage(ctx, storeCtx, msg)
+			}(msg)
+		}
+		wg.Wait()
+	}
+}
+
+// handleSQSMessage translates a message into the output queue and deletes
+// it, keeping it invisible meanwhile. Messages failing with transient
+// errors are received again after a backoff, SQS_MAX_ATTEMPTS receives at
+// most; those that still fail, or are invalid, are moved to the dead-letter
+// queue when there is one, and otherwise left to the input queue's redrive
+// policy. Messages are left to become visible again when ctx is done while
+// they wait for a rate limit or can't be written.
+func (s *Server) handleSQSMessage(ctx, storeCtx context.Context, msg types.Message) {
+	log := slog.With("queue", s.config.SQSInputQueueURL, "message_id", aws.ToString(msg.MessageId))
+	stop := s.extendSQSVisibility(storeCtx, log, msg)
+	defer stop()
+
+	var value []byte
+	var failure *queueFailure
+	for {
+		value, failure = s.translateQueued(storeCtx, sqsKeyName, mediaJSON, []byte(aws.ToString(msg.Body)))
+		if failure == nil || failure.retryAfter == 0 {
+			break
+		}
+		// Throttled messages wait without being received again, which would
+		// count as an attempt
+		log.Warn("sqs message rate limited, retrying", "backoff", failure.retryAfter.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(failure.retryAfter):
+		}
+	}
+	if failure == nil {
+		if err := s.sendSQSMessage(storeCtx, s.config.SQSOutputQueueURL, msg, string(value), nil); err != nil {
+			log.Error("failed to send sqs result", "error", err)
+			return
+		}
+		s.deleteSQSMessage(storeCtx, log, msg)
+		sqsMessages.WithLabelValues("translated").Inc()
+		return
+	}
+
+	receives, _ := strconv.Atoi(msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	if failure.transient && receives < s.config.SQSRetry.MaxAttempts {
+		delay := s.config.SQSRetry.Backoff(max(receives, 1))
+		log.Warn("sqs message failed, retrying", "code", failure.Code, "receives", receives, "backoff", delay.String(), "error", failure.Message)
+		stop()
+		s.setSQSVisibility(storeCtx, log, msg, delay)
+		sqsMessages.WithLabelValues("retried").Inc()
+		return
+	}
+
+	log.Warn("sqs message failed", "code", failure.Code, "receives", receives, "error", failure.Message)
+	if s.config.SQSDLQQueueURL == "" {
+		// Received again once visible, until the redrive policy moves it
+		sqsMessages.WithLabelValues("released").Inc()
+		return
+	}
+	attributes := map[string]types.MessageAttributeValue{
+		sqsAttrErrorCode: {DataType: aws.String("String"), StringValue: aws.String(failure.Code)},
+		sqsAttrError:     {DataType: aws.String("String"), StringValue: aws.String(failure.Message)},
+	}
+	if err := s.sendSQSMessage(storeCtx, s.config.SQSDLQQueueURL, msg, aws.ToString(msg.Body), attributes); err != nil {
+		log.Error("failed to send sqs message to the dead-letter queue", "error", err)
+		return
+	}
+	s.deleteSQSMessage(storeCtx, log, msg)
+	sqsMessages.WithLabelValues("dead_lettered").Inc()
+}
+
+// sendSQSMessage sends body to a queue as the answer to msg, with msg's
+// message attributes, its ID and extra as far as they fit. Messages to FIFO
+// queues keep msg's group, and are deduplicated by its ID.
+func (s *Server) sendSQSMessage(ctx context.Context, queueURL string, msg types.Message, body string, extra map[string]types.MessageAttributeValue) error {
+	attributes := make(map[string]types.MessageAttributeValue, sqsMaxAttributes)
+	for name, value := range msg.MessageAttributes {
+		attributes[name] = value
+	}
+	add := func(name string, value types.MessageAttributeValue) {
+		if _, ok := attributes[name]; ok || len(attributes) < sqsMaxAttributes {
+			attributes[name] = value
+		}
+	}
+	add(sqsAttrSourceMessageID, types.MessageAttributeValue{DataType: aws.String("String"), StringValue: msg.MessageId})
+	for name, value := range extra {
+		add(name, value)
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(queueURL),
+		MessageBody:       aws.String(body),
+		MessageAttributes: attributes,
+	}
+	if isFIFOQueue(queueURL) {
+		group := msg.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]
+		if group == "" {
+			group = sqsKeyName
+		}
+		input.MessageGroupId = aws.String(group)
+		input.MessageDeduplicationId = msg.MessageId
+	}
+	_, err := s.sqs.SendMessage(ctx, input)
+	return err
+}
+
+// deleteSQSMessage deletes a handled message from the input queue
+func (s *Server) deleteSQSMessage(ctx context.Context, log *slog.Logger, msg types.Message) {
+	_, err := s.sqs.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(s.config.SQSInputQueueURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		// Received and answered again once visible
+		log.Error("failed to delete sqs message", "error", err)
+	}
+}
+
+// setSQSVisibility makes a message visible again after d, rounded up to the
+// whole seconds of SQS
+func (s *Server) setSQSVisibility(ctx context.Context, log *slog.Logger, msg types.Message, d time.Duration) {
+	_, err := s.sqs.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(s.config.SQSInputQueueURL),
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: int32(math.Ceil(d.Seconds())),
+	})
+	if err != nil {
+		log.Warn("failed to change sqs message visibility", "error", err)
+	}
+}
+
+// extendSQSVisibility renews the visibility timeout of a message every half
+// SQS_VISIBILITY_TIMEOUT until the returned function is called, so it isn't
+// received again while it is translated however long that takes
+func (s *Server) extendSQSVisibility(ctx context.Context, log *slog.Logger, msg types.Message) func() {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(s.config.SQSVisibilityTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.setSQSVisibility(ctx, log, msg, s.config.SQSVisibilityTimeout)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
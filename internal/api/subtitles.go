@@ -0,0 +1,228 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// utf8BOM is the byte order mark some editors put at the start of files
+const utf8BOM = "\ufeff"
+
+// Subtitle formats
+const (
+	subtitleSRT = "srt"
+	subtitleVTT = "vtt"
+)
+
+// subtitleContentTypes are the response content types of each format
+var subtitleContentTypes = map[string]string{
+	subtitleSRT: "application/x-subrip; charset=utf-8",
+	subtitleVTT: "text/vtt; charset=utf-8",
+}
+
+// subtitleBreakMask masks the line breaks within a cue, so a cue is
+// translated as one text and its lines survive
+var subtitleBreakMask = newTokenMask("BR")
+
+// subtitleCue is the text of a cue, as a range of lines of the file
+type subtitleCue struct {
+	start, end int
+}
+
+// parseSubtitleCues returns the cue text of lines of an SRT or WebVTT
+// file. Blocks are separated by blank lines, and a cue's text follows its
+// timing line; everything else, from cue numbers and identifiers to WebVTT
+// NOTE, STYLE and REGION blocks, is left as it is.
+func parseSubtitleCues(lines []string) []subtitleCue {
+	var cues []subtitleCue
+	for i := 0; i < len(lines); {
+		if strings.TrimSpace(lines[i]) == "" {
+			i++
+			continue
+		}
+		start := i
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+			i++
+		}
+
+		header := strings.TrimSpace(lines[start])
+		if header == "WEBVTT" || strings.HasPrefix(header, "WEBVTT ") || strings.HasPrefix(header, "NOTE") ||
+			header == "STYLE" || header == "REGION" {
+			continue
+		}
+		for j := start; j < i; j++ {
+			if strings.Contains(lines[j], "-->") {
+				if j+1 < i {
+					cues = append(cues, subtitleCue{start: j + 1, end: i})
+				}
+				break
+			}
+		}
+	}
+	return cues
+}
+
+// maskSubtitleBreaks joins the lines of a cue, masking the breaks between
+// them with tokens set off by spaces so providers keep the words apart
+func maskSubtitleBreaks(lines []string) (string, []string) {
+	var b strings.Builder
+	breaks := make([]string, 0, len(lines)-1)
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString(" " + subtitleBreakMask.token(i-1) + " ")
+			breaks = append(breaks, "\n")
+		}
+		b.WriteString(strings.TrimSpace(line))
+	}
+	return b.String(), breaks
+}
+
+// handleSubtitles translates the cue text of an SRT or WebVTT file,
+// preserving its timestamps, cue identifiers and line breaks, and returns
+// the file in the same format. The file is the request body or, in a
+// multipart form, its file field; source_lang, target_lang and format come
+// from the query string or form.
+func (s *Server) handleSubtitles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx, ok := s.authenticateRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: Invalid API key")
+		logger(r.Context()).Warn("unauthorized request", "remote_addr", s.clientIP(r))
+		return
+	}
+
+	data, _, ok := readUploadedFile(w, r)
+	if !ok {
+		return
+	}
+	if !utf8.Valid(data) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: subtitles must be UTF-8")
+		return
+	}
+	base := TranslationRequest{
+		SourceLang: r.FormValue("source_lang"),
+		TargetLang: r.FormValue("target_lang"),
+		Formality:  r.FormValue("formality"),
+		Tone:       r.FormValue("tone"),
+		Domain:     r.FormValue("domain"),
+	}
+	if err := validateTranslationOptions(&base); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	if err := validateLanguages(base); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidLang, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+
+	// Keep the byte order mark and line endings as they were
+	text := string(data)
+	bom := ""
+	if strings.HasPrefix(text, utf8BOM) {
+		bom, text = utf8BOM, text[len(utf8BOM):]
+	}
+	newline := "\n"
+	if strings.Contains(text, "\r\n") {
+		newline = "\r\n"
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	format := r.FormValue("format")
+	switch {
+	case format == "" && strings.HasPrefix(strings.TrimSpace(lines[0]), "WEBVTT"):
+		format = subtitleVTT
+	case format == "":
+		format = subtitleSRT
+	case subtitleContentTypes[format] == "":
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: format must be %q or %q", subtitleSRT, subtitleVTT))
+		return
+	}
+
+	// Translate each distinct cue text once
+	cues := parseSubtitleCues(lines)
+	cueTexts := make([]string, len(cues))
+	index := make(map[string]int)
+	var texts []string
+	chars := 0
+	for i, cue := range cues {
+		masked, _ := maskSubtitleBreaks(lines[cue.start:cue.end])
+		cueTexts[i] = masked
+		if _, ok := index[masked]; !ok {
+			index[masked] = len(texts)
+			texts = append(texts, masked)
+			chars += utf8.RuneCountInString(strings.Join(lines[cue.start:cue.end], "\n"))
+		}
+	}
+	if len(texts) > s.config.DocumentMaxStrings {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: file has %d distinct cues, the maximum is %d", len(texts), s.config.DocumentMaxStrings))
+		return
+	}
+	if !s.authorizeTranslation(ctx, w, chars) {
+		return
+	}
+
+	responses, err := s.translateStrings(ctx, base, texts)
+	if err != nil {
+		s.writeTranslationError(ctx, w, err)
+		return
+	}
+	s.recordStringsUsage(ctx, texts, responses)
+
+	// Replace each cue's lines with its translation, back to front so the
+	// line numbers of earlier cues stay valid
+	lost := 0
+	for i := len(cues) - 1; i >= 0; i-- {
+		cue := cues[i]
+		_, breaks := maskSubtitleBreaks(lines[cue.start:cue.end])
+		translated, missing := subtitleBreakMask.restore(responses[index[cueTexts[i]]].TranslatedText, breaks)
+		lost += missing
+		cueLines := strings.Split(translated, "\n")
+		for j := range cueLines {
+			cueLines[j] = strings.TrimSpace(cueLines[j])
+		}
+		lines = append(lines[:cue.start], append(cueLines, lines[cue.end:]...)...)
+	}
+	if lost > 0 {
+		logger(ctx).Warn("subtitle line breaks lost in translation", "missing", lost)
+	}
+	logger(ctx).Info("translated subtitles", "format", format, "cues", len(cues), "characters", chars)
+
+	w.Header().Set("Content-Type", subtitleContentTypes[format])
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, bom+strings.Join(lines, newline))
+}
+
+// readUploadedFile reads the file of an upload, the file field of a
+// multipart form or otherwise the whole body, and its file name, which is
+// empty for bodies. It writes the error response and returns false on
+// failure.
+func readUploadedFile(w http.ResponseWriter, r *http.Request) ([]byte, string, bool) {
+	var body io.Reader = r.Body
+	var filename string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeBodyError(w, err)
+			return nil, "", false
+		}
+		defer file.Close()
+		body, filename = file, header.Filename
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		writeBodyError(w, err)
+		return nil, "", false
+	}
+	if len(data) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: file is required")
+		return nil, "", false
+	}
+	return data, filename, true
+}
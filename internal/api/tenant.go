@@ -0,0 +1,352 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis keys used by the tenant store
+const (
+	tenantIDsKey       = "tenants:ids" // Set of all tenant IDs with settings
+	tenantRecordPrefix = "tenants:id:" // tenants:id:<id> holds the JSON Tenant
+)
+
+// tenantScopePrefix prefixes a tenant's ID where it shares a namespace with
+// key names, which never contain a colon
+const tenantScopePrefix = "tenant:"
+
+// errTenantNotFound is returned when a tenant has no stored settings
+var errTenantNotFound = errors.New("tenant not found")
+
+// validTenantID matches tenant IDs, which appear in cache and Redis keys
+var validTenantID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// tenantContextKey is the context key holding the caller's tenant
+type tenantContextKey struct{}
+
+// Tenant groups the API keys of one product. Its keys share a cache
+// namespace, a glossary, rate limits, a daily quota and the language pairs
+// they may translate, which default to the server's settings when unset.
+type Tenant struct {
+	ID                   string    `json:"id"`
+	Description          string    `json:"description,omitempty"`
+	DailyCharQuota       *int64    `json:"daily_char_quota,omitempty"`
+	RateLimitRPS         *float64  `json:"rate_limit_rps,omitempty"`
+	RateLimitBurst       *int      `json:"rate_limit_burst,omitempty"`
+	RateLimitCharsPerMin *int      `json:"rate_limit_chars_per_min,omitempty"`
+	AllowedLanguagePairs []string  `json:"allowed_language_pairs,omitempty"`
+	DeniedLanguagePairs  []string  `json:"denied_language_pairs,omitempty"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+// SetTenantRequest is the body of PUT /admin/tenants/{id}
+type SetTenantRequest struct {
+	Description          string   `json:"description,omitempty"`
+	DailyCharQuota       *int64   `json:"daily_char_quota,omitempty"`
+	RateLimitRPS         *float64 `json:"rate_limit_rps,omitempty"`
+	RateLimitBurst       *int     `json:"rate_limit_burst,omitempty"`
+	RateLimitCharsPerMin *int     `json:"rate_limit_chars_per_min,omitempty"`
+	AllowedLanguagePairs []string `json:"allowed_language_pairs,omitempty"`
+	DeniedLanguagePairs  []string `json:"denied_language_pairs,omitempty"`
+}
+
+// parseKeyTenants parses a comma-separated list of key name:tenant pairs
+func parseKeyTenants(value string) map[string]string {
+	tenants := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		name, tenant, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || !validTenantID.MatchString(tenant) {
+			if entry != "" {
+				slog.Warn("ignoring malformed API_KEY_TENANTS entry, expected name:tenant", "entry", entry)
+			}
+			continue
+		}
+		tenants[name] = tenant
+	}
+	return tenants
+}
+
+// keyTenant returns the ID of the tenant an API key belongs to, or an empty
+// string for keys outside any tenant
+func (s *Server) keyTenant(ctx context.Context, keyName string) string {
+	if tenant, ok := s.config.APIKeyTenants[keyName]; ok {
+		return tenant
+	}
+	_, static := s.config.APIKeys[keyName]
+	if _, signing := s.config.SigningKeys[keyName]; static || signing || keyName == anonymousKeyName || !s.redisAvailable() {
+		return ""
+	}
+	key, err := s.getAPIKey(ctx, keyName)
+	if err != nil {
+		if err != errKeyNotFound {
+			logger(ctx).Warn("failed to look up API key tenant", "error", err)
+		}
+		return ""
+	}
+	return key.Tenant
+}
+
+// withTenant returns a copy of ctx carrying the tenant id, with its
+// settings, unless id is empty
+func (s *Server) withTenant(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	tenant := &Tenant{ID: id}
+	if s.redisAvailable() {
+		stored, err := s.getTenant(ctx, id)
+		switch {
+		case err == nil:
+			tenant = stored
+		case err != errTenantNotFound:
+			logger(ctx).Warn("failed to load tenant settings, using the server's", "tenant", id, "error", err)
+		}
+	}
+	if info := getRequestInfo(ctx); info != nil {
+		info.Tenant = id
+	}
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// requestTenant returns the caller's tenant from ctx, or nil outside one
+func requestTenant(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(tenantContextKey{}).(*Tenant)
+	return tenant
+}
+
+// scopeName names what per-caller state belongs to: the caller's tenant,
+// as tenant:<id>, or its key name outside a tenant. Glossaries, quotas and
+// rate limits are kept per scope.
+func scopeName(ctx context.Context) string {
+	if tenant := requestTenant(ctx); tenant != nil {
+		return tenantScopePrefix + tenant.ID
+	}
+	return apiKeyName(ctx)
+}
+
+// tenantCacheVariant separates the cached translations of the caller's
+// tenant from everyone else's
+func tenantCacheVariant(ctx context.Context) string {
+	if tenant := requestTenant(ctx); tenant != nil {
+		return "tenant-" + tenant.ID
+	}
+	return ""
+}
+
+// rateLimits are the rate limits applied to a scope
+type rateLimits struct {
+	rps         float64
+	burst       float64
+	charsPerMin int
+}
+
+// scopeRateLimits returns the caller's rate limits: its tenant's, falling
+// back to the server's for those the tenant doesn't set
+func (s *Server) scopeRateLimits(ctx context.Context) rateLimits {
+	c := s.currentConfig()
+	limits := rateLimits{rps: c.RateLimitRPS, burst: float64(c.RateLimitBurst), charsPerMin: c.RateLimitCharsPerMin}
+	if tenant := requestTenant(ctx); tenant != nil {
+		if tenant.RateLimitRPS != nil {
+			limits.rps = *tenant.RateLimitRPS
+		}
+		if tenant.RateLimitBurst != nil {
+			limits.burst = float64(*tenant.RateLimitBurst)
+		}
+		if tenant.RateLimitCharsPerMin != nil {
+			limits.charsPerMin = *tenant.RateLimitCharsPerMin
+		}
+	}
+	if limits.burst <= 0 {
+		limits.burst = math.Ceil(limits.rps)
+	}
+	return limits
+}
+
+// scopeDailyQuota returns the caller's daily character quota: its tenant's,
+// or the server's when the tenant doesn't set one
+func (s *Server) scopeDailyQuota(ctx context.Context) int64 {
+	if tenant := requestTenant(ctx); tenant != nil && tenant.DailyCharQuota != nil {
+		return *tenant.DailyCharQuota
+	}
+	return s.currentConfig().DailyCharQuota
+}
+
+// getTenant loads a tenant's settings
+func (s *Server) getTenant(ctx context.Context, id string) (*Tenant, error) {
+	data, err := s.redis.Get(ctx, tenantRecordPrefix+id).Result()
+	if err == redis.Nil {
+		return nil, errTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tenant Tenant
+	if err := json.Unmarshal([]byte(data), &tenant); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tenant: %v", err)
+	}
+	return &tenant, nil
+}
+
+// saveTenant stores a tenant's settings, replacing any previous ones
+func (s *Server) saveTenant(ctx context.Context, tenant *Tenant) error {
+	data, err := json.Marshal(tenant)
+	if err != nil {
+		return err
+	}
+	pipe := s.redis.TxPipeline()
+	pipe.Set(ctx, tenantRecordPrefix+tenant.ID, data, 0)
+	pipe.SAdd(ctx, tenantIDsKey, tenant.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// deleteTenant removes a tenant's settings, so its keys fall back to the
+// server's limits
+func (s *Server) deleteTenant(ctx context.Context, id string) error {
+	pipe := s.redis.TxPipeline()
+	del := pipe.Del(ctx, tenantRecordPrefix+id)
+	pipe.SRem(ctx, tenantIDsKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if del.Val() == 0 {
+		return errTenantNotFound
+	}
+	return nil
+}
+
+// listTenants returns every tenant with stored settings ordered by ID
+func (s *Server) listTenants(ctx context.Context) ([]Tenant, error) {
+	ids, err := s.redis.SMembers(ctx, tenantIDsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	tenants := make([]Tenant, 0, len(ids))
+	for _, id := range ids {
+		tenant, err := s.getTenant(ctx, id)
+		if err == errTenantNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, *tenant)
+	}
+	return tenants, nil
+}
+
+// newTenant validates the body of PUT /admin/tenants/{id}
+func newTenant(id string, req SetTenantRequest) (*Tenant, error) {
+	if !validTenantID.MatchString(id) {
+		return nil, errors.New("tenant ID must be up to 63 lowercase letters, digits, '-' or '_'")
+	}
+	if req.DailyCharQuota != nil && *req.DailyCharQuota < 0 ||
+		req.RateLimitRPS != nil && *req.RateLimitRPS < 0 ||
+		req.RateLimitBurst != nil && *req.RateLimitBurst < 0 ||
+		req.RateLimitCharsPerMin != nil && *req.RateLimitCharsPerMin < 0 {
+		return nil, errors.New("limits must not be negative")
+	}
+	if err := validateLanguagePairs(req.AllowedLanguagePairs); err != nil {
+		return nil, fmt.Errorf("allowed_language_pairs: %v", err)
+	}
+	if err := validateLanguagePairs(req.DeniedLanguagePairs); err != nil {
+		return nil, fmt.Errorf("denied_language_pairs: %v", err)
+	}
+	return &Tenant{
+		ID:                   id,
+		Description:          req.Description,
+		DailyCharQuota:       req.DailyCharQuota,
+		RateLimitRPS:         req.RateLimitRPS,
+		RateLimitBurst:       req.RateLimitBurst,
+		RateLimitCharsPerMin: req.RateLimitCharsPerMin,
+		AllowedLanguagePairs: req.AllowedLanguagePairs,
+		DeniedLanguagePairs:  req.DeniedLanguagePairs,
+		UpdatedAt:            time.Now().UTC(),
+	}, nil
+}
+
+// handleAdminTenants serves the tenant settings API. Tenants exist as soon
+// as a key is assigned to one; stored settings override the server's rate
+// limits and quota for the tenant's keys.
+//
+//	GET    /admin/tenants      list tenants with settings
+//	GET    /admin/tenants/{id} show a tenant's settings
+//	PUT    /admin/tenants/{id} create or replace a tenant's settings
+//	DELETE /admin/tenants/{id} delete a tenant's settings
+//
+// Credentials are served by handleTenantCredentials.
+func (s *Server) handleAdminTenants(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/tenants"), "/")
+	id, sub, _ := strings.Cut(path, "/")
+	if resource, provider, _ := strings.Cut(sub, "/"); resource == "credentials" {
+		s.handleTenantCredentials(w, r, id, provider)
+		return
+	} else if sub != "" {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := r.Context()
+
+	var response interface{}
+	var err error
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		response, err = s.listTenants(ctx)
+	case id != "" && r.Method == http.MethodGet:
+		response, err = s.getTenant(ctx, id)
+	case id != "" && r.Method == http.MethodPut:
+		var req SetTenantRequest
+		if !decodeRequestBody(w, r, &req) {
+			return
+		}
+		tenant, err := newTenant(id, req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+			return
+		}
+		if err := s.saveTenant(ctx, tenant); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Tenant operation failed: %v", err))
+			return
+		}
+		logger(ctx).Info("saved tenant settings", "tenant", id)
+		response = tenant
+	case id != "" && r.Method == http.MethodDelete:
+		err = s.deleteTenant(ctx, id)
+		if err == nil {
+			logger(ctx).Info("deleted tenant settings", "tenant", id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err == errTenantNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Tenant operation failed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/provider"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/sync/singleflight"
+)
+
+// tenantCredentialsPrefix prefixes the Redis hash of a tenant's sealed
+// credentials, tenants:credentials:<id>, by provider name
+const tenantCredentialsPrefix = "tenants:credentials:"
+
+// Lifetime of providers built from tenant credentials
+const (
+	tenantProviderRefresh = time.Minute // How long credentials are used before checking for changes
+	tenantProviderGrace   = time.Minute // How long a replaced provider may finish in-flight calls
+)
+
+// errTenantCredentialsNotFound is returned when a tenant has no credentials
+// for a provider
+var errTenantCredentialsNotFound = errors.New("credentials not found")
+
+// errTenantCredentialsUnavailable is returned when it isn't known whether a
+// tenant has credentials, as Redis can't be read and none were loaded yet
+var errTenantCredentialsUnavailable = errors.New("tenant credentials unavailable")
+
+// sealedCredentials is how a tenant's credentials for a provider are stored
+type sealedCredentials struct {
+	Ciphertext string    `json:"ciphertext"` // base64 of the nonce and AES-GCM output
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// TenantCredentials describes stored credentials without revealing them
+type TenantCredentials struct {
+	Provider  string    `json:"provider"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// azureCredentials are a tenant's Azure Translator credentials
+type azureCredentials struct {
+	Key    string `json:"key"`
+	Region string `json:"region,omitempty"`
+}
+
+// setupTenantCredentials enables tenant credentials, encrypted with a
+// base64-encoded 32-byte AES-256 key
+func (s *Server) setupTenantCredentials(key string) error {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("invalid base64: %v", err)
+	}
+	if len(raw) != 32 {
+		return fmt.Errorf("key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return err
+	}
+	s.credentialsAEAD, err = cipher.NewGCM(block)
+	return err
+}
+
+// credentialsAAD binds sealed credentials to their tenant and provider, so
+// they can't be moved to another
+func credentialsAAD(tenant, provider string) []byte {
+	return []byte(tenant + "\x00" + provider)
+}
+
+// sealCredentials encrypts a tenant's credentials for a provider
+func (s *Server) sealCredentials(tenant, provider string, plaintext []byte) (string, error) {
+	nonce := make([]byte, s.credentialsAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.credentialsAEAD.Seal(nonce, nonce, plaintext, credentialsAAD(tenant, provider))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openCredentials decrypts a tenant's credentials for a provider
+func (s *Server) openCredentials(tenant, provider, ciphertext string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	n := s.credentialsAEAD.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("ciphertext too short")
+	}
+	plaintext, err := s.credentialsAEAD.Open(nil, sealed[:n], sealed[n:], credentialsAAD(tenant, provider))
+	if err != nil {
+		return nil, errors.New("failed to decrypt credentials, was the key changed?")
+	}
+	return plaintext, nil
+}
+
+// createTenantProvider creates the named provider with a tenant's
+// credentials: a Google service account key or Azure Translator key. Google
+// v3 translations use the service account's project with the default model
+// and no native glossary nor domain models, since the configured ones
+// belong to our project.
+func (s *Server) createTenantProvider(ctx context.Context, name string, secret []byte) (provider.Provider, error) {
+	switch name {
+	case "google":
+		creds, err := google.CredentialsFromJSON(ctx, secret, provider.GoogleScope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Google credentials: %v", err)
+		}
+		return s.createGoogleProvider(ctx, creds, creds.ProjectID, "", "", nil)
+	case "azure":
+		var creds azureCredentials
+		if err := json.Unmarshal(secret, &creds); err != nil {
+			return nil, fmt.Errorf("invalid Azure credentials: %v", err)
+		}
+		if creds.Key == "" {
+			return nil, errors.New("invalid Azure credentials: key is required")
+		}
+		return provider.NewAzure(s.config.AzureEndpoint, creds.Key, creds.Region)
+	default:
+		return nil, fmt.Errorf("tenant credentials aren't supported for provider %q", name)
+	}
+}
+
+// getSealedCredentials loads a tenant's sealed credentials for a provider
+func (s *Server) getSealedCredentials(ctx context.Context, tenant, provider string) (*sealedCredentials, error) {
+	data, err := s.redis.HGet(ctx, tenantCredentialsPrefix+tenant, provider).Result()
+	if err == redis.Nil {
+		return nil, errTenantCredentialsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sealed sealedCredentials
+	if err := json.Unmarshal([]byte(data), &sealed); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal credentials: %v", err)
+	}
+	return &sealed, nil
+}
+
+// listTenantCredentials lists the providers a tenant has credentials for
+func (s *Server) listTenantCredentials(ctx context.Context, tenant string) ([]TenantCredentials, error) {
+	fields, err := s.redis.HGetAll(ctx, tenantCredentialsPrefix+tenant).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]TenantCredentials, 0, len(fields))
+	for provider, data := range fields {
+		var sealed sealedCredentials
+		if err := json.Unmarshal([]byte(data), &sealed); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal credentials: %v", err)
+		}
+		list = append(list, TenantCredentials{Provider: provider, UpdatedAt: sealed.UpdatedAt})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Provider < list[j].Provider })
+	return list, nil
+}
+
+// tenantProviderEntry is a provider built from a tenant's credentials
+type tenantProviderEntry struct {
+	provider   provider.Provider // nil when the tenant has no credentials
+	ciphertext string            // Credentials the provider was built from
+	checked    time.Time
+}
+
+// tenantProviderCache holds the providers built from tenant credentials, by
+// tenant and provider name
+type tenantProviderCache struct {
+	server  *Server
+	mu      sync.Mutex
+	entries map[string]*tenantProviderEntry
+	group   singleflight.Group
+}
+
+// get returns the provider built from a tenant's credentials for name, or
+// nil when the tenant has none. Credentials are checked for changes every
+// tenantProviderRefresh; while Redis is unavailable the last provider is
+// kept, and errTenantCredentialsUnavailable returned when there is none.
+func (c *tenantProviderCache) get(ctx context.Context, tenant, name string) (provider.Provider, error) {
+	s := c.server
+	key := tenant + "/" + name
+	c.mu.Lock()
+	entry := c.entries[key]
+	c.mu.Unlock()
+	if entry != nil && (time.Since(entry.checked) < tenantProviderRefresh || !s.redisAvailable()) {
+		return entry.provider, nil
+	}
+	if entry == nil && !s.redisAvailable() {
+		return nil, errTenantCredentialsUnavailable
+	}
+
+	p, err, _ := c.group.Do(key, func() (interface{}, error) {
+		ciphertext := ""
+		sealed, err := s.getSealedCredentials(ctx, tenant, name)
+		switch {
+		case err == nil:
+			ciphertext = sealed.Ciphertext
+		case err != errTenantCredentialsNotFound:
+			logger(ctx).Warn("failed to load tenant credentials", "tenant", tenant, "provider", name, "error", err)
+			if entry != nil {
+				return entry.provider, nil
+			}
+			return nil, errTenantCredentialsUnavailable
+		}
+		if entry != nil && entry.ciphertext == ciphertext {
+			c.mu.Lock()
+			entry.checked = time.Now()
+			c.mu.Unlock()
+			return entry.provider, nil
+		}
+
+		var p provider.Provider
+		if ciphertext != "" {
+			secret, err := s.openCredentials(tenant, name, ciphertext)
+			if err == nil {
+				p, err = s.createTenantProvider(ctx, name, secret)
+			}
+			if err == nil {
+				p, err = s.decorateProvider(p)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to set up %s credentials of tenant %s: %v", name, tenant, err)
+			}
+			logger(ctx).Info("using tenant credentials", "tenant", tenant, "provider", name)
+		}
+		c.replace(key, &tenantProviderEntry{provider: p, ciphertext: ciphertext, checked: time.Now()})
+		return p, nil
+	})
+	if err != nil || p == nil {
+		return nil, err
+	}
+	return p.(provider.Provider), nil
+}
+
+// replace stores the entry under key, closing the provider it replaces once
+// its in-flight calls had time to finish. A nil entry removes the key.
+func (c *tenantProviderCache) replace(key string, entry *tenantProviderEntry) {
+	c.mu.Lock()
+	old := c.entries[key]
+	if entry != nil {
+		c.entries[key] = entry
+	} else {
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+	if old == nil || old.provider == nil {
+		return
+	}
+	if closer, ok := old.provider.(io.Closer); ok {
+		time.AfterFunc(tenantProviderGrace, func() { closer.Close() })
+	}
+}
+
+// providerFor returns the provider translating for the caller: one built
+// from its tenant's credentials for the primary provider, when the tenant
+// has them, or the shared provider when it has none. Tenant providers don't
+// fail over or take part in canary routing, and tenants whose credentials
+// can't be loaded get an error, so their translations are never billed to
+// the shared credentials.
+func (s *Server) providerFor(ctx context.Context) (provider.Provider, error) {
+	tenant := requestTenant(ctx)
+	if tenant == nil || s.credentialsAEAD == nil {
+		return s.provider, nil
+	}
+	p, err := s.tenantProviders.get(ctx, tenant.ID, s.providers[0].Name())
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return s.provider, nil
+	}
+	return p, nil
+}
+
+// handleTenantCredentials serves the credentials of a tenant, which are
+// write-only:
+//
+//	GET    /admin/tenants/{id}/credentials            list providers with credentials
+//	PUT    /admin/tenants/{id}/credentials/{provider} store credentials, a Google service account key or {"key": ..., "region": ...} for Azure
+//	DELETE /admin/tenants/{id}/credentials/{provider} delete credentials
+func (s *Server) handleTenantCredentials(w http.ResponseWriter, r *http.Request, tenant, provider string) {
+	if s.credentialsAEAD == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Tenant credentials are disabled")
+		return
+	}
+	if !s.redisAvailable() {
+		writeError(w, http.StatusServiceUnavailable, codeServiceUnavailable, "Tenant credentials unavailable: Redis is down")
+		return
+	}
+	if !validTenantID.MatchString(tenant) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: invalid tenant ID")
+		return
+	}
+	ctx := r.Context()
+	key := tenantCredentialsPrefix + tenant
+
+	switch {
+	case provider == "" && r.Method == http.MethodGet:
+		list, err := s.listTenantCredentials(ctx, tenant)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Tenant operation failed: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	case provider != "" && r.Method == http.MethodPut:
+		secret, _, ok := readUploadedFile(w, r)
+		if !ok {
+			return
+		}
+		// Building the provider validates the credentials without a request
+		p, err := s.createTenantProvider(ctx, provider, secret)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+			return
+		}
+		if closer, ok := p.(io.Closer); ok {
+			closer.Close()
+		}
+		ciphertext, err := s.sealCredentials(tenant, provider, secret)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Tenant operation failed: %v", err))
+			return
+		}
+		sealed := sealedCredentials{Ciphertext: ciphertext, UpdatedAt: time.Now().UTC()}
+		data, _ := json.Marshal(sealed)
+		if err := s.redis.HSet(ctx, key, provider, data).Err(); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Tenant operation failed: %v", err))
+			return
+		}
+		s.tenantProviders.replace(tenant+"/"+provider, nil)
+		logger(ctx).Info("stored tenant credentials", "tenant", tenant, "provider", provider)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TenantCredentials{Provider: provider, UpdatedAt: sealed.UpdatedAt})
+	case provider != "" && r.Method == http.MethodDelete:
+		n, err := s.redis.HDel(ctx, key, provider).Result()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Tenant operation failed: %v", err))
+			return
+		}
+		if n == 0 {
+			writeError(w, http.StatusNotFound, codeNotFound, "Credentials not found")
+			return
+		}
+		s.tenantProviders.replace(tenant+"/"+provider, nil)
+		logger(ctx).Info("deleted tenant credentials", "tenant", tenant, "provider", provider)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// newCredentialsServer returns a test server with tenant credentials
+// enabled, its primary provider named azure
+func newCredentialsServer(t *testing.T) *Server {
+	t.Helper()
+	s, _ := newTestServer(t, &fakeProvider{name: "azure"}, nil)
+	if err := s.setupTenantCredentials(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))); err != nil {
+		t.Fatalf("setupTenantCredentials() error = %v", err)
+	}
+	return s
+}
+
+// storeCredentials seals and stores a tenant's credentials for a provider
+func storeCredentials(t *testing.T, s *Server, tenant, provider, secret string) {
+	t.Helper()
+	ciphertext, err := s.sealCredentials(tenant, provider, []byte(secret))
+	if err != nil {
+		t.Fatalf("sealCredentials() error = %v", err)
+	}
+	data, _ := json.Marshal(sealedCredentials{Ciphertext: ciphertext, UpdatedAt: time.Now().UTC()})
+	if err := s.redis.HSet(context.Background(), tenantCredentialsPrefix+tenant, provider, data).Err(); err != nil {
+		t.Fatalf("HSET error = %v", err)
+	}
+}
+
+func TestProviderFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		tenant string
+		// setup prepares the server, returning the provider it should pick,
+		// nil for the shared one
+		setup   func(t *testing.T, s *Server) provider.Provider
+		wantErr error
+	}{
+		{
+			name:  "outside a tenant",
+			setup: func(t *testing.T, s *Server) provider.Provider { return nil },
+		},
+		{
+			name:   "tenant without credentials",
+			tenant: "shop",
+			setup:  func(t *testing.T, s *Server) provider.Provider { return nil },
+		},
+		{
+			name:   "unreadable credentials",
+			tenant: "shop",
+			setup: func(t *testing.T, s *Server) provider.Provider {
+				// Of the wrong type, so HGET fails
+				s.redis.Set(context.Background(), tenantCredentialsPrefix+"shop", "x", 0)
+				return nil
+			},
+			wantErr: errTenantCredentialsUnavailable,
+		},
+		{
+			name:   "Redis unavailable, nothing loaded",
+			tenant: "shop",
+			setup: func(t *testing.T, s *Server) provider.Provider {
+				s.redisUp.Store(false)
+				return nil
+			},
+			wantErr: errTenantCredentialsUnavailable,
+		},
+		{
+			name:   "Redis unavailable, loaded before",
+			tenant: "shop",
+			setup: func(t *testing.T, s *Server) provider.Provider {
+				loaded := &fakeProvider{name: "azure"}
+				s.tenantProviders.replace("shop/azure", &tenantProviderEntry{provider: loaded, ciphertext: "c", checked: time.Now().Add(-time.Hour)})
+				s.redisUp.Store(false)
+				return loaded
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newCredentialsServer(t)
+			want := tt.setup(t, s)
+			if want == nil {
+				want = s.provider
+			}
+			ctx := s.withAPIKeyName(context.Background(), "web", tt.tenant)
+
+			p, err := s.providerFor(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("providerFor() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && p != want {
+				t.Errorf("providerFor() = %v, want %v", p, want)
+			}
+		})
+	}
+}
+
+func TestProviderForTenantCredentials(t *testing.T) {
+	s := newCredentialsServer(t)
+	storeCredentials(t, s, "shop", "azure", `{"key": "tenant-key", "region": "westeurope"}`)
+
+	p, err := s.providerFor(s.withAPIKeyName(context.Background(), "web", "shop"))
+	if err != nil {
+		t.Fatalf("providerFor() error = %v", err)
+	}
+	if p == s.provider || p.Name() != "azure" {
+		t.Errorf("providerFor() = %v, want the tenant's azure provider", p)
+	}
+	other, err := s.providerFor(s.withAPIKeyName(context.Background(), "web", "blog"))
+	if err != nil {
+		t.Fatalf("providerFor() error = %v", err)
+	}
+	if other != s.provider {
+		t.Errorf("providerFor() of another tenant = %v, want the shared provider", other)
+	}
+}
+
+func TestSetupTenantCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"AES-256 key", base64.StdEncoding.EncodeToString(make([]byte, 32)), false},
+		{"not base64", "not base64!", true},
+		{"AES-128 key", base64.StdEncoding.EncodeToString(make([]byte, 16)), true},
+		{"too long", base64.StdEncoding.EncodeToString(make([]byte, 33)), true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, nil)
+			if err := s.setupTenantCredentials(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("setupTenantCredentials() error = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpenCredentials(t *testing.T) {
+	const secret = `{"key": "tenant-key"}`
+	s := newCredentialsServer(t)
+	ciphertext, err := s.sealCredentials("shop", "azure", []byte(secret))
+	if err != nil {
+		t.Fatalf("sealCredentials() error = %v", err)
+	}
+	if strings.Contains(ciphertext, "tenant-key") {
+		t.Fatalf("sealCredentials() = %q, holding the secret", ciphertext)
+	}
+	if again, _ := s.sealCredentials("shop", "azure", []byte(secret)); again == ciphertext {
+		t.Error("sealCredentials() reused a nonce")
+	}
+	raw, _ := base64.StdEncoding.DecodeString(ciphertext)
+	tampered := append([]byte(nil), raw...)
+	tampered[len(tampered)-1] ^= 1
+
+	otherKey := newCredentialsServer(t)
+	if err := otherKey.setupTenantCredentials(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))); err != nil {
+		t.Fatalf("setupTenantCredentials() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		server     *Server
+		tenant     string
+		provider   string
+		ciphertext string
+		wantErr    bool
+	}{
+		{"sealed", s, "shop", "azure", ciphertext, false},
+		// The credentials are bound to their tenant and provider
+		{"another tenant", s, "blog", "azure", ciphertext, true},
+		{"another provider", s, "shop", "google", ciphertext, true},
+		{"tampered", s, "shop", "azure", base64.StdEncoding.EncodeToString(tampered), true},
+		{"truncated", s, "shop", "azure", base64.StdEncoding.EncodeToString(raw[:8]), true},
+		{"not base64", s, "shop", "azure", "not base64!", true},
+		{"another key", otherKey, "shop", "azure", ciphertext, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.server.openCredentials(tt.tenant, tt.provider, tt.ciphertext)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openCredentials() error = %v, want an error: %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != secret {
+				t.Errorf("openCredentials() = %q, want %q", got, secret)
+			}
+		})
+	}
+}
+
+func TestTenantCredentialsAPI(t *testing.T) {
+	const secret = `{"key": "tenant-key", "region": "westeurope"}`
+	s := newCredentialsServer(t)
+	s.config.AdminToken = "admin"
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"store", http.MethodPut, "/admin/tenants/shop/credentials/azure", secret, http.StatusOK},
+		{"list", http.MethodGet, "/admin/tenants/shop/credentials", "", http.StatusOK},
+		{"store invalid", http.MethodPut, "/admin/tenants/shop/credentials/azure", `{"region": "westeurope"}`, http.StatusBadRequest},
+		{"store unsupported provider", http.MethodPut, "/admin/tenants/shop/credentials/deepl", secret, http.StatusBadRequest},
+		{"store without credentials", http.MethodPut, "/admin/tenants/shop/credentials/azure", "", http.StatusBadRequest},
+		{"invalid tenant", http.MethodPut, "/admin/tenants/Shop!/credentials/azure", secret, http.StatusBadRequest},
+		{"read", http.MethodGet, "/admin/tenants/shop/credentials/azure", "", http.StatusMethodNotAllowed},
+		{"delete", http.MethodDelete, "/admin/tenants/shop/credentials/azure", "", http.StatusNoContent},
+		{"delete again", http.MethodDelete, "/admin/tenants/shop/credentials/azure", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer admin")
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			// The credentials are write-only
+			if strings.Contains(w.Body.String(), "tenant-key") {
+				t.Errorf("response has the credentials: %s", w.Body)
+			}
+		})
+	}
+
+	// Stored sealed, and used once stored
+	r := httptest.NewRequest(http.MethodPut, "/admin/tenants/shop/credentials/azure", strings.NewReader(secret))
+	r.Header.Set("Authorization", "Bearer admin")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), r)
+	stored, err := s.redis.HGet(context.Background(), tenantCredentialsPrefix+"shop", "azure").Result()
+	if err != nil {
+		t.Fatalf("HGET error = %v", err)
+	}
+	if strings.Contains(stored, "tenant-key") {
+		t.Errorf("stored credentials = %s, holding the secret", stored)
+	}
+	p, err := s.providerFor(s.withAPIKeyName(context.Background(), "web", "shop"))
+	if err != nil || p == s.provider {
+		t.Errorf("providerFor() = %v, %v, want the tenant's provider", p, err)
+	}
+}
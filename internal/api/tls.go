@@ -0,0 +1,136 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certCheckInterval bounds how often TLS_CERT_FILE is checked for renewal
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the key pair of TLS_CERT_FILE and TLS_KEY_FILE,
+// loading it again once the files change, so renewed certificates are
+// picked up without a restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// newCertReloader loads the key pair, failing when it can't be
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the key pair when the certificate file changed since the last
+// load
+func (r *certReloader) load() error {
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && info.ModTime().Equal(r.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, info.ModTime()
+	return nil
+}
+
+// getCertificate is the tls.Config GetCertificate hook. The previous key
+// pair keeps being served when the files can't be loaded, e.g. halfway
+// through a renewal.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= certCheckInterval {
+		r.checked = time.Now()
+		if err := r.load(); err != nil {
+			slog.Warn("failed to reload TLS certificate, serving the previous one", "error", err)
+		}
+	}
+	return r.cert, nil
+}
+
+// TLSEnabled reports whether the listener serves HTTPS
+func (s *Server) TLSEnabled() bool {
+	return s.config.TLSCertFile != "" || s.config.TLSKeyFile != "" || len(s.config.TLSAutocertDomains) > 0
+}
+
+// TLSConfig builds the listener's TLS configuration from a key pair or
+// certificates obtained from an ACME CA, requiring client certificates
+// signed by TLS_CLIENT_CA_FILE when set. HTTP/2 is negotiated over it.
+func (s *Server) TLSConfig() (*tls.Config, error) {
+	var tlsConfig *tls.Config
+	switch {
+	case len(s.config.TLSAutocertDomains) > 0:
+		if s.config.TLSCertFile != "" || s.config.TLSKeyFile != "" {
+			return nil, errors.New("TLS_AUTOCERT_DOMAINS can't be combined with TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(s.config.TLSAutocertDomains...),
+			Cache:      autocert.DirCache(s.config.TLSAutocertCacheDir),
+			Email:      s.config.TLSAutocertEmail,
+		}
+		// Serves the TLS-ALPN-01 challenge alongside h2 and HTTP/1.1
+		tlsConfig = manager.TLSConfig()
+	case s.config.TLSCertFile != "" && s.config.TLSKeyFile != "":
+		reloader, err := newCertReloader(s.config.TLSCertFile, s.config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key pair: %v", err)
+		}
+		tlsConfig = &tls.Config{
+			GetCertificate: reloader.getCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}
+	default:
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	if s.config.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(s.config.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", s.config.TLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+		// The ACME CA doesn't present a client certificate when validating
+		// the TLS-ALPN-01 challenge
+		tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+				challenge := tlsConfig.Clone()
+				challenge.GetConfigForClient = nil
+				challenge.ClientAuth = tls.NoClientCert
+				return challenge, nil
+			}
+			return nil, nil
+		}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,667 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis keys used by the translation memory, which never expire
+const (
+	tmKeyPrefix      = "tm:"          // tm:<source>:<target>:<sha256(text)> holds the JSON TMEntry
+	tmVersionsPrefix = "tm-versions:" // tm-versions:<source>:<target>:<sha256(text)> lists replaced versions, newest first
+)
+
+// tmMaxVersions bounds the replaced versions kept per entry
+const tmMaxVersions = 20
+
+// tmProviderName is reported as the provider of translations served from
+// the translation memory
+const tmProviderName = "translation_memory"
+
+// tmBatchSize is how many entries are written or read per Redis round trip
+const tmBatchSize = 500
+
+// Origins of translation memory entries
+const (
+	tmOriginTMX      = "tmx"      // Imported from a TMX file
+	tmOriginAPI      = "api"      // Set with PUT /admin/tm
+	tmOriginOverride = "override" // Pinned with PUT /admin/translations
+)
+
+// Provenances of translation memory entries
+const (
+	tmProvenanceHuman   = "human"   // Translated or reviewed by a person
+	tmProvenanceMachine = "machine" // Machine translated, such as pre-translated vendor segments
+)
+
+// errTMEntryNotFound is returned when a text has no translation memory entry
+var errTMEntryNotFound = errors.New("translation memory entry not found")
+
+// tmxTimeFormat is the TMX date format, ISO 8601 in UTC
+const tmxTimeFormat = "20060102T150405Z"
+
+// TMEntry is a curated translation of a source text. Every change makes a
+// new version, keeping the replaced one.
+type TMEntry struct {
+	SourceLang string    `json:"source_lang"`
+	TargetLang string    `json:"target_lang"`
+	SourceText string    `json:"source_text"`
+	TargetText string    `json:"target_text"`
+	Provenance string    `json:"provenance"`
+	Origin     string    `json:"origin"`
+	Version    int       `json:"version"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Overrides are pinned: they are served even with the translation memory
+	// disabled, and only replaced by other overrides
+	Pinned bool   `json:"pinned,omitempty"`
+	SetBy  string `json:"set_by,omitempty"` // Who pinned the translation
+	Reason string `json:"reason,omitempty"`
+}
+
+// TMMatch describes the translation memory entry a translation was served
+// from
+type TMMatch struct {
+	Provenance string    `json:"provenance"`
+	Version    int       `json:"version"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Pinned     bool      `json:"pinned,omitempty"`
+	Score      float64   `json:"score"`                 // Similarity of the entry's source text, 1 for exact matches
+	SourceText string    `json:"source_text,omitempty"` // Of fuzzy matches
+}
+
+// newTMMatch describes an entry matching with score
+func newTMMatch(entry *TMEntry, score float64) *TMMatch {
+	match := &TMMatch{Provenance: entry.Provenance, Version: entry.Version, UpdatedAt: entry.UpdatedAt, Pinned: entry.Pinned, Score: score}
+	if score < 1 {
+		match.SourceText = entry.SourceText
+	}
+	return match
+}
+
+// SetTMEntryRequest is the body of PUT /admin/tm
+type SetTMEntryRequest struct {
+	SourceLang string `json:"source_lang"`
+	TargetLang string `json:"target_lang"`
+	SourceText string `json:"source_text"`
+	TargetText string `json:"target_text"`
+	Provenance string `json:"provenance,omitempty"` // Defaults to human
+}
+
+// TMEntryResponse is the body returned by GET /admin/tm
+type TMEntryResponse struct {
+	TMEntry
+	Versions []TMEntry `json:"versions"` // Replaced versions, newest first
+}
+
+// TMImportResponse is the body returned by POST /admin/tm/import
+type TMImportResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"` // Unchanged entries, and existing ones kept with overwrite=false
+}
+
+// tmKey returns the key of the entry translating text between two languages
+func tmKey(sourceLang, targetLang, text string) string {
+	return tmKeyPrefix + strings.ToLower(sourceLang) + ":" + strings.ToLower(targetLang) + ":" + textHash(text)
+}
+
+// tmVersionsKey returns the key listing the replaced versions of the entry
+// stored under key
+func tmVersionsKey(key string) string {
+	return tmVersionsPrefix + strings.TrimPrefix(key, tmKeyPrefix)
+}
+
+// validTMProvenance reports whether provenance is a known provenance
+func validTMProvenance(provenance string) bool {
+	return provenance == tmProvenanceHuman || provenance == tmProvenanceMachine
+}
+
+// tmLookupPairs returns the language pairs of the entries that may
+// translate req, in order of preference: the request's languages, then
+// their base languages
+func tmLookupPairs(req TranslationRequest) [][2]string {
+	sources := []string{req.SourceLang}
+	if base, _, found := strings.Cut(req.SourceLang, "-"); found {
+		sources = append(sources, base)
+	}
+	targets := []string{req.TargetLang}
+	if base, _, found := strings.Cut(req.TargetLang, "-"); found {
+		targets = append(targets, base)
+	}
+	var pairs [][2]string
+	for _, target := range targets {
+		for _, source := range sources {
+			pairs = append(pairs, [2]string{source, target})
+		}
+	}
+	return pairs
+}
+
+// tmLookupKeys returns the keys an entry for req may be stored under, in
+// order of preference
+func tmLookupKeys(req TranslationRequest) []string {
+	var keys []string
+	for _, pair := range tmLookupPairs(req) {
+		keys = append(keys, tmKey(pair[0], pair[1], req.Text))
+	}
+	return keys
+}
+
+// lookupTranslationMemory returns the translation memory's translation of
+// req, or nil. Requests without a source language only match overrides set
+// without one, and translations proceed without the memory while it can't
+// be read.
+func (s *Server) lookupTranslationMemory(ctx context.Context, req TranslationRequest) *TMEntry {
+	if !s.redisAvailable() {
+		return nil
+	}
+	values, err := s.redis.MGet(ctx, tmLookupKeys(req)...).Result()
+	if err != nil {
+		logger(ctx).Warn("failed to look up translation memory", "error", err)
+		tmLookups.WithLabelValues("error").Inc()
+		return nil
+	}
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var entry TMEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			logger(ctx).Warn("failed to unmarshal translation memory entry", "error", err)
+			continue
+		}
+		if entry.SourceText == req.Text && (s.config.TranslationMemory || entry.Pinned) {
+			tmLookups.WithLabelValues("hit").Inc()
+			return &entry
+		}
+	}
+	tmLookups.WithLabelValues("miss").Inc()
+	return nil
+}
+
+// getTMEntry loads the entry translating text between two languages
+func (s *Server) getTMEntry(ctx context.Context, sourceLang, targetLang, text string) (*TMEntry, error) {
+	data, err := s.redis.Get(ctx, tmKey(sourceLang, targetLang, text)).Result()
+	if err == redis.Nil {
+		return nil, errTMEntryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry TMEntry
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal translation memory entry: %v", err)
+	}
+	if entry.SourceText != text {
+		return nil, errTMEntryNotFound
+	}
+	return &entry, nil
+}
+
+// getTMVersions loads the replaced versions of an entry, newest first
+func (s *Server) getTMVersions(ctx context.Context, entry *TMEntry) ([]TMEntry, error) {
+	key := tmVersionsKey(tmKey(entry.SourceLang, entry.TargetLang, entry.SourceText))
+	values, err := s.redis.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]TMEntry, 0, len(values))
+	for _, data := range values {
+		var version TMEntry
+		if err := json.Unmarshal([]byte(data), &version); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal translation memory version: %v", err)
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// saveTMEntries stores entries in batches as new versions of any existing
+// entries, which are kept unless overwrite is set. Entries that wouldn't
+// change are skipped. It returns how many were stored.
+func (s *Server) saveTMEntries(ctx context.Context, entries []TMEntry, overwrite bool) (int, error) {
+	saved := 0
+	for start := 0; start < len(entries); start += tmBatchSize {
+		end := start + tmBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		batch := entries[start:end]
+		keys := make([]string, len(batch))
+		for i, entry := range batch {
+			keys[i] = tmKey(entry.SourceLang, entry.TargetLang, entry.SourceText)
+		}
+		existing, err := s.redis.MGet(ctx, keys...).Result()
+		if err != nil {
+			return saved, err
+		}
+
+		pipe := s.redis.Pipeline()
+		for i, entry := range batch {
+			// Indexing is idempotent, so reimports index older entries too
+			indexTMEntry(ctx, pipe, entry)
+			entry.Version = 1
+			if old, ok := existing[i].(string); ok {
+				var current TMEntry
+				if err := json.Unmarshal([]byte(old), &current); err != nil {
+					return saved, fmt.Errorf("failed to unmarshal translation memory entry: %v", err)
+				}
+				if !overwrite || current.Pinned && !entry.Pinned || sameTMEntry(current, entry) {
+					continue
+				}
+				entry.Version = current.Version + 1
+				pipe.LPush(ctx, tmVersionsKey(keys[i]), old)
+				pipe.LTrim(ctx, tmVersionsKey(keys[i]), 0, tmMaxVersions-1)
+			}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return saved, err
+			}
+			pipe.Set(ctx, keys[i], data, 0)
+			saved++
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return saved, err
+		}
+	}
+	return saved, nil
+}
+
+// sameTMEntry reports whether saving entry over current would change nothing
+func sameTMEntry(current, entry TMEntry) bool {
+	return current.TargetText == entry.TargetText && current.Provenance == entry.Provenance &&
+		current.Pinned == entry.Pinned && current.SetBy == entry.SetBy && current.Reason == entry.Reason
+}
+
+// deleteTMEntry removes the entry translating text between two languages,
+// with its versions
+func (s *Server) deleteTMEntry(ctx context.Context, sourceLang, targetLang, text string) error {
+	key := tmKey(sourceLang, targetLang, text)
+	pipe := s.redis.TxPipeline()
+	del := pipe.Del(ctx, key, tmVersionsKey(key))
+	unindexTMEntry(ctx, pipe, sourceLang, targetLang, text)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if del.Val() == 0 {
+		return errTMEntryNotFound
+	}
+	return nil
+}
+
+// scanTMEntries calls fn with every entry between two languages, either of
+// which may be empty to match any language
+func (s *Server) scanTMEntries(ctx context.Context, sourceLang, targetLang string, fn func(TMEntry) error) error {
+	source, target := "*", "*"
+	if sourceLang != "" {
+		source = escapeGlob(strings.ToLower(sourceLang))
+	}
+	if targetLang != "" {
+		target = escapeGlob(strings.ToLower(targetLang))
+	}
+	pattern := tmKeyPrefix + source + ":" + target + ":*"
+
+	var cursor uint64
+	for {
+		keys, next, err := s.redis.Scan(ctx, cursor, pattern, tmBatchSize).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			values, err := s.redis.MGet(ctx, keys...).Result()
+			if err != nil {
+				return err
+			}
+			for _, value := range values {
+				data, ok := value.(string)
+				if !ok {
+					continue // Deleted since the scan
+				}
+				var entry TMEntry
+				if err := json.Unmarshal([]byte(data), &entry); err != nil {
+					return fmt.Errorf("failed to unmarshal translation memory entry: %v", err)
+				}
+				if err := fn(entry); err != nil {
+					return err
+				}
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// parseTMX reads the translation units of a TMX file as entries from each
+// unit's source language to each of its other languages. Inline codes such
+// as <bpt> and <ph> are replaced by the native markup they hold. Units have
+// the given provenance unless an x-provenance property says otherwise.
+func parseTMX(data []byte, provenance string) ([]TMEntry, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	now := time.Now().UTC()
+	var entries []TMEntry
+	var headerLang, unitLang, unitProvenance, lang string
+	var segs [][2]string // Language and text of each variant of the unit
+	var seg *strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "header":
+				headerLang = xmlAttr(t, "srclang")
+			case "tu":
+				unitLang, unitProvenance, segs = xmlAttr(t, "srclang"), provenance, nil
+				if unitLang == "" {
+					unitLang = headerLang
+				}
+			case "prop":
+				var prop struct {
+					Value string `xml:",chardata"`
+				}
+				if err := dec.DecodeElement(&prop, &t); err != nil {
+					return nil, err
+				}
+				if xmlAttr(t, "type") == "x-provenance" {
+					if unitProvenance = strings.TrimSpace(prop.Value); !validTMProvenance(unitProvenance) {
+						return nil, fmt.Errorf("invalid x-provenance %q", unitProvenance)
+					}
+				}
+			case "tuv":
+				// xml:lang, or lang in TMX 1.1
+				lang = xmlAttr(t, "lang")
+			case "seg":
+				seg = &strings.Builder{}
+			}
+		case xml.CharData:
+			if seg != nil {
+				seg.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "seg":
+				if lang != "" {
+					segs = append(segs, [2]string{lang, seg.String()})
+				}
+				seg = nil
+			case "tu":
+				entries = append(entries, tmxUnitEntries(unitLang, unitProvenance, segs, now)...)
+			}
+		}
+	}
+	if headerLang == "" {
+		return nil, fmt.Errorf("missing header srclang")
+	}
+	return entries, nil
+}
+
+// tmxUnitEntries returns the entries of a translation unit. A source
+// language of *all* makes every variant a source for the others.
+func tmxUnitEntries(sourceLang, provenance string, segs [][2]string, now time.Time) []TMEntry {
+	var entries []TMEntry
+	for _, source := range segs {
+		if sourceLang != "*all*" && !strings.EqualFold(source[0], sourceLang) || strings.TrimSpace(source[1]) == "" {
+			continue
+		}
+		for _, target := range segs {
+			if strings.EqualFold(target[0], source[0]) || strings.TrimSpace(target[1]) == "" {
+				continue
+			}
+			entries = append(entries, TMEntry{
+				SourceLang: strings.ToLower(source[0]),
+				TargetLang: strings.ToLower(target[0]),
+				SourceText: source[1],
+				TargetText: target[1],
+				Provenance: provenance,
+				Origin:     tmOriginTMX,
+				UpdatedAt:  now,
+			})
+		}
+	}
+	return entries
+}
+
+// writeTMX writes a TMX 1.4 file, calling entries to write each of its
+// translation units
+func writeTMX(w io.Writer, sourceLang string, entries func(unit func(TMEntry) error) error) error {
+	if sourceLang == "" {
+		sourceLang = "*all*"
+	}
+	escape := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	fmt.Fprintf(w, "%s<tmx version=\"1.4\">\n"+
+		"  <header creationtool=\"translation-service\" creationtoolversion=\"1\" segtype=\"sentence\" o-tmf=\"redis\" adminlang=\"en\" srclang=\"%s\" datatype=\"plaintext\"/>\n"+
+		"  <body>\n", xml.Header, escape(sourceLang))
+	err := entries(func(e TMEntry) error {
+		_, err := fmt.Fprintf(w, "    <tu srclang=\"%s\" changedate=\"%s\">\n"+
+			"      <prop type=\"x-provenance\">%s</prop>\n"+
+			"      <prop type=\"x-origin\">%s</prop>\n"+
+			"      <tuv xml:lang=\"%s\"><seg>%s</seg></tuv>\n"+
+			"      <tuv xml:lang=\"%s\"><seg>%s</seg></tuv>\n"+
+			"    </tu>\n",
+			escape(e.SourceLang), e.UpdatedAt.UTC().Format(tmxTimeFormat), escape(e.Provenance), escape(e.Origin),
+			escape(e.SourceLang), escape(e.SourceText), escape(e.TargetLang), escape(e.TargetText))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "  </body>\n</tmx>\n")
+	return err
+}
+
+// handleAdminTM serves the translation memory API. Entries are identified
+// by their source_lang, target_lang and text query parameters.
+//
+//	GET    /admin/tm?source_lang=en&target_lang=de&text=... show an entry and its versions
+//	PUT    /admin/tm                                         create or update an entry
+//	DELETE /admin/tm?source_lang=en&target_lang=de&text=... delete an entry
+//	POST   /admin/tm/import                                  load a TMX file, uploaded like subtitles
+//	GET    /admin/tm/export?source_lang=en&target_lang=de    download entries as TMX, optionally of one language pair
+func (s *Server) handleAdminTM(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+	ctx := r.Context()
+	if !s.redisAvailable() {
+		writeError(w, http.StatusServiceUnavailable, codeServiceUnavailable, "Translation memory unavailable: Redis is down")
+		return
+	}
+
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/admin/tm":
+		s.handleTMEntry(w, r)
+	case "/admin/tm/import":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		overwrite := true
+		if v := query.Get("overwrite"); v != "" {
+			var err error
+			if overwrite, err = strconv.ParseBool(v); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: invalid overwrite: %v", err))
+				return
+			}
+		}
+		provenance := query.Get("provenance")
+		if provenance == "" {
+			provenance = tmProvenanceHuman
+		}
+		if !validTMProvenance(provenance) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: provenance must be %q or %q", tmProvenanceHuman, tmProvenanceMachine))
+			return
+		}
+		data, _, ok := readUploadedFile(w, r)
+		if !ok {
+			return
+		}
+		entries, err := parseTMX(data, provenance)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid TMX file: %v", err))
+			return
+		}
+		saved, err := s.saveTMEntries(ctx, entries, overwrite)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Translation memory import failed: %v", err))
+			return
+		}
+		logger(ctx).Info("imported translation memory", "entries", saved, "skipped", len(entries)-saved)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(TMImportResponse{Imported: saved, Skipped: len(entries) - saved})
+	case "/admin/tm/export":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		sourceLang, targetLang := query.Get("source_lang"), query.Get("target_lang")
+		w.Header().Set("Content-Type", "application/x-tmx+xml; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="translation-memory.tmx"`)
+		err := writeTMX(w, sourceLang, func(unit func(TMEntry) error) error {
+			return s.scanTMEntries(ctx, sourceLang, targetLang, unit)
+		})
+		if err != nil {
+			// The response is already underway
+			logger(ctx).Error("translation memory export failed", "error", err)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleTMEntry shows, sets and deletes single translation memory entries
+func (s *Server) handleTMEntry(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	sourceLang, targetLang, text := query.Get("source_lang"), query.Get("target_lang"), query.Get("text")
+	if r.Method != http.MethodPut && (sourceLang == "" || targetLang == "" || text == "") {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request: source_lang, target_lang and text are required")
+		return
+	}
+
+	var response interface{}
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		var entry *TMEntry
+		if entry, err = s.getTMEntry(ctx, sourceLang, targetLang, text); err == nil {
+			var versions []TMEntry
+			if versions, err = s.getTMVersions(ctx, entry); err == nil {
+				response = TMEntryResponse{TMEntry: *entry, Versions: versions}
+			}
+		}
+	case http.MethodPut:
+		var req SetTMEntryRequest
+		if !decodeRequestBody(w, r, &req) {
+			return
+		}
+		entry, err := newTMEntry(req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, invalidRequestCode(err), fmt.Sprintf("Invalid request: %v", err))
+			return
+		}
+		stored, ok := s.putTMEntry(ctx, w, entry)
+		if !ok {
+			return
+		}
+		response = stored
+	case http.MethodDelete:
+		err = s.deleteTMEntry(ctx, sourceLang, targetLang, text)
+		if err == nil {
+			logger(ctx).Info("deleted translation memory entry", "source_lang", sourceLang, "target_lang", targetLang)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err == errTMEntryNotFound {
+		writeError(w, http.StatusNotFound, codeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Translation memory operation failed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// putTMEntry saves a single entry and returns it as stored, writing the
+// error response and returning false on failure. Overrides can't be
+// replaced by other entries.
+func (s *Server) putTMEntry(ctx context.Context, w http.ResponseWriter, entry TMEntry) (*TMEntry, bool) {
+	if _, err := s.saveTMEntries(ctx, []TMEntry{entry}, true); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Translation memory operation failed: %v", err))
+		return nil, false
+	}
+	stored, err := s.getTMEntry(ctx, entry.SourceLang, entry.TargetLang, entry.SourceText)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Translation memory operation failed: %v", err))
+		return nil, false
+	}
+	if stored.Pinned && !entry.Pinned {
+		writeError(w, http.StatusConflict, codeConflict, "Entry is pinned by an override, change it with PUT /admin/translations")
+		return nil, false
+	}
+	logger(ctx).Info("saved translation memory entry", "source_lang", stored.SourceLang, "target_lang", stored.TargetLang, "version", stored.Version, "pinned", stored.Pinned)
+	return stored, true
+}
+
+// newTMEntry validates the body of PUT /admin/tm, returning its entry
+func newTMEntry(req SetTMEntryRequest) (TMEntry, error) {
+	if req.SourceText == "" || req.TargetText == "" {
+		return TMEntry{}, errors.New("source_text and target_text are required")
+	}
+	if req.SourceLang == "" || req.TargetLang == "" {
+		return TMEntry{}, errors.New("source_lang and target_lang are required")
+	}
+	if err := validateLanguages(TranslationRequest{SourceLang: req.SourceLang, TargetLang: req.TargetLang}); err != nil {
+		return TMEntry{}, err
+	}
+	if req.Provenance == "" {
+		req.Provenance = tmProvenanceHuman
+	}
+	if !validTMProvenance(req.Provenance) {
+		return TMEntry{}, fmt.Errorf("provenance must be %q or %q", tmProvenanceHuman, tmProvenanceMachine)
+	}
+	return TMEntry{
+		SourceLang: strings.ToLower(req.SourceLang),
+		TargetLang: strings.ToLower(req.TargetLang),
+		SourceText: req.SourceText,
+		TargetText: req.TargetText,
+		Provenance: req.Provenance,
+		Origin:     tmOriginAPI,
+		UpdatedAt:  time.Now().UTC(),
+	}, nil
+}
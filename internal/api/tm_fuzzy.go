@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tmIndexPrefix prefixes the word index of the translation memory,
+// tm-index:<source>:<target>:<word> holding the text hashes of the entries
+// whose source text has the word
+const tmIndexPrefix = "tm-index:"
+
+// Bounds of fuzzy lookups, keeping them to a few cheap Redis round trips
+const (
+	tmIndexMaxWords      = 64    // Distinct words of a text that are indexed or looked up
+	tmIndexMaxPostings   = 10000 // Words in more entries than this are too common to narrow the search
+	tmFuzzyCandidates    = 20    // Entries sharing the most words that are scored
+	tmFuzzyMaxTextLength = 2000  // Longer texts, in runes, aren't fuzzy matched
+)
+
+// tmIndexWords returns the distinct lowercased words of text, in order
+func tmIndexWords(text string) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if utf8.RuneCountInString(word) < 2 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+		if len(words) == tmIndexMaxWords {
+			break
+		}
+	}
+	return words
+}
+
+// tmIndexKey returns the key of the index set of a word
+func tmIndexKey(sourceLang, targetLang, word string) string {
+	return tmIndexPrefix + strings.ToLower(sourceLang) + ":" + strings.ToLower(targetLang) + ":" + word
+}
+
+// indexTMEntry adds the words of an entry's source text to the index
+func indexTMEntry(ctx context.Context, pipe redis.Pipeliner, entry TMEntry) {
+	hash := textHash(entry.SourceText)
+	for _, word := range tmIndexWords(entry.SourceText) {
+		pipe.SAdd(ctx, tmIndexKey(entry.SourceLang, entry.TargetLang, word), hash)
+	}
+}
+
+// unindexTMEntry removes the words of a deleted entry's source text from the
+// index
+func unindexTMEntry(ctx context.Context, pipe redis.Pipeliner, sourceLang, targetLang, text string) {
+	hash := textHash(text)
+	for _, word := range tmIndexWords(text) {
+		pipe.SRem(ctx, tmIndexKey(sourceLang, targetLang, word), hash)
+	}
+}
+
+// similarity returns the Levenshtein similarity of two texts, from 0 for
+// entirely different texts to 1 for identical ones
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+// similarLength reports whether texts of the lengths of a and b could be
+// as similar as threshold, saving the full comparison when they can't
+func similarLength(a, b string, threshold float64) bool {
+	la, lb := utf8.RuneCountInString(a), utf8.RuneCountInString(b)
+	if la > lb {
+		la, lb = lb, la
+	}
+	return lb == 0 || float64(la)/float64(lb) >= threshold
+}
+
+// fuzzyLookupTranslationMemory returns the entry whose source text is most
+// similar to req's, with its similarity, when that is at least
+// config.TMFuzzyThreshold. Candidates are the entries sharing the most
+// words with the text, so texts without indexable words never match.
+func (s *Server) fuzzyLookupTranslationMemory(ctx context.Context, req TranslationRequest) (*TMEntry, float64) {
+	threshold := s.currentConfig().TMFuzzyThreshold
+	if threshold <= 0 || !s.redisAvailable() || utf8.RuneCountInString(req.Text) > tmFuzzyMaxTextLength {
+		return nil, 0
+	}
+	words := tmIndexWords(req.Text)
+	if len(words) == 0 {
+		return nil, 0
+	}
+
+	var best *TMEntry
+	bestScore := 0.0
+	for _, pair := range tmLookupPairs(req) {
+		entries, err := s.fuzzyCandidates(ctx, pair[0], pair[1], words)
+		if err != nil {
+			logger(ctx).Warn("failed to look up translation memory", "error", err)
+			tmLookups.WithLabelValues("error").Inc()
+			return nil, 0
+		}
+		for i := range entries {
+			entry := &entries[i]
+			if !s.config.TranslationMemory && !entry.Pinned || !similarLength(req.Text, entry.SourceText, threshold) {
+				continue
+			}
+			if score := similarity(req.Text, entry.SourceText); score > bestScore {
+				best, bestScore = entry, score
+			}
+		}
+	}
+	if best == nil || bestScore < threshold {
+		tmLookups.WithLabelValues("fuzzy_miss").Inc()
+		return nil, 0
+	}
+	tmLookups.WithLabelValues("fuzzy_hit").Inc()
+	return best, bestScore
+}
+
+// fuzzyCandidates loads the entries of a language pair sharing the most
+// words with a text
+func (s *Server) fuzzyCandidates(ctx context.Context, sourceLang, targetLang string, words []string) ([]TMEntry, error) {
+	// Skip words that are in no entry or too many
+	pipe := s.redis.Pipeline()
+	cards := make([]*redis.IntCmd, len(words))
+	for i, word := range words {
+		cards[i] = pipe.SCard(ctx, tmIndexKey(sourceLang, targetLang, word))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	pipe = s.redis.Pipeline()
+	var members []*redis.StringSliceCmd
+	for i, word := range words {
+		if n := cards[i].Val(); n > 0 && n <= tmIndexMaxPostings {
+			members = append(members, pipe.SMembers(ctx, tmIndexKey(sourceLang, targetLang, word)))
+		}
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	shared := make(map[string]int)
+	for _, cmd := range members {
+		for _, hash := range cmd.Val() {
+			shared[hash]++
+		}
+	}
+	hashes := make([]string, 0, len(shared))
+	for hash := range shared {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		if shared[hashes[i]] != shared[hashes[j]] {
+			return shared[hashes[i]] > shared[hashes[j]]
+		}
+		return hashes[i] < hashes[j]
+	})
+	if len(hashes) > tmFuzzyCandidates {
+		hashes = hashes[:tmFuzzyCandidates]
+	}
+
+	keys := make([]string, len(hashes))
+	for i, hash := range hashes {
+		keys[i] = tmKeyPrefix + strings.ToLower(sourceLang) + ":" + strings.ToLower(targetLang) + ":" + hash
+	}
+	values, err := s.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]TMEntry, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue // Deleted since it was indexed
+		}
+		var entry TMEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			logger(ctx).Warn("failed to unmarshal translation memory entry", "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,1420 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dphase/ss-translate/internal/cache"
+	"github.com/dphase/ss-translate/internal/provider"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/text/language"
+)
+
+// TranslationRequest represents the incoming request for translation
+type TranslationRequest struct {
+	Text       string `json:"text"`
+	SourceLang string `json:"source_lang,omitempty"` // ISO 639-1 code, optional
+	TargetLang string `json:"target_lang"`           // ISO 639-1 code, required
+	Format     string `json:"format,omitempty"`      // text (default) or html
+	Formality  string `json:"formality,omitempty"`   // formal or informal, where the provider supports it
+	Tone       string `json:"tone,omitempty"`        // Such as friendly or professional, for LLM providers
+	Context    string `json:"context,omitempty"`     // Where the text appears, such as a sentence or screen name
+	Domain     string `json:"domain,omitempty"`      // Such as medical or legal, selecting a configured model, terminology or prompt
+
+	// Cache control
+	CacheTTLSeconds int  `json:"cache_ttl_seconds,omitempty"` // Overrides the cache TTL, within the configured bounds
+	NoCache         bool `json:"no_cache,omitempty"`          // Skip the cache lookup
+	NoStore         bool `json:"no_store,omitempty"`          // Don't cache the result
+
+	// Detect the languages an auto-detected text may also be in
+	DetectAlternatives bool `json:"detect_alternatives,omitempty"`
+	// Check the translation by translating it back
+	Verify bool `json:"verify,omitempty"`
+	// Queue the translation for a human reviewer
+	Review bool `json:"review,omitempty"`
+}
+
+// providerRequest returns the part of the request passed to providers
+func (req TranslationRequest) providerRequest() provider.Request {
+	return provider.Request{
+		Text:       req.Text,
+		SourceLang: req.SourceLang,
+		TargetLang: req.TargetLang,
+		Format:     req.Format,
+		Formality:  req.Formality,
+		Tone:       req.Tone,
+		Context:    req.Context,
+		Domain:     req.Domain,
+	}
+}
+
+// TranslationResponse represents the response from the translation service
+type TranslationResponse struct {
+	TranslatedText string   `json:"translated_text"`
+	SourceLang     string   `json:"source_lang"`
+	TargetLang     string   `json:"target_lang"`
+	CacheHit       bool     `json:"cache_hit"`
+	Provider       string   `json:"provider"`          // Provider that produced the translation
+	Memory         *TMMatch `json:"memory,omitempty"`  // Translation memory entry the translation was served from
+	Skipped        bool     `json:"skipped,omitempty"` // The text was returned as is, without translating it
+	SkipReason     string   `json:"skip_reason,omitempty"`
+
+	// Auto-detection of the source language, as reported by the provider
+	DetectionConfidence   float64            `json:"detection_confidence,omitempty"`   // From 0 to 1
+	DetectionAlternatives []DetectedLanguage `json:"detection_alternatives,omitempty"` // Other likely languages, most likely first
+
+	Verification *Verification `json:"verification,omitempty"` // Back-translation check, with verify
+	Moderation   *Moderation   `json:"moderation,omitempty"`   // What MODERATION_INPUT and MODERATION_OUTPUT found
+
+	// Accounting, not cached with the translation
+	Characters        int     `json:"characters"`          // Characters of the text
+	BilledCharacters  int     `json:"billed_characters"`   // Characters sent to the provider, zero when none was called
+	ProviderLatencyMs int64   `json:"provider_latency_ms"` // Time spent waiting for the provider
+	EstimatedCost     float64 `json:"estimated_cost"`      // Cost of the billed characters at PROVIDER_PRICES
+}
+
+// DetectedLanguage is a language a text may be in
+type DetectedLanguage struct {
+	Language   string  `json:"language"`
+	Confidence float64 `json:"confidence,omitempty"` // From 0 to 1, left out when the provider gives none
+}
+
+// Configuration for the service
+type Config struct {
+	RedisAddress           string
+	RedisPassword          string
+	RedisDB                int
+	RedisMasterName        string // Sentinel master name, enables Sentinel mode
+	RedisSentinelAddresses []string
+	RedisSentinelPassword  string
+	RedisHealthInterval    time.Duration // How often Redis is checked to enter or leave degraded mode
+	RedisHealthTimeout     time.Duration
+	ReadyzAllowDegraded    bool          // Stay ready while Redis is unreachable
+	ProviderHealthInterval time.Duration // How often providers are checked in the background, zero disables
+	ServerPort             string
+	TTL                    time.Duration // Cache TTL of translations that don't request one
+	CacheBackend           string        // redis, memory or none
+	CacheMaxEntries        int           // Capacity of the memory cache
+	CacheMinTTL            time.Duration // Bounds for per-request cache TTLs
+	CacheMaxTTL            time.Duration
+	CacheTTLOverrides      []CacheTTLOverride
+	CacheKeyNormalization  []string      // nfc, whitespace and trim, applied to texts before hashing their cache key
+	CacheSoftTTL           time.Duration // Age after which hits are refreshed in the background, disabled when zero
+	CacheRefreshTimeout    time.Duration
+	CacheWarmFile          string            // Seed file of translations warmed into the cache on startup
+	CacheWarmRate          float64           // Seed entries translated per second while warming
+	CachePopularTop        int               // Most accessed entries refreshed before they expire, disabled when zero
+	CachePopularInterval   time.Duration     // How often popular entries are checked
+	CachePopularAhead      time.Duration     // How long before expiring popular entries are refreshed
+	GetCacheMaxAge         time.Duration     // max-age of GET /translate responses for HTTP caches, zero makes them revalidate
+	APIKeys                map[string]string // API keys accepted for requests, by key name
+	APIKeyTenants          map[string]string // Tenants of static API and signing keys, by key name
+	SigningKeys            map[string]string // Secrets signed requests are verified with, by key name
+	SignatureMaxAge        time.Duration     // How far the timestamp of a signed request may be from now
+	TenantCredentialsKey   string            // Base64 AES-256 key encrypting tenant provider credentials, which are disabled when empty
+
+	// JWT authentication, enabled by the JWKS URL
+	JWTJWKSURL       string
+	JWTIssuer        string // Required iss claim, unchecked when empty
+	JWTAudience      string // Required aud claim, unchecked when empty
+	JWTTenantClaim   string // Claim naming the caller's tenant
+	JWTScopeClaim    string // Claim listing the caller's scopes
+	JWTRequiredScope string // Scope API requests need, none when empty
+	JWTAdminScope    string // Scope granting access to the /admin API, none when empty
+	JWKSRefresh      time.Duration
+	AdminToken       string // Token for the /admin API, which is disabled when empty
+
+	// Per-key rate limits, or per tenant for keys of a tenant, disabled when zero
+	RateLimitRPS         float64       // Sustained requests per second
+	RateLimitBurst       int           // Request bucket size, defaults to RateLimitRPS rounded up
+	RateLimitCharsPerMin int           // Characters of input text per minute
+	DailyCharQuota       int64         // Characters each key or tenant may translate per UTC day, unlimited when zero
+	TracingEnabled       bool          // Export OpenTelemetry traces over OTLP/HTTP
+	LogLevel             string        // debug, info, warn or error
+	LogFormat            string        // json or text
+	ShutdownTimeout      time.Duration // Grace period for draining requests on SIGTERM
+
+	// HTTP server limits
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	WSIdleTimeout     time.Duration // Of /ws/translate connections waiting for a message
+	MaxBodyBytes      int64         // Largest request body accepted, after decompression, and /ws/translate message
+	GzipMinBytes      int           // Smallest response body gzipped for clients accepting it
+	IPAllowlist       []string      // Networks requests are accepted from, any when empty
+	TrustedProxies    []string      // Networks of proxies whose X-Forwarded-For is trusted
+
+	// HTTPS on the listener, plain HTTP when neither a key pair nor autocert
+	// domains are set
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSAutocertDomains  []string // Domains to obtain certificates for from an ACME CA
+	TLSAutocertCacheDir string   // Where obtained certificates are kept
+	TLSAutocertEmail    string   // Contact address for the ACME account
+	TLSClientCAFile     string   // CAs client certificates must be signed by, optional
+
+	SkipUntranslatable bool // Return texts without letters, URLs and email addresses as they are
+	LanguageFallback   bool // Translate into the closest variant the provider supports of languages it doesn't
+
+	LanguagePairsAllow []string // source:target patterns of the only pairs served, all when empty
+	LanguagePairsDeny  []string // source:target patterns of pairs never served
+
+	PlaceholderProtection bool   // Shield interpolation variables from translation
+	PlaceholderPattern    string // Regular expression matching placeholders
+
+	PIIRedaction []string // Detectors of personal data redacted before provider calls
+	PIIPattern   string   // Regular expression matching further data to redact
+
+	HTMLSanitization      bool     // Remove markup outside the allowlist from HTML translations
+	HTMLAllowedTags       []string // Elements kept, the defaults when empty
+	HTMLAllowedAttributes []string // Attributes kept on them, data-* style prefixes allowed; the defaults when empty
+
+	ChunkMaxBytes    int // Overrides the providers' text size limits, zero uses them
+	ChunkConcurrency int // Chunks of one text translated at once
+
+	// Document translation
+	DocumentConcurrency int   // Strings of one document translated at once
+	DocumentMaxStrings  int   // Distinct strings a document may select
+	FileMaxBodyBytes    int64 // Largest CSV or JSONL upload accepted by POST /translate/file
+
+	// Page translation
+	PageFetchAllowlist    []string      // Hosts pages may be fetched from, *.domain for its subdomains; disabled when empty
+	PageFetchAllowPrivate bool          // Fetch from loopback, private and other internal addresses too
+	PageFetchTimeout      time.Duration // Of a fetch, with its redirects
+	PageMaxBytes          int64         // Largest page fetched
+
+	// Reverse-proxy mode
+	ProxyUpstream      string        // Base URL proxied on ProxyPort, translating its responses; disabled when empty
+	ProxyPort          string        // Port of the proxy listener
+	ProxySourceLang    string        // Language the upstream serves
+	ProxyLanguages     []string      // Languages responses are translated into, matched with Accept-Language
+	ProxyJSONSelectors []string      // Selectors of the JSON values translated, all strings by default
+	ProxyMaxBytes      int64         // Largest response translated; larger ones pass through
+	ProxyCacheTTL      time.Duration // Of the cached translations of a URL's segments
+
+	// Kafka worker mode, translating the requests of KafkaInputTopic into
+	// KafkaOutputTopic; disabled when KafkaBrokers is empty
+	KafkaBrokers       []string
+	KafkaInputTopic    string
+	KafkaOutputTopic   string
+	KafkaDLQTopic      string               // Receives the messages that fail to translate, which are dropped when empty
+	KafkaGroupID       string               // Consumer group sharing the input topic's partitions
+	KafkaSerde         string               // Encoding of messages: json, msgpack or protobuf
+	KafkaConsumers     int                  // Consumers of the group run by this replica
+	KafkaRetry         provider.RetryPolicy // Retries of messages failing with transient errors
+	KafkaTLS           bool
+	KafkaSASLMechanism string // plain, scram-sha-256 or scram-sha-512, no authentication when empty
+	KafkaSASLUsername  string
+	KafkaSASLPassword  string
+
+	// SQS worker mode, translating the requests of SQSInputQueueURL into
+	// SQSOutputQueueURL; disabled when SQSInputQueueURL is empty
+	SQSInputQueueURL     string
+	SQSOutputQueueURL    string
+	SQSDLQQueueURL       string               // Receives the messages that fail to translate, which are left to the input queue's redrive policy when empty
+	SQSConsumers         int                  // Receive loops run by this replica
+	SQSBatchSize         int                  // Messages received at once, translated concurrently
+	SQSVisibilityTimeout time.Duration        // Of received messages, extended while they are translated
+	SQSWaitTime          time.Duration        // Long polling wait of receives
+	SQSRetry             provider.RetryPolicy // Receives of messages failing with transient errors, and the delays before them
+
+	// Pub/Sub worker mode, translating the messages of PubSubSubscription
+	// into PubSubOutputTopic; disabled when PubSubSubscription is empty
+	PubSubProjectID      string // Defaults to the credentials' project
+	PubSubSubscription   string
+	PubSubOutputTopic    string
+	PubSubDLQTopic       string               // Receives the messages that fail to translate, which are nacked when empty
+	PubSubMaxOutstanding int                  // Messages translated at once by this replica
+	PubSubRetry          provider.RetryPolicy // Retries of messages failing with transient errors
+
+	// NATS request-reply transport, serving translations and detections on
+	// subjects under NATSSubjectPrefix; disabled when NATSURL is empty
+	NATSURL           []string
+	NATSSubjectPrefix string
+	NATSQueueGroup    string // Shares the requests between replicas
+	NATSConcurrency   int    // Requests served at once by this replica
+	NATSCredsFile     string // User JWT and NKey seed, no authentication when unset
+	NATSToken         string
+
+	// Translation memory
+	TranslationMemory    bool    // Serve approved translations before the cache and provider
+	TMFuzzyThreshold     float64 // Least similarity of fuzzy matches served on cache misses, disabled when zero
+	TMImportMaxBodyBytes int64   // Largest TMX file accepted by POST /admin/tm/import
+
+	VerifyMinSimilarity float64 // Back-translation similarity under which verified texts are retried with another provider
+
+	// Content moderation of texts before and translations after translating
+	ModerationInput     string            // flag, mask or reject, disabled when empty
+	ModerationOutput    string            // flag, mask or reject, disabled when empty
+	ModerationWordlists map[string]string // Wordlist files by language, * for every language
+	ModerationURL       string            // Base URL of an OpenAI-compatible moderation API, without /moderations
+	ModerationAPIKey    string
+	ModerationModel     string
+
+	// Review queue
+	ReviewMinConfidence float64       // Detection confidence under which auto-detected translations are queued, disabled when zero
+	ReviewMaxQuality    float64       // Quality score at or under which translations are queued, disabled when zero
+	ReviewRetention     time.Duration // How long queued translations wait for a reviewer
+
+	// Translation history, disabled when HistoryDatabaseURL is empty
+	HistoryDatabaseURL string        // Postgres connection string
+	HistoryRetention   time.Duration // How long records are kept, forever when zero
+
+	// Quality estimation of recorded translations, disabled when
+	// QualityEstimator is empty
+	QualityEstimator         string // chrf, embedding or http
+	QualityEstimationURL     string // Base URL of the embeddings API, or URL of the QE model
+	QualityEstimationAPIKey  string
+	QualityEstimationModel   string // Embedding model
+	QualityEstimationWorkers int    // Translations scored at once
+
+	// Asynchronous jobs
+	JobWorkers          int           // Jobs processed at once by this replica
+	JobRetention        time.Duration // How long jobs and their results are kept
+	IdempotencyTTL      time.Duration // How long responses to Idempotency-Key requests are replayed
+	JobMaxRequests      int
+	JobMaxBodyBytes     int64                // Largest POST /jobs body accepted
+	WebhookSecret       string               // Signs job callbacks, which are disabled when empty
+	WebhookAllowPrivate bool                 // Deliver callbacks to loopback, private and other internal addresses too
+	WebhookRetry        provider.RetryPolicy // Retries of failed callback deliveries
+
+	// File jobs read and write the objects of these s3:// and gs://
+	// buckets; they are disabled when it is empty
+	JobStorageBuckets    []string
+	JobFileBatchSize     int   // Records of a file job translated at once
+	JobFileMaxXLIFFBytes int64 // Largest XLIFF input of a file job, which is read whole
+	S3ForcePathStyle     bool  // Path-style S3 URLs, for S3-compatible stores such as MinIO
+
+	// Usage accounting
+	UsageRetention          time.Duration        // How long daily usage counters are kept
+	ProviderPrices          map[string]float64   // Price per million characters, by provider name
+	Provider                string               // Name of the translation provider to use
+	Providers               []string             // Ordered failover chain, overrides Provider when set
+	CanaryProvider          string               // Provider receiving a share of traffic for evaluation
+	CanaryPercent           float64              // Percentage of translations sent to CanaryProvider
+	Retry                   provider.RetryPolicy // Retries of transient provider errors
+	CircuitBreakerThreshold int                  // Consecutive failures that open a provider's circuit, disabled when zero
+	CircuitBreakerCooldown  time.Duration        // How long an open circuit fails fast before a trial call
+	ProviderMaxInFlight     int                  // Provider calls in flight at once across providers, unbounded when zero
+	ProviderQueueSize       int                  // Calls waiting for a slot beyond ProviderMaxInFlight
+	ProviderQueueTimeout    time.Duration        // How long a call waits for a slot before failing with 503
+	ProviderMinuteBudgets   map[string]int64     // Characters sent per minute, by provider name
+	ProviderDailyBudgets    map[string]int64     // Characters sent per UTC day, by provider name
+	ProviderBudgetReserve   float64              // Share of each budget low-priority requests can't use
+	ProviderBudgetMaxDefer  time.Duration        // Longest wait of a job for an exhausted budget to reset
+
+	// Google Translate provider settings
+	GoogleAPIVersion string // v2 (Basic) or v3 (Advanced)
+	GoogleProjectID  string // v3 only, defaults to the credentials' project
+	GoogleLocation   string // v3 only
+	GoogleModel      string // v3 only, model ID or resource name
+	GoogleGlossary   string // v3 only, glossary ID or resource name
+	// v3 only, model IDs or resource names by request domain
+	GoogleDomainModels map[string]string
+
+	// AWS Translate provider settings
+	AWSRegion        string   // Overrides the region from the AWS credential chain
+	AWSTerminologies []string // Custom terminology names applied to every request
+	// Custom terminology names by request domain, applied instead
+	AWSDomainTerminologies map[string]string
+
+	// Azure Translator provider settings
+	AzureEndpoint string
+	AzureKey      string // Cognitive Services subscription key
+	AzureRegion   string // Region of the Translator resource, required for regional resources
+
+	// LibreTranslate provider settings
+	LibreTranslateURL    string // Base URL of the LibreTranslate instance
+	LibreTranslateAPIKey string // Only needed when the instance requires API keys
+
+	// OpenAI-compatible LLM provider settings
+	LLMEndpoint       string // Base URL of the API, without /chat/completions
+	LLMAPIKey         string
+	LLMModel          string
+	LLMTemperature    float64
+	LLMPromptTemplate string   // text/template for the system prompt, rendered with the request
+	LLMLanguages      []string // Language codes reported as supported
+	// Prompt template files by request domain, used instead of LLMPromptTemplate
+	LLMDomainPrompts map[string]string
+}
+
+// shutdown writes the queued history, closes the provider and Redis clients
+// and flushes traces
+func (s *Server) shutdown(ctx context.Context) {
+	s.stopHistory(ctx)
+	if s.kafkaWriter != nil {
+		if err := s.kafkaWriter.Close(); err != nil {
+			slog.Warn("failed to close kafka producer", "error", err)
+		}
+	}
+	if s.pubsub != nil {
+		s.pubsubOutput.Stop()
+		if s.pubsubDLQ != nil {
+			s.pubsubDLQ.Stop()
+		}
+		if err := s.pubsub.Close(); err != nil {
+			slog.Warn("failed to close pubsub client", "error", err)
+		}
+	}
+	if s.nats != nil {
+		// Sends the answers still buffered
+		s.nats.Close()
+	}
+	if s.gcs != nil {
+		if err := s.gcs.Close(); err != nil {
+			slog.Warn("failed to close cloud storage client", "error", err)
+		}
+	}
+	for _, p := range s.providers {
+		if closer, ok := p.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				slog.Warn("failed to close provider", "provider", p.Name(), "error", err)
+			}
+		}
+	}
+	if err := s.redis.Close(); err != nil {
+		slog.Warn("failed to close Redis client", "error", err)
+	}
+	if err := s.shutdownTracing(ctx); err != nil {
+		slog.Warn("failed to flush traces", "error", err)
+	}
+}
+
+// limitRequestBody caps every request body at config.MaxBodyBytes, or
+// config.JobMaxBodyBytes for job submissions, config.FileMaxBodyBytes for
+// record files and config.TMImportMaxBodyBytes for TMX imports
+func (s *Server) limitRequestBody(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.config.MaxBodyBytes
+		switch r.URL.Path {
+		case "/jobs":
+			limit = s.config.JobMaxBodyBytes
+		case "/translate/file":
+			limit = s.config.FileMaxBodyBytes
+		case "/admin/tm/import":
+			limit = s.config.TMImportMaxBodyBytes
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// handleHealth provides a simple health check endpoint
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Check the translation provider's upstream when it supports it
+	ctx := r.Context()
+	if checker, ok := s.provider.(provider.HealthChecker); ok {
+		if err := checker.HealthCheck(ctx); err != nil {
+			writeError(w, http.StatusServiceUnavailable, codeProviderUnavailable, fmt.Sprintf("Provider health check failed: %v", err))
+			return
+		}
+	}
+
+	// Translations still work without Redis, just uncached
+	if !s.redisAvailable() {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "DEGRADED: Redis unavailable, caching disabled: %v", s.redisStatusError())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// handleTranslation processes translation requests, POSTed as a body or
+// as a GET with query parameters
+func (s *Server) handleTranslation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	req, ctx, ok := s.parseTranslationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Process translation
+	response, err := s.translateText(ctx, req)
+	if err != nil {
+		s.writeTranslationError(ctx, w, err)
+		return
+	}
+	if info := getRequestInfo(ctx); info != nil {
+		info.SourceLang = response.SourceLang
+		info.Provider = response.Provider
+		info.CacheHit = &response.CacheHit
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("translation.source_lang", response.SourceLang),
+		attribute.String("translation.target_lang", response.TargetLang),
+		attribute.String("translation.provider", response.Provider),
+		attribute.Bool("translation.cache_hit", response.CacheHit),
+	)
+	s.recordQuotaUsage(ctx, response.Characters)
+	s.recordUsage(ctx, apiKeyName(ctx), response)
+
+	// Return response. GETs are cacheable by HTTP caches, per credentials,
+	// and answered with 304 Not Modified when the client has the translation.
+	// Signed GETs carry neither Authorization nor X-API-Key, so a shared
+	// cache would hand them to anyone: only the client may cache those.
+	if r.Method == http.MethodGet {
+		if maxAge := s.config.GetCacheMaxAge; maxAge > 0 {
+			scope := "public"
+			if signedRequest(r) {
+				scope = "private"
+			}
+			w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		w.Header().Add("Vary", "Authorization, X-API-Key")
+	}
+	if notModified(w, r, translationETag(ctx, req, response)) {
+		return
+	}
+	writeResponse(w, r, http.StatusOK, response)
+}
+
+// translationRequestFromQuery returns the request of a GET /translate,
+// which takes text, target and the optional source and format parameters
+func translationRequestFromQuery(query url.Values) TranslationRequest {
+	param := func(name, alias string) string {
+		if value := query.Get(name); value != "" {
+			return value
+		}
+		return query.Get(alias)
+	}
+	return TranslationRequest{
+		Text:       query.Get("text"),
+		SourceLang: param("source", "source_lang"),
+		TargetLang: param("target", "target_lang"),
+		Format:     query.Get("format"),
+		Formality:  query.Get("formality"),
+		Tone:       query.Get("tone"),
+		Context:    query.Get("context"),
+		Domain:     query.Get("domain"),
+	}
+}
+
+// writeTranslationError logs a failed translation and writes its error
+// response
+func (s *Server) writeTranslationError(ctx context.Context, w http.ResponseWriter, err error) {
+	var langErr languageError
+	if errors.As(err, &langErr) {
+		writeError(w, http.StatusBadRequest, codeInvalidLang, fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	var pairErr languagePairError
+	if errors.As(err, &pairErr) {
+		writeError(w, http.StatusForbidden, codeLanguagePairDenied, fmt.Sprintf("Language pair %s is not allowed", pairErr.pair()))
+		return
+	}
+	var rejectedErr contentRejectedError
+	if errors.As(err, &rejectedErr) {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, codeContentRejected, fmt.Sprintf("Translation rejected: %v", err),
+			map[string]interface{}{"stage": rejectedErr.stage, "categories": rejectedErr.categories})
+		return
+	}
+	logger(ctx).Error("translation failed", "error", err)
+	if errors.Is(err, provider.ErrSaturated) {
+		// Callers back off for as long as a queued call would wait
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(s.config.ProviderQueueTimeout)))
+		writeError(w, http.StatusServiceUnavailable, codeServiceUnavailable, "Too many translations in progress, retry later")
+		return
+	}
+	var budgetErr *budgetError
+	if errors.As(err, &budgetErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(budgetErr.retryAfter)))
+		writeError(w, http.StatusServiceUnavailable, codeProviderUnavailable, "Translation provider budget exhausted, only cached translations can be served")
+		return
+	}
+	if errors.Is(err, errTenantCredentialsUnavailable) {
+		writeError(w, http.StatusServiceUnavailable, codeServiceUnavailable, "Tenant credentials unavailable, retry later")
+		return
+	}
+	if errors.Is(err, provider.ErrCircuitOpen) {
+		// Only cached translations can be served until the provider recovers
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.config.CircuitBreakerCooldown.Seconds())))
+		writeError(w, http.StatusServiceUnavailable, codeProviderUnavailable, "Translation provider unavailable, only cached translations can be served")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, codeProviderError, fmt.Sprintf("Translation failed: %v", err))
+}
+
+// parseTranslationRequest authenticates the request and decodes and
+// validates its body, writing the error response and returning false on
+// failure. The returned context carries the authenticated key's name.
+func (s *Server) parseTranslationRequest(w http.ResponseWriter, r *http.Request) (TranslationRequest, context.Context, bool) {
+	var req TranslationRequest
+
+	// Authenticate request
+	ctx, ok := s.authenticateRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: Invalid API key")
+		logger(r.Context()).Warn("unauthorized request", "remote_addr", s.clientIP(r))
+		return req, nil, false
+	}
+
+	// Parse request
+	if r.Method == http.MethodGet {
+		req = translationRequestFromQuery(r.URL.Query())
+	} else if !decodeRequestBody(w, r, &req) {
+		return req, nil, false
+	}
+
+	// Validate request
+	if err := validateTranslationRequest(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+		return req, nil, false
+	}
+
+	if info := getRequestInfo(ctx); info != nil {
+		info.SourceLang = req.SourceLang
+		info.TargetLang = req.TargetLang
+	}
+
+	if !s.authorizeTranslation(ctx, w, utf8.RuneCountInString(req.Text)) {
+		return req, nil, false
+	}
+	return req, ctx, true
+}
+
+// decodeRequestBody decodes the JSON, MessagePack or protobuf body of r
+// into v, writing the error response and returning false on failure. Bodies
+// are first validated against the operation's OpenAPI schema, answering
+// every violation at once.
+func decodeRequestBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		body, err = requestBodyJSON(r, body)
+	}
+	if err != nil {
+		writeBodyError(w, err)
+		return false
+	}
+	violations, err := validateRequestBody(r, body)
+	if err == nil && len(violations) > 0 {
+		writeValidationError(w, violations)
+		return false
+	}
+	if err == nil {
+		err = json.NewDecoder(bytes.NewReader(body)).Decode(v)
+	}
+	if err != nil {
+		writeBodyError(w, err)
+		return false
+	}
+	return true
+}
+
+// writeBodyError writes the error response for a body that couldn't be
+// read or parsed
+func writeBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+		return
+	}
+	if errors.Is(err, errUnsupportedMediaType) {
+		writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "Unsupported media type: "+err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request: %v", err))
+}
+
+// authorizeTranslation enforces the caller's rate limits and daily quota for
+// translating chars characters, writing the error response and returning
+// false when either is exceeded
+func (s *Server) authorizeTranslation(ctx context.Context, w http.ResponseWriter, chars int) bool {
+	// Enforce per-key or per-tenant rate limits. Requests with more
+	// characters than a minute's would never get through.
+	if perMin := s.scopeRateLimits(ctx).charsPerMin; perMin > 0 && chars > perMin {
+		writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+			fmt.Sprintf("Request has %d characters, over the rate limit of %d a minute", chars, perMin))
+		logger(ctx).Warn("request over the character rate limit", "chars", chars)
+		return false
+	}
+	if ok, retry := s.checkRateLimit(ctx, chars); !ok {
+		writeRateLimited(w, retry)
+		logger(ctx).Warn("rate limited request")
+		return false
+	}
+
+	// Enforce the daily character quota
+	return s.checkQuota(ctx, w, chars)
+}
+
+// maxContextLength is the most characters a request's context may have
+const maxContextLength = 1000
+
+// validTone matches the tones a request may ask for, which end up in LLM
+// prompts
+var validTone = regexp.MustCompile(`^[a-z][a-z -]{0,31}$`)
+
+// validateTranslationRequest checks a request's required fields and options,
+// filling in defaults
+func validateTranslationRequest(req *TranslationRequest) error {
+	if req.Text == "" {
+		return errors.New("text is required")
+	}
+	if req.Verify && utf8.RuneCountInString(req.Text) > maxVerifyLength {
+		return fmt.Errorf("verify is limited to texts of up to %d characters", maxVerifyLength)
+	}
+	return validateTranslationOptions(req)
+}
+
+// validateTranslationOptions checks everything of a request but its text,
+// filling in defaults
+func validateTranslationOptions(req *TranslationRequest) error {
+	if req.TargetLang == "" {
+		return errors.New("target language is required")
+	}
+	// Clients send locales as pt_BR or zh-hant-tw
+	req.SourceLang = normalizeLanguageTag(req.SourceLang)
+	req.TargetLang = normalizeLanguageTag(req.TargetLang)
+	switch req.Format {
+	case "":
+		req.Format = provider.FormatText
+	case provider.FormatText, provider.FormatHTML:
+	default:
+		return fmt.Errorf("format must be %q or %q", provider.FormatText, provider.FormatHTML)
+	}
+	switch req.Formality {
+	case "", provider.FormalityFormal, provider.FormalityInformal:
+	default:
+		return fmt.Errorf("formality must be %q or %q", provider.FormalityFormal, provider.FormalityInformal)
+	}
+	req.Tone = strings.ToLower(strings.TrimSpace(req.Tone))
+	if req.Tone != "" && !validTone.MatchString(req.Tone) {
+		return errors.New("tone must be up to 32 letters, spaces or hyphens")
+	}
+	req.Context = strings.TrimSpace(req.Context)
+	if utf8.RuneCountInString(req.Context) > maxContextLength {
+		return fmt.Errorf("context must be at most %d characters", maxContextLength)
+	}
+	if req.Domain != "" && !validDomain.MatchString(req.Domain) {
+		return errors.New("domain must be a lowercase name of up to 32 letters, digits, hyphens or underscores")
+	}
+	if req.CacheTTLSeconds < 0 {
+		return errors.New("cache TTL must not be negative")
+	}
+	return nil
+}
+
+// skippedProviderName is the provider of texts returned without calling one
+const skippedProviderName = "none"
+
+// sameLanguage reports whether two language codes are the same valid tag,
+// so en matches EN but not en-GB
+func sameLanguage(a, b string) bool {
+	tagA, err := language.Parse(a)
+	if err != nil {
+		return false
+	}
+	tagB, err := language.Parse(b)
+	return err == nil && tagA == tagB
+}
+
+// skippedTranslation returns the text of req untranslated, in the source
+// language sourceLang, for the given reason
+func skippedTranslation(req TranslationRequest, sourceLang, provider, reason string) *TranslationResponse {
+	skippedRequests.WithLabelValues(reason).Inc()
+	return &TranslationResponse{
+		TranslatedText: req.Text,
+		SourceLang:     sourceLang,
+		TargetLang:     req.TargetLang,
+		Provider:       provider,
+		Skipped:        true,
+		SkipReason:     reason,
+	}
+}
+
+// validateLanguages checks that the request's language codes are valid tags
+func validateLanguages(req TranslationRequest) error {
+	if req.SourceLang != "" {
+		if _, err := language.Parse(req.SourceLang); err != nil {
+			return languageError{fmt.Errorf("invalid source language: %v", err)}
+		}
+	}
+	if _, err := language.Parse(req.TargetLang); err != nil {
+		return languageError{fmt.Errorf("invalid target language: %v", err)}
+	}
+	return nil
+}
+
+// translateText handles the translation with moderation, HTML sanitization,
+// the translation memory and caching, recording it in the history
+func (s *Server) translateText(ctx context.Context, req TranslationRequest) (*TranslationResponse, error) {
+	start := time.Now()
+	if err := s.checkLanguagePair(ctx, req.SourceLang, req.TargetLang); err != nil {
+		return nil, err
+	}
+	moderation, err := s.moderateInput(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.lookupOrTranslate(ctx, req)
+	if err == nil && req.SourceLang == "" && response.SourceLang != "" {
+		// Only known now, the detected source may be denied too
+		err = s.checkLanguagePair(ctx, response.SourceLang, req.TargetLang)
+	}
+	if err == nil {
+		s.detectAlternatives(ctx, req, response)
+		s.verifyTranslation(ctx, req, response)
+		s.sanitizeTranslation(ctx, req, response)
+		err = s.moderateOutput(ctx, response, moderation)
+	}
+	if err == nil {
+		s.flagForReview(ctx, req, response)
+		response.Characters = utf8.RuneCountInString(req.Text)
+		response.EstimatedCost = s.estimatedCost(response.Provider, response.BilledCharacters)
+		s.recordHistory(ctx, req, response, time.Since(start))
+	}
+	return response, err
+}
+
+// Translate validates and translates a request like POST /translate, for
+// programs embedding the service. Authentication, rate limits, quotas and
+// usage accounting don't apply.
+func (s *Server) Translate(ctx context.Context, req TranslationRequest) (*TranslationResponse, error) {
+	if err := validateTranslationRequest(&req); err != nil {
+		return nil, err
+	}
+	return s.translateText(ctx, req)
+}
+
+// Detect returns the most likely language of text according to the
+// provider, for programs embedding the service
+func (s *Server) Detect(ctx context.Context, text string) (*provider.Detection, error) {
+	if text == "" {
+		return nil, errors.New("text is required")
+	}
+	return s.provider.Detect(ctx, text)
+}
+
+// maxDetectionAlternatives is how many alternative languages are returned
+const maxDetectionAlternatives = 3
+
+// detectAlternatives adds the languages the text of response may also be in,
+// and the provider's confidence in its detected source language when the
+// translation didn't report it, to the responses of auto-detected requests
+// asking for them. The detection is a provider call of its own, billed with
+// the translation; when it fails the translation is served without them.
+func (s *Server) detectAlternatives(ctx context.Context, req TranslationRequest, response *TranslationResponse) {
+	if !req.DetectAlternatives || req.SourceLang != "" || response.SourceLang == "" {
+		return
+	}
+	p, err := s.providerFor(ctx)
+	if err != nil {
+		logger(ctx).Warn("failed to detect alternative languages", "error", err)
+		return
+	}
+	start := time.Now()
+	detection, err := p.Detect(ctx, req.Text)
+	if err != nil {
+		logger(ctx).Warn("failed to detect alternative languages", "error", err)
+		return
+	}
+	response.BilledCharacters += utf8.RuneCountInString(req.Text)
+	response.ProviderLatencyMs += time.Since(start).Milliseconds()
+
+	for _, d := range append([]provider.Detection{*detection}, detection.Alternatives...) {
+		if sameLanguage(d.Language, response.SourceLang) {
+			if response.DetectionConfidence == 0 {
+				response.DetectionConfidence = d.Confidence
+			}
+			continue
+		}
+		if len(response.DetectionAlternatives) < maxDetectionAlternatives {
+			response.DetectionAlternatives = append(response.DetectionAlternatives, DetectedLanguage{Language: d.Language, Confidence: d.Confidence})
+		}
+	}
+}
+
+// lookupOrTranslate serves a request from the translation memory or cache,
+// or translates it
+func (s *Server) lookupOrTranslate(ctx context.Context, req TranslationRequest) (*TranslationResponse, error) {
+	// Text already in the target language, or nothing a provider would
+	// change, needs no provider nor cache
+	if reason := s.untranslatable(req); reason != "" {
+		return skippedTranslation(req, req.SourceLang, skippedProviderName, reason), nil
+	}
+	if req.SourceLang != "" && sameLanguage(req.SourceLang, req.TargetLang) {
+		return skippedTranslation(req, req.SourceLang, skippedProviderName, skipSameLanguage), nil
+	}
+
+	// Approved translations win over cached and machine ones
+	if entry := s.lookupTranslationMemory(ctx, req); entry != nil {
+		return &TranslationResponse{
+			TranslatedText: entry.TargetText,
+			SourceLang:     req.SourceLang,
+			TargetLang:     req.TargetLang,
+			Provider:       tmProviderName,
+			Memory:         newTMMatch(entry, 1),
+		}, nil
+	}
+
+	terms := s.glossaryTerms(ctx, req)
+	key := s.translationCacheKey(ctx, req, terms)
+
+	// Check cache first, unless the caller asked to skip it
+	var cachedResult []byte
+	err := cache.ErrMiss
+	if !req.NoCache {
+		cachedResult, err = s.cache.Get(ctx, key)
+	}
+	switch {
+	case req.NoCache:
+		cacheRequests.WithLabelValues("skip").Inc()
+	case err == nil:
+		var entry cacheEntry
+		if err := json.Unmarshal(cachedResult, &entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cached result: %v", err)
+		}
+		if s.normalizeCacheText(entry.SourceText) == s.normalizeCacheText(req.Text) {
+			// Cache hit. A stale entry is still served, and refreshed in
+			// the background.
+			response := entry.TranslationResponse
+			response.CacheHit = true
+			if entry.stale(time.Now()) && !req.NoStore {
+				cacheRequests.WithLabelValues("stale").Inc()
+				s.refreshInBackground(ctx, req, key, terms)
+			} else {
+				cacheRequests.WithLabelValues("hit").Inc()
+			}
+			s.trackPopularity(ctx, req, key)
+			return &response, nil
+		}
+		// Hash collision or corrupted entry, translate and overwrite it
+		logger(ctx).Warn("cached source text does not match request, ignoring entry", "cache_key", key)
+		cacheRequests.WithLabelValues("miss").Inc()
+	case errors.Is(err, cache.ErrMiss):
+		cacheRequests.WithLabelValues("miss").Inc()
+	case errors.Is(err, cache.ErrUnavailable):
+		// Degraded mode, bypass the cache
+		cacheRequests.WithLabelValues("bypass").Inc()
+	default:
+		// Cache error - log but continue with translation
+		logger(ctx).Error("cache error when checking cache", "error", err)
+		cacheRequests.WithLabelValues("error").Inc()
+	}
+
+	// Cache miss or cache unavailable, perform translation
+	response, err := s.translateCoalesced(ctx, req, key, terms)
+	if err == nil {
+		s.trackPopularity(ctx, req, key)
+	}
+	return response, err
+}
+
+// translateCoalesced runs translateAndCache once for concurrent identical
+// requests. The shared call isn't canceled by any one caller going away.
+func (s *Server) translateCoalesced(ctx context.Context, req TranslationRequest, key string, terms []glossaryTerm) (*TranslationResponse, error) {
+	group := key
+	if req.NoStore {
+		group += "|no_store"
+	}
+	// Only the caller whose call ran is billed for it
+	var ran bool
+	ch := s.translationGroup.DoChan(group, func() (interface{}, error) {
+		ran = true
+		return s.translateAndCache(context.WithoutCancel(ctx), req, key, terms)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		if res.Shared {
+			coalescedRequests.Inc()
+		}
+		response := *res.Val.(*TranslationResponse)
+		if !ran {
+			response.BilledCharacters, response.ProviderLatencyMs = 0, 0
+		}
+		return &response, nil
+	}
+}
+
+// translateAndCache translates a request with the provider, applying
+// glossary terms, and caches the result under key
+func (s *Server) translateAndCache(ctx context.Context, req TranslationRequest, key string, terms []glossaryTerm) (*TranslationResponse, error) {
+	if err := validateLanguages(req); err != nil {
+		return nil, err
+	}
+
+	// A close enough translation memory match saves the provider call
+	response := s.fuzzyTranslation(ctx, req)
+	if response == nil {
+		var err error
+		if response, err = s.translateWithProvider(ctx, req, terms); err != nil {
+			return nil, err
+		}
+		// Detected as already in the target language, the identity
+		// translation isn't worth caching
+		if req.SourceLang == "" && sameLanguage(response.SourceLang, req.TargetLang) {
+			skipped := skippedTranslation(req, response.SourceLang, response.Provider, skipSameLanguage)
+			skipped.BilledCharacters, skipped.ProviderLatencyMs = response.BilledCharacters, response.ProviderLatencyMs
+			return skipped, nil
+		}
+	}
+
+	// Cache the result, unless the caller asked not to
+	if req.NoStore {
+		return response, nil
+	}
+	ttl := s.cacheTTL(req, key)
+	jsonData, err := json.Marshal(s.newCacheEntry(req, response, ttl))
+	if err != nil {
+		logger(ctx).Warn("failed to marshal response for caching", "error", err)
+	} else if err := s.cache.Set(ctx, key, jsonData, ttl); err != nil && !errors.Is(err, cache.ErrUnavailable) {
+		logger(ctx).Warn("failed to cache translation", "error", err)
+	}
+
+	return response, nil
+}
+
+// fuzzyTranslation returns the translation of the translation memory's
+// closest fuzzy match for req, or nil
+func (s *Server) fuzzyTranslation(ctx context.Context, req TranslationRequest) *TranslationResponse {
+	entry, score := s.fuzzyLookupTranslationMemory(ctx, req)
+	if entry == nil {
+		return nil
+	}
+	return &TranslationResponse{
+		TranslatedText: entry.TargetText,
+		SourceLang:     req.SourceLang,
+		TargetLang:     req.TargetLang,
+		Provider:       tmProviderName,
+		Memory:         newTMMatch(entry, score),
+	}
+}
+
+// translateWithProvider translates a request with the caller's provider,
+// applying glossary terms
+func (s *Server) translateWithProvider(ctx context.Context, req TranslationRequest, terms []glossaryTerm) (*TranslationResponse, error) {
+	provider, err := s.providerFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.translateWith(ctx, provider, req, terms)
+}
+
+// translateWith translates a request with provider, applying glossary terms
+func (s *Server) translateWith(ctx context.Context, provider provider.Provider, req TranslationRequest, terms []glossaryTerm) (*TranslationResponse, error) {
+	providerReq := req.providerRequest()
+	providerReq.SourceLang = s.supportedLanguage(ctx, provider, providerReq.SourceLang)
+	providerReq.TargetLang = s.supportedLanguage(ctx, provider, providerReq.TargetLang)
+	var replacements []string
+	if len(terms) > 0 {
+		providerReq.Text, replacements = applyGlossary(terms, req.Text)
+	}
+	start := time.Now()
+	result, err := provider.Translate(ctx, providerReq)
+	if err != nil {
+		return nil, err
+	}
+	latency := time.Since(start)
+	if len(replacements) > 0 {
+		var missing int
+		result.TranslatedText, missing = glossaryMask.restore(result.TranslatedText, replacements)
+		if missing > 0 {
+			logger(ctx).Warn("glossary terms lost in translation", "missing", missing, "terms", len(replacements))
+		}
+	}
+
+	response := &TranslationResponse{
+		TranslatedText: result.TranslatedText,
+		SourceLang:     result.SourceLang,
+		TargetLang:     providerReq.TargetLang,
+		CacheHit:       false,
+		Provider:       result.Provider,
+
+		BilledCharacters:  utf8.RuneCountInString(providerReq.Text),
+		ProviderLatencyMs: latency.Milliseconds(),
+	}
+	if req.SourceLang == "" {
+		response.DetectionConfidence = result.Confidence
+	}
+	if response.Provider == "" {
+		response.Provider = provider.Name()
+	}
+	return response, nil
+}
+
+// ReadConfig reads the configuration from the environment
+func ReadConfig() Config {
+	c := Config{
+		RedisAddress:           getEnv("REDIS_ADDRESS", "localhost:6379"),
+		RedisPassword:          getEnv("REDIS_PASSWORD", ""),
+		RedisDB:                0, // Using default DB
+		RedisMasterName:        getEnv("REDIS_MASTER_NAME", ""),
+		RedisSentinelAddresses: getEnvList("REDIS_SENTINEL_ADDRESSES"),
+		RedisSentinelPassword:  getEnv("REDIS_SENTINEL_PASSWORD", ""),
+		RedisHealthInterval:    getEnvDuration("REDIS_HEALTH_INTERVAL", 5*time.Second),
+		RedisHealthTimeout:     getEnvDuration("REDIS_HEALTH_TIMEOUT", 2*time.Second),
+		ReadyzAllowDegraded:    getEnvBool("READYZ_ALLOW_DEGRADED", false),
+		ProviderHealthInterval: getEnvDuration("PROVIDER_HEALTH_INTERVAL", 0),
+		ServerPort:             getEnv("SERVER_PORT", "8080"),
+		TTL:                    getEnvDuration("CACHE_TTL", time.Hour*24*14),
+		CacheBackend:           getEnv("CACHE_BACKEND", "redis"),
+		CacheMaxEntries:        getEnvInt("CACHE_MAX_ENTRIES", 100000),
+		CacheMinTTL:            getEnvDuration("CACHE_MIN_TTL", time.Minute),
+		CacheMaxTTL:            getEnvDuration("CACHE_MAX_TTL", time.Hour*24*14),
+		CacheTTLOverrides:      parseCacheTTLOverrides(getEnv("CACHE_TTL_OVERRIDES", "")),
+		CacheKeyNormalization:  getEnvList("CACHE_KEY_NORMALIZATION"),
+		CacheSoftTTL:           getEnvDuration("CACHE_SOFT_TTL", 0),
+		CacheRefreshTimeout:    getEnvDuration("CACHE_REFRESH_TIMEOUT", 30*time.Second),
+		CacheWarmFile:          getEnv("CACHE_WARM_FILE", ""),
+		CacheWarmRate:          getEnvFloat("CACHE_WARM_RATE", 5),
+		CachePopularTop:        getEnvInt("CACHE_POPULAR_TOP", 0),
+		CachePopularInterval:   getEnvDuration("CACHE_POPULAR_INTERVAL", 5*time.Minute),
+		CachePopularAhead:      getEnvDuration("CACHE_POPULAR_AHEAD", time.Hour),
+		GetCacheMaxAge:         getEnvDuration("GET_CACHE_MAX_AGE", time.Hour),
+		APIKeys:                parseAPIKeys(getEnv("API_KEYS", "")),
+		APIKeyTenants:          parseKeyTenants(getEnv("API_KEY_TENANTS", "")),
+		SigningKeys:            parseAPIKeys(getEnv("SIGNING_KEYS", "")),
+		SignatureMaxAge:        getEnvDuration("SIGNATURE_MAX_AGE", 5*time.Minute),
+		TenantCredentialsKey:   getEnv("TENANT_CREDENTIALS_KEY", ""),
+
+		JWTJWKSURL:       getEnv("JWT_JWKS_URL", ""),
+		JWTIssuer:        getEnv("JWT_ISSUER", ""),
+		JWTAudience:      getEnv("JWT_AUDIENCE", ""),
+		JWTTenantClaim:   getEnv("JWT_TENANT_CLAIM", "tenant"),
+		JWTScopeClaim:    getEnv("JWT_SCOPE_CLAIM", "scope"),
+		JWTRequiredScope: getEnv("JWT_REQUIRED_SCOPE", ""),
+		JWTAdminScope:    getEnv("JWT_ADMIN_SCOPE", ""),
+		JWKSRefresh:      getEnvDuration("JWT_JWKS_REFRESH", time.Hour),
+		AdminToken:       getEnv("ADMIN_TOKEN", ""),
+
+		RateLimitRPS:         getEnvFloat("RATE_LIMIT_RPS", 0),
+		RateLimitBurst:       getEnvInt("RATE_LIMIT_BURST", 0),
+		RateLimitCharsPerMin: getEnvInt("RATE_LIMIT_CHARS_PER_MIN", 0),
+		DailyCharQuota:       int64(getEnvInt("DAILY_CHAR_QUOTA", 0)),
+		LogLevel:             getEnv("LOG_LEVEL", "info"),
+		LogFormat:            getEnv("LOG_FORMAT", "json"),
+		ShutdownTimeout:      getEnvDuration("SHUTDOWN_TIMEOUT", 25*time.Second),
+
+		ReadHeaderTimeout: getEnvDuration("READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      getEnvDuration("WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       getEnvDuration("IDLE_TIMEOUT", 120*time.Second),
+		WSIdleTimeout:     getEnvDuration("WS_IDLE_TIMEOUT", 5*time.Minute),
+		MaxBodyBytes:      int64(getEnvInt("MAX_BODY_BYTES", 1<<20)),
+		GzipMinBytes:      getEnvInt("GZIP_MIN_BYTES", 1024),
+		IPAllowlist:       getEnvList("IP_ALLOWLIST"),
+		TrustedProxies:    getEnvList("TRUSTED_PROXIES"),
+		TracingEnabled:    getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")) != "",
+
+		TLSCertFile:         getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:          getEnv("TLS_KEY_FILE", ""),
+		TLSAutocertDomains:  getEnvList("TLS_AUTOCERT_DOMAINS"),
+		TLSAutocertCacheDir: getEnv("TLS_AUTOCERT_CACHE_DIR", "autocert"),
+		TLSAutocertEmail:    getEnv("TLS_AUTOCERT_EMAIL", ""),
+		TLSClientCAFile:     getEnv("TLS_CLIENT_CA_FILE", ""),
+
+		SkipUntranslatable: getEnvBool("SKIP_UNTRANSLATABLE", true),
+		LanguageFallback:   getEnvBool("LANGUAGE_FALLBACK", true),
+
+		LanguagePairsAllow: getEnvList("LANGUAGE_PAIRS_ALLOW"),
+		LanguagePairsDeny:  getEnvList("LANGUAGE_PAIRS_DENY"),
+
+		PlaceholderProtection: getEnvBool("PLACEHOLDER_PROTECTION", true),
+		PlaceholderPattern:    getEnv("PLACEHOLDER_PATTERN", defaultPlaceholderPattern),
+
+		PIIRedaction: getEnvList("PII_REDACTION"),
+		PIIPattern:   getEnv("PII_PATTERN", ""),
+
+		HTMLSanitization:      getEnvBool("HTML_SANITIZATION", true),
+		HTMLAllowedTags:       getEnvList("HTML_ALLOWED_TAGS"),
+		HTMLAllowedAttributes: getEnvList("HTML_ALLOWED_ATTRIBUTES"),
+
+		ChunkMaxBytes:       getEnvInt("CHUNK_MAX_BYTES", 0),
+		ChunkConcurrency:    getEnvInt("CHUNK_CONCURRENCY", 4),
+		DocumentConcurrency: getEnvInt("DOCUMENT_CONCURRENCY", 8),
+		DocumentMaxStrings:  getEnvInt("DOCUMENT_MAX_STRINGS", 5000),
+		FileMaxBodyBytes:    int64(getEnvInt("FILE_MAX_BODY_BYTES", 16<<20)),
+
+		PageFetchAllowlist:    getEnvList("PAGE_FETCH_ALLOWLIST"),
+		PageFetchAllowPrivate: getEnvBool("PAGE_FETCH_ALLOW_PRIVATE", false),
+		PageFetchTimeout:      getEnvDuration("PAGE_FETCH_TIMEOUT", 10*time.Second),
+		PageMaxBytes:          int64(getEnvInt("PAGE_MAX_BYTES", 2<<20)),
+
+		ProxyUpstream:      getEnv("PROXY_UPSTREAM", ""),
+		ProxyPort:          getEnv("PROXY_PORT", "8081"),
+		ProxySourceLang:    getEnv("PROXY_SOURCE_LANG", ""),
+		ProxyLanguages:     getEnvList("PROXY_LANGUAGES"),
+		ProxyJSONSelectors: getEnvList("PROXY_JSON_SELECTORS"),
+		ProxyMaxBytes:      int64(getEnvInt("PROXY_MAX_BYTES", 2<<20)),
+		ProxyCacheTTL:      getEnvDuration("PROXY_CACHE_TTL", time.Hour),
+
+		KafkaBrokers:     getEnvList("KAFKA_BROKERS"),
+		KafkaInputTopic:  getEnv("KAFKA_INPUT_TOPIC", ""),
+		KafkaOutputTopic: getEnv("KAFKA_OUTPUT_TOPIC", ""),
+		KafkaDLQTopic:    getEnv("KAFKA_DLQ_TOPIC", ""),
+		KafkaGroupID:     getEnv("KAFKA_GROUP_ID", "ss-translate"),
+		KafkaSerde:       getEnv("KAFKA_SERDE", "json"),
+		KafkaConsumers:   getEnvInt("KAFKA_CONSUMERS", 1),
+		KafkaRetry: provider.RetryPolicy{
+			MaxAttempts:    getEnvInt("KAFKA_MAX_ATTEMPTS", 5),
+			InitialBackoff: getEnvDuration("KAFKA_INITIAL_BACKOFF", time.Second),
+			MaxBackoff:     getEnvDuration("KAFKA_MAX_BACKOFF", time.Minute),
+		},
+		KafkaTLS:           getEnvBool("KAFKA_TLS", false),
+		KafkaSASLMechanism: getEnv("KAFKA_SASL_MECHANISM", ""),
+		KafkaSASLUsername:  getEnv("KAFKA_SASL_USERNAME", ""),
+		KafkaSASLPassword:  getEnv("KAFKA_SASL_PASSWORD", ""),
+
+		SQSInputQueueURL:     getEnv("SQS_INPUT_QUEUE_URL", ""),
+		SQSOutputQueueURL:    getEnv("SQS_OUTPUT_QUEUE_URL", ""),
+		SQSDLQQueueURL:       getEnv("SQS_DLQ_QUEUE_URL", ""),
+		SQSConsumers:         getEnvInt("SQS_CONSUMERS", 1),
+		SQSBatchSize:         getEnvInt("SQS_BATCH_SIZE", 10),
+		SQSVisibilityTimeout: getEnvDuration("SQS_VISIBILITY_TIMEOUT", time.Minute),
+		SQSWaitTime:          getEnvDuration("SQS_WAIT_TIME", 20*time.Second),
+		SQSRetry: provider.RetryPolicy{
+			MaxAttempts:    getEnvInt("SQS_MAX_ATTEMPTS", 5),
+			InitialBackoff: getEnvDuration("SQS_INITIAL_BACKOFF", 10*time.Second),
+			MaxBackoff:     getEnvDuration("SQS_MAX_BACKOFF", 15*time.Minute),
+		},
+
+		PubSubProjectID:      getEnv("PUBSUB_PROJECT_ID", ""),
+		PubSubSubscription:   getEnv("PUBSUB_SUBSCRIPTION", ""),
+		PubSubOutputTopic:    getEnv("PUBSUB_OUTPUT_TOPIC", ""),
+		PubSubDLQTopic:       getEnv("PUBSUB_DLQ_TOPIC", ""),
+		PubSubMaxOutstanding: getEnvInt("PUBSUB_MAX_OUTSTANDING", 10),
+		PubSubRetry: provider.RetryPolicy{
+			MaxAttempts:    getEnvInt("PUBSUB_MAX_ATTEMPTS", 5),
+			InitialBackoff: getEnvDuration("PUBSUB_INITIAL_BACKOFF", time.Second),
+			MaxBackoff:     getEnvDuration("PUBSUB_MAX_BACKOFF", time.Minute),
+		},
+
+		NATSURL:           getEnvList("NATS_URL"),
+		NATSSubjectPrefix: getEnv("NATS_SUBJECT_PREFIX", "translation"),
+		NATSQueueGroup:    getEnv("NATS_QUEUE_GROUP", "ss-translate"),
+		NATSConcurrency:   getEnvInt("NATS_CONCURRENCY", 64),
+		NATSCredsFile:     getEnv("NATS_CREDS_FILE", ""),
+		NATSToken:         getEnv("NATS_TOKEN", ""),
+
+		TranslationMemory:    getEnvBool("TRANSLATION_MEMORY", true),
+		TMFuzzyThreshold:     getEnvFloat("TM_FUZZY_THRESHOLD", 0),
+		TMImportMaxBodyBytes: int64(getEnvInt("TM_IMPORT_MAX_BODY_BYTES", 64<<20)),
+
+		VerifyMinSimilarity: getEnvFloat("VERIFY_MIN_SIMILARITY", 0),
+
+		ModerationInput:     getEnv("MODERATION_INPUT", ""),
+		ModerationOutput:    getEnv("MODERATION_OUTPUT", ""),
+		ModerationWordlists: getEnvMap("MODERATION_WORDLISTS"),
+		ModerationURL:       getEnv("MODERATION_URL", ""),
+		ModerationAPIKey:    getEnv("MODERATION_API_KEY", ""),
+		ModerationModel:     getEnv("MODERATION_MODEL", ""),
+
+		ReviewMinConfidence: getEnvFloat("REVIEW_MIN_CONFIDENCE", 0),
+		ReviewMaxQuality:    getEnvFloat("REVIEW_MAX_QUALITY", 0),
+		ReviewRetention:     getEnvDuration("REVIEW_RETENTION", 30*24*time.Hour),
+
+		HistoryDatabaseURL: getEnv("HISTORY_DATABASE_URL", ""),
+		HistoryRetention:   getEnvDuration("HISTORY_RETENTION", 90*24*time.Hour),
+
+		QualityEstimator:         getEnv("QUALITY_ESTIMATOR", ""),
+		QualityEstimationURL:     getEnv("QUALITY_ESTIMATION_URL", ""),
+		QualityEstimationAPIKey:  getEnv("QUALITY_ESTIMATION_API_KEY", ""),
+		QualityEstimationModel:   getEnv("QUALITY_ESTIMATION_MODEL", ""),
+		QualityEstimationWorkers: getEnvInt("QUALITY_ESTIMATION_WORKERS", 2),
+
+		JobWorkers:          getEnvInt("JOB_WORKERS", 4),
+		JobRetention:        getEnvDuration("JOB_RETENTION", 24*time.Hour),
+		IdempotencyTTL:      getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		JobMaxRequests:      getEnvInt("JOB_MAX_REQUESTS", 1000),
+		JobMaxBodyBytes:     int64(getEnvInt("JOB_MAX_BODY_BYTES", 32<<20)),
+		WebhookSecret:       getEnv("WEBHOOK_SECRET", ""),
+		WebhookAllowPrivate: getEnvBool("WEBHOOK_ALLOW_PRIVATE", false),
+		WebhookRetry: provider.RetryPolicy{
+			MaxAttempts:    getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+			InitialBackoff: getEnvDuration("WEBHOOK_INITIAL_BACKOFF", time.Second),
+			MaxBackoff:     getEnvDuration("WEBHOOK_MAX_BACKOFF", time.Minute),
+		},
+		JobStorageBuckets:    getEnvList("JOB_STORAGE_BUCKETS"),
+		JobFileBatchSize:     getEnvInt("JOB_FILE_BATCH_SIZE", 100),
+		JobFileMaxXLIFFBytes: int64(getEnvInt("JOB_FILE_MAX_XLIFF_BYTES", 64<<20)),
+		S3ForcePathStyle:     getEnvBool("S3_FORCE_PATH_STYLE", false),
+
+		UsageRetention: time.Hour * 24 * time.Duration(getEnvInt("USAGE_RETENTION_DAYS", 400)),
+		ProviderPrices: parseProviderPrices(getEnv("PROVIDER_PRICES", "google:20,aws:15,azure:10")),
+		Provider:       getEnv("TRANSLATE_PROVIDER", "google"),
+		Providers:      getEnvList("PROVIDERS"),
+		CanaryProvider: getEnv("CANARY_PROVIDER", ""),
+		CanaryPercent:  getEnvFloat("CANARY_PERCENT", 0),
+		Retry: provider.RetryPolicy{
+			MaxAttempts:    getEnvInt("RETRY_MAX_ATTEMPTS", 3),
+			InitialBackoff: getEnvDuration("RETRY_INITIAL_BACKOFF", 100*time.Millisecond),
+			MaxBackoff:     getEnvDuration("RETRY_MAX_BACKOFF", 2*time.Second),
+		},
+		CircuitBreakerThreshold: getEnvInt("CIRCUIT_BREAKER_THRESHOLD", 5),
+		CircuitBreakerCooldown:  getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", 30*time.Second),
+		ProviderMaxInFlight:     getEnvInt("PROVIDER_MAX_IN_FLIGHT", 64),
+		ProviderQueueSize:       getEnvInt("PROVIDER_QUEUE_SIZE", 256),
+		ProviderQueueTimeout:    getEnvDuration("PROVIDER_QUEUE_TIMEOUT", 5*time.Second),
+		ProviderMinuteBudgets:   parseProviderBudgets(getEnv("PROVIDER_MINUTE_BUDGETS", "")),
+		ProviderDailyBudgets:    parseProviderBudgets(getEnv("PROVIDER_DAILY_BUDGETS", "")),
+		ProviderBudgetReserve:   getEnvFloat("PROVIDER_BUDGET_RESERVE", 0.2),
+		ProviderBudgetMaxDefer:  getEnvDuration("PROVIDER_BUDGET_MAX_DEFER", time.Minute),
+
+		GoogleAPIVersion: getEnv("GOOGLE_TRANSLATE_API_VERSION", "v2"),
+		GoogleProjectID:  getEnv("GOOGLE_PROJECT_ID", ""),
+		GoogleLocation:   getEnv("GOOGLE_LOCATION", "global"),
+		GoogleModel:      getEnv("GOOGLE_MODEL", ""),
+		GoogleGlossary:   getEnv("GOOGLE_GLOSSARY", ""),
+
+		GoogleDomainModels: getEnvMap("GOOGLE_DOMAIN_MODELS"),
+
+		AWSRegion:        getEnv("AWS_TRANSLATE_REGION", ""),
+		AWSTerminologies: getEnvList("AWS_TRANSLATE_TERMINOLOGIES"),
+
+		AWSDomainTerminologies: getEnvMap("AWS_TRANSLATE_DOMAIN_TERMINOLOGIES"),
+
+		AzureEndpoint: getEnv("AZURE_TRANSLATOR_ENDPOINT", "https://api.cognitive.microsofttranslator.com"),
+		AzureKey:      getEnv("AZURE_TRANSLATOR_KEY", ""),
+		AzureRegion:   getEnv("AZURE_TRANSLATOR_REGION", ""),
+
+		LibreTranslateURL:    getEnv("LIBRETRANSLATE_URL", ""),
+		LibreTranslateAPIKey: getEnv("LIBRETRANSLATE_API_KEY", ""),
+
+		LLMEndpoint:       getEnv("LLM_ENDPOINT", "https://api.openai.com/v1"),
+		LLMAPIKey:         getEnv("LLM_API_KEY", ""),
+		LLMModel:          getEnv("LLM_MODEL", "gpt-4o-mini"),
+		LLMTemperature:    getEnvFloat("LLM_TEMPERATURE", 0.2),
+		LLMPromptTemplate: getEnv("LLM_PROMPT_TEMPLATE", ""),
+		LLMLanguages:      getEnvList("LLM_LANGUAGES"),
+
+		LLMDomainPrompts: getEnvMap("LLM_DOMAIN_PROMPTS"),
+	}
+
+	// Keep accepting the legacy single shared token
+	if token := getEnv("AUTH_TOKEN", ""); token != "" {
+		c.APIKeys["default"] = token
+	}
+	return c
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value := setting(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvInt gets an integer environment variable or returns a default value
+// when it is unset. Invalid values are reported by ValidateConfig.
+func getEnvInt(key string, defaultValue int) int {
+	raw := setting(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		settingError(key, "%q is not an integer", raw)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvBool gets a boolean environment variable or returns a default value
+// when it is unset. Invalid values are reported by ValidateConfig.
+func getEnvBool(key string, defaultValue bool) bool {
+	raw := setting(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		settingError(key, "%q is not a boolean, use true or false", raw)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvFloat gets a floating point environment variable or returns a
+// default value when it is unset. Invalid values are reported by
+// ValidateConfig.
+func getEnvFloat(key string, defaultValue float64) float64 {
+	raw := setting(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		settingError(key, "%q is not a number", raw)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvDuration gets a duration environment variable (e.g. "30s") or
+// returns a default value when it is unset. Invalid values are reported by
+// ValidateConfig.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	raw := setting(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		settingError(key, "%q is not a duration, use a unit such as 500ms, 30s or 2h", raw)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvList splits a comma-separated environment variable into its
+// non-empty, trimmed elements
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(setting(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvMap splits a comma-separated environment variable of key:value
+// pairs, which config file mappings are written as. Malformed pairs are
+// reported by ValidateConfig.
+func getEnvMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range getEnvList(key) {
+		name, value, ok := strings.Cut(pair, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			settingError(key, "%q is not a key:value pair", pair)
+			continue
+		}
+		values[name] = value
+	}
+	return values
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTranslateGETCaching(t *testing.T) {
+	tests := []struct {
+		name             string
+		signed           bool
+		maxAge           time.Duration
+		wantCacheControl string
+	}{
+		{"api key", false, time.Hour, "public, max-age=3600"},
+		{"signed", true, time.Hour, "private, max-age=3600"},
+		{"not cached", false, 0, "no-cache"},
+		{"signed, not cached", true, 0, "no-cache"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) {
+				c.APIKeys = parseAPIKeys("web:k1")
+				c.SigningKeys = map[string]string{"spa": "s3cret"}
+				c.GetCacheMaxAge = tt.maxAge
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/translate?text=Hello&source=en&target=de", nil)
+			if tt.signed {
+				timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+				r.Header.Set("X-Timestamp", timestamp)
+				r.Header.Set("X-Signature", "sha256="+hex.EncodeToString(signRequest("s3cret", timestamp, nil)))
+			} else {
+				r.Header.Set("Authorization", "Bearer k1")
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.wantCacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantCacheControl)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// Reasons texts are returned untranslated, as skip_reason
+const (
+	skipSameLanguage = "same_language"
+	skipWhitespace   = "whitespace"
+	skipURL          = "url"
+	skipEmail        = "email"
+	skipNumeric      = "numeric"
+	skipEmoji        = "emoji"
+	skipPunctuation  = "punctuation"
+)
+
+var (
+	// untranslatableURL matches a text that is a single URL
+	untranslatableURL = regexp.MustCompile(`(?i)^(https?://|ftp://|www\.)\S+$`)
+	// untranslatableEmail matches a text that is a single email address
+	untranslatableEmail = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@]+$`)
+)
+
+// untranslatableReason returns why text has nothing a provider could
+// translate, or an empty string when it may. Texts without a single letter,
+// such as order numbers, prices or emoji, are returned by providers as they
+// are anyway.
+func untranslatableReason(text string) string {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return skipWhitespace
+	case untranslatableURL.MatchString(text):
+		return skipURL
+	case untranslatableEmail.MatchString(text):
+		return skipEmail
+	}
+
+	var digits, symbols bool
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r):
+			return ""
+		case unicode.IsDigit(r):
+			digits = true
+		case unicode.Is(unicode.So, r):
+			symbols = true
+		}
+	}
+	switch {
+	case digits:
+		return skipNumeric
+	case symbols:
+		return skipEmoji
+	default:
+		return skipPunctuation
+	}
+}
+
+// untranslatable returns why req needs no provider call, if it doesn't. HTML
+// texts are only skipped when blank, since markup hides the text content.
+func (s *Server) untranslatable(req TranslationRequest) string {
+	if !s.config.SkipUntranslatable {
+		return ""
+	}
+	reason := untranslatableReason(req.Text)
+	if req.Format == provider.FormatHTML && reason != skipWhitespace {
+		return ""
+	}
+	return reason
+}
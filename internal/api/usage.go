@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis keys used for usage accounting
+const (
+	usageKeysKey   = "usage:keys" // Set of every key name with recorded usage
+	usagePrefix    = "usage:"     // usage:<YYYYMMDD>:<key name> is a hash of counters
+	usageDayFormat = "20060102"
+	usageMaxDays   = 366
+)
+
+// Usage counter names. Hash fields are "<source>><target>|<counter>", and
+// billed characters are kept per provider as "<pair>|billed:<provider>".
+const (
+	usageRequests        = "requests"
+	usageChars           = "chars"
+	usageCacheHits       = "cache_hits"
+	usageProviderCalls   = "provider_calls"
+	usageProviderLatency = "provider_latency_ms"
+	usageBilledPrefix    = "billed:"
+)
+
+// UsageStats are the aggregated counters for a key or language pair
+type UsageStats struct {
+	Requests             int64   `json:"requests"`
+	Characters           int64   `json:"characters"`
+	BilledCharacters     int64   `json:"billed_characters"` // Characters sent to providers
+	CacheHits            int64   `json:"cache_hits"`
+	CacheHitRatio        float64 `json:"cache_hit_ratio"`
+	ProviderCalls        int64   `json:"provider_calls"`
+	AvgProviderLatencyMs float64 `json:"avg_provider_latency_ms"`
+	EstimatedCost        float64 `json:"estimated_cost"`
+
+	providerLatencyMs int64
+}
+
+// PairUsage is the usage of one language pair
+type PairUsage struct {
+	SourceLang string `json:"source_lang"`
+	TargetLang string `json:"target_lang"`
+	UsageStats
+}
+
+// KeyUsage is the usage of one API key
+type KeyUsage struct {
+	Key string `json:"key"`
+	UsageStats
+	LanguagePairs []PairUsage `json:"language_pairs"`
+}
+
+// UsageResponse is the body returned by GET /admin/usage
+type UsageResponse struct {
+	From string     `json:"from"`
+	To   string     `json:"to"`
+	Keys []KeyUsage `json:"keys"`
+}
+
+// usageKey returns the Redis hash holding keyName's usage on the UTC day of t
+func usageKey(keyName string, t time.Time) string {
+	return usagePrefix + t.UTC().Format(usageDayFormat) + ":" + keyName
+}
+
+// recordUsage counts one served translation for keyName, billing its
+// provider for the response's billed characters. Usage is not recorded in
+// degraded mode.
+func (s *Server) recordUsage(ctx context.Context, keyName string, response *TranslationResponse) {
+	if !s.redisAvailable() {
+		return
+	}
+	sourceLang := response.SourceLang
+	if sourceLang == "" {
+		sourceLang = "auto"
+	}
+	pair := sourceLang + ">" + response.TargetLang + "|"
+	key := usageKey(keyName, time.Now())
+
+	pipe := s.redis.TxPipeline()
+	pipe.SAdd(ctx, usageKeysKey, keyName)
+	pipe.HIncrBy(ctx, key, pair+usageRequests, 1)
+	pipe.HIncrBy(ctx, key, pair+usageChars, int64(response.Characters))
+	if response.CacheHit {
+		pipe.HIncrBy(ctx, key, pair+usageCacheHits, 1)
+	}
+	if response.BilledCharacters > 0 {
+		pipe.HIncrBy(ctx, key, pair+usageBilledPrefix+response.Provider, int64(response.BilledCharacters))
+		pipe.HIncrBy(ctx, key, pair+usageProviderCalls, 1)
+		pipe.HIncrBy(ctx, key, pair+usageProviderLatency, response.ProviderLatencyMs)
+	}
+	pipe.Expire(ctx, key, s.config.UsageRetention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger(ctx).Warn("failed to record usage", "error", err)
+	}
+}
+
+// add merges the counter named field into s, pricing billed characters at
+// prices per million
+func (s *UsageStats) add(field string, value int64, prices map[string]float64) {
+	switch {
+	case field == usageRequests:
+		s.Requests += value
+	case field == usageChars:
+		s.Characters += value
+	case field == usageCacheHits:
+		s.CacheHits += value
+	case field == usageProviderCalls:
+		s.ProviderCalls += value
+	case field == usageProviderLatency:
+		s.providerLatencyMs += value
+	case strings.HasPrefix(field, usageBilledPrefix):
+		provider := strings.TrimPrefix(field, usageBilledPrefix)
+		s.BilledCharacters += value
+		s.EstimatedCost += float64(value) * prices[provider] / 1e6
+	}
+}
+
+// finish computes the derived fields of s
+func (s *UsageStats) finish() {
+	if s.Requests > 0 {
+		s.CacheHitRatio = float64(s.CacheHits) / float64(s.Requests)
+	}
+	if s.ProviderCalls > 0 {
+		s.AvgProviderLatencyMs = float64(s.providerLatencyMs) / float64(s.ProviderCalls)
+	}
+}
+
+// estimatedCost prices chars sent to the named provider at PROVIDER_PRICES
+func (s *Server) estimatedCost(provider string, chars int) float64 {
+	return float64(chars) * s.config.ProviderPrices[provider] / 1e6
+}
+
+// loadUsage aggregates keyName's usage over the UTC days from..to inclusive
+func (s *Server) loadUsage(ctx context.Context, keyName string, from, to time.Time) (*KeyUsage, error) {
+	usage := &KeyUsage{Key: keyName}
+	pairs := make(map[string]*PairUsage)
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		fields, err := s.redis.HGetAll(ctx, usageKey(keyName, day)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for field, raw := range fields {
+			value, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				continue
+			}
+			pairName, counter, ok := strings.Cut(field, "|")
+			if !ok {
+				continue
+			}
+			pair, ok := pairs[pairName]
+			if !ok {
+				source, target, _ := strings.Cut(pairName, ">")
+				pair = &PairUsage{SourceLang: source, TargetLang: target}
+				pairs[pairName] = pair
+			}
+			pair.add(counter, value, s.config.ProviderPrices)
+			usage.add(counter, value, s.config.ProviderPrices)
+		}
+	}
+
+	usage.finish()
+	usage.LanguagePairs = make([]PairUsage, 0, len(pairs))
+	for _, pair := range pairs {
+		pair.finish()
+		usage.LanguagePairs = append(usage.LanguagePairs, *pair)
+	}
+	sort.Slice(usage.LanguagePairs, func(i, j int) bool {
+		return usage.LanguagePairs[i].Characters > usage.LanguagePairs[j].Characters
+	})
+	return usage, nil
+}
+
+// parseUsageDate parses a YYYY-MM-DD query parameter, returning def when empty
+func parseUsageDate(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// handleAdminUsage reports usage per API key and language pair. The optional
+// key parameter limits the report to one key; from and to (YYYY-MM-DD, UTC,
+// inclusive) default to the current month.
+func (s *Server) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+
+	now := time.Now().UTC()
+	query := r.URL.Query()
+	from, err := parseUsageDate(query.Get("from"), time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid from date: %v", err))
+		return
+	}
+	to, err := parseUsageDate(query.Get("to"), now.Truncate(24*time.Hour))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid to date: %v", err))
+		return
+	}
+	if to.Before(from) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "The to date must not be before the from date")
+		return
+	}
+	if to.Sub(from) > usageMaxDays*24*time.Hour {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Date range must not exceed %d days", usageMaxDays))
+		return
+	}
+
+	ctx := r.Context()
+	keyNames := []string{query.Get("key")}
+	if keyNames[0] == "" {
+		keyNames, err = s.redis.SMembers(ctx, usageKeysKey).Result()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to list keys: %v", err))
+			return
+		}
+		sort.Strings(keyNames)
+	}
+
+	response := UsageResponse{
+		From: from.Format("2006-01-02"),
+		To:   to.Format("2006-01-02"),
+		Keys: make([]KeyUsage, 0, len(keyNames)),
+	}
+	for _, keyName := range keyNames {
+		usage, err := s.loadUsage(ctx, keyName, from, to)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to load usage: %v", err))
+			return
+		}
+		response.Keys = append(response.Keys, *usage)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseProviderPrices parses a comma-separated list of provider:price pairs,
+// with prices per million characters
+func parseProviderPrices(value string) map[string]float64 {
+	prices := make(map[string]float64)
+	for _, entry := range strings.Split(value, ",") {
+		provider, price, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			continue
+		}
+		p, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			slog.Warn("ignoring invalid provider price", "provider", provider, "error", err)
+			continue
+		}
+		prices[provider] = p
+	}
+	return prices
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// maxVerifyLength is the most characters of a text checked by
+// back-translation, as scoring takes time quadratic in its length
+const maxVerifyLength = 5000
+
+// Verification is the back-translation check of a translation, returned
+// with verify
+type Verification struct {
+	BackTranslation string  `json:"back_translation"`       // The translation translated back into the source language
+	Similarity      float64 `json:"similarity"`             // Of the back-translation to the text, from 0 to 1
+	RetriedWith     string  `json:"retried_with,omitempty"` // Provider the text was translated again with, for scoring under VERIFY_MIN_SIMILARITY
+}
+
+// verifyTranslation adds a back-translation check to the response of a
+// request asking for one: the translation is translated back into the
+// source language and compared with the text. Below VERIFY_MIN_SIMILARITY
+// the text is translated again with the next configured provider, whose
+// translation is served when it scores higher. Every call is billed with
+// the translation; when the check fails the translation is served without
+// it.
+func (s *Server) verifyTranslation(ctx context.Context, req TranslationRequest, response *TranslationResponse) {
+	// Skipped texts and approved translations have nothing to check
+	if !req.Verify || response.Skipped || response.Memory != nil || response.SourceLang == "" {
+		return
+	}
+	p, err := s.providerFor(ctx)
+	if err == nil {
+		response.Verification, err = s.backTranslate(ctx, p, req, response)
+	}
+	if err != nil {
+		logger(ctx).Warn("failed to verify translation", "error", err)
+		return
+	}
+	verificationSimilarity.Observe(response.Verification.Similarity)
+
+	retry := s.verifyRetryProvider(response.Provider)
+	if response.Verification.Similarity >= s.currentConfig().VerifyMinSimilarity || retry == nil {
+		return
+	}
+	response.Verification.RetriedWith = retry.Name()
+	// The source is known by now, and may have been detected
+	retryReq := req
+	retryReq.SourceLang = response.SourceLang
+	retried, err := s.translateWith(ctx, retry, retryReq, s.glossaryTerms(ctx, req))
+	var verification *Verification
+	if err == nil {
+		verification, err = s.backTranslate(ctx, retry, req, retried)
+		response.BilledCharacters += retried.BilledCharacters
+		response.ProviderLatencyMs += retried.ProviderLatencyMs
+	}
+	if err != nil {
+		verificationRetries.WithLabelValues("failed").Inc()
+		logger(ctx).Warn("failed to retry a translation under the verification threshold", "provider", retry.Name(), "error", err)
+		return
+	}
+	if verification.Similarity <= response.Verification.Similarity {
+		verificationRetries.WithLabelValues("kept").Inc()
+		return
+	}
+	verificationRetries.WithLabelValues("improved").Inc()
+	verification.RetriedWith = retry.Name()
+	response.TranslatedText, response.TargetLang, response.Provider = retried.TranslatedText, retried.TargetLang, retried.Provider
+	response.CacheHit = false
+	response.Verification = verification
+}
+
+// backTranslate translates the translation of response back into its
+// source language with p, scoring its similarity to the text of req. The
+// provider call is billed to response.
+func (s *Server) backTranslate(ctx context.Context, p provider.Provider, req TranslationRequest, response *TranslationResponse) (*Verification, error) {
+	back := provider.Request{
+		Text:       response.TranslatedText,
+		SourceLang: response.TargetLang,
+		TargetLang: s.supportedLanguage(ctx, p, response.SourceLang),
+		Format:     req.Format,
+		Domain:     req.Domain,
+	}
+	start := time.Now()
+	result, err := p.Translate(ctx, back)
+	if err != nil {
+		return nil, err
+	}
+	response.BilledCharacters += utf8.RuneCountInString(back.Text)
+	response.ProviderLatencyMs += time.Since(start).Milliseconds()
+	if result.TranslatedText == "" {
+		return nil, errors.New("empty back-translation")
+	}
+	score := similarity(verificationText(req.Text), verificationText(result.TranslatedText))
+	return &Verification{
+		BackTranslation: result.TranslatedText,
+		Similarity:      math.Round(score*1000) / 1000,
+	}, nil
+}
+
+// verificationText returns text as compared with its back-translation:
+// lowercased, with runs of whitespace as single spaces
+func verificationText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// verifyRetryProvider returns the first configured provider other than the
+// named one, or nil when there is none
+func (s *Server) verifyRetryProvider(name string) provider.Provider {
+	for _, p := range s.providers {
+		if p.Name() != name {
+			return p
+		}
+	}
+	return nil
+}
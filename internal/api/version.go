@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set at build time with
+//
+//	pkg=github.com/dphase/ss-translate/internal/api
+//	go build -ldflags "-X $pkg.Version=1.4.0 -X $pkg.gitCommit=$(git rev-parse HEAD) -X $pkg.buildTime=$(date -u +%FT%TZ)" ./cmd/ss-translate
+//
+// The commit and build time fall back to the VCS stamp the Go toolchain
+// embeds when building from a checkout.
+var (
+	Version   = "dev"
+	gitCommit string
+	buildTime string
+)
+
+// VersionResponse is the body of GET /version
+type VersionResponse struct {
+	Version        string   `json:"version"`
+	GitCommit      string   `json:"git_commit,omitempty"`
+	BuildTime      string   `json:"build_time,omitempty"`
+	GoVersion      string   `json:"go_version"`
+	Providers      []string `json:"providers"`
+	CanaryProvider string   `json:"canary_provider,omitempty"`
+	CacheBackend   string   `json:"cache_backend"`
+	Features       []string `json:"features"`
+}
+
+// buildInfo returns the commit and build time, from the linker flags or the
+// embedded VCS stamp
+func buildInfo() (string, string) {
+	commit, built := gitCommit, buildTime
+	if info, ok := debug.ReadBuildInfo(); ok {
+		var modified bool
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if commit == "" {
+					commit = s.Value
+				}
+			case "vcs.time":
+				if built == "" {
+					built = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && gitCommit == "" && commit != "" {
+			commit += "-dirty"
+		}
+	}
+	return commit, built
+}
+
+// enabledFeatures lists the optional features turned on by the configuration
+func (s *Server) enabledFeatures() []string {
+	features := []string{}
+	add := func(name string, enabled bool) {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+	add("admin_api", s.config.AdminToken != "" || s.config.JWTAdminScope != "")
+	add("jwt", s.jwtEnabled())
+	add("request_signing", len(s.config.SigningKeys) > 0)
+	add("tenant_credentials", s.credentialsAEAD != nil)
+	add("ip_allowlist", len(s.ipAllowlist) > 0)
+	add("tls", s.TLSEnabled())
+	add("mtls", s.config.TLSClientCAFile != "")
+	live := s.currentConfig()
+	add("rate_limits", live.RateLimitRPS > 0 || live.RateLimitCharsPerMin > 0)
+	add("daily_quota", live.DailyCharQuota > 0)
+	add("failover", len(s.config.Providers) > 1)
+	add("circuit_breaker", s.config.CircuitBreakerThreshold > 0)
+	add("placeholder_protection", s.config.PlaceholderProtection)
+	add("pii_redaction", len(s.config.PIIRedaction) > 0 || s.config.PIIPattern != "")
+	add("html_sanitization", s.config.HTMLSanitization)
+	add("reverse_proxy", s.proxyUpstream != nil)
+	add("kafka", s.kafkaWriter != nil)
+	add("sqs", s.sqs != nil)
+	add("pubsub", s.pubsub != nil)
+	add("nats", s.nats != nil)
+	add("translation_memory", s.config.TranslationMemory)
+	add("history", s.config.HistoryDatabaseURL != "")
+	add("webhooks", s.config.WebhookSecret != "")
+	add("tracing", s.config.TracingEnabled)
+	add("provider_health", s.config.ProviderHealthInterval > 0)
+	return features
+}
+
+// handleVersion reports which build is running and how it is configured
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	commit, built := buildInfo()
+	names := s.config.Providers
+	if len(names) == 0 {
+		names = []string{s.config.Provider}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VersionResponse{
+		Version:        Version,
+		GitCommit:      commit,
+		BuildTime:      built,
+		GoVersion:      runtime.Version(),
+		Providers:      names,
+		CanaryProvider: s.config.CanaryProvider,
+		CacheBackend:   s.config.CacheBackend,
+		Features:       s.enabledFeatures(),
+	})
+}
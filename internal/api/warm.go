@@ -0,0 +1,183 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/cache"
+)
+
+// CacheWarmResponse is the body returned by POST /admin/cache/warm
+type CacheWarmResponse struct {
+	Entries int `json:"entries"` // Seed entries queued, translated in the background unless cached
+}
+
+// loadCacheSeed reads and parses the seed file at path
+func loadCacheSeed(path string) ([]TranslationRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCacheSeed(data)
+}
+
+// parseCacheSeed parses a seed file of texts to translate: a JSON array of
+// objects with text, source_lang, target_lang and optionally format, or CSV
+// rows of text, source language and target language. Entries are validated
+// like POST /translate bodies.
+func parseCacheSeed(data []byte) ([]TranslationRequest, error) {
+	var seed []TranslationRequest
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &seed); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+	} else if seed, err = parseCacheSeedCSV(data); err != nil {
+		return nil, err
+	}
+	if len(seed) == 0 {
+		return nil, errors.New("no entries")
+	}
+	for i := range seed {
+		err := validateTranslationRequest(&seed[i])
+		if err == nil {
+			err = validateLanguages(seed[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i+1, err)
+		}
+	}
+	return seed, nil
+}
+
+// parseCacheSeedCSV parses the CSV rows of a seed file. A header row whose
+// first column is "text" names the other columns (source or source_lang,
+// target or target_lang, format), which may then come in any order.
+func parseCacheSeedCSV(data []byte) ([]TranslationRequest, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	columns := map[string]int{"source": 1, "target": 2, "format": -1}
+	var seed []TranslationRequest
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return seed, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		if row == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "text") {
+			columns = map[string]int{"source": -1, "target": -1, "format": -1}
+			for i, name := range record {
+				name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), "_lang")
+				if _, ok := columns[name]; ok {
+					columns[name] = i
+				}
+			}
+			if columns["target"] < 0 {
+				return nil, errors.New("invalid CSV: the header names no target column")
+			}
+			continue
+		}
+		field := func(name string) string {
+			if i := columns[name]; i >= 0 && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		seed = append(seed, TranslationRequest{
+			Text:       record[0],
+			SourceLang: field("source"),
+			TargetLang: field("target"),
+			Format:     field("format"),
+		})
+	}
+}
+
+// warmCache translates the seed entries that aren't cached yet, at most
+// CACHE_WARM_RATE a second, so a cold cache fills up without a spike of
+// provider calls. Warming translations are low priority for provider budgets.
+// It returns false without doing anything while another warming runs.
+func (s *Server) warmCache(ctx context.Context, seed []TranslationRequest) bool {
+	if !s.warming.CompareAndSwap(false, true) {
+		return false
+	}
+	go func() {
+		defer s.warming.Store(false)
+		ctx := withLowPriority(ctx)
+		start := time.Now()
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / s.config.CacheWarmRate))
+		defer ticker.Stop()
+
+		var translated, cached, failed int
+		for _, req := range seed {
+			key := s.translationCacheKey(ctx, req, s.glossaryTerms(ctx, req))
+			_, err := s.cache.Get(ctx, key)
+			if err == nil || s.lookupTranslationMemory(ctx, req) != nil {
+				cached++
+				continue
+			}
+			if errors.Is(err, cache.ErrUnavailable) {
+				logger(ctx).Warn("cache unavailable, stopping cache warming", "translated", translated)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				logger(ctx).Info("cache warming stopped", "translated", translated)
+				return
+			case <-ticker.C:
+			}
+			if _, err := s.translateText(ctx, req); err != nil {
+				failed++
+				logger(ctx).Warn("failed to warm cache entry", "source_lang", req.SourceLang, "target_lang", req.TargetLang, "error", err)
+				continue
+			}
+			translated++
+		}
+		logger(ctx).Info("cache warming finished", "entries", len(seed), "translated", translated, "cached", cached,
+			"failed", failed, "duration_ms", time.Since(start).Milliseconds())
+	}()
+	return true
+}
+
+// handleAdminCacheWarm serves POST /admin/cache/warm, which warms the cache
+// from a seed file uploaded like subtitles
+func (s *Server) handleAdminCacheWarm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.authenticateAdmin(w, r) {
+		return
+	}
+
+	data, _, ok := readUploadedFile(w, r)
+	if !ok {
+		return
+	}
+	seed, err := parseCacheSeed(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid seed file: %v", err))
+		return
+	}
+	// Warming outlives the request
+	if !s.warmCache(context.WithoutCancel(r.Context()), seed) {
+		writeError(w, http.StatusConflict, codeConflict, "Cache warming is already in progress")
+		return
+	}
+	logger(r.Context()).Info("cache warming started", "entries", len(seed))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(CacheWarmResponse{Entries: len(seed)})
+}
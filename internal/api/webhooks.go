@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+// Callback delivery states reported on a job
+const (
+	callbackPending   = "pending"
+	callbackDelivered = "delivered"
+	callbackFailed    = "failed"
+)
+
+// webhookTimeout bounds a callback delivery attempt, with its redirects
+const webhookTimeout = 10 * time.Second
+
+// maxWebhookRedirects is the most redirects a callback delivery follows
+const maxWebhookRedirects = 5
+
+// newWebhookClient returns the client callbacks are delivered with. Unless
+// WEBHOOK_ALLOW_PRIVATE is set, the address of every connection is checked
+// once resolved, as for pages, so callback hosts can't resolve to internal
+// addresses, and the URL of every redirect is validated again.
+func (s *Server) newWebhookClient() *http.Client {
+	dialer := newPublicDialer(webhookTimeout, s.config.WebhookAllowPrivate)
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   webhookTimeout,
+			ResponseHeaderTimeout: webhookTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxWebhookRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxWebhookRedirects)
+			}
+			return s.validateCallbackURL(req.URL.String())
+		},
+	}
+}
+
+// validateCallbackURL checks that a callback URL is an absolute HTTP(S) URL
+// and, unless WEBHOOK_ALLOW_PRIVATE is set, doesn't name localhost or an
+// internal IP address. Host names are checked again once resolved.
+func (s *Server) validateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback URL must be an absolute http or https URL")
+	}
+	if s.config.WebhookAllowPrivate {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("callback URL must not be on localhost")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !publicAddress(addr) {
+		return fmt.Errorf("callback URL must not be an internal address, %s is not public", addr)
+	}
+	return nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>" under the
+// webhook secret. Binding the timestamp lets receivers reject replays.
+func (s *Server) signWebhook(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(s.config.WebhookSecret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook makes a single callback delivery attempt
+func (s *Server) postWebhook(ctx context.Context, callbackURL, jobID string, body []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Job-ID", jobID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+s.signWebhook(timestamp, body))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &provider.HTTPError{StatusCode: resp.StatusCode, Message: resp.Status}
+	}
+	return nil
+}
+
+// deliverCallback POSTs a finished job to its callback URL, retrying with
+// backoff until the receiver answers 2xx, the attempts run out or ctx is
+// done. Unlike provider calls every failure is retried, as receivers are
+// often briefly unavailable.
+func (s *Server) deliverCallback(ctx context.Context, job *Job) {
+	view := *job
+	view.Requests = nil
+	body, err := json.Marshal(view)
+	if err != nil {
+		job.CallbackStatus = callbackFailed
+		return
+	}
+
+	policy := s.config.WebhookRetry
+	log := logger(ctx).With("job_id", job.ID)
+	for attempt := 1; ; attempt++ {
+		job.CallbackAttempts = attempt
+		err := s.postWebhook(ctx, job.CallbackURL, job.ID, body)
+		if err == nil {
+			job.CallbackStatus = callbackDelivered
+			webhookDeliveries.WithLabelValues("delivered").Inc()
+			log.Info("job callback delivered", "attempts", attempt)
+			return
+		}
+		// Callbacks reaching internal addresses are refused for good
+		var blocked blockedAddressError
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil || errors.As(err, &blocked) {
+			job.CallbackStatus = callbackFailed
+			webhookDeliveries.WithLabelValues("failed").Inc()
+			log.Error("job callback failed", "attempts", attempt, "error", err)
+			return
+		}
+
+		delay := policy.Backoff(attempt)
+		log.Warn("job callback failed, retrying", "attempt", attempt, "backoff", delay.String(), "error", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+}
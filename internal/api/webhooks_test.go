@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.7", false},
+		{"169.254.169.254", false}, // Cloud metadata
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:93.184.216.34", true},
+		{"64:ff9b::a00:1", false},
+		{"2001:db8::1", false},
+	}
+	for _, tt := range tests {
+		if got := publicAddress(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("publicAddress(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{url: "https://hooks.example.com/done", wantErr: false},
+		{url: "http://93.184.216.34:8080/done", wantErr: false},
+		{url: "http://localhost/done", wantErr: true},
+		{url: "http://LOCALHOST./done", wantErr: true},
+		{url: "http://api.localhost/done", wantErr: true},
+		{url: "http://127.0.0.1:6379/", wantErr: true},
+		{url: "http://10.0.0.5/done", wantErr: true},
+		{url: "http://[::1]/done", wantErr: true},
+		{url: "http://[::ffff:10.0.0.5]/done", wantErr: true},
+		{url: "http://169.254.169.254/latest/meta-data/", wantErr: true},
+		{url: "ftp://hooks.example.com/done", wantErr: true},
+		{url: "/done", wantErr: true},
+		{url: "https:///done", wantErr: true},
+		{url: "http://localhost/done", allowPrivate: true, wantErr: false},
+		{url: "http://10.0.0.5/done", allowPrivate: true, wantErr: false},
+		{url: "ftp://10.0.0.5/done", allowPrivate: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) { c.WebhookAllowPrivate = tt.allowPrivate })
+		if err := s.validateCallbackURL(tt.url); (err != nil) != tt.wantErr {
+			t.Errorf("validateCallbackURL(%q) = %v, want an error: %v (WEBHOOK_ALLOW_PRIVATE=%v)", tt.url, err, tt.wantErr, tt.allowPrivate)
+		}
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	tests := []struct {
+		name         string
+		allowPrivate bool
+		wantBlocked  bool
+	}{
+		{"refused", false, true},
+		{"allowed", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, &fakeProvider{}, func(c *Config) { c.WebhookAllowPrivate = tt.allowPrivate })
+			s.webhookClient = s.newWebhookClient()
+
+			// The receiver listens on loopback, as a name resolving to an
+			// internal address would
+			err := s.postWebhook(context.Background(), receiver.URL, "job-1", []byte("{}"))
+			var blocked blockedAddressError
+			if errors.As(err, &blocked) != tt.wantBlocked {
+				t.Errorf("postWebhook() error = %v, want blocked: %v", err, tt.wantBlocked)
+			}
+			if !tt.wantBlocked && err != nil {
+				t.Errorf("postWebhook() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestWebhookClientRefusesPrivateRedirects(t *testing.T) {
+	s, _ := newTestServer(t, &fakeProvider{}, nil)
+	s.webhookClient = s.newWebhookClient()
+	tests := []struct {
+		location string
+		wantErr  bool
+	}{
+		{"https://other.example.com/done", false},
+		{"http://127.0.0.1/admin", true},
+		{"http://169.254.169.254/latest/meta-data/", true},
+		{"http://localhost/", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.location, nil)
+		via := []*http.Request{httptest.NewRequest(http.MethodPost, "https://hooks.example.com/done", nil)}
+		if err := s.webhookClient.CheckRedirect(req, via); (err != nil) != tt.wantErr {
+			t.Errorf("CheckRedirect(%s) = %v, want an error: %v", tt.location, err, tt.wantErr)
+		}
+	}
+
+	// Redirects stop at the limit, wherever they lead
+	via := make([]*http.Request, maxWebhookRedirects)
+	req := httptest.NewRequest(http.MethodPost, "https://other.example.com/done", nil)
+	if err := s.webhookClient.CheckRedirect(req, via); err == nil {
+		t.Errorf("CheckRedirect() after %d redirects = nil, want an error", maxWebhookRedirects)
+	}
+}
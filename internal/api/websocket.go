@@ -0,0 +1,239 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsCloseGoingAway is the WebSocket close code of connections closed on
+// shutdown
+const wsCloseGoingAway = 1001
+
+// StreamMessage is a text fragment sent over /ws/translate. Its options
+// default to those of the connection's query.
+type StreamMessage struct {
+	ID string `json:"id,omitempty"` // Echoed back with its translation
+	TranslationRequest
+}
+
+// StreamResult is the translation of a StreamMessage, or why it failed
+type StreamResult struct {
+	ID string `json:"id,omitempty"`
+	*TranslationResponse
+	Error *ErrorResponse `json:"error,omitempty"`
+}
+
+// messageWriter collects the error response of a stream message, written
+// by the helpers writing those of HTTP requests
+type messageWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+// Header implements http.ResponseWriter
+func (w *messageWriter) Header() http.Header {
+	return w.header
+}
+
+// Write implements http.ResponseWriter
+func (w *messageWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+// WriteHeader implements http.ResponseWriter; the status is left out of
+// stream results, whose error codes tell failures apart
+func (w *messageWriter) WriteHeader(int) {}
+
+// errorResponse returns the error response written, if any
+func (w *messageWriter) errorResponse() *ErrorResponse {
+	if w.body.Len() == 0 {
+		return nil
+	}
+	var response ErrorResponse
+	if err := json.Unmarshal(w.body.Bytes(), &response); err != nil {
+		return &ErrorResponse{Code: codeInternalError, Message: "Failed to encode the error"}
+	}
+	return &response
+}
+
+// handleWebSocket translates the text fragments streamed over a WebSocket
+// connection one after the other, answering each with its translation on
+// the same connection, for live chat and captions. The connection is
+// authenticated once, on the upgrade; every fragment counts against the
+// rate limits and quota like a /translate request.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		writeError(w, http.StatusUpgradeRequired, codeInvalidRequest, "Invalid request: connect with a WebSocket client")
+		return
+	}
+	acceptQueryAPIKey(r)
+	ctx, ok := s.authenticateRequest(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized: Invalid API key")
+		logger(r.Context()).Warn("unauthorized request", "remote_addr", s.clientIP(r))
+		return
+	}
+	defaults := translationRequestFromQuery(r.URL.Query())
+	defaults.Text = ""
+
+	server := websocket.Server{
+		// Connections carry API keys rather than cookies, so they may come
+		// from any origin
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			s.serveWebSocket(ctx, ws, defaults)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// serveWebSocket answers the messages of a connection until the client
+// closes it, it is idle for WS_IDLE_TIMEOUT or the server shuts down
+func (s *Server) serveWebSocket(ctx context.Context, ws *websocket.Conn, defaults TranslationRequest) {
+	s.webSockets.Store(ws, struct{}{})
+	s.webSocketsOpen.Add(1)
+	wsConnections.Inc()
+	defer func() {
+		wsConnections.Dec()
+		s.webSocketsOpen.Done()
+		s.webSockets.Delete(ws)
+		ws.Close()
+	}()
+	ws.MaxPayloadBytes = int(s.config.MaxBodyBytes)
+	// Clear the deadlines of the HTTP request the connection started as
+	ws.SetWriteDeadline(time.Time{})
+
+	requestID := ""
+	if info := getRequestInfo(ctx); info != nil {
+		requestID = info.ID
+	}
+	messages := 0
+	for {
+		// Set before checking for shutdown, so closeWebSockets' deadline
+		// can't be overwritten
+		ws.SetReadDeadline(time.Now().Add(s.config.WSIdleTimeout))
+		if s.closingWebSockets.Load() {
+			ws.WriteClose(wsCloseGoingAway)
+			break
+		}
+		var data []byte
+		err := websocket.Message.Receive(ws, &data)
+		if errors.Is(err, websocket.ErrFrameTooLarge) {
+			s.sendStreamResult(ctx, ws, StreamResult{Error: &ErrorResponse{
+				Code:      codePayloadTooLarge,
+				Message:   fmt.Sprintf("Message exceeds %d bytes", s.config.MaxBodyBytes),
+				RequestID: requestID,
+			}})
+			continue
+		}
+		if err != nil {
+			if s.closingWebSockets.Load() {
+				ws.WriteClose(wsCloseGoingAway)
+			}
+			break
+		}
+		messages++
+		result := s.translateStreamMessage(ctx, data, defaults, requestID)
+		if !s.sendStreamResult(ctx, ws, result) {
+			break
+		}
+	}
+	logger(ctx).Info("websocket closed", "messages", messages)
+}
+
+// translateStreamMessage translates a message of a connection. Messages
+// are StreamMessage objects; other messages are texts translated with the
+// connection's options.
+func (s *Server) translateStreamMessage(ctx context.Context, data []byte, defaults TranslationRequest, requestID string) StreamResult {
+	msg := StreamMessage{TranslationRequest: defaults}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &msg); err != nil {
+			wsMessages.WithLabelValues("failed").Inc()
+			return StreamResult{Error: &ErrorResponse{Code: codeInvalidRequest, Message: fmt.Sprintf("Invalid request: %v", err), RequestID: requestID}}
+		}
+	} else {
+		msg.Text = string(data)
+	}
+
+	w := &messageWriter{header: http.Header{}}
+	w.header.Set(requestIDHeader, requestID)
+	response, ok := s.translateStreamRequest(ctx, w, msg.TranslationRequest)
+	if !ok {
+		wsMessages.WithLabelValues("failed").Inc()
+		return StreamResult{ID: msg.ID, Error: w.errorResponse()}
+	}
+	wsMessages.WithLabelValues("translated").Inc()
+	return StreamResult{ID: msg.ID, TranslationResponse: response}
+}
+
+// translateStreamRequest validates, authorizes and translates the request
+// of a stream message like handleTranslation, writing the error response
+// to w and returning false on failure
+func (s *Server) translateStreamRequest(ctx context.Context, w http.ResponseWriter, req TranslationRequest) (*TranslationResponse, bool) {
+	ctx, release := s.withQuotaReservation(ctx)
+	defer release()
+	if err := validateTranslationRequest(&req); err != nil {
+		writeError(w, http.StatusBadRequest, invalidRequestCode(err), fmt.Sprintf("Invalid request: %v", err))
+		return nil, false
+	}
+	if !s.authorizeTranslation(ctx, w, utf8.RuneCountInString(req.Text)) {
+		return nil, false
+	}
+	response, err := s.translateText(ctx, req)
+	if err != nil {
+		s.writeTranslationError(ctx, w, err)
+		return nil, false
+	}
+	s.recordQuotaUsage(ctx, response.Characters)
+	s.recordUsage(ctx, apiKeyName(ctx), response)
+	return response, true
+}
+
+// sendStreamResult sends a result over a connection, returning false when
+// the connection failed
+func (s *Server) sendStreamResult(ctx context.Context, ws *websocket.Conn, result StreamResult) bool {
+	var deadline time.Time
+	if s.config.WriteTimeout > 0 {
+		deadline = time.Now().Add(s.config.WriteTimeout)
+	}
+	ws.SetWriteDeadline(deadline)
+	if err := websocket.JSON.Send(ws, result); err != nil {
+		logger(ctx).Warn("failed to send a websocket message", "error", err)
+		return false
+	}
+	return true
+}
+
+// closeWebSockets closes the WebSocket connections once the message each is
+// translating is answered, waiting for them until ctx expires
+func (s *Server) closeWebSockets(ctx context.Context) {
+	s.closingWebSockets.Store(true)
+	s.webSockets.Range(func(key, _ any) bool {
+		// Wakes connections waiting for a message
+		key.(*websocket.Conn).SetReadDeadline(time.Now())
+		return true
+	})
+	done := make(chan struct{})
+	go func() {
+		s.webSocketsOpen.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
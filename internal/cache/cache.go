@@ -0,0 +1,254 @@
+// Package cache implements the backends translations are cached in
+package cache
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// cacheScanCount is the SCAN batch size hint used when deleting by pattern
+const cacheScanCount = 1000
+
+var (
+	// ErrMiss is returned by Cache.Get when the key is not cached
+	ErrMiss = errors.New("cache miss")
+	// ErrUnavailable is returned while the cache backend is unreachable
+	ErrUnavailable = errors.New("cache unavailable")
+)
+
+// Cache is implemented by translation cache backends
+type Cache interface {
+	// Get returns the value of key, or ErrMiss
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value under key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Del deletes keys and returns how many existed
+	Del(ctx context.Context, keys ...string) (int64, error)
+	// MGet returns the values of keys, with nil for misses
+	MGet(ctx context.Context, keys ...string) ([][]byte, error)
+}
+
+// PatternDeleter is implemented by caches that can delete every key matching
+// a Redis-style glob pattern
+type PatternDeleter interface {
+	DeletePattern(ctx context.Context, pattern string) (int64, error)
+}
+
+// Redis stores translations in Redis. It reports ErrUnavailable
+// while the service is in degraded mode.
+type Redis struct {
+	client    *redis.Client
+	available func() bool
+}
+
+// NewRedis creates a cache stored with client, which is used only while
+// available reports true
+func NewRedis(client *redis.Client, available func() bool) *Redis {
+	return &Redis{client: client, available: available}
+}
+
+// Get implements Cache
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	if !c.available() {
+		return nil, ErrUnavailable
+	}
+	value, err := c.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+	return value, err
+}
+
+// Set implements Cache
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if !c.available() {
+		return ErrUnavailable
+	}
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+// Del implements Cache
+func (c *Redis) Del(ctx context.Context, keys ...string) (int64, error) {
+	if !c.available() {
+		return 0, ErrUnavailable
+	}
+	return c.client.Del(ctx, keys...).Result()
+}
+
+// MGet implements Cache
+func (c *Redis) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	if !c.available() {
+		return nil, ErrUnavailable
+	}
+	values, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]byte, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			result[i] = []byte(s)
+		}
+	}
+	return result, nil
+}
+
+// DeletePattern implements PatternDeleter, scanning in batches so Redis is
+// never blocked by a KEYS call
+func (c *Redis) DeletePattern(ctx context.Context, pattern string) (int64, error) {
+	if !c.available() {
+		return 0, ErrUnavailable
+	}
+	var deleted int64
+	var cursor uint64
+	for {
+		keys, next, err := c.client.Scan(ctx, cursor, pattern, cacheScanCount).Result()
+		if err != nil {
+			return deleted, err
+		}
+		if len(keys) > 0 {
+			n, err := c.client.Unlink(ctx, keys...).Result()
+			if err != nil {
+				return deleted, err
+			}
+			deleted += n
+		}
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
+}
+
+// memoryItem is a value held by Memory
+type memoryItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// Memory is an in-process cache bounded to maxEntries. When full,
+// expired entries are dropped first, then arbitrary ones.
+type Memory struct {
+	mu         sync.Mutex
+	items      map[string]memoryItem
+	maxEntries int
+}
+
+// NewMemory creates an in-process cache holding up to maxEntries
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{items: make(map[string]memoryItem), maxEntries: maxEntries}
+}
+
+// get returns the live item under key; the caller holds c.mu
+func (c *Memory) get(key string, now time.Time) ([]byte, bool) {
+	item, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(item.expiresAt) {
+		delete(c.items, key)
+		return nil, false
+	}
+	return item.value, true
+}
+
+// Get implements Cache
+func (c *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value, ok := c.get(key, time.Now()); ok {
+		return value, nil
+	}
+	return nil, ErrMiss
+}
+
+// Set implements Cache
+func (c *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, exists := c.items[key]; !exists && len(c.items) >= c.maxEntries {
+		for k, item := range c.items {
+			if now.After(item.expiresAt) {
+				delete(c.items, k)
+			}
+		}
+		for k := range c.items {
+			if len(c.items) < c.maxEntries {
+				break
+			}
+			delete(c.items, k)
+		}
+	}
+	c.items[key] = memoryItem{value: value, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// Del implements Cache
+func (c *Memory) Del(ctx context.Context, keys ...string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var deleted int64
+	for _, key := range keys {
+		if _, ok := c.items[key]; ok {
+			delete(c.items, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// MGet implements Cache
+func (c *Memory) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i], _ = c.get(key, now)
+	}
+	return values, nil
+}
+
+// DeletePattern implements PatternDeleter
+func (c *Memory) DeletePattern(ctx context.Context, pattern string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var deleted int64
+	for key := range c.items {
+		if ok, _ := path.Match(pattern, key); ok {
+			delete(c.items, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Noop caches nothing
+type Noop struct{}
+
+// Get implements Cache
+func (Noop) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, ErrMiss
+}
+
+// Set implements Cache
+func (Noop) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return nil
+}
+
+// Del implements Cache
+func (Noop) Del(ctx context.Context, keys ...string) (int64, error) {
+	return 0, nil
+}
+
+// MGet implements Cache
+func (Noop) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	return make([][]byte, len(keys)), nil
+}
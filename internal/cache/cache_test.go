@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// patternCache is a Cache deleting by pattern, as every backend but Noop is
+type patternCache interface {
+	Cache
+	PatternDeleter
+}
+
+// testCaches are the backends the cache tests run against, by name. Each
+// returns a new cache and the function ageing its entries by a duration.
+var testCaches = map[string]func(t *testing.T) (patternCache, func(time.Duration)){
+	"memory": func(t *testing.T) (patternCache, func(time.Duration)) {
+		c := NewMemory(100)
+		return c, func(d time.Duration) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			for key, item := range c.items {
+				item.expiresAt = item.expiresAt.Add(-d)
+				c.items[key] = item
+			}
+		}
+	},
+	"redis": func(t *testing.T) (patternCache, func(time.Duration)) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return NewRedis(client, func() bool { return true }), mr.FastForward
+	},
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	for name, newCache := range testCaches {
+		t.Run(name, func(t *testing.T) {
+			c, age := newCache(t)
+			if _, err := c.Get(ctx, "missing"); err != ErrMiss {
+				t.Errorf("Get() of a missing key: error = %v, want ErrMiss", err)
+			}
+
+			if err := c.Set(ctx, "translate:de:a", []byte("Hallo"), time.Minute); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := c.Set(ctx, "translate:fr:a", []byte("Bonjour"), time.Hour); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if value, err := c.Get(ctx, "translate:de:a"); err != nil || string(value) != "Hallo" {
+				t.Errorf("Get() = %q, %v, want Hallo", value, err)
+			}
+
+			values, err := c.MGet(ctx, "translate:de:a", "missing", "translate:fr:a")
+			if err != nil {
+				t.Fatalf("MGet() error = %v", err)
+			}
+			if len(values) != 3 || string(values[0]) != "Hallo" || values[1] != nil || string(values[2]) != "Bonjour" {
+				t.Errorf("MGet() = %q, want Hallo, nil and Bonjour", values)
+			}
+
+			age(2 * time.Minute)
+			if _, err := c.Get(ctx, "translate:de:a"); err != ErrMiss {
+				t.Errorf("Get() of an expired key: error = %v, want ErrMiss", err)
+			}
+			if _, err := c.Get(ctx, "translate:fr:a"); err != nil {
+				t.Errorf("Get() of a live key: error = %v", err)
+			}
+
+			if n, err := c.Del(ctx, "translate:fr:a", "missing"); err != nil || n != 1 {
+				t.Errorf("Del() = %d, %v, want 1", n, err)
+			}
+			if _, err := c.Get(ctx, "translate:fr:a"); err != ErrMiss {
+				t.Errorf("Get() of a deleted key: error = %v, want ErrMiss", err)
+			}
+		})
+	}
+}
+
+func TestCacheDeletePattern(t *testing.T) {
+	ctx := context.Background()
+	for name, newCache := range testCaches {
+		t.Run(name, func(t *testing.T) {
+			c, _ := newCache(t)
+			for _, key := range []string{"translate:de:a", "translate:de:b", "translate:fr:a"} {
+				c.Set(ctx, key, []byte(key), time.Hour)
+			}
+			if n, err := c.DeletePattern(ctx, "translate:de:*"); err != nil || n != 2 {
+				t.Errorf("DeletePattern() = %d, %v, want 2", n, err)
+			}
+			values, _ := c.MGet(ctx, "translate:de:a", "translate:de:b", "translate:fr:a")
+			if values[0] != nil || values[1] != nil || values[2] == nil {
+				t.Errorf("MGet() after DeletePattern() = %q, want only translate:fr:a", values)
+			}
+		})
+	}
+}
+
+func TestMemoryBound(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(3)
+	c.Set(ctx, "expired", []byte("x"), -time.Second)
+	for i := 0; i < 5; i++ {
+		c.Set(ctx, fmt.Sprint(i), []byte("x"), time.Hour)
+	}
+	if len(c.items) != 3 {
+		t.Errorf("%d entries, want at most 3", len(c.items))
+	}
+	if _, ok := c.items["4"]; !ok {
+		t.Error("the last entry set was evicted")
+	}
+	if _, ok := c.items["expired"]; ok {
+		t.Error("the expired entry was kept")
+	}
+
+	// Replacing an entry of a full cache evicts nothing
+	c.Set(ctx, "4", []byte("y"), time.Hour)
+	if len(c.items) != 3 {
+		t.Errorf("%d entries after replacing one, want 3", len(c.items))
+	}
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	c := NewRedis(client, func() bool { return false })
+	ctx := context.Background()
+
+	if _, err := c.Get(ctx, "a"); err != ErrUnavailable {
+		t.Errorf("Get() error = %v, want ErrUnavailable", err)
+	}
+	if err := c.Set(ctx, "a", []byte("b"), time.Minute); err != ErrUnavailable {
+		t.Errorf("Set() error = %v, want ErrUnavailable", err)
+	}
+	if _, err := c.MGet(ctx, "a"); err != ErrUnavailable {
+		t.Errorf("MGet() error = %v, want ErrUnavailable", err)
+	}
+	if len(mr.Keys()) != 0 {
+		t.Errorf("keys = %q, want none", mr.Keys())
+	}
+}
+
+func TestNoop(t *testing.T) {
+	ctx := context.Background()
+	var c Cache = Noop{}
+	c.Set(ctx, "a", []byte("b"), time.Minute)
+	if _, err := c.Get(ctx, "a"); err != ErrMiss {
+		t.Errorf("Get() error = %v, want ErrMiss", err)
+	}
+	if values, _ := c.MGet(ctx, "a", "b"); len(values) != 2 || values[0] != nil {
+		t.Errorf("MGet() = %q, want two misses", values)
+	}
+}
@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/translate"
+	"github.com/aws/aws-sdk-go-v2/service/translate/types"
+)
+
+// awsAutoDetect is the source language code that makes AWS Translate detect
+// the source language itself
+const awsAutoDetect = "auto"
+
+// AWS translates using Amazon Translate
+type AWS struct {
+	client        *translate.Client
+	terminologies []string
+	// Terminology names of request domains, used instead of terminologies
+	domainTerminologies map[string]string
+}
+
+// NewAWS creates an Amazon Translate client using the standard AWS
+// credential chain (environment, shared config, IAM role). An empty region
+// falls back to the region from the environment or shared config.
+func NewAWS(ctx context.Context, region string, terminologies []string, domainTerminologies map[string]string) (*AWS, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("no AWS region configured")
+	}
+
+	slog.Info("connected to AWS Translate", "region", cfg.Region)
+	return &AWS{
+		client:              translate.NewFromConfig(cfg),
+		terminologies:       terminologies,
+		domainTerminologies: domainTerminologies,
+	}, nil
+}
+
+// terminologiesOf returns the custom terminologies applied to req: its
+// domain's when it has one, otherwise those of every request
+func (p *AWS) terminologiesOf(req Request) []string {
+	if name, ok := p.domainTerminologies[req.Domain]; ok {
+		return []string{name}
+	}
+	return p.terminologies
+}
+
+// Name implements Provider
+func (p *AWS) Name() string {
+	return "aws"
+}
+
+// Translate implements Provider
+func (p *AWS) Translate(ctx context.Context, req Request) (*Result, error) {
+	sourceLang := req.SourceLang
+	if sourceLang == "" {
+		sourceLang = awsAutoDetect
+	}
+
+	if req.Format == FormatHTML {
+		return p.translateHTML(ctx, req, sourceLang)
+	}
+
+	out, err := p.client.TranslateText(ctx, &translate.TranslateTextInput{
+		Text:               aws.String(req.Text),
+		SourceLanguageCode: aws.String(sourceLang),
+		TargetLanguageCode: aws.String(req.TargetLang),
+		TerminologyNames:   p.terminologiesOf(req),
+		Settings:           awsSettings(req),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("translation API error: %w", err)
+	}
+
+	return &Result{
+		TranslatedText: aws.ToString(out.TranslatedText),
+		SourceLang:     aws.ToString(out.SourceLanguageCode),
+	}, nil
+}
+
+// translateHTML translates markup with TranslateDocument, as TranslateText
+// only handles plain text. AWS requires English on one side and limits
+// documents to 100 KB.
+func (p *AWS) translateHTML(ctx context.Context, req Request, sourceLang string) (*Result, error) {
+	out, err := p.client.TranslateDocument(ctx, &translate.TranslateDocumentInput{
+		Document: &types.Document{
+			Content:     []byte(req.Text),
+			ContentType: aws.String("text/html"),
+		},
+		SourceLanguageCode: aws.String(sourceLang),
+		TargetLanguageCode: aws.String(req.TargetLang),
+		TerminologyNames:   p.terminologiesOf(req),
+		Settings:           awsSettings(req),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("translation API error: %w", err)
+	}
+	if out.TranslatedDocument == nil {
+		return nil, fmt.Errorf("no translation returned")
+	}
+
+	return &Result{
+		TranslatedText: string(out.TranslatedDocument.Content),
+		SourceLang:     aws.ToString(out.SourceLanguageCode),
+	}, nil
+}
+
+// awsSettings returns the translation settings of req, or nil for the
+// defaults. Amazon Translate supports formality for some target languages
+// only.
+func awsSettings(req Request) *types.TranslationSettings {
+	switch req.Formality {
+	case FormalityFormal:
+		return &types.TranslationSettings{Formality: types.FormalityFormal}
+	case FormalityInformal:
+		return &types.TranslationSettings{Formality: types.FormalityInformal}
+	}
+	return nil
+}
+
+// MaxTextBytes implements TextLimiter. Amazon Translate accepts up to
+// 10,000 bytes per request.
+func (p *AWS) MaxTextBytes() int {
+	return 10000
+}
+
+// Detect implements Provider. Amazon Translate has no standalone detection
+// call, so the text is translated with automatic source detection and the
+// detected source reported; no confidence is available.
+func (p *AWS) Detect(ctx context.Context, text string) (*Detection, error) {
+	out, err := p.client.TranslateText(ctx, &translate.TranslateTextInput{
+		Text:               aws.String(text),
+		SourceLanguageCode: aws.String(awsAutoDetect),
+		TargetLanguageCode: aws.String("en"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("detection API error: %w", err)
+	}
+	return &Detection{Language: aws.ToString(out.SourceLanguageCode)}, nil
+}
+
+// Languages implements Provider. AWS only localizes language names into a
+// handful of display languages; other targets fall back to English names.
+func (p *AWS) Languages(ctx context.Context, target string) ([]Language, error) {
+	display := types.DisplayLanguageCodeEn
+	for _, code := range display.Values() {
+		if string(code) == target {
+			display = code
+		}
+	}
+
+	var languages []Language
+	input := &translate.ListLanguagesInput{DisplayLanguageCode: display}
+	paginator := translate.NewListLanguagesPaginator(p.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("languages API error: %w", err)
+		}
+		for _, l := range page.Languages {
+			languages = append(languages, Language{
+				Code: aws.ToString(l.LanguageCode),
+				Name: aws.ToString(l.LanguageName),
+			})
+		}
+	}
+	return languages, nil
+}
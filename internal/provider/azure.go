@@ -0,0 +1,194 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// azureAPIVersion is the Microsoft Translator Text API version used
+const azureAPIVersion = "3.0"
+
+// Azure translates using Microsoft Translator (Azure Cognitive Services)
+type Azure struct {
+	endpoint   string
+	key        string
+	region     string
+	httpClient *http.Client
+}
+
+// azureText is the request body element for translate and detect calls
+type azureText struct {
+	Text string `json:"Text"`
+}
+
+// azureError is the error payload returned by the Translator API
+type azureError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewAzure creates a Microsoft Translator client for the given
+// subscription key and resource region
+func NewAzure(endpoint, key, region string) (*Azure, error) {
+	if key == "" {
+		return nil, fmt.Errorf("AZURE_TRANSLATOR_KEY is required for the azure provider")
+	}
+	return &Azure{
+		endpoint:   endpoint,
+		key:        key,
+		region:     region,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Name implements Provider
+func (p *Azure) Name() string {
+	return "azure"
+}
+
+// Translate implements Provider
+func (p *Azure) Translate(ctx context.Context, req Request) (*Result, error) {
+	query := url.Values{"to": {req.TargetLang}}
+	if req.SourceLang != "" {
+		query.Set("from", req.SourceLang)
+	}
+	if req.Format == FormatHTML {
+		query.Set("textType", "html")
+	}
+
+	var results []struct {
+		DetectedLanguage *struct {
+			Language string  `json:"language"`
+			Score    float64 `json:"score"`
+		} `json:"detectedLanguage"`
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/translate", query, nil, []azureText{{Text: req.Text}}, &results); err != nil {
+		return nil, fmt.Errorf("translation API error: %w", err)
+	}
+	if len(results) == 0 || len(results[0].Translations) == 0 {
+		return nil, fmt.Errorf("no translation returned")
+	}
+
+	result := &Result{
+		TranslatedText: results[0].Translations[0].Text,
+		SourceLang:     req.SourceLang,
+	}
+	if req.SourceLang == "" && results[0].DetectedLanguage != nil {
+		result.SourceLang = results[0].DetectedLanguage.Language
+		result.Confidence = results[0].DetectedLanguage.Score
+	}
+	return result, nil
+}
+
+// MaxTextBytes implements TextLimiter. Microsoft Translator accepts up
+// to 50,000 characters per request.
+func (p *Azure) MaxTextBytes() int {
+	return 50000
+}
+
+// Detect implements Provider
+func (p *Azure) Detect(ctx context.Context, text string) (*Detection, error) {
+	type azureDetection struct {
+		Language string  `json:"language"`
+		Score    float64 `json:"score"`
+	}
+	var results []struct {
+		azureDetection
+		Alternatives []azureDetection `json:"alternatives"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/detect", nil, nil, []azureText{{Text: text}}, &results); err != nil {
+		return nil, fmt.Errorf("detection API error: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no detection returned")
+	}
+	candidates := []Detection{{Language: results[0].Language, Confidence: results[0].Score}}
+	for _, a := range results[0].Alternatives {
+		candidates = append(candidates, Detection{Language: a.Language, Confidence: a.Score})
+	}
+	return bestDetection(candidates), nil
+}
+
+// Languages implements Provider
+func (p *Azure) Languages(ctx context.Context, target string) ([]Language, error) {
+	query := url.Values{"scope": {"translation"}}
+	var result struct {
+		Translation map[string]struct {
+			Name string `json:"name"`
+		} `json:"translation"`
+	}
+	// The display language for names is selected with Accept-Language
+	header := http.Header{}
+	if target != "" {
+		header.Set("Accept-Language", target)
+	}
+	if err := p.do(ctx, http.MethodGet, "/languages", query, header, nil, &result); err != nil {
+		return nil, fmt.Errorf("languages API error: %w", err)
+	}
+
+	languages := make([]Language, 0, len(result.Translation))
+	for code, l := range result.Translation {
+		languages = append(languages, Language{Code: code, Name: l.Name})
+	}
+	return languages, nil
+}
+
+// do performs a Translator API call with the extra headers, encoding body as
+// JSON when non-nil and decoding the response into out
+func (p *Azure) do(ctx context.Context, method, path string, query url.Values, header http.Header, body, out interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("api-version", azureAPIVersion)
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, p.endpoint+path+"?"+query.Encode(), reqBody)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Ocp-Apim-Subscription-Key", p.key)
+	if p.region != "" {
+		httpReq.Header.Set("Ocp-Apim-Subscription-Region", p.region)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range header {
+		httpReq.Header[name] = values
+	}
+
+	resp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr azureError
+		message := resp.Status
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err == nil && apiErr.Error.Message != "" {
+			message = fmt.Sprintf("%s (code %d)", apiErr.Error.Message, apiErr.Error.Code)
+		}
+		return &HTTPError{StatusCode: resp.StatusCode, Message: message}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
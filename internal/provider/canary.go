@@ -0,0 +1,114 @@
+package provider
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// providerStats counts the requests routed to a provider by Canary
+type providerStats struct {
+	requests  atomic.Int64
+	errors    atomic.Int64
+	latencyMs atomic.Int64 // Sum over all requests
+}
+
+// record adds one request with its latency and outcome
+func (s *providerStats) record(start time.Time, err error) {
+	s.requests.Add(1)
+	s.latencyMs.Add(time.Since(start).Milliseconds())
+	if err != nil {
+		s.errors.Add(1)
+	}
+}
+
+// Stats is the JSON view of a provider's canary statistics
+type Stats struct {
+	Role         string  `json:"role"` // "primary" or "canary"
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// Canary sends a percentage of translations to a canary provider and
+// the rest to the primary, so a new engine can be evaluated on live traffic.
+// Failed canary translations are retried on the primary.
+type Canary struct {
+	primary      Provider
+	canary       Provider
+	percent      func() float64 // Share of translations sent to the canary
+	primaryStats providerStats
+	canaryStats  providerStats
+}
+
+// NewCanary creates a provider sending percent() percent of the
+// translations to canary
+func NewCanary(primary, canary Provider, percent func() float64) *Canary {
+	return &Canary{primary: primary, canary: canary, percent: percent}
+}
+
+// Name implements Provider
+func (c *Canary) Name() string {
+	return c.primary.Name()
+}
+
+// Translate implements Provider
+func (c *Canary) Translate(ctx context.Context, req Request) (*Result, error) {
+	if rand.Float64()*100 < c.percent() {
+		start := time.Now()
+		result, err := c.canary.Translate(ctx, req)
+		c.canaryStats.record(start, err)
+		if err == nil {
+			if result.Provider == "" {
+				result.Provider = c.canary.Name()
+			}
+			return result, nil
+		}
+		slog.WarnContext(ctx, "canary provider failed, using primary", "provider", c.canary.Name(), "error", err)
+	}
+
+	start := time.Now()
+	result, err := c.primary.Translate(ctx, req)
+	c.primaryStats.record(start, err)
+	return result, err
+}
+
+// Detect implements Provider using the primary only
+func (c *Canary) Detect(ctx context.Context, text string) (*Detection, error) {
+	return c.primary.Detect(ctx, text)
+}
+
+// Languages implements Provider using the primary only
+func (c *Canary) Languages(ctx context.Context, target string) ([]Language, error) {
+	return c.primary.Languages(ctx, target)
+}
+
+// HealthCheck implements HealthChecker. Only the primary matters, since
+// canary failures fall back to it.
+func (c *Canary) HealthCheck(ctx context.Context) error {
+	if checker, ok := c.primary.(HealthChecker); ok {
+		return checker.HealthCheck(ctx)
+	}
+	return nil
+}
+
+// Stats returns the statistics of both providers keyed by provider name
+func (c *Canary) Stats() map[string]Stats {
+	view := func(role string, s *providerStats) Stats {
+		r := Stats{
+			Role:     role,
+			Requests: s.requests.Load(),
+			Errors:   s.errors.Load(),
+		}
+		if r.Requests > 0 {
+			r.AvgLatencyMs = float64(s.latencyMs.Load()) / float64(r.Requests)
+		}
+		return r
+	}
+	return map[string]Stats{
+		c.primary.Name(): view("primary", &c.primaryStats),
+		c.canary.Name():  view("canary", &c.canaryStats),
+	}
+}
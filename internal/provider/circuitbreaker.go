@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrCircuitOpen is returned without calling the provider while its circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("provider circuit breaker is open")
+
+// circuitOpen reports 1 while a provider's circuit breaker is open
+var circuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "translation_provider_circuit_open",
+	Help: "Whether a provider's circuit breaker is open (1) or closed (0).",
+}, []string{"provider"})
+
+// circuitState is the state of a circuit breaker
+type circuitState int
+
+const (
+	circuitClosed   circuitState = iota // Calls pass through
+	circuitOpened                       // Calls fail fast until the cooldown ends
+	circuitHalfOpen                     // One trial call decides whether to close
+)
+
+// CircuitBreaker stops calling a provider after Threshold consecutive
+// failures. Once Cooldown has passed a single trial call is let through,
+// closing the circuit on success and reopening it on failure.
+type CircuitBreaker struct {
+	Wrapper
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker wraps p in a circuit breaker
+func NewCircuitBreaker(p Provider, threshold int, cooldown time.Duration) *CircuitBreaker {
+	circuitOpen.WithLabelValues(p.Name()).Set(0)
+	return &CircuitBreaker{
+		Wrapper:   Wrapper{p},
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a call may proceed, moving an open circuit to
+// half-open once the cooldown has passed
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpened:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// A trial call is already in flight
+		return false
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a call
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil || !countsAsFailure(ctx, err) {
+		if b.state != circuitClosed {
+			slog.InfoContext(ctx, "provider circuit breaker closed", "provider", b.Name())
+			circuitOpen.WithLabelValues(b.Name()).Set(0)
+		}
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		if b.state != circuitOpened {
+			slog.WarnContext(ctx, "provider circuit breaker opened", "provider", b.Name(), "failures", b.failures, "error", err)
+			circuitOpen.WithLabelValues(b.Name()).Set(1)
+		}
+		b.state = circuitOpened
+		b.openedAt = time.Now()
+	}
+}
+
+// countsAsFailure reports whether err indicates an unhealthy provider rather
+// than a bad request. Client errors other than timeouts and quota exhaustion
+// don't count, nor do calls cancelled by the caller.
+func countsAsFailure(ctx context.Context, err error) bool {
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return false
+	}
+
+	code := 0
+	var apiErr *googleapi.Error
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &apiErr) {
+		code = apiErr.Code
+	} else if errors.As(err, &statusErr) {
+		code = statusErr.HTTPStatusCode()
+	}
+	if code >= 400 && code < 500 {
+		return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// call runs fn through the breaker
+func (b *CircuitBreaker) call(ctx context.Context, fn func() error) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	b.record(ctx, err)
+	return err
+}
+
+// Translate implements Provider
+func (b *CircuitBreaker) Translate(ctx context.Context, req Request) (*Result, error) {
+	var result *Result
+	err := b.call(ctx, func() error {
+		var err error
+		result, err = b.Provider.Translate(ctx, req)
+		return err
+	})
+	return result, err
+}
+
+// Detect implements Provider
+func (b *CircuitBreaker) Detect(ctx context.Context, text string) (*Detection, error) {
+	var detection *Detection
+	err := b.call(ctx, func() error {
+		var err error
+		detection, err = b.Provider.Detect(ctx, text)
+		return err
+	})
+	return detection, err
+}
+
+// Languages implements Provider
+func (b *CircuitBreaker) Languages(ctx context.Context, target string) ([]Language, error) {
+	var languages []Language
+	err := b.call(ctx, func() error {
+		var err error
+		languages, err = b.Provider.Languages(ctx, target)
+		return err
+	})
+	return languages, err
+}
@@ -0,0 +1,143 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCountsAsFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &HTTPError{StatusCode: http.StatusInternalServerError}, true},
+		{"quota", &HTTPError{StatusCode: http.StatusTooManyRequests}, true},
+		{"request timeout", &HTTPError{StatusCode: http.StatusRequestTimeout}, true},
+		{"bad request", &HTTPError{StatusCode: http.StatusBadRequest}, false},
+		{"network", errors.New("connection refused"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countsAsFailure(context.Background(), tt.err); got != tt.want {
+				t.Errorf("countsAsFailure(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if countsAsFailure(ctx, context.Canceled) {
+		t.Error("calls cancelled by the caller count as failures")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := &HTTPError{StatusCode: http.StatusBadGateway, Message: "bad gateway"}
+	invalid := &HTTPError{StatusCode: http.StatusBadRequest, Message: "invalid"}
+	tests := []struct {
+		name string
+		errs []error
+		// want is the error of each call in turn
+		want      []error
+		wantCalls int
+	}{
+		{
+			name:      "opens after the threshold",
+			errs:      []error{failure, failure, failure},
+			want:      []error{failure, failure, failure, ErrCircuitOpen, ErrCircuitOpen},
+			wantCalls: 3,
+		},
+		{
+			name:      "a success resets the failures",
+			errs:      []error{failure, failure, nil, failure, failure},
+			want:      []error{failure, failure, nil, failure, failure, nil},
+			wantCalls: 6,
+		},
+		{
+			name:      "client errors don't count",
+			errs:      []error{invalid, invalid, invalid, invalid},
+			want:      []error{invalid, invalid, invalid, invalid, nil},
+			wantCalls: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &fakeProvider{name: "breaker-" + tt.name, errs: append([]error(nil), tt.errs...)}
+			b := NewCircuitBreaker(inner, 3, time.Hour)
+			for i, want := range tt.want {
+				if _, err := b.Translate(context.Background(), Request{Text: "hello", TargetLang: "de"}); err != want {
+					t.Fatalf("call %d: error = %v, want %v", i+1, err, want)
+				}
+			}
+			if inner.Calls() != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", inner.Calls(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	failure := &HTTPError{StatusCode: http.StatusServiceUnavailable, Message: "unavailable"}
+	tests := []struct {
+		name      string
+		trial     error
+		wantAfter error
+	}{
+		{"a successful trial closes", nil, nil},
+		{"a failed trial reopens", failure, ErrCircuitOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &fakeProvider{name: "half-open", errs: []error{failure, tt.trial}}
+			b := NewCircuitBreaker(inner, 1, 20*time.Millisecond)
+			if _, err := b.Detect(context.Background(), "hello"); err != failure {
+				t.Fatalf("first call: error = %v, want %v", err, failure)
+			}
+			if _, err := b.Detect(context.Background(), "hello"); err != ErrCircuitOpen {
+				t.Fatalf("call while open: error = %v, want ErrCircuitOpen", err)
+			}
+
+			time.Sleep(30 * time.Millisecond)
+			if _, err := b.Detect(context.Background(), "hello"); err != tt.trial {
+				t.Fatalf("trial call: error = %v, want %v", err, tt.trial)
+			}
+			b.openedAt = time.Now() // The cooldown only ends once
+			if _, err := b.Detect(context.Background(), "hello"); err != tt.wantAfter {
+				t.Errorf("call after the trial: error = %v, want %v", err, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerOneTrialAtATime(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	inner := &fakeProvider{name: "one-trial", translate: func(ctx context.Context, req Request) (*Result, error) {
+		close(started)
+		<-release
+		return &Result{TranslatedText: req.Text}, nil
+	}}
+	b := NewCircuitBreaker(inner, 1, 0)
+	b.state, b.openedAt = circuitOpened, time.Now()
+
+	done := make(chan error)
+	go func() {
+		_, err := b.Translate(context.Background(), Request{Text: "trial"})
+		done <- err
+	}()
+	<-started
+	if _, err := b.Translate(context.Background(), Request{Text: "second"}); err != ErrCircuitOpen {
+		t.Errorf("call during the trial: error = %v, want ErrCircuitOpen", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("trial call: error = %v", err)
+	}
+	if b.state != circuitClosed {
+		t.Errorf("state after a successful trial = %v, want closed", b.state)
+	}
+}
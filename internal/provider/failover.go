@@ -0,0 +1,105 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Failover tries an ordered list of providers, falling back to the
+// next one whenever a provider fails
+type Failover struct {
+	providers []Provider
+}
+
+// NewFailover creates a provider trying providers in order
+func NewFailover(providers []Provider) *Failover {
+	return &Failover{providers: providers}
+}
+
+// Name implements Provider
+func (f *Failover) Name() string {
+	names := make([]string, len(f.providers))
+	for i, p := range f.providers {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Translate implements Provider, reporting the provider that succeeded
+func (f *Failover) Translate(ctx context.Context, req Request) (*Result, error) {
+	var errs []error
+	for _, p := range f.providers {
+		result, err := p.Translate(ctx, req)
+		if err == nil {
+			if result.Provider == "" {
+				result.Provider = p.Name()
+			}
+			return result, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		// The concurrency limit is shared, so the next provider would be
+		// saturated too
+		if ctx.Err() != nil || errors.Is(err, ErrSaturated) {
+			break
+		}
+		slog.WarnContext(ctx, "provider failed, trying next provider", "provider", p.Name(), "error", err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Detect implements Provider
+func (f *Failover) Detect(ctx context.Context, text string) (*Detection, error) {
+	var errs []error
+	for _, p := range f.providers {
+		detection, err := p.Detect(ctx, text)
+		if err == nil {
+			return detection, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		// The concurrency limit is shared, so the next provider would be
+		// saturated too
+		if ctx.Err() != nil || errors.Is(err, ErrSaturated) {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Languages implements Provider
+func (f *Failover) Languages(ctx context.Context, target string) ([]Language, error) {
+	var errs []error
+	for _, p := range f.providers {
+		languages, err := p.Languages(ctx, target)
+		if err == nil {
+			return languages, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		// The concurrency limit is shared, so the next provider would be
+		// saturated too
+		if ctx.Err() != nil || errors.Is(err, ErrSaturated) {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// HealthCheck implements HealthChecker. The chain is healthy as long as one
+// provider is, and providers without health checks are assumed healthy.
+func (f *Failover) HealthCheck(ctx context.Context) error {
+	var errs []error
+	for _, p := range f.providers {
+		checker, ok := p.(HealthChecker)
+		if !ok {
+			return nil
+		}
+		err := checker.HealthCheck(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+	}
+	return errors.Join(errs...)
+}
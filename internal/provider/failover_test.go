@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFailover(t *testing.T) {
+	failure := errors.New("unavailable")
+	tests := []struct {
+		name         string
+		errs         [][]error // Of each provider
+		wantProvider string
+		wantErr      []error // Each wrapped by the returned error
+		wantCalls    []int
+	}{
+		{"the first succeeds", [][]error{nil, nil}, "first", nil, []int{1, 0}},
+		{"falls back", [][]error{{failure}, nil}, "second", nil, []int{1, 1}},
+		{"all fail", [][]error{{failure}, {ErrCircuitOpen}}, "", []error{failure, ErrCircuitOpen}, []int{1, 1}},
+		{"stops when saturated", [][]error{{ErrSaturated}, nil}, "", []error{ErrSaturated}, []int{1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providers := []*fakeProvider{{name: "first", errs: tt.errs[0]}, {name: "second", errs: tt.errs[1]}}
+			f := NewFailover([]Provider{providers[0], providers[1]})
+			if got := f.Name(); got != "first,second" {
+				t.Errorf("Name() = %q", got)
+			}
+
+			result, err := f.Translate(context.Background(), Request{Text: "hello", TargetLang: "de"})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Translate() error = %v", err)
+				}
+				if result.Provider != tt.wantProvider {
+					t.Errorf("Provider = %q, want %q", result.Provider, tt.wantProvider)
+				}
+			}
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("Translate() error = %v, want it to wrap %v", err, want)
+				}
+			}
+			for i, p := range providers {
+				if p.Calls() != tt.wantCalls[i] {
+					t.Errorf("calls of %s = %d, want %d", p.Name(), p.Calls(), tt.wantCalls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCanary(t *testing.T) {
+	failure := errors.New("unavailable")
+	tests := []struct {
+		name         string
+		percent      float64
+		canaryErrs   []error
+		wantProvider string
+		wantStats    map[string]Stats
+	}{
+		{"none to the canary", 0, nil, "", map[string]Stats{
+			"primary": {Role: "primary", Requests: 1},
+			"canary":  {Role: "canary"},
+		}},
+		{"all to the canary", 100, nil, "canary", map[string]Stats{
+			"primary": {Role: "primary"},
+			"canary":  {Role: "canary", Requests: 1},
+		}},
+		{"failed canary falls back", 100, []error{failure}, "", map[string]Stats{
+			"primary": {Role: "primary", Requests: 1},
+			"canary":  {Role: "canary", Requests: 1, Errors: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &fakeProvider{name: "primary"}
+			canary := &fakeProvider{name: "canary", errs: tt.canaryErrs}
+			c := NewCanary(primary, canary, func() float64 { return tt.percent })
+
+			result, err := c.Translate(context.Background(), Request{Text: "hello", TargetLang: "de"})
+			if err != nil {
+				t.Fatalf("Translate() error = %v", err)
+			}
+			if result.Provider != tt.wantProvider {
+				t.Errorf("Provider = %q, want %q", result.Provider, tt.wantProvider)
+			}
+			stats := c.Stats()
+			for name, want := range tt.wantStats {
+				got := stats[name]
+				got.AvgLatencyMs = 0
+				if got != want {
+					t.Errorf("Stats()[%q] = %+v, want %+v", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"cloud.google.com/go/translate"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/text/language"
+	"google.golang.org/api/option"
+)
+
+// Google translates using the Google Cloud Translation v2 API
+type Google struct {
+	client *translate.Client
+}
+
+// GoogleScope is the OAuth scope of the Cloud Translation API
+const GoogleScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// GoogleCredentials loads credentials from GOOGLE_APPLICATION_CREDENTIALS_JSON
+// when set and falls back to the GOOGLE_APPLICATION_CREDENTIALS file or
+// other application default credentials otherwise
+func GoogleCredentials(ctx context.Context) (*google.Credentials, error) {
+	if credJSON := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS_JSON"); credJSON != "" {
+		// Try to parse JSON to verify its structure
+		var jsonMap map[string]interface{}
+		if err := json.Unmarshal([]byte(credJSON), &jsonMap); err != nil {
+			return nil, fmt.Errorf("invalid JSON format in credentials: %v", err)
+		}
+
+		creds, err := google.CredentialsFromJSON(ctx, []byte(credJSON), GoogleScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create credentials: %v", err)
+		}
+		slog.Info("using Google credentials", "credentials", "GOOGLE_APPLICATION_CREDENTIALS_JSON")
+		return creds, nil
+	}
+
+	// Fall back to GOOGLE_APPLICATION_CREDENTIALS file
+	creds, err := google.FindDefaultCredentials(ctx, GoogleScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find credentials: %v", err)
+	}
+	slog.Info("using Google credentials", "credentials", "file")
+	return creds, nil
+}
+
+// NewGoogle creates a Google Translate v2 client
+func NewGoogle(ctx context.Context, creds *google.Credentials) (*Google, error) {
+	client, err := translate.NewClient(ctx, option.WithCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create translate client: %v", err)
+	}
+	slog.Info("connected to Google Translate API", "version", "v2")
+	return &Google{client: client}, nil
+}
+
+// Name implements Provider
+func (p *Google) Name() string {
+	return "google"
+}
+
+// Translate implements Provider
+func (p *Google) Translate(ctx context.Context, req Request) (*Result, error) {
+	targetLang, err := language.Parse(req.TargetLang)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target language: %v", err)
+	}
+
+	opts := &translate.Options{
+		Format: translate.Text,
+	}
+	if req.Format == FormatHTML {
+		opts.Format = translate.HTML
+	}
+	if req.SourceLang != "" {
+		// Source language is specified
+		sourceLang, err := language.Parse(req.SourceLang)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source language: %v", err)
+		}
+		opts.Source = sourceLang
+	}
+
+	translations, err := p.client.Translate(ctx, []string{req.Text}, targetLang, opts)
+	if err != nil {
+		return nil, fmt.Errorf("translation API error: %w", err)
+	}
+	if len(translations) == 0 {
+		return nil, fmt.Errorf("no translation returned")
+	}
+
+	result := &Result{
+		TranslatedText: translations[0].Text,
+		SourceLang:     req.SourceLang,
+	}
+	if req.SourceLang == "" {
+		// Auto-detected source language
+		result.SourceLang = translations[0].Source.String()
+	}
+	return result, nil
+}
+
+// Close implements io.Closer
+func (p *Google) Close() error {
+	return p.client.Close()
+}
+
+// MaxTextBytes implements TextLimiter. Google accepts up to 30,000 code
+// points per request.
+func (p *Google) MaxTextBytes() int {
+	return 30000
+}
+
+// Detect implements Provider
+func (p *Google) Detect(ctx context.Context, text string) (*Detection, error) {
+	detections, err := p.client.DetectLanguage(ctx, []string{text})
+	if err != nil {
+		return nil, fmt.Errorf("detection API error: %w", err)
+	}
+	if len(detections) == 0 || len(detections[0]) == 0 {
+		return nil, fmt.Errorf("no detection returned")
+	}
+
+	candidates := make([]Detection, 0, len(detections[0]))
+	for _, d := range detections[0] {
+		candidates = append(candidates, Detection{Language: d.Language.String(), Confidence: d.Confidence})
+	}
+	return bestDetection(candidates), nil
+}
+
+// Languages implements Provider
+func (p *Google) Languages(ctx context.Context, target string) ([]Language, error) {
+	targetLang := language.English
+	if target != "" {
+		var err error
+		targetLang, err = language.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target language: %v", err)
+		}
+	}
+
+	supported, err := p.client.SupportedLanguages(ctx, targetLang)
+	if err != nil {
+		return nil, fmt.Errorf("languages API error: %w", err)
+	}
+
+	languages := make([]Language, 0, len(supported))
+	for _, l := range supported {
+		languages = append(languages, Language{Code: l.Tag.String(), Name: l.Name})
+	}
+	return languages, nil
+}
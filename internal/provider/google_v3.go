@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	translatev3 "cloud.google.com/go/translate/apiv3"
+	"cloud.google.com/go/translate/apiv3/translatepb"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+)
+
+// GoogleV3 translates using the Google Cloud Translation v3
+// (Advanced) API, which adds regional endpoints, native glossaries and
+// custom AutoML models. It reports itself as "google" like the v2 provider.
+type GoogleV3 struct {
+	client   *translatev3.TranslationClient
+	parent   string // projects/<project>/locations/<location>
+	model    string // Full model resource name, empty for the default model
+	glossary string // Full glossary resource name, empty for none
+	// Full model resource names of request domains, used instead of model
+	domainModels map[string]string
+}
+
+// NewGoogleV3 creates a Google Translate v3 client. The project
+// defaults to the one of the credentials; model, glossary and the models of
+// domainModels may be given as IDs within the project and location or as
+// full resource names.
+func NewGoogleV3(ctx context.Context, creds *google.Credentials, project, location, model, glossary string, domainModels map[string]string) (*GoogleV3, error) {
+	if project == "" {
+		project = creds.ProjectID
+	}
+	if project == "" {
+		return nil, fmt.Errorf("GOOGLE_PROJECT_ID is required for the v3 API")
+	}
+
+	// The REST transport reports googleapi errors, which retries and the
+	// circuit breaker already classify
+	client, err := translatev3.NewTranslationRESTClient(ctx, option.WithCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create translate client: %v", err)
+	}
+
+	parent := fmt.Sprintf("projects/%s/locations/%s", project, location)
+	slog.Info("connected to Google Translate API", "version", "v3", "parent", parent)
+	models := make(map[string]string, len(domainModels))
+	for domain, id := range domainModels {
+		models[domain] = googleResourceName(parent, "models", id)
+	}
+	return &GoogleV3{
+		client:       client,
+		parent:       parent,
+		model:        googleResourceName(parent, "models", model),
+		glossary:     googleResourceName(parent, "glossaries", glossary),
+		domainModels: models,
+	}, nil
+}
+
+// googleResourceName expands an ID to a resource name under parent, leaving
+// full resource names as they are
+func googleResourceName(parent, collection, id string) string {
+	if id == "" || strings.HasPrefix(id, "projects/") {
+		return id
+	}
+	return parent + "/" + collection + "/" + id
+}
+
+// googleMimeType returns the v3 MIME type of a request format
+func googleMimeType(format string) string {
+	if format == FormatHTML {
+		return "text/html"
+	}
+	return "text/plain"
+}
+
+// Name implements Provider
+func (p *GoogleV3) Name() string {
+	return "google"
+}
+
+// Translate implements Provider. The native glossary needs a known source
+// language, so it is only applied when the request has one. Requests of a
+// domain with a model of its own are translated with that model.
+func (p *GoogleV3) Translate(ctx context.Context, req Request) (*Result, error) {
+	model, ok := p.domainModels[req.Domain]
+	if !ok {
+		model = p.model
+	}
+	in := &translatepb.TranslateTextRequest{
+		Parent:             p.parent,
+		Contents:           []string{req.Text},
+		MimeType:           googleMimeType(req.Format),
+		SourceLanguageCode: req.SourceLang,
+		TargetLanguageCode: req.TargetLang,
+		Model:              model,
+	}
+	if p.glossary != "" && req.SourceLang != "" {
+		in.GlossaryConfig = &translatepb.TranslateTextGlossaryConfig{Glossary: p.glossary}
+	}
+
+	out, err := p.client.TranslateText(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("translation API error: %w", err)
+	}
+	translations := out.GetGlossaryTranslations()
+	if len(translations) == 0 {
+		translations = out.GetTranslations()
+	}
+	if len(translations) == 0 {
+		return nil, fmt.Errorf("no translation returned")
+	}
+
+	result := &Result{
+		TranslatedText: translations[0].GetTranslatedText(),
+		SourceLang:     req.SourceLang,
+	}
+	if req.SourceLang == "" {
+		// Auto-detected source language
+		result.SourceLang = translations[0].GetDetectedLanguageCode()
+	}
+	return result, nil
+}
+
+// Close implements io.Closer
+func (p *GoogleV3) Close() error {
+	return p.client.Close()
+}
+
+// MaxTextBytes implements TextLimiter. Google accepts up to 30,720 code
+// points per request.
+func (p *GoogleV3) MaxTextBytes() int {
+	return 30000
+}
+
+// Detect implements Provider
+func (p *GoogleV3) Detect(ctx context.Context, text string) (*Detection, error) {
+	out, err := p.client.DetectLanguage(ctx, &translatepb.DetectLanguageRequest{
+		Parent:   p.parent,
+		Source:   &translatepb.DetectLanguageRequest_Content{Content: text},
+		MimeType: "text/plain",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("detection API error: %w", err)
+	}
+	detections := out.GetLanguages()
+	if len(detections) == 0 {
+		return nil, fmt.Errorf("no detection returned")
+	}
+
+	candidates := make([]Detection, 0, len(detections))
+	for _, d := range detections {
+		candidates = append(candidates, Detection{Language: d.GetLanguageCode(), Confidence: float64(d.GetConfidence())})
+	}
+	return bestDetection(candidates), nil
+}
+
+// Languages implements Provider
+func (p *GoogleV3) Languages(ctx context.Context, target string) ([]Language, error) {
+	if target == "" {
+		target = "en"
+	}
+	out, err := p.client.GetSupportedLanguages(ctx, &translatepb.GetSupportedLanguagesRequest{
+		Parent:              p.parent,
+		DisplayLanguageCode: target,
+		Model:               p.model,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("languages API error: %w", err)
+	}
+
+	languages := make([]Language, 0, len(out.GetLanguages()))
+	for _, l := range out.GetLanguages() {
+		languages = append(languages, Language{Code: l.GetLanguageCode(), Name: l.GetDisplayName()})
+	}
+	return languages, nil
+}
@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LibreTranslate translates using a (typically self-hosted)
+// LibreTranslate instance
+type LibreTranslate struct {
+	endpoint   string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewLibreTranslate creates a LibreTranslate client for the instance
+// at endpoint. apiKey is only required when the instance enforces keys.
+func NewLibreTranslate(endpoint, apiKey string) (*LibreTranslate, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("LIBRETRANSLATE_URL is required for the libretranslate provider")
+	}
+	return &LibreTranslate{
+		endpoint:   strings.TrimRight(endpoint, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Name implements Provider
+func (p *LibreTranslate) Name() string {
+	return "libretranslate"
+}
+
+// Translate implements Provider
+func (p *LibreTranslate) Translate(ctx context.Context, req Request) (*Result, error) {
+	sourceLang := req.SourceLang
+	if sourceLang == "" {
+		sourceLang = "auto"
+	}
+
+	format := FormatText
+	if req.Format == FormatHTML {
+		format = FormatHTML
+	}
+	body := map[string]string{
+		"q":      req.Text,
+		"source": sourceLang,
+		"target": req.TargetLang,
+		"format": format,
+	}
+	var result struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage *struct {
+			Language   string  `json:"language"`
+			Confidence float64 `json:"confidence"` // Percentage, 0-100
+		} `json:"detectedLanguage"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/translate", body, &result); err != nil {
+		return nil, fmt.Errorf("translation API error: %w", err)
+	}
+
+	response := &Result{
+		TranslatedText: result.TranslatedText,
+		SourceLang:     req.SourceLang,
+	}
+	if req.SourceLang == "" && result.DetectedLanguage != nil {
+		response.SourceLang = result.DetectedLanguage.Language
+		response.Confidence = result.DetectedLanguage.Confidence / 100
+	}
+	return response, nil
+}
+
+// Detect implements Provider
+func (p *LibreTranslate) Detect(ctx context.Context, text string) (*Detection, error) {
+	var results []struct {
+		Language   string  `json:"language"`
+		Confidence float64 `json:"confidence"` // Percentage, 0-100
+	}
+	if err := p.do(ctx, http.MethodPost, "/detect", map[string]string{"q": text}, &results); err != nil {
+		return nil, fmt.Errorf("detection API error: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no detection returned")
+	}
+	candidates := make([]Detection, 0, len(results))
+	for _, r := range results {
+		candidates = append(candidates, Detection{Language: r.Language, Confidence: r.Confidence / 100})
+	}
+	return bestDetection(candidates), nil
+}
+
+// Languages implements Provider. LibreTranslate only reports English names,
+// so target is ignored.
+func (p *LibreTranslate) Languages(ctx context.Context, target string) ([]Language, error) {
+	var results []struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/languages", nil, &results); err != nil {
+		return nil, fmt.Errorf("languages API error: %w", err)
+	}
+
+	languages := make([]Language, 0, len(results))
+	for _, l := range results {
+		languages = append(languages, Language{Code: l.Code, Name: l.Name})
+	}
+	return languages, nil
+}
+
+// HealthCheck implements HealthChecker by listing the upstream's languages
+func (p *LibreTranslate) HealthCheck(ctx context.Context) error {
+	var results []json.RawMessage
+	return p.do(ctx, http.MethodGet, "/languages", nil, &results)
+}
+
+// do performs a LibreTranslate API call, sending body as JSON (with the API
+// key added) when non-nil and decoding the response into out
+func (p *LibreTranslate) do(ctx context.Context, method, path string, body map[string]string, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		if p.apiKey != "" {
+			body["api_key"] = p.apiKey
+		}
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, p.endpoint+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		message := resp.Status
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err == nil && apiErr.Error != "" {
+			message = apiErr.Error
+		}
+		return &HTTPError{StatusCode: resp.StatusCode, Message: message}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
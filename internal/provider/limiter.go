@@ -0,0 +1,146 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrSaturated is returned without calling the provider when no slot of its
+// ConcurrencyLimit freed up within the queue timeout, or the queue is full
+var ErrSaturated = errors.New("provider concurrency limit reached")
+
+var (
+	// limitInFlight counts the provider calls holding a slot
+	limitInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "translation_provider_in_flight",
+		Help: "Provider calls in flight under the concurrency limit.",
+	})
+	// limitQueued counts the provider calls waiting for a slot
+	limitQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "translation_provider_queued",
+		Help: "Provider calls waiting for a slot of the concurrency limit.",
+	})
+	// limitRejected counts the provider calls failed with ErrSaturated
+	limitRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "translation_provider_saturated_total",
+		Help: "Provider calls rejected because the concurrency limit stayed saturated.",
+	})
+)
+
+// ConcurrencyLimit bounds the calls in flight of every provider it is
+// shared by. Calls beyond the limit wait in a queue of bounded length for
+// up to a timeout.
+type ConcurrencyLimit struct {
+	slots    chan struct{}
+	maxQueue int64
+	timeout  time.Duration
+	queued   atomic.Int64
+}
+
+// NewConcurrencyLimit creates a limit of maxInFlight calls, with up to
+// maxQueue more waiting at most timeout for a slot
+func NewConcurrencyLimit(maxInFlight, maxQueue int, timeout time.Duration) *ConcurrencyLimit {
+	return &ConcurrencyLimit{
+		slots:    make(chan struct{}, maxInFlight),
+		maxQueue: int64(maxQueue),
+		timeout:  timeout,
+	}
+}
+
+// acquire takes a slot, waiting in the queue when none is free
+func (l *ConcurrencyLimit) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		limitInFlight.Inc()
+		return nil
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		limitRejected.Inc()
+		return ErrSaturated
+	}
+	limitQueued.Inc()
+	defer func() {
+		l.queued.Add(-1)
+		limitQueued.Dec()
+	}()
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		limitInFlight.Inc()
+		return nil
+	case <-timer.C:
+		limitRejected.Inc()
+		return ErrSaturated
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot taken by acquire
+func (l *ConcurrencyLimit) release() {
+	<-l.slots
+	limitInFlight.Dec()
+}
+
+// Limited makes the calls of a provider take a slot of a ConcurrencyLimit
+type Limited struct {
+	Wrapper
+	limit *ConcurrencyLimit
+}
+
+// NewLimited wraps p so its calls count against limit
+func NewLimited(p Provider, limit *ConcurrencyLimit) *Limited {
+	return &Limited{Wrapper: Wrapper{p}, limit: limit}
+}
+
+// call runs fn holding a slot
+func (l *Limited) call(ctx context.Context, fn func() error) error {
+	if err := l.limit.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.limit.release()
+	return fn()
+}
+
+// Translate implements Provider
+func (l *Limited) Translate(ctx context.Context, req Request) (*Result, error) {
+	var result *Result
+	err := l.call(ctx, func() error {
+		var err error
+		result, err = l.Provider.Translate(ctx, req)
+		return err
+	})
+	return result, err
+}
+
+// Detect implements Provider
+func (l *Limited) Detect(ctx context.Context, text string) (*Detection, error) {
+	var detection *Detection
+	err := l.call(ctx, func() error {
+		var err error
+		detection, err = l.Provider.Detect(ctx, text)
+		return err
+	})
+	return detection, err
+}
+
+// Languages implements Provider
+func (l *Limited) Languages(ctx context.Context, target string) ([]Language, error) {
+	var languages []Language
+	err := l.call(ctx, func() error {
+		var err error
+		languages, err = l.Provider.Languages(ctx, target)
+		return err
+	})
+	return languages, err
+}
@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingProvider returns a fakeProvider whose translations wait for
+// release, reporting on started as they begin
+func blockingProvider(started chan<- struct{}, release <-chan struct{}) *fakeProvider {
+	return &fakeProvider{translate: func(ctx context.Context, req Request) (*Result, error) {
+		started <- struct{}{}
+		<-release
+		return &Result{TranslatedText: req.Text}, nil
+	}}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxQueue int
+		timeout  time.Duration
+		cancel   bool
+		want     error
+	}{
+		{"rejects when the queue is full", 0, time.Hour, false, ErrSaturated},
+		{"rejects after the timeout", 1, 10 * time.Millisecond, false, ErrSaturated},
+		{"stops waiting when cancelled", 1, time.Hour, true, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started, release := make(chan struct{}), make(chan struct{})
+			p := NewLimited(blockingProvider(started, release), NewConcurrencyLimit(1, tt.maxQueue, tt.timeout))
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.Translate(context.Background(), Request{Text: "first"})
+			}()
+			<-started
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+			if _, err := p.Translate(ctx, Request{Text: "second"}); err != tt.want {
+				t.Errorf("Translate() while saturated: error = %v, want %v", err, tt.want)
+			}
+			cancel()
+			close(release)
+			wg.Wait()
+		})
+	}
+}
+
+func TestConcurrencyLimitQueues(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	limit := NewConcurrencyLimit(1, 1, time.Hour)
+	// Both providers share the limit
+	first := NewLimited(blockingProvider(started, release), limit)
+	second := NewLimited(&fakeProvider{}, limit)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		first.Translate(context.Background(), Request{Text: "first"})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, err := second.Translate(context.Background(), Request{Text: "queued"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("queued call returned %v while the slot was held", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got := limit.queued.Load(); got != 1 {
+		t.Errorf("queued = %d, want 1", got)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("queued call: error = %v", err)
+	}
+	wg.Wait()
+	if got := len(limit.slots); got != 0 {
+		t.Errorf("slots held after every call returned = %d, want 0", got)
+	}
+}
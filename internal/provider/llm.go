@@ -0,0 +1,224 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// defaultLLMPrompt is the system prompt template used when LLM_PROMPT_TEMPLATE
+// is not set. The template receives the Request.
+const defaultLLMPrompt = `You are a professional translator. Translate the user's message ` +
+	`{{if .SourceLang}}from {{.SourceLang}} {{end}}into {{.TargetLang}}. ` +
+	`Preserve the meaning, {{if not .Tone}}tone, {{end}}formatting and any placeholders. ` +
+	`{{if eq .Formality "formal"}}Use formal language and forms of address. {{end}}` +
+	`{{if eq .Formality "informal"}}Use informal language and forms of address. {{end}}` +
+	`{{if .Tone}}Write in a {{.Tone}} tone. {{end}}` +
+	`{{if .Domain}}The message is {{.Domain}} content: use the terminology and register of the field. {{end}}` +
+	`{{if .Context}}The message appears in this context, which is not to be translated: {{printf "%q" .Context}}. {{end}}` +
+	`{{if eq .Format "html"}}The message is HTML: keep every tag and attribute unchanged and translate only the text. {{end}}` +
+	`Reply with the translation only.`
+
+// llmDetectPrompt asks the model to identify the language of the user's message
+const llmDetectPrompt = `Identify the language of the user's message. ` +
+	`Reply with its ISO 639-1 code only, in lowercase, with no punctuation.`
+
+// defaultLLMLanguages is reported by Languages when LLM_LANGUAGES is not set
+var defaultLLMLanguages = []string{
+	"ar", "de", "en", "es", "fr", "hi", "it", "ja", "ko", "nl", "pl", "pt", "ru", "sv", "tr", "uk", "zh",
+}
+
+// LLM translates using an OpenAI-compatible chat completion endpoint
+type LLM struct {
+	endpoint    string
+	apiKey      string
+	model       string
+	temperature float64
+	prompt      *template.Template
+	languages   []string
+	httpClient  *http.Client
+	// Used instead of prompt for requests of their domain
+	domainPrompts map[string]*template.Template
+}
+
+// chatMessage is a single message of a chat completion request
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// NewLLM creates an LLM provider. promptTemplate is a text/template
+// rendered with the Request; empty uses defaultLLMPrompt. domainPrompts
+// are the templates of request domains prompted differently.
+func NewLLM(endpoint, apiKey, model string, temperature float64, promptTemplate string, domainPrompts map[string]string, languages []string) (*LLM, error) {
+	if model == "" {
+		return nil, fmt.Errorf("LLM_MODEL is required for the llm provider")
+	}
+	if promptTemplate == "" {
+		promptTemplate = defaultLLMPrompt
+	}
+	prompt, err := template.New("prompt").Parse(promptTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LLM prompt template: %v", err)
+	}
+	domainTemplates := make(map[string]*template.Template, len(domainPrompts))
+	for domain, text := range domainPrompts {
+		if domainTemplates[domain], err = template.New(domain).Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid LLM prompt template of domain %s: %v", domain, err)
+		}
+	}
+	if len(languages) == 0 {
+		languages = defaultLLMLanguages
+	}
+
+	return &LLM{
+		endpoint:    strings.TrimRight(endpoint, "/"),
+		apiKey:      apiKey,
+		model:       model,
+		temperature: temperature,
+		prompt:      prompt,
+		languages:   languages,
+		httpClient:  &http.Client{Timeout: 60 * time.Second},
+
+		domainPrompts: domainTemplates,
+	}, nil
+}
+
+// Name implements Provider
+func (p *LLM) Name() string {
+	return "llm"
+}
+
+// Translate implements Provider. When no source language is given it is
+// detected first, since the model's reply only contains the translation.
+func (p *LLM) Translate(ctx context.Context, req Request) (*Result, error) {
+	sourceLang := req.SourceLang
+	if sourceLang == "" {
+		detection, err := p.Detect(ctx, req.Text)
+		if err != nil {
+			return nil, err
+		}
+		sourceLang = detection.Language
+	}
+
+	tmpl, ok := p.domainPrompts[req.Domain]
+	if !ok {
+		tmpl = p.prompt
+	}
+	var prompt bytes.Buffer
+	if err := tmpl.Execute(&prompt, req); err != nil {
+		return nil, fmt.Errorf("failed to render prompt: %v", err)
+	}
+
+	text, err := p.complete(ctx, prompt.String(), req.Text, p.temperature)
+	if err != nil {
+		return nil, fmt.Errorf("translation API error: %w", err)
+	}
+	return &Result{TranslatedText: text, SourceLang: sourceLang}, nil
+}
+
+// MaxTextBytes implements TextLimiter. Long texts are chunked to keep
+// replies well within typical output token limits.
+func (p *LLM) MaxTextBytes() int {
+	return 8000
+}
+
+// Detect implements Provider. The model gives no confidence score.
+func (p *LLM) Detect(ctx context.Context, text string) (*Detection, error) {
+	code, err := p.complete(ctx, llmDetectPrompt, text, 0)
+	if err != nil {
+		return nil, fmt.Errorf("detection API error: %w", err)
+	}
+	tag, err := language.Parse(strings.TrimSpace(code))
+	if err != nil {
+		return nil, fmt.Errorf("model returned an invalid language code %q", code)
+	}
+	return &Detection{Language: tag.String()}, nil
+}
+
+// Languages implements Provider using the configured language list, since
+// chat models have no way of listing what they support
+func (p *LLM) Languages(ctx context.Context, target string) ([]Language, error) {
+	displayLang := language.English
+	if target != "" {
+		var err error
+		displayLang, err = language.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target language: %v", err)
+		}
+	}
+
+	namer := display.Languages(displayLang)
+	languages := make([]Language, 0, len(p.languages))
+	for _, code := range p.languages {
+		l := Language{Code: code}
+		if tag, err := language.Parse(code); err == nil {
+			l.Name = namer.Name(tag)
+		}
+		languages = append(languages, l)
+	}
+	return languages, nil
+}
+
+// complete sends a chat completion with the given system prompt and user
+// message and returns the reply
+func (p *LLM) complete(ctx context.Context, system, user string, temperature float64) (string, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"model":       p.model,
+		"temperature": temperature,
+		"messages": []chatMessage{
+			{Role: "system", Content: system},
+			{Role: "user", Content: user},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/chat/completions", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		message := resp.Status
+		if decodeErr == nil && result.Error != nil && result.Error.Message != "" {
+			message = result.Error.Message
+		}
+		return "", &HTTPError{StatusCode: resp.StatusCode, Message: message}
+	}
+	if decodeErr != nil {
+		return "", fmt.Errorf("unexpected response: %w", decodeErr)
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("no completion returned")
+	}
+	return strings.TrimSpace(result.Choices[0].Message.Content), nil
+}
@@ -0,0 +1,131 @@
+// Package provider implements the translation backends and the decorators
+// every backend is wrapped in: retries, circuit breaking, failover and
+// canary routing.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Request is a text to translate, as passed to a Provider
+type Request struct {
+	Text       string
+	SourceLang string // ISO 639-1 code, detected when empty
+	TargetLang string // ISO 639-1 code
+	Format     string // FormatText (default) or FormatHTML
+	Formality  string // FormalityFormal or FormalityInformal, the provider's default when empty
+	Tone       string // Such as friendly or professional, for providers that are prompted
+	Context    string // Where the text appears, to disambiguate it, for providers that are prompted
+	Domain     string // Such as medical or legal, selecting a provider's model, terminology or prompt
+}
+
+// Result is the outcome of a single translation performed by a Provider
+type Result struct {
+	TranslatedText string
+	SourceLang     string  // Detected or requested source language
+	Provider       string  // Name of the provider that produced the result, if not the configured one
+	Confidence     float64 // Confidence in a detected SourceLang, from 0 to 1, zero when not reported
+}
+
+// Detection is a language detected by a Provider
+type Detection struct {
+	Language     string
+	Confidence   float64     // From 0 to 1, zero when the provider gives none
+	Alternatives []Detection // Less likely languages, most likely first
+}
+
+// bestDetection returns the most likely of candidates, with the others as
+// its alternatives
+func bestDetection(candidates []Detection) *Detection {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+	best := candidates[0]
+	if len(candidates) > 1 {
+		best.Alternatives = candidates[1:]
+	}
+	return &best
+}
+
+// Text formats a Request may be in
+const (
+	FormatText = "text"
+	FormatHTML = "html" // Markup is preserved, only text content is translated
+)
+
+// Formalities a Request may ask for, honored by providers that support
+// them for the target language
+const (
+	FormalityFormal   = "formal"
+	FormalityInformal = "informal"
+)
+
+// Language is a language supported by a Provider
+type Language struct {
+	Code string
+	Name string
+}
+
+// Provider is implemented by every translation backend
+type Provider interface {
+	// Name returns the identifier used to select the provider in config
+	Name() string
+	// Translate translates req.Text into req.TargetLang, auto-detecting the
+	// source language when req.SourceLang is empty
+	Translate(ctx context.Context, req Request) (*Result, error)
+	// Detect returns the most likely language of text
+	Detect(ctx context.Context, text string) (*Detection, error)
+	// Languages lists the supported languages, with names localized to target
+	Languages(ctx context.Context, target string) ([]Language, error)
+}
+
+// HTTPError is returned by HTTP-based providers when the upstream
+// responds with an error status
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error implements error
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s (status %d)", e.Message, e.StatusCode)
+}
+
+// HTTPStatusCode returns the upstream's status code
+func (e *HTTPError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+// Wrapper is embedded by providers that decorate another provider,
+// passing optional interfaces through to it
+type Wrapper struct {
+	Provider
+}
+
+// Close implements io.Closer when the wrapped provider does
+func (w Wrapper) Close() error {
+	if closer, ok := w.Provider.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// HealthCheck implements HealthChecker when the wrapped provider does
+func (w Wrapper) HealthCheck(ctx context.Context) error {
+	if checker, ok := w.Provider.(HealthChecker); ok {
+		return checker.HealthCheck(ctx)
+	}
+	return nil
+}
+
+// HealthChecker is implemented by providers whose upstream can be checked
+// cheaply, e.g. self-hosted instances reported on by /health
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// Names are the names providers are registered under
+var Names = []string{"google", "aws", "azure", "libretranslate", "llm"}
@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// fakeProvider is a Provider answering every call with the next of errs,
+// and with translate once they run out
+type fakeProvider struct {
+	name      string
+	translate func(ctx context.Context, req Request) (*Result, error)
+
+	mu    sync.Mutex
+	errs  []error
+	calls int
+}
+
+// Name implements Provider
+func (p *fakeProvider) Name() string {
+	if p.name == "" {
+		return "fake"
+	}
+	return p.name
+}
+
+// next counts a call and returns the error it fails with
+func (p *fakeProvider) next() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if len(p.errs) == 0 {
+		return nil
+	}
+	err := p.errs[0]
+	p.errs = p.errs[1:]
+	return err
+}
+
+// Calls returns the number of calls made
+func (p *fakeProvider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+// Translate implements Provider
+func (p *fakeProvider) Translate(ctx context.Context, req Request) (*Result, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.translate != nil {
+		return p.translate(ctx, req)
+	}
+	return &Result{TranslatedText: "[" + req.TargetLang + "] " + req.Text, SourceLang: req.SourceLang}, nil
+}
+
+// Detect implements Provider
+func (p *fakeProvider) Detect(ctx context.Context, text string) (*Detection, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return &Detection{Language: "en", Confidence: 1}, nil
+}
+
+// Languages implements Provider
+func (p *fakeProvider) Languages(ctx context.Context, target string) ([]Language, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return []Language{{Code: "en", Name: "English"}}, nil
+}
+
+// fakeCloser is a fakeProvider that records being closed
+type fakeCloser struct {
+	fakeProvider
+	closed bool
+}
+
+// Close implements io.Closer
+func (p *fakeCloser) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestWrapperPassesClose(t *testing.T) {
+	inner := &fakeCloser{}
+	p := NewRetry(NewCircuitBreaker(inner, 1, 0), RetryPolicy{MaxAttempts: 1})
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if !inner.closed {
+		t.Error("Close() wasn't passed to the wrapped provider")
+	}
+	if err := NewRetry(&fakeProvider{}, RetryPolicy{}).Close(); err != nil {
+		t.Errorf("Close() of a provider that isn't a Closer = %v", err)
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	err := error(&HTTPError{StatusCode: 503, Message: "unavailable"})
+	if got, want := err.Error(), "unavailable (status 503)"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	var statusErr interface{ HTTPStatusCode() int }
+	if !errors.As(err, &statusErr) || statusErr.HTTPStatusCode() != 503 {
+		t.Errorf("HTTPStatusCode() isn't 503")
+	}
+}
+
+func TestBestDetection(t *testing.T) {
+	best := bestDetection([]Detection{
+		{Language: "de", Confidence: 0.2},
+		{Language: "en", Confidence: 0.7},
+		{Language: "nl", Confidence: 0.1},
+	})
+	if best.Language != "en" {
+		t.Fatalf("Language = %q, want en", best.Language)
+	}
+	if len(best.Alternatives) != 2 || best.Alternatives[0].Language != "de" || best.Alternatives[1].Language != "nl" {
+		t.Errorf("Alternatives = %+v, want de then nl", best.Alternatives)
+	}
+}
@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// RetryPolicy configures retries of transient provider failures
+type RetryPolicy struct {
+	MaxAttempts    int           // Total attempts, including the first
+	InitialBackoff time.Duration // Backoff before the first retry
+	MaxBackoff     time.Duration // Upper bound for the backoff
+}
+
+// Backoff returns the full-jitter delay before retry number attempt (1-based)
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.InitialBackoff << (attempt - 1)
+	if d <= 0 || d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// do runs fn until it succeeds, fails with a non-transient error, the
+// attempts run out or ctx is done
+func (p RetryPolicy) do(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= p.MaxAttempts || !isTransient(ctx, err) {
+			return err
+		}
+
+		delay := p.Backoff(attempt)
+		slog.WarnContext(ctx, "transient provider error, retrying", "attempt", attempt, "backoff", delay.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isTransient reports whether a provider error is worth retrying: 5xx and
+// request timeout statuses and network timeouts. Quota (429), validation and
+// other client errors are not retried, nor is anything once ctx is done.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return transientStatus(apiErr.Code)
+	}
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		return transientStatus(statusErr.HTTPStatusCode())
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// transientStatus reports whether an HTTP status indicates a transient failure
+func transientStatus(code int) bool {
+	return code >= 500 || code == http.StatusRequestTimeout
+}
+
+// Retry retries transient failures of a Provider
+type Retry struct {
+	Wrapper
+	policy RetryPolicy
+}
+
+// NewRetry wraps p, retrying its transient failures with policy
+func NewRetry(p Provider, policy RetryPolicy) *Retry {
+	return &Retry{Wrapper: Wrapper{p}, policy: policy}
+}
+
+// Translate implements Provider
+func (p *Retry) Translate(ctx context.Context, req Request) (*Result, error) {
+	var result *Result
+	err := p.policy.do(ctx, func() error {
+		var err error
+		result, err = p.Provider.Translate(ctx, req)
+		return err
+	})
+	return result, err
+}
+
+// Detect implements Provider
+func (p *Retry) Detect(ctx context.Context, text string) (*Detection, error) {
+	var detection *Detection
+	err := p.policy.do(ctx, func() error {
+		var err error
+		detection, err = p.Provider.Detect(ctx, text)
+		return err
+	})
+	return detection, err
+}
+
+// Languages implements Provider
+func (p *Retry) Languages(ctx context.Context, target string) ([]Language, error) {
+	var languages []Language
+	err := p.policy.do(ctx, func() error {
+		var err error
+		languages, err = p.Provider.Languages(ctx, target)
+		return err
+	})
+	return languages, err
+}
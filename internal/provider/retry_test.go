@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &HTTPError{StatusCode: http.StatusBadGateway}, true},
+		{"request timeout", &HTTPError{StatusCode: http.StatusRequestTimeout}, true},
+		{"quota", &HTTPError{StatusCode: http.StatusTooManyRequests}, false},
+		{"bad request", &HTTPError{StatusCode: http.StatusBadRequest}, false},
+		{"wrapped server error", fmt.Errorf("translate: %w", &HTTPError{StatusCode: 500}), true},
+		{"google server error", &googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{"google forbidden", &googleapi.Error{Code: http.StatusForbidden}, false},
+		{"network timeout", timeoutError{}, true},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"other", errors.New("unsupported language"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(context.Background(), tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if isTransient(ctx, &HTTPError{StatusCode: 500}) {
+		t.Error("errors are transient once the context is done")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{70, time.Second}, // The shift overflows
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := policy.Backoff(tt.attempt); d < 0 || d > tt.max {
+				t.Fatalf("Backoff(%d) = %v, want within [0, %v]", tt.attempt, d, tt.max)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	unavailable := &HTTPError{StatusCode: http.StatusServiceUnavailable, Message: "unavailable"}
+	invalid := &HTTPError{StatusCode: http.StatusBadRequest, Message: "invalid"}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{"succeeds", nil, nil, 1},
+		{"retries transient failures", []error{unavailable, unavailable}, nil, 3},
+		{"gives up after the attempts", []error{unavailable, unavailable, unavailable, unavailable}, unavailable, 3},
+		{"doesn't retry client errors", []error{invalid}, invalid, 1},
+		{"stops at a client error", []error{unavailable, invalid}, invalid, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &fakeProvider{errs: append([]error(nil), tt.errs...)}
+			p := NewRetry(inner, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+			result, err := p.Translate(context.Background(), Request{Text: "hello", TargetLang: "de"})
+			if err != tt.wantErr {
+				t.Fatalf("Translate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && result.TranslatedText != "[de] hello" {
+				t.Errorf("TranslatedText = %q", result.TranslatedText)
+			}
+			if inner.Calls() != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", inner.Calls(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	inner := &fakeProvider{errs: []error{&HTTPError{StatusCode: 500}, &HTTPError{StatusCode: 500}}}
+	p := NewRetry(inner, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.Detect(ctx, "hello"); err == nil {
+		t.Fatal("Detect() succeeded, want the provider's error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Detect() waited %v for its backoff after the context was done", elapsed)
+	}
+}
@@ -0,0 +1,105 @@
+// Package translate embeds the translation service in other programs: the
+// configured providers and cache, with the translation memory, glossaries
+// and everything else applied to HTTP requests, without going over HTTP, or
+// the HTTP API itself, to serve from an existing server.
+//
+//	config, err := translate.LoadConfig(os.Getenv("CONFIG_FILE"))
+//	if err != nil {
+//		return err
+//	}
+//	service, err := translate.New(ctx, config)
+//	if err != nil {
+//		return err
+//	}
+//	defer service.Close(context.Background())
+//	response, err := service.Translate(ctx, translate.Request{Text: "Hello", TargetLang: "de"})
+package translate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dphase/ss-translate/internal/api"
+	"github.com/dphase/ss-translate/internal/provider"
+)
+
+type (
+	// Config is the configuration of the service, see the readme for the
+	// environment variables setting each field
+	Config = api.Config
+	// Request is a text to translate
+	Request = api.TranslationRequest
+	// Response is a translated text
+	Response = api.TranslationResponse
+	// Detection is a detected language and the provider's confidence in it,
+	// with the other languages the text may be in
+	Detection = provider.Detection
+	// DetectedLanguage is an alternative language of a response's text
+	DetectedLanguage = api.DetectedLanguage
+	// Verification is the back-translation check of a response's
+	// translation
+	Verification = api.Verification
+	// Moderation reports what moderation found in a response's text or
+	// translation
+	Moderation = api.Moderation
+)
+
+// LoadConfig reads the configuration like the service does: from the
+// environment, with the settings of the YAML file at path, if any, applying
+// where the environment doesn't set them. The file's settings are put in
+// the process environment.
+func LoadConfig(path string) (Config, error) {
+	if err := api.LoadConfigFile(path); err != nil {
+		return Config{}, err
+	}
+	config := api.ReadConfig()
+	if errs := api.ValidateConfig(&config); len(errs) > 0 {
+		return Config{}, fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+	return config, nil
+}
+
+// Service is an embedded translation service
+type Service struct {
+	server *api.Server
+}
+
+// New connects to Redis and sets up the providers of config. The service
+// logs with the default slog logger.
+func New(ctx context.Context, config Config) (*Service, error) {
+	server, err := api.NewServer(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{server: server}, nil
+}
+
+// Translate translates a request, served from the translation memory or
+// cache when possible
+func (s *Service) Translate(ctx context.Context, req Request) (*Response, error) {
+	return s.server.Translate(ctx, req)
+}
+
+// Detect returns the most likely language of text
+func (s *Service) Detect(ctx context.Context, text string) (*Detection, error) {
+	return s.server.Detect(ctx, text)
+}
+
+// Handler returns the handler serving the HTTP API. Call Start before
+// serving it, so asynchronous jobs are processed.
+func (s *Service) Handler() http.Handler {
+	return s.server.Handler()
+}
+
+// Start runs the job workers and provider health checks until ctx is done
+func (s *Service) Start(ctx context.Context) {
+	s.server.Start(ctx)
+}
+
+// Close waits for running jobs, then flushes the translation history and
+// closes the clients
+func (s *Service) Close(ctx context.Context) {
+	s.server.Close(ctx)
+}
@@ -0,0 +1,6 @@
+// Package translatepb holds the protobuf messages of the translation API,
+// for callers that send and accept application/x-protobuf bodies instead of
+// JSON.
+package translatepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative translate.proto